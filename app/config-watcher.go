@@ -0,0 +1,120 @@
+package app
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Reloads configuration of the container on SIGHUP and when
+// the configuration file changes
+type ConfigWatcher struct {
+	path      string
+	container *Container
+	modTime   time.Time
+	signals   chan os.Signal
+	stop      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+// Starts watching configuration file at path, file changes are checked
+// every ReloadIntervalS seconds of the container configuration.
+// Only SIGHUP reloads environment variables when path is empty
+func WatchConfig(path string, container *Container) *ConfigWatcher {
+	w := &ConfigWatcher{
+		path:      path,
+		container: container,
+		signals:   make(chan os.Signal, 1),
+		stop:      make(chan struct{}),
+	}
+	w.modTime, _ = w.fileModTime()
+	signal.Notify(w.signals, syscall.SIGHUP)
+
+	w.stopped.Add(1)
+	go w.run()
+	return w
+}
+
+// Stops watching
+func (w *ConfigWatcher) Close() {
+	signal.Stop(w.signals)
+	close(w.stop)
+	w.stopped.Wait()
+}
+
+// Reloads configuration on signals and file changes until Close
+func (w *ConfigWatcher) run() {
+	defer w.stopped.Done()
+
+	interval := w.interval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.signals:
+			zap.L().Info("Received SIGHUP, reloading configuration")
+			w.reload()
+		case <-ticker.C:
+			modTime, err := w.fileModTime()
+			if err != nil || modTime.Equal(w.modTime) {
+				continue
+			}
+			w.modTime = modTime
+			zap.L().Info("Configuration file changed, reloading", zap.String("path", w.path))
+			w.reload()
+		}
+		if next := w.interval(); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// Loads configuration and applies it, invalid configuration
+// is logged and the current one is kept
+func (w *ConfigWatcher) reload() {
+	defer zap.L().Sync()
+
+	config, err := LoadConfig(w.path)
+	if err == nil {
+		err = w.container.Reload(config)
+	}
+	if err != nil {
+		zap.L().Error(
+			"Cannot reload configuration, using previous one",
+			zap.String("path", w.path),
+			zap.Error(err),
+		)
+	}
+}
+
+// Returns modification time of configuration file
+func (w *ConfigWatcher) fileModTime() (time.Time, error) {
+	if len(w.path) == 0 {
+		return time.Time{}, os.ErrNotExist
+	}
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Returns interval of file checks from current configuration
+func (w *ConfigWatcher) interval() time.Duration {
+	w.container.reload.Lock()
+	defer w.container.reload.Unlock()
+
+	seconds := w.container.Config.ReloadIntervalS
+	if seconds <= 0 {
+		seconds = DefaultReloadIntervalS
+	}
+	return time.Duration(seconds) * time.Second
+}
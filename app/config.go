@@ -0,0 +1,742 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/MichalMitros/feed-parser/auth"
+	"github.com/MichalMitros/feed-parser/deduplicator"
+	"github.com/MichalMitros/feed-parser/filefetcher/httpfilefetcher"
+	"github.com/MichalMitros/feed-parser/itemrouter"
+	"github.com/MichalMitros/feed-parser/jobqueue/rabbitbroker"
+	"github.com/MichalMitros/feed-parser/scheduler"
+	"github.com/MichalMitros/feed-parser/urlpolicy"
+	"gopkg.in/yaml.v2"
+)
+
+// Configuration of the whole service, read from optional YAML or TOML
+// file and overridden with environment variables
+type Config struct {
+	Server   ServerConfig   `json:"server"`
+	Fetcher  FetcherConfig  `json:"fetcher"`
+	Pipeline PipelineConfig `json:"pipeline"`
+	Sinks    SinksConfig    `json:"sinks"`
+	Registry RegistryConfig `json:"registry"`
+	// Periodic runs of registered feeds, requires the registry
+	Scheduler SchedulerConfig `json:"scheduler"`
+	// Distribution of parse requests to workers
+	Jobs JobsConfig `json:"jobs"`
+	// Authentication and quotas of API clients
+	Auth AuthConfig `json:"auth"`
+	// Interval of checking the configuration file for changes,
+	// DefaultReloadIntervalS is used when 0
+	ReloadIntervalS int `json:"reloadIntervalS"`
+}
+
+type ServerConfig struct {
+	Address string `json:"address"`
+	// "development" or "production"
+	Mode string `json:"mode"`
+	// Maximal time of parsing feeds in progress after SIGTERM,
+	// not limited when 0
+	DrainTimeoutS int `json:"drainTimeoutS"`
+	// Number of feeds being parsed at which the service reports
+	// not ready, not limited when 0
+	MaxInFlightFeeds int `json:"maxInFlightFeeds"`
+}
+
+// Settings of feed downloads, applied on reload
+type FetcherConfig struct {
+	// Timeout of connecting and waiting for response headers,
+	// not limited when 0
+	TimeoutMs    int `json:"timeoutMs"`
+	Retries      int `json:"retries"`
+	RetryDelayMs int `json:"retryDelayMs"`
+	// Maximal number of requests per second to a single host,
+	// not limited when 0
+	RateLimit float64 `json:"rateLimit"`
+	Proxy     string  `json:"proxy"`
+	// Urls which may be fetched, private addresses are blocked
+	// by default
+	UrlPolicy UrlPolicyConfig `json:"urlPolicy"`
+}
+
+type UrlPolicyConfig struct {
+	// urlpolicy.DefaultSchemes are used when empty
+	AllowedSchemes []string `json:"allowedSchemes"`
+	// Host patterns like "*.example.com", all hosts are allowed
+	// when empty
+	AllowedHosts []string `json:"allowedHosts"`
+	DeniedHosts  []string `json:"deniedHosts"`
+	// Networks in CIDR notation exempted from blocking of private
+	// addresses, e.g. feeds hosted in the cluster
+	AllowedNetworks []string `json:"allowedNetworks"`
+	// urlpolicy.DefaultMaxRedirects is used when 0
+	MaxRedirects int `json:"maxRedirects"`
+}
+
+type PipelineConfig struct {
+	// Handling of repeated ITEM_IDs, deduplicator.KeepFirst when empty
+	DuplicatesPolicy string `json:"duplicatesPolicy"`
+	// Number of offending item ids per rule in quality reports
+	ReportSampleSize int `json:"reportSampleSize"`
+	// Routing outputs, applied on reload. Ignored when
+	// RoutingConfigPath is set
+	Routing *itemrouter.RoutingConfig `json:"routing"`
+	// Named routing configurations selected by routing profile
+	// of registered feeds
+	RoutingProfiles map[string]itemrouter.RoutingConfig `json:"routingProfiles"`
+	// Optional configuration files, reloaded on their own changes
+	RoutingConfigPath    string `json:"routingConfigPath"`
+	TransformsConfigPath string `json:"transformsConfigPath"`
+	// Delta publishing is enabled when set
+	DeltaStorePath string `json:"deltaStorePath"`
+	DeltaOnly      bool   `json:"deltaOnly"`
+}
+
+type RegistryConfig struct {
+	// Feed registry is disabled when empty
+	Path string `json:"path"`
+}
+
+type SchedulerConfig struct {
+	Enabled bool `json:"enabled"`
+	// Interval of reading schedules from the registry,
+	// scheduler.DefaultRefreshInterval is used when 0
+	RefreshIntervalS int `json:"refreshIntervalS"`
+	// Maximal random delay of scheduled runs
+	MaxJitterS int `json:"maxJitterS"`
+	// "skip" or "run_once", "skip" is used when empty
+	MissedRunPolicy string `json:"missedRunPolicy"`
+}
+
+type JobsConfig struct {
+	// "local" parses requests in this instance, "api" only enqueues them
+	// to the work queue and "worker" also parses queued jobs.
+	// "local" is used when empty
+	Mode string `json:"mode"`
+	// RabbitMQ of the work queue, connection of sinks.rabbitmq
+	// is used when host is empty
+	Host     string `json:"host"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// rabbitbroker.DefaultQueue and rabbitbroker.DefaultStatusExchange
+	// are used when empty
+	Queue          string `json:"queue"`
+	StatusExchange string `json:"statusExchange"`
+	// Number of jobs parsed at once by worker, also used as prefetch
+	Concurrency int `json:"concurrency"`
+	// Number of jobs with statuses kept for GET /jobs/:id
+	StatusStoreCapacity int `json:"statusStoreCapacity"`
+}
+
+type AuthConfig struct {
+	// Clients with their API keys and quotas
+	Clients []ClientConfig `json:"clients"`
+	// JWT bearer tokens signed with keys from the JWKS file
+	// are accepted when set
+	JwksPath string `json:"jwksPath"`
+	// Expected "iss" and "aud" claims, not checked when empty
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// Claim with client identity, auth.DefaultClientClaim is used
+	// when empty
+	ClientClaim string `json:"clientClaim"`
+	// Quotas of every client, not limited when 0
+	MaxConcurrentJobs int `json:"maxConcurrentJobs"`
+	MaxFeedsPerHour   int `json:"maxFeedsPerHour"`
+	// Clients accessing registered feeds and jobs of all clients,
+	// other clients access only their own ones
+	AdminClients []string `json:"adminClients"`
+}
+
+type ClientConfig struct {
+	// Identity of the client, "sub" claim of its JWT by default
+	Name string `json:"name"`
+	// Client can authenticate only with JWT when empty
+	ApiKey string `json:"apiKey"`
+	// Quotas of the client, default quotas are used when 0
+	MaxConcurrentJobs int `json:"maxConcurrentJobs"`
+	MaxFeedsPerHour   int `json:"maxFeedsPerHour"`
+}
+
+type SinksConfig struct {
+	// Comma separated queue writers with optional failure policies,
+	// e.g. "rabbitmq,file:best_effort". "rabbitmq" is used when empty
+	// and RabbitMQ host is set, "stdout" otherwise
+	QueueWriter string         `json:"queueWriter"`
+	RabbitMQ    RabbitMQConfig `json:"rabbitmq"`
+	Kafka       KafkaConfig    `json:"kafka"`
+	Nats        NatsConfig     `json:"nats"`
+	Redis       RedisConfig    `json:"redis"`
+	File        FileConfig     `json:"file"`
+	Outbox      OutboxConfig   `json:"outbox"`
+}
+
+type RabbitMQConfig struct {
+	Host     string `json:"host"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// Non-durable queues are kept by default, as already declared
+	// queues can't change durability
+	Durable    bool `json:"durable"`
+	Persistent bool `json:"persistent"`
+	// Default exchange with queue names as routing keys is used
+	// when exchange is not set
+	Exchange     string `json:"exchange"`
+	ExchangeType string `json:"exchangeType"`
+	RoutingKey   string `json:"routingKey"`
+	// Every item is published as separate message when format is empty
+	BatchSize       int    `json:"batchSize"`
+	BatchIntervalMs int    `json:"batchIntervalMs"`
+	BatchFormat     string `json:"batchFormat"`
+	Compression     string `json:"compression"`
+}
+
+type KafkaConfig struct {
+	Brokers          []string `json:"brokers"`
+	ClientId         string   `json:"clientId"`
+	Acks             string   `json:"acks"`
+	Idempotent       bool     `json:"idempotent"`
+	TopicsConfigPath string   `json:"topicsConfigPath"`
+}
+
+type NatsConfig struct {
+	Url     string `json:"url"`
+	Subject string `json:"subject"`
+	// Streams are expected to exist when not set
+	Stream            string   `json:"stream"`
+	StreamSubjects    []string `json:"streamSubjects"`
+	DuplicatesWindowS int      `json:"duplicatesWindowS"`
+	AckTimeoutMs      int      `json:"ackTimeoutMs"`
+}
+
+type RedisConfig struct {
+	Address  string `json:"address"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	Stream   string `json:"stream"`
+	Encoding string `json:"encoding"`
+	// Streams are not trimmed when 0
+	MaxLen          int  `json:"maxLen"`
+	ApproximateTrim bool `json:"approximateTrim"`
+}
+
+type FileConfig struct {
+	Dir    string `json:"dir"`
+	Format string `json:"format"`
+	Gzip   bool   `json:"gzip"`
+	// Every run of every queue is written to a single file when 0
+	MaxItems int `json:"maxItems"`
+	MaxBytes int `json:"maxBytes"`
+}
+
+type OutboxConfig struct {
+	// Outbox is disabled when empty
+	Dir         string `json:"dir"`
+	SegmentSize int    `json:"segmentSize"`
+	// Number of waiting items at which the service reports not ready,
+	// not limited when 0
+	MaxBacklog int `json:"maxBacklog"`
+}
+
+// Default values of Config
+const (
+	DefaultServerAddress   = ":8080"
+	DefaultServerMode      = "production"
+	DefaultDrainTimeoutS   = 30
+	DefaultReloadIntervalS = 5
+)
+
+// Returns configuration with default values
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Address:       DefaultServerAddress,
+			Mode:          DefaultServerMode,
+			DrainTimeoutS: DefaultDrainTimeoutS,
+		},
+		Sinks: SinksConfig{
+			Redis: RedisConfig{ApproximateTrim: true},
+		},
+		ReloadIntervalS: DefaultReloadIntervalS,
+	}
+}
+
+// Reads configuration from file at path, format is chosen by extension
+// (.yaml, .yml, .toml or .json). Values are overridden with environment
+// variables and validated. Only environment variables are used when
+// path is empty
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	if len(path) > 0 {
+		if err := readConfigFile(path, &config); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := config.applyEnv(); err != nil {
+		return Config{}, err
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// Reads configuration from environment variables only
+func LoadConfigFromEnv() (Config, error) {
+	return LoadConfig("")
+}
+
+// Decodes configuration file into config. YAML and TOML are converted
+// to JSON first, so all formats share the same keys and unknown keys
+// are rejected
+func readConfigFile(path string, config *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read configuration file: %w", err)
+	}
+
+	var raw interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &raw)
+		raw = normalizeYaml(raw)
+	case ".toml":
+		var table map[string]interface{}
+		err = toml.Unmarshal(content, &table)
+		raw = table
+	case ".json":
+		err = json.Unmarshal(content, &raw)
+	default:
+		return fmt.Errorf("unknown configuration file format %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot parse configuration file: %w", err)
+	}
+	if raw == nil {
+		return nil
+	}
+
+	content, err = json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("cannot parse configuration file: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("invalid configuration file: %w", err)
+	}
+	return nil
+}
+
+// Converts maps decoded from YAML to maps with string keys
+func normalizeYaml(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			normalized[fmt.Sprint(key)] = normalizeYaml(item)
+		}
+		return normalized
+	case []interface{}:
+		for idx, item := range value {
+			value[idx] = normalizeYaml(item)
+		}
+	}
+	return value
+}
+
+// Overrides configuration with set environment variables
+func (c *Config) applyEnv() error {
+	env := &envReader{}
+
+	c.Server.Address = env.string("SERVER_ADDRESS", c.Server.Address)
+	c.Server.Mode = env.string("ENV", c.Server.Mode)
+	c.Server.DrainTimeoutS = env.int("SERVER_DRAIN_TIMEOUT_S", c.Server.DrainTimeoutS)
+	c.Server.MaxInFlightFeeds = env.int("SERVER_MAX_IN_FLIGHT_FEEDS", c.Server.MaxInFlightFeeds)
+	c.ReloadIntervalS = env.int("CONFIG_RELOAD_INTERVAL_S", c.ReloadIntervalS)
+
+	f := &c.Fetcher
+	f.TimeoutMs = env.int("FETCHER_TIMEOUT_MS", f.TimeoutMs)
+	f.Retries = env.int("FETCHER_RETRIES", f.Retries)
+	f.RetryDelayMs = env.int("FETCHER_RETRY_DELAY_MS", f.RetryDelayMs)
+	f.RateLimit = env.float("FETCHER_RATE_LIMIT", f.RateLimit)
+	f.Proxy = env.string("FETCHER_PROXY", f.Proxy)
+	u := &f.UrlPolicy
+	u.AllowedSchemes = env.list("FETCHER_ALLOWED_SCHEMES", u.AllowedSchemes)
+	u.AllowedHosts = env.list("FETCHER_ALLOWED_HOSTS", u.AllowedHosts)
+	u.DeniedHosts = env.list("FETCHER_DENIED_HOSTS", u.DeniedHosts)
+	u.AllowedNetworks = env.list("FETCHER_ALLOWED_NETWORKS", u.AllowedNetworks)
+	u.MaxRedirects = env.int("FETCHER_MAX_REDIRECTS", u.MaxRedirects)
+
+	p := &c.Pipeline
+	p.DuplicatesPolicy = env.string("DUPLICATES_POLICY", p.DuplicatesPolicy)
+	p.ReportSampleSize = env.int("REPORT_SAMPLE_SIZE", p.ReportSampleSize)
+	p.RoutingConfigPath = env.string("ROUTING_CONFIG_PATH", p.RoutingConfigPath)
+	p.TransformsConfigPath = env.string("TRANSFORMS_CONFIG_PATH", p.TransformsConfigPath)
+	p.DeltaStorePath = env.string("DELTA_STORE_PATH", p.DeltaStorePath)
+	p.DeltaOnly = env.bool("DELTA_ONLY", p.DeltaOnly)
+
+	c.Registry.Path = env.string("FEED_REGISTRY_PATH", c.Registry.Path)
+
+	sc := &c.Scheduler
+	sc.Enabled = env.bool("SCHEDULER_ENABLED", sc.Enabled)
+	sc.RefreshIntervalS = env.int("SCHEDULER_REFRESH_INTERVAL_S", sc.RefreshIntervalS)
+	sc.MaxJitterS = env.int("SCHEDULER_MAX_JITTER_S", sc.MaxJitterS)
+	sc.MissedRunPolicy = env.string("SCHEDULER_MISSED_RUN_POLICY", sc.MissedRunPolicy)
+
+	j := &c.Jobs
+	j.Mode = env.string("JOBS_MODE", j.Mode)
+	j.Host = env.string("JOBS_RABBITMQ_HOST", j.Host)
+	j.User = env.string("JOBS_RABBITMQ_USER", j.User)
+	j.Password = env.string("JOBS_RABBITMQ_PASSWORD", j.Password)
+	j.Queue = env.string("JOBS_QUEUE", j.Queue)
+	j.StatusExchange = env.string("JOBS_STATUS_EXCHANGE", j.StatusExchange)
+	j.Concurrency = env.int("JOBS_CONCURRENCY", j.Concurrency)
+	j.StatusStoreCapacity = env.int("JOBS_STATUS_STORE_CAPACITY", j.StatusStoreCapacity)
+
+	a := &c.Auth
+	a.JwksPath = env.string("AUTH_JWKS_PATH", a.JwksPath)
+	a.Issuer = env.string("AUTH_JWT_ISSUER", a.Issuer)
+	a.Audience = env.string("AUTH_JWT_AUDIENCE", a.Audience)
+	a.ClientClaim = env.string("AUTH_JWT_CLIENT_CLAIM", a.ClientClaim)
+	a.MaxConcurrentJobs = env.int("AUTH_MAX_CONCURRENT_JOBS", a.MaxConcurrentJobs)
+	a.MaxFeedsPerHour = env.int("AUTH_MAX_FEEDS_PER_HOUR", a.MaxFeedsPerHour)
+	a.AdminClients = env.list("AUTH_ADMIN_CLIENTS", a.AdminClients)
+	// API keys of clients as comma separated "client:key" pairs
+	for _, pair := range env.list("AUTH_API_KEYS", nil) {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			env.errs = append(env.errs, "'AUTH_API_KEYS' should contain 'client:key' pairs")
+			break
+		}
+		a.setApiKey(parts[0], parts[1])
+	}
+
+	s := &c.Sinks
+	s.QueueWriter = env.string("QUEUE_WRITER", s.QueueWriter)
+
+	r := &s.RabbitMQ
+	r.Host = env.string("RABBITMQ_HOST", r.Host)
+	r.User = env.string("RABBITMQ_USER", r.User)
+	r.Password = env.string("RABBITMQ_PASSWORD", r.Password)
+	r.Durable = env.bool("RABBITMQ_DURABLE", r.Durable)
+	r.Persistent = env.bool("RABBITMQ_PERSISTENT", r.Persistent)
+	r.Exchange = env.string("RABBITMQ_EXCHANGE", r.Exchange)
+	r.ExchangeType = env.string("RABBITMQ_EXCHANGE_TYPE", r.ExchangeType)
+	r.RoutingKey = env.string("RABBITMQ_ROUTING_KEY", r.RoutingKey)
+	r.BatchSize = env.int("RABBITMQ_BATCH_SIZE", r.BatchSize)
+	r.BatchIntervalMs = env.int("RABBITMQ_BATCH_INTERVAL_MS", r.BatchIntervalMs)
+	r.BatchFormat = env.string("RABBITMQ_BATCH_FORMAT", r.BatchFormat)
+	r.Compression = env.string("RABBITMQ_COMPRESSION", r.Compression)
+
+	k := &s.Kafka
+	k.Brokers = env.list("KAFKA_BROKERS", k.Brokers)
+	k.ClientId = env.string("KAFKA_CLIENT_ID", k.ClientId)
+	k.Acks = env.string("KAFKA_ACKS", k.Acks)
+	k.Idempotent = env.bool("KAFKA_IDEMPOTENT", k.Idempotent)
+	k.TopicsConfigPath = env.string("KAFKA_TOPICS_CONFIG_PATH", k.TopicsConfigPath)
+
+	n := &s.Nats
+	n.Url = env.string("NATS_URL", n.Url)
+	n.Subject = env.string("NATS_SUBJECT", n.Subject)
+	n.Stream = env.string("NATS_STREAM", n.Stream)
+	n.StreamSubjects = env.list("NATS_STREAM_SUBJECTS", n.StreamSubjects)
+	n.DuplicatesWindowS = env.int("NATS_DUPLICATES_WINDOW_S", n.DuplicatesWindowS)
+	n.AckTimeoutMs = env.int("NATS_ACK_TIMEOUT_MS", n.AckTimeoutMs)
+
+	rd := &s.Redis
+	rd.Address = env.string("REDIS_ADDRESS", rd.Address)
+	rd.Username = env.string("REDIS_USERNAME", rd.Username)
+	rd.Password = env.string("REDIS_PASSWORD", rd.Password)
+	rd.DB = env.int("REDIS_DB", rd.DB)
+	rd.Stream = env.string("REDIS_STREAM", rd.Stream)
+	rd.Encoding = env.string("REDIS_ENCODING", rd.Encoding)
+	rd.MaxLen = env.int("REDIS_MAXLEN", rd.MaxLen)
+	rd.ApproximateTrim = env.bool("REDIS_APPROXIMATE_TRIM", rd.ApproximateTrim)
+
+	fw := &s.File
+	fw.Dir = env.string("FILE_WRITER_DIR", fw.Dir)
+	fw.Format = env.string("FILE_WRITER_FORMAT", fw.Format)
+	fw.Gzip = env.bool("FILE_WRITER_GZIP", fw.Gzip)
+	fw.MaxItems = env.int("FILE_WRITER_MAX_ITEMS", fw.MaxItems)
+	fw.MaxBytes = env.int("FILE_WRITER_MAX_BYTES", fw.MaxBytes)
+
+	s.Outbox.Dir = env.string("OUTBOX_DIR", s.Outbox.Dir)
+	s.Outbox.SegmentSize = env.int("OUTBOX_SEGMENT_SIZE", s.Outbox.SegmentSize)
+	s.Outbox.MaxBacklog = env.int("OUTBOX_MAX_BACKLOG", s.Outbox.MaxBacklog)
+
+	return env.err()
+}
+
+// Checks configuration, returns error listing all problems
+func (c Config) Validate() error {
+	problems := []string{}
+	if len(c.Server.Address) == 0 {
+		problems = append(problems, "server address is empty")
+	}
+	switch strings.ToLower(c.Server.Mode) {
+	case "development", "production":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown server mode %q", c.Server.Mode))
+	}
+	if c.ReloadIntervalS < 0 {
+		problems = append(problems, "reload interval can't be negative")
+	}
+	if c.Server.DrainTimeoutS < 0 || c.Server.MaxInFlightFeeds < 0 {
+		problems = append(problems, "drain timeout and maximal in-flight feeds can't be negative")
+	}
+	if c.Sinks.Outbox.MaxBacklog < 0 {
+		problems = append(problems, "maximal outbox backlog can't be negative")
+	}
+	if _, err := c.Fetcher.newFetcher(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := c.Pipeline.duplicatesPolicy(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.Pipeline.Routing != nil {
+		if _, err := itemrouter.CompileRoutes(*c.Pipeline.Routing); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid routing: %v", err))
+		}
+	}
+	profiles := make([]string, 0, len(c.Pipeline.RoutingProfiles))
+	for name := range c.Pipeline.RoutingProfiles {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	for _, name := range profiles {
+		if _, err := itemrouter.CompileRoutes(c.Pipeline.RoutingProfiles[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid routing profile %q: %v", name, err))
+		}
+	}
+	if _, err := c.Sinks.sinkConfigs(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if strings.EqualFold(c.Server.Mode, "production") && c.Sinks.fallsBackToStdout() {
+		problems = append(problems, "production server mode requires 'QUEUE_WRITER', set it to \"stdout\" to write items to standard output")
+	}
+	if c.Scheduler.Enabled && len(c.Registry.Path) == 0 {
+		problems = append(problems, "scheduler requires feed registry path")
+	}
+	if c.Scheduler.RefreshIntervalS < 0 || c.Scheduler.MaxJitterS < 0 {
+		problems = append(problems, "scheduler intervals can't be negative")
+	}
+	switch scheduler.MissedRunPolicy(c.Scheduler.MissedRunPolicy) {
+	case "", scheduler.SkipMissed, scheduler.RunOnce:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown missed run policy %q", c.Scheduler.MissedRunPolicy))
+	}
+	switch c.Jobs.Mode {
+	case "", "local":
+	case "api", "worker":
+		if len(c.Jobs.Host) == 0 && len(c.Sinks.RabbitMQ.Host) == 0 {
+			problems = append(problems, fmt.Sprintf("jobs mode %q requires RabbitMQ host", c.Jobs.Mode))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown jobs mode %q", c.Jobs.Mode))
+	}
+	if c.Scheduler.Enabled && c.Jobs.queued() {
+		// Every replica would parse every scheduled feed
+		problems = append(problems, fmt.Sprintf("scheduler can't be enabled with jobs mode %q, run it on a separate instance in local mode", c.Jobs.Mode))
+	}
+	if c.Jobs.Concurrency < 0 || c.Jobs.StatusStoreCapacity < 0 {
+		problems = append(problems, "jobs concurrency and status store capacity can't be negative")
+	}
+	problems = append(problems, c.Auth.problems()...)
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Returns settings which can't be applied without restart
+func (c Config) structural() Config {
+	c.Fetcher = FetcherConfig{}
+	c.ReloadIntervalS = 0
+	// Routing can be changed, but not moved from or to a file
+	if c.Pipeline.Routing != nil {
+		c.Pipeline.Routing = &itemrouter.RoutingConfig{}
+	}
+	return c
+}
+
+// Returns options of scheduler with these settings
+func (c SchedulerConfig) options() scheduler.SchedulerOptions {
+	return scheduler.SchedulerOptions{
+		RefreshInterval: time.Duration(c.RefreshIntervalS) * time.Second,
+		MaxJitter:       time.Duration(c.MaxJitterS) * time.Second,
+		MissedRunPolicy: scheduler.MissedRunPolicy(c.MissedRunPolicy),
+	}
+}
+
+// Checks if parse requests are enqueued to workers
+func (c JobsConfig) queued() bool {
+	return c.Mode == "api" || c.Mode == "worker"
+}
+
+// Returns options of job broker, RabbitMQ connection of sinks
+// is used when host is not set
+func (c JobsConfig) brokerOptions(sink RabbitMQConfig) rabbitbroker.RabbitBrokerOptions {
+	options := rabbitbroker.RabbitBrokerOptions{
+		Username:       c.User,
+		Password:       c.Password,
+		Hostname:       c.Host,
+		Queue:          c.Queue,
+		StatusExchange: c.StatusExchange,
+		Prefetch:       c.Concurrency,
+	}
+	if len(c.Host) == 0 {
+		options.Username = sink.User
+		options.Password = sink.Password
+		options.Hostname = sink.Host
+	}
+	return options
+}
+
+// Sets API key of client, client is added when it's not configured
+func (c *AuthConfig) setApiKey(name string, key string) {
+	for idx := range c.Clients {
+		if c.Clients[idx].Name == name {
+			c.Clients[idx].ApiKey = key
+			return
+		}
+	}
+	c.Clients = append(c.Clients, ClientConfig{Name: name, ApiKey: key})
+}
+
+// Returns problems of clients and quotas
+func (c AuthConfig) problems() []string {
+	problems := []string{}
+	if c.MaxConcurrentJobs < 0 || c.MaxFeedsPerHour < 0 {
+		problems = append(problems, "client quotas can't be negative")
+	}
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for _, client := range c.Clients {
+		switch {
+		case len(client.Name) == 0:
+			problems = append(problems, "client name is empty")
+		case names[client.Name]:
+			problems = append(problems, fmt.Sprintf("client %q is configured more than once", client.Name))
+		case len(client.ApiKey) > 0 && keys[client.ApiKey]:
+			problems = append(problems, fmt.Sprintf("API key of client %q is used by other client", client.Name))
+		case client.MaxConcurrentJobs < 0 || client.MaxFeedsPerHour < 0:
+			problems = append(problems, fmt.Sprintf("quotas of client %q can't be negative", client.Name))
+		}
+		names[client.Name] = true
+		if len(client.ApiKey) > 0 {
+			keys[client.ApiKey] = true
+		}
+	}
+	return problems
+}
+
+// Creates authenticator of API keys and JWT, nil when no API keys
+// nor JWKS file are set
+func (c AuthConfig) newAuthenticator() (auth.AuthenticatorInterface, error) {
+	authenticators := []auth.AuthenticatorInterface{}
+	keys := make(map[string]string)
+	for _, client := range c.Clients {
+		if len(client.ApiKey) > 0 {
+			keys[client.Name] = client.ApiKey
+		}
+	}
+	if len(keys) > 0 {
+		authenticators = append(authenticators, auth.NewApiKeyAuthenticator(keys))
+	}
+	if len(c.JwksPath) > 0 {
+		authenticator, err := auth.NewJwtAuthenticator(auth.JwtAuthenticatorOptions{
+			JwksPath:    c.JwksPath,
+			Issuer:      c.Issuer,
+			Audience:    c.Audience,
+			ClientClaim: c.ClientClaim,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return auth.NewChainAuthenticator(authenticators...), nil
+}
+
+// Returns client labels of metrics with configured clients
+func (c AuthConfig) clientLabels() *auth.ClientLabels {
+	names := make([]string, 0, len(c.Clients))
+	for _, client := range c.Clients {
+		names = append(names, client.Name)
+	}
+	return auth.NewClientLabels(names...)
+}
+
+// Returns options of quota limiter, false when no quotas are set
+func (c AuthConfig) quotaOptions() (auth.QuotaLimiterOptions, bool) {
+	options := auth.QuotaLimiterOptions{
+		Default: auth.Quota{
+			MaxConcurrentJobs: c.MaxConcurrentJobs,
+			MaxFeedsPerHour:   c.MaxFeedsPerHour,
+		},
+		Clients: make(map[string]auth.Quota),
+		Labels:  c.clientLabels(),
+	}
+	limited := options.Default != (auth.Quota{})
+	for _, client := range c.Clients {
+		quota := auth.Quota{
+			MaxConcurrentJobs: client.MaxConcurrentJobs,
+			MaxFeedsPerHour:   client.MaxFeedsPerHour,
+		}
+		if quota != (auth.Quota{}) {
+			options.Clients[client.Name] = quota
+			limited = true
+		}
+	}
+	return options, limited
+}
+
+// Creates feed fetcher with these settings
+func (c FetcherConfig) newFetcher() (*httpfilefetcher.HttpFileFetcher, error) {
+	policy, err := urlpolicy.NewUrlPolicy(urlpolicy.UrlPolicyOptions{
+		AllowedSchemes:  c.UrlPolicy.AllowedSchemes,
+		AllowedHosts:    c.UrlPolicy.AllowedHosts,
+		DeniedHosts:     c.UrlPolicy.DeniedHosts,
+		AllowedNetworks: c.UrlPolicy.AllowedNetworks,
+		MaxRedirects:    c.UrlPolicy.MaxRedirects,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid url policy: %w", err)
+	}
+	fetcher, err := httpfilefetcher.NewHttpFileFetcherWithOptions(
+		httpfilefetcher.HttpFileFetcherOptions{
+			Timeout:    time.Duration(c.TimeoutMs) * time.Millisecond,
+			Retries:    c.Retries,
+			RetryDelay: time.Duration(c.RetryDelayMs) * time.Millisecond,
+			RateLimit:  c.RateLimit,
+			Proxy:      c.Proxy,
+			UrlPolicy:  policy,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fetcher settings: %w", err)
+	}
+	return fetcher, nil
+}
+
+// Returns duplicates policy, deduplicator.KeepFirst when it's not set
+func (c PipelineConfig) duplicatesPolicy() (deduplicator.Policy, error) {
+	if len(c.DuplicatesPolicy) == 0 {
+		return deduplicator.KeepFirst, nil
+	}
+	return deduplicator.ParsePolicy(c.DuplicatesPolicy)
+}
@@ -0,0 +1,282 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/itemrouter"
+)
+
+func TestLoadConfigYaml(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", mockedYamlConfig)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig(), err = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(config, mockedFileConfig) {
+		t.Fatalf("LoadConfig(), got = %+v, want %+v", config, mockedFileConfig)
+	}
+}
+
+func TestLoadConfigToml(t *testing.T) {
+	path := writeConfigFile(t, "config.toml", mockedTomlConfig)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig(), err = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(config, mockedFileConfig) {
+		t.Fatalf("LoadConfig(), got = %+v, want %+v", config, mockedFileConfig)
+	}
+}
+
+func TestLoadConfigExample(t *testing.T) {
+	if _, err := LoadConfig("../config.example.yaml"); err != nil {
+		t.Fatalf("LoadConfig(), err = %v, want nil", err)
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", mockedYamlConfig)
+	t.Setenv("SERVER_ADDRESS", ":9090")
+	t.Setenv("FETCHER_RATE_LIMIT", "0.5")
+	t.Setenv("KAFKA_BROKERS", "kafka-1:9092, kafka-2:9092")
+	t.Setenv("REDIS_APPROXIMATE_TRIM", "false")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig(), err = %v, want nil", err)
+	}
+	expected := mockedFileConfig
+	expected.Server.Address = ":9090"
+	expected.Fetcher.RateLimit = 0.5
+	expected.Sinks.Kafka.Brokers = []string{"kafka-1:9092", "kafka-2:9092"}
+	expected.Sinks.Redis.ApproximateTrim = false
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("LoadConfig(), got = %+v, want %+v", config, expected)
+	}
+}
+
+func TestLoadConfigAuth(t *testing.T) {
+	t.Setenv("QUEUE_WRITER", "stdout")
+	content := "auth:\n  maxFeedsPerHour: 100\n  clients:\n" +
+		"    - name: shop_a\n      apiKey: key-a\n      maxConcurrentJobs: 2\n" +
+		"    - name: shop_b\n      maxFeedsPerHour: 500\n"
+	path := writeConfigFile(t, "config.yaml", content)
+	t.Setenv("AUTH_API_KEYS", "shop_a:key-a2,shop_c:key-c")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig(), err = %v, want nil", err)
+	}
+	expected := AuthConfig{
+		MaxFeedsPerHour: 100,
+		Clients: []ClientConfig{
+			{Name: "shop_a", ApiKey: "key-a2", MaxConcurrentJobs: 2},
+			{Name: "shop_b", MaxFeedsPerHour: 500},
+			{Name: "shop_c", ApiKey: "key-c"},
+		},
+	}
+	if !reflect.DeepEqual(config.Auth, expected) {
+		t.Fatalf("LoadConfig(), auth = %+v, want %+v", config.Auth, expected)
+	}
+
+	t.Setenv("AUTH_API_KEYS", "key-without-client")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "AUTH_API_KEYS") {
+		t.Fatalf("LoadConfig() with invalid env, err = %v, want error with AUTH_API_KEYS", err)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	t.Setenv("QUEUE_WRITER", "stdout")
+	files := map[string]string{
+		"unknown key":   "server:\n  adress: \":8080\"\n",
+		"invalid type":  "fetcher:\n  retries: many\n",
+		"invalid value": "fetcher:\n  retries: -1\n",
+		"invalid route": "pipeline:\n  routing:\n    outputs:\n      - name: cheap\n        predicate: \"price <\"\n",
+		"server mode":   "server:\n  mode: staging\n",
+		"no registry":   "scheduler:\n  enabled: true\n",
+		"missed runs":   "registry:\n  path: feeds.db\nscheduler:\n  missedRunPolicy: run_all\n",
+		"jobs mode":     "jobs:\n  mode: cluster\n",
+		"jobs broker":   "jobs:\n  mode: worker\n",
+		"queued runs":   "registry:\n  path: feeds.db\nscheduler:\n  enabled: true\njobs:\n  mode: api\n  host: rabbitmq\n",
+		"client name":   "auth:\n  clients:\n    - apiKey: secret\n",
+		"api key reuse": "auth:\n  clients:\n    - name: a\n      apiKey: secret\n    - name: b\n      apiKey: secret\n",
+		"quota":         "auth:\n  maxFeedsPerHour: -1\n",
+		"url policy":    "fetcher:\n  urlPolicy:\n    allowedNetworks: [10.0.0.0]\n",
+	}
+	for name, content := range files {
+		path := writeConfigFile(t, "config.yaml", content)
+		if _, err := LoadConfig(path); err == nil {
+			t.Fatalf("LoadConfig() with %s, err = nil, want error", name)
+		}
+	}
+
+	if _, err := LoadConfig(writeConfigFile(t, "config.ini", "")); err == nil {
+		t.Fatalf("LoadConfig() with .ini file, err = nil, want error")
+	}
+
+	t.Setenv("FETCHER_RETRIES", "many")
+	_, err := LoadConfig("")
+	if err == nil || !strings.Contains(err.Error(), "FETCHER_RETRIES") {
+		t.Fatalf("LoadConfig() with invalid env, err = %v, want error with FETCHER_RETRIES", err)
+	}
+}
+
+func TestContainerReload(t *testing.T) {
+	config := mockedConfig()
+	config.Pipeline.Routing = &itemrouter.RoutingConfig{
+		Outputs: []itemrouter.OutputConfig{{Name: "shop_items", Predicate: "true"}},
+	}
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	defer container.Close()
+
+	// Invalid configuration isn't applied
+	invalid := config
+	invalid.Fetcher.Retries = -1
+	if err := container.Reload(invalid); err == nil {
+		t.Fatalf("Reload() with invalid config, err = nil, want error")
+	}
+
+	reloaded := config
+	reloaded.Fetcher.Retries = 3
+	reloaded.Pipeline.Routing = &itemrouter.RoutingConfig{
+		Outputs: []itemrouter.OutputConfig{{Name: "all_items", Predicate: "true"}},
+	}
+	reloaded.Server.Address = ":9090"
+	if err := container.Reload(reloaded); err != nil {
+		t.Fatalf("Reload(), err = %v, want nil", err)
+	}
+
+	routes, _ := container.ParserOptions.RoutesProvider.GetRoutes()
+	if len(routes) != 1 || routes[0].Name != "all_items" {
+		t.Fatalf("Reload(), routes = %v, want all_items", routes)
+	}
+	if container.Config.Fetcher.Retries != 3 {
+		t.Fatalf("Reload(), fetcher retries = %v, want 3", container.Config.Fetcher.Retries)
+	}
+	// Server address is applied only after restart
+	if container.Config.Server.Address != DefaultServerAddress {
+		t.Fatalf("Reload(), server address = %v, want %v", container.Config.Server.Address, DefaultServerAddress)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	t.Setenv("QUEUE_WRITER", "stdout")
+	path := writeConfigFile(t, "config.yaml", "reloadIntervalS: 1\nfetcher:\n  retries: 1\n")
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig(), err = %v, want nil", err)
+	}
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	defer container.Close()
+	watcher := WatchConfig(path, container)
+	defer watcher.Close()
+
+	// Changed file is reloaded
+	os.WriteFile(path, []byte("reloadIntervalS: 1\nfetcher:\n  retries: 2\n"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for retries := 1; retries != 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("WatchConfig(), fetcher retries = %v, want 2", retries)
+		}
+		time.Sleep(100 * time.Millisecond)
+		container.reload.Lock()
+		retries = container.Config.Fetcher.Retries
+		container.reload.Unlock()
+	}
+}
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("os.WriteFile(), err = %v, want nil", err)
+	}
+	return path
+}
+
+// MOCKED DATA
+
+var mockedYamlConfig = `
+server:
+  address: ":8081"
+  mode: development
+fetcher:
+  timeoutMs: 10000
+  retries: 2
+  rateLimit: 4
+  proxy: http://proxy:3128
+pipeline:
+  duplicatesPolicy: keep_last
+  routing:
+    outputs:
+      - name: shop_items
+        predicate: "true"
+sinks:
+  queueWriter: kafka
+  kafka:
+    brokers: [kafka:9092]
+    acks: all
+`
+
+var mockedTomlConfig = `
+[server]
+address = ":8081"
+mode = "development"
+
+[fetcher]
+timeoutMs = 10000
+retries = 2
+rateLimit = 4
+proxy = "http://proxy:3128"
+
+[pipeline]
+duplicatesPolicy = "keep_last"
+
+[[pipeline.routing.outputs]]
+name = "shop_items"
+predicate = "true"
+
+[sinks]
+queueWriter = "kafka"
+
+[sinks.kafka]
+brokers = ["kafka:9092"]
+acks = "all"
+`
+
+var mockedFileConfig = Config{
+	Server: ServerConfig{Address: ":8081", Mode: "development", DrainTimeoutS: DefaultDrainTimeoutS},
+	Fetcher: FetcherConfig{
+		TimeoutMs: 10000,
+		Retries:   2,
+		RateLimit: 4,
+		Proxy:     "http://proxy:3128",
+	},
+	Pipeline: PipelineConfig{
+		DuplicatesPolicy: "keep_last",
+		Routing: &itemrouter.RoutingConfig{
+			Outputs: []itemrouter.OutputConfig{{Name: "shop_items", Predicate: "true"}},
+		},
+	},
+	Sinks: SinksConfig{
+		QueueWriter: "kafka",
+		Kafka:       KafkaConfig{Brokers: []string{"kafka:9092"}, Acks: "all"},
+		Redis:       RedisConfig{ApproximateTrim: true},
+	},
+	ReloadIntervalS: DefaultReloadIntervalS,
+}
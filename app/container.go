@@ -0,0 +1,531 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/MichalMitros/feed-parser/auth"
+	"github.com/MichalMitros/feed-parser/deduplicator"
+	"github.com/MichalMitros/feed-parser/deltadetector"
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/feedregistry"
+	"github.com/MichalMitros/feed-parser/feedregistry/boltregistry"
+	"github.com/MichalMitros/feed-parser/filefetcher"
+	"github.com/MichalMitros/feed-parser/fileparser"
+	"github.com/MichalMitros/feed-parser/fileparser/xmlparser"
+	"github.com/MichalMitros/feed-parser/itemrouter"
+	"github.com/MichalMitros/feed-parser/itemtransformer"
+	"github.com/MichalMitros/feed-parser/itemvalidator/heurekavalidator"
+	"github.com/MichalMitros/feed-parser/itemvalidator/logsink"
+	"github.com/MichalMitros/feed-parser/jobqueue"
+	"github.com/MichalMitros/feed-parser/jobqueue/rabbitbroker"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/multiwriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/outboxwriter"
+	"github.com/MichalMitros/feed-parser/scheduler"
+	"github.com/MichalMitros/feed-parser/snapshotstore/boltstore"
+	"go.uber.org/zap"
+)
+
+// Dependencies of the service created from its configuration
+type Container struct {
+	Config      Config
+	Fetcher     filefetcher.FileFetcherInterface
+	FileParser  fileparser.FeedFileParserInterface
+	QueueWriter queuewriter.QueueWriterInterface
+	// Disk outbox of queue writer, nil when it's not enabled
+	Outbox     *outboxwriter.OutboxWriter
+	FeedParser *feedparser.FeedParser
+	// Registry of managed feeds, nil when it's not enabled
+	Registry feedregistry.FeedRegistryInterface
+	// Parser of urls and registered feeds
+	Runner *FeedRunner
+	// Periodic runs of registered feeds, nil when it's not enabled
+	Scheduler *scheduler.Scheduler
+	// Enqueues parse requests to workers, nil in local jobs mode
+	Jobs *jobqueue.Dispatcher
+	// Consumer of queued jobs, nil when it's not a worker
+	Worker *jobqueue.Worker
+	// Authenticator of API clients, nil when authentication is disabled
+	Auth auth.AuthenticatorInterface
+	// Quotas of API clients, nil when no quotas are set
+	Quotas *auth.QuotaLimiter
+	// Bounded client labels of metrics
+	ClientLabels *auth.ClientLabels
+	// Pipeline stages of FeedParser
+	ParserOptions feedparser.FeedParserOptions
+
+	// Parts of the pipeline changed on reload
+	fetcher *reloadableFetcher
+	// Routes from the configuration, nil when they are read from file
+	routes *itemrouter.StaticRoutes
+	reload sync.Mutex
+
+	sinks []*pendingWriter
+	// Combined writer of many sinks, nil for a single sink
+	multi    *multiwriter.MultiWriter
+	store    *boltstore.BoltStore
+	registry *boltregistry.BoltRegistry
+	broker   *rabbitbroker.RabbitBroker
+
+	// Set when shutdown starts, accessed atomically
+	draining int32
+	// Stops worker and scheduler once, closed when they are stopped
+	stopRuns  sync.Once
+	runsEnded chan struct{}
+}
+
+// Creates all dependencies of the service. Invalid configuration
+// is returned as error, while unavailable brokers only start their
+// writers in degraded mode, see SinksStatus
+func NewContainer(config Config) (*Container, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	sinkConfigs, err := config.Sinks.sinkConfigs()
+	if err != nil {
+		return nil, err
+	}
+	if config.Sinks.fallsBackToStdout() {
+		zap.L().Warn("No queue writer configured, items are written to standard output")
+	}
+	fetcher, err := config.Fetcher.newFetcher()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := config.Pipeline.duplicatesPolicy()
+	if err != nil {
+		return nil, err
+	}
+	dedup, err := deduplicator.NewDeduplicator(
+		deduplicator.DeduplicatorOptions{Policy: policy},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duplicates policy: %w", err)
+	}
+
+	c := &Container{
+		Config:     config,
+		FileParser: xmlparser.NewXmlFeedParser(),
+		ParserOptions: feedparser.FeedParserOptions{
+			Validator:        heurekavalidator.DefaultHeurekaValidator(),
+			DiagnosticsSink:  logsink.NewLogSink(),
+			ReportSampleSize: config.Pipeline.ReportSampleSize,
+			Deduplicator:     dedup,
+		},
+		fetcher: &reloadableFetcher{fetcher: fetcher},
+	}
+	c.Fetcher = c.fetcher
+
+	// Authenticate API clients with API keys or JWT
+	authenticator, err := config.Auth.newAuthenticator()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create authenticator: %w", err)
+	}
+	c.Auth = authenticator
+	c.ClientLabels = config.Auth.clientLabels()
+	if options, limited := config.Auth.quotaOptions(); limited {
+		c.Quotas = auth.NewQuotaLimiter(options)
+	}
+
+	// Read routing outputs from file or from the configuration
+	pipeline := config.Pipeline
+	if len(pipeline.RoutingConfigPath) > 0 {
+		routes, err := itemrouter.NewFileRoutes(pipeline.RoutingConfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load routing configuration: %w", err)
+		}
+		c.ParserOptions.RoutesProvider = routes
+	} else if pipeline.Routing != nil {
+		routes, err := itemrouter.NewStaticRoutes(*pipeline.Routing)
+		if err != nil {
+			return nil, fmt.Errorf("invalid routing: %w", err)
+		}
+		c.routes = routes
+		c.ParserOptions.RoutesProvider = routes
+	}
+
+	// Read per-feed transforms from file when configured
+	if len(pipeline.TransformsConfigPath) > 0 {
+		transforms, err := itemtransformer.LoadFeedTransforms(pipeline.TransformsConfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load transforms configuration: %w", err)
+		}
+		c.ParserOptions.Transforms = transforms
+	}
+
+	// Enable delta publishing when snapshot store is configured
+	if len(pipeline.DeltaStorePath) > 0 {
+		store, err := boltstore.NewBoltStore(pipeline.DeltaStorePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open delta snapshot store: %w", err)
+		}
+		c.store = store
+		c.ParserOptions.DeltaDetector = deltadetector.NewDeltaDetector(store)
+		c.ParserOptions.SkipFullStream = pipeline.DeltaOnly
+	}
+
+	// Create queue writers, combined into MultiWriter when there are many
+	sinks := []multiwriter.Sink{}
+	for _, sink := range sinkConfigs {
+		writer := newPendingWriter(sink.name, sink.factory)
+		c.sinks = append(c.sinks, writer)
+		sinks = append(sinks, multiwriter.Sink{
+			Name:   sink.name,
+			Writer: writer,
+			Policy: sink.policy,
+		})
+	}
+	if len(sinks) == 1 && len(sinks[0].Policy) == 0 {
+		c.QueueWriter = sinks[0].Writer
+	} else {
+		multi, err := multiwriter.NewMultiWriter(sinks...)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("invalid queue writers: %w", err)
+		}
+		c.multi = multi
+		c.QueueWriter = multi
+	}
+
+	// Store items in disk outbox when the broker is unavailable
+	if outboxConfig := config.Sinks.Outbox; len(outboxConfig.Dir) > 0 {
+		outbox, err := outboxwriter.NewOutboxWriter(
+			c.QueueWriter,
+			outboxwriter.OutboxWriterOptions{
+				Dir:         outboxConfig.Dir,
+				SegmentSize: int64(outboxConfig.SegmentSize),
+			},
+		)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("cannot open outbox: %w", err)
+		}
+		c.Outbox = outbox
+		c.QueueWriter = outbox
+	}
+
+	c.FeedParser = feedparser.NewFeedParserWithOptions(
+		c.Fetcher,
+		c.FileParser,
+		c.QueueWriter,
+		c.ParserOptions,
+	)
+
+	// Open feed registry when configured
+	if len(config.Registry.Path) > 0 {
+		registry, err := boltregistry.NewBoltRegistry(config.Registry.Path)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("cannot open feed registry: %w", err)
+		}
+		c.registry = registry
+		c.Registry = registry
+	}
+	profiles := make(map[string]itemrouter.RoutesProviderInterface)
+	for name, routing := range pipeline.RoutingProfiles {
+		routes, err := itemrouter.NewStaticRoutes(routing)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("invalid routing profile %q: %w", name, err)
+		}
+		profiles[name] = routes
+	}
+	c.Runner = NewFeedRunner(c.FeedParser, c.Registry, profiles)
+
+	// Start scheduled runs of registered feeds
+	if config.Scheduler.Enabled {
+		feedScheduler, err := scheduler.NewScheduler(
+			c.Registry,
+			c.Runner,
+			config.Scheduler.options(),
+		)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("cannot start scheduler: %w", err)
+		}
+		c.Scheduler = feedScheduler
+	}
+
+	// Enqueue parse requests and consume them in worker mode
+	if config.Jobs.queued() {
+		c.broker = rabbitbroker.NewRabbitBroker(
+			config.Jobs.brokerOptions(config.Sinks.RabbitMQ),
+		)
+		dispatcher, err := jobqueue.NewDispatcher(
+			c.broker,
+			jobqueue.DispatcherOptions{StatusStoreCapacity: config.Jobs.StatusStoreCapacity},
+		)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.Jobs = dispatcher
+	}
+	if config.Jobs.Mode == "worker" {
+		worker, err := jobqueue.NewWorker(
+			c.broker,
+			c.Runner,
+			jobqueue.WorkerOptions{Concurrency: config.Jobs.Concurrency},
+		)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.Worker = worker
+	}
+	return c, nil
+}
+
+// Applies fetcher settings and routing of reloaded configuration.
+// Other changed settings are logged and applied only after restart.
+// Invalid configuration is returned as error and nothing is applied
+func (c *Container) Reload(config Config) error {
+	defer zap.L().Sync()
+
+	c.reload.Lock()
+	defer c.reload.Unlock()
+
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	fetcher, err := config.Fetcher.newFetcher()
+	if err != nil {
+		return err
+	}
+	if c.routes != nil && config.Pipeline.Routing != nil {
+		if err := c.routes.Update(*config.Pipeline.Routing); err != nil {
+			return fmt.Errorf("invalid routing: %w", err)
+		}
+		c.Config.Pipeline.Routing = config.Pipeline.Routing
+	}
+	c.fetcher.set(fetcher)
+	c.Config.Fetcher = config.Fetcher
+	c.Config.ReloadIntervalS = config.ReloadIntervalS
+
+	if !reflect.DeepEqual(config.structural(), c.Config.structural()) {
+		zap.L().Warn("Configuration changes of server, sinks and pipeline stages require restart")
+	}
+	zap.L().Info("Configuration reloaded")
+	return nil
+}
+
+// Checks feed url with url policy of the current fetcher settings,
+// returns error wrapping urlpolicy.ErrForbiddenUrl for forbidden urls
+func (c *Container) CheckFeedUrl(url string) error {
+	return c.fetcher.CheckUrl(url)
+}
+
+// Stops taking new parse requests, stops worker and scheduler and waits
+// until feeds being parsed finish. Returns error when ctx is done first,
+// unfinished queued jobs are then redelivered to other workers after
+// Close. Drain doesn't close anything, Close has to be called after it
+func (c *Container) Drain(ctx context.Context) error {
+	defer zap.L().Sync()
+
+	atomic.StoreInt32(&c.draining, 1)
+	c.Runner.StopAccepting()
+	zap.L().Info("Draining feeds being parsed", zap.Strings("feedUrls", c.Runner.InFlight()))
+
+	err := c.stopBackgroundRuns(ctx)
+	if err == nil {
+		err = c.Runner.Wait(ctx)
+	}
+	if err != nil {
+		zap.L().Warn(
+			"Feeds not parsed before drain timeout",
+			zap.Strings("feedUrls", c.Runner.InFlight()),
+			zap.Error(err),
+		)
+		return err
+	}
+	zap.L().Info("All feeds parsed")
+	return nil
+}
+
+// Checks if shutdown has started
+func (c *Container) Draining() bool {
+	return atomic.LoadInt32(&c.draining) == 1
+}
+
+// Stops worker and scheduler and waits for their runs until ctx is done
+func (c *Container) stopBackgroundRuns(ctx context.Context) error {
+	c.stopRuns.Do(func() {
+		c.runsEnded = make(chan struct{})
+		go func() {
+			defer close(c.runsEnded)
+			if c.Worker != nil {
+				c.Worker.Close()
+			}
+			if c.Scheduler != nil {
+				c.Scheduler.Close()
+			}
+		}()
+	})
+	select {
+	case <-c.runsEnded:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Creates FeedParser publishing to writer with the same stages
+// as FeedParser but without delta detection, so it doesn't change
+// stored snapshots
+func (c *Container) NewPreviewParser(
+	writer queuewriter.QueueWriterInterface,
+) *feedparser.FeedParser {
+	options := c.ParserOptions
+	options.DeltaDetector = nil
+	options.SkipFullStream = false
+	return feedparser.NewFeedParserWithOptions(
+		c.Fetcher,
+		c.FileParser,
+		writer,
+		options,
+	)
+}
+
+// Returns readiness of all queue writers
+func (c *Container) SinksStatus() []models.SinkStatus {
+	statuses := []models.SinkStatus{}
+	for _, sink := range c.sinks {
+		status := models.SinkStatus{
+			Name:  sink.name,
+			Ready: sink.IsConnected(),
+		}
+		if err := sink.Err(); err != nil {
+			status.Error = err.Error()
+		} else if !status.Ready {
+			status.Error = "not connected"
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Returns number of items waiting in the outbox and false
+// when the outbox is not enabled
+func (c *Container) OutboxBacklog() (int, bool) {
+	if c.Outbox == nil {
+		return 0, false
+	}
+	return c.Outbox.Backlog(), true
+}
+
+// Returns checks of dependencies deciding readiness of the service:
+// queue writers, outbox backlog, job broker and saturation of parsing
+func (c *Container) HealthChecks() []models.HealthCheck {
+	checks := []models.HealthCheck{}
+	backlog, outbox := c.OutboxBacklog()
+
+	for _, sink := range c.SinksStatus() {
+		check := models.HealthCheck{Name: "sink:" + sink.Name, Status: models.CheckPass}
+		if !sink.Ready {
+			check.Status = models.CheckFail
+			check.Message = sink.Error
+			// Items are stored in the outbox until the sink recovers
+			if outbox {
+				check.Status = models.CheckWarn
+			}
+		}
+		checks = append(checks, check)
+	}
+
+	if outbox {
+		check := models.HealthCheck{
+			Name:    "outbox",
+			Status:  models.CheckPass,
+			Message: fmt.Sprintf("%d items waiting", backlog),
+		}
+		if backlog > 0 {
+			check.Status = models.CheckWarn
+		}
+		if limit := c.Config.Sinks.Outbox.MaxBacklog; limit > 0 && backlog >= limit {
+			check.Status = models.CheckFail
+			check.Message = fmt.Sprintf("%d items waiting, limit is %d", backlog, limit)
+		}
+		checks = append(checks, check)
+	}
+
+	if c.broker != nil {
+		check := models.HealthCheck{Name: "job_broker", Status: models.CheckPass}
+		if !c.broker.IsConnected() {
+			check.Status = models.CheckFail
+			check.Message = "not connected"
+		}
+		checks = append(checks, check)
+	}
+
+	// Saturated worker still takes jobs from the queue, only requests
+	// parsed by this instance make it not ready
+	if c.Worker != nil {
+		busy, size := c.Worker.Load()
+		check := models.HealthCheck{
+			Name:    "worker_pool",
+			Status:  models.CheckPass,
+			Message: fmt.Sprintf("%d of %d jobs being parsed", busy, size),
+		}
+		if busy >= size {
+			check.Status = models.CheckWarn
+		}
+		checks = append(checks, check)
+	}
+	inFlight := len(c.Runner.InFlight())
+	check := models.HealthCheck{
+		Name:    "feeds",
+		Status:  models.CheckPass,
+		Message: fmt.Sprintf("%d feeds being parsed", inFlight),
+	}
+	if limit := c.Config.Server.MaxInFlightFeeds; limit > 0 && inFlight >= limit {
+		check.Status = models.CheckFail
+		check.Message = fmt.Sprintf("%d feeds being parsed, limit is %d", inFlight, limit)
+	}
+	return append(checks, check)
+}
+
+// Stops worker and scheduler and closes outbox, queue writers,
+// snapshot store, feed registry and job broker
+func (c *Container) Close() error {
+	var err error
+	keepFirst := func(closeErr error) {
+		if closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	// Jobs and scheduled runs in progress finish before their writers
+	// are closed, unless they already had their drain timeout
+	if c.Draining() {
+		expired, cancel := context.WithCancel(context.Background())
+		cancel()
+		c.stopBackgroundRuns(expired)
+	} else {
+		c.stopBackgroundRuns(context.Background())
+	}
+	if c.Outbox != nil {
+		keepFirst(c.Outbox.Close())
+	}
+	// MultiWriter waits for its background writes before closing sinks
+	if c.multi != nil {
+		keepFirst(c.multi.Close())
+	} else {
+		for _, sink := range c.sinks {
+			keepFirst(sink.Close())
+		}
+	}
+	if c.store != nil {
+		keepFirst(c.store.Close())
+	}
+	if c.registry != nil {
+		keepFirst(c.registry.Close())
+	}
+	if c.broker != nil {
+		keepFirst(c.broker.Close())
+	}
+	return err
+}
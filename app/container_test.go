@@ -0,0 +1,434 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/outboxwriter"
+	"github.com/MichalMitros/feed-parser/urlpolicy"
+)
+
+func TestNewContainer(t *testing.T) {
+	config := mockedConfig()
+	config.Sinks = SinksConfig{
+		QueueWriter: "stdout,file:best_effort",
+		File:        FileConfig{Dir: t.TempDir()},
+		Outbox:      OutboxConfig{Dir: t.TempDir()},
+	}
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	defer container.Close()
+
+	if container.FeedParser == nil {
+		t.Fatalf("NewContainer(), FeedParser = nil, want FeedParser")
+	}
+	if _, ok := container.QueueWriter.(*outboxwriter.OutboxWriter); !ok {
+		t.Fatalf("NewContainer(), QueueWriter = %T, want *outboxwriter.OutboxWriter", container.QueueWriter)
+	}
+	if backlog, enabled := container.OutboxBacklog(); backlog != 0 || !enabled {
+		t.Fatalf("OutboxBacklog(), got = (%v, %v), want (0, true)", backlog, enabled)
+	}
+	expectedStatuses := []models.SinkStatus{
+		{Name: "stdout", Ready: true},
+		{Name: "file", Ready: true},
+	}
+	if statuses := container.SinksStatus(); !equalStatuses(statuses, expectedStatuses) {
+		t.Fatalf("SinksStatus(), got = %v, want %v", statuses, expectedStatuses)
+	}
+}
+
+func TestNewContainerDefaultWriter(t *testing.T) {
+	// Production mode requires explicit queue writer
+	if _, err := NewContainer(DefaultConfig()); err == nil {
+		t.Fatalf("NewContainer() in production mode without writer, err = nil, want error")
+	}
+
+	config := DefaultConfig()
+	config.Server.Mode = "development"
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	defer container.Close()
+
+	if _, ok := container.QueueWriter.(*pendingWriter); !ok {
+		t.Fatalf("NewContainer(), QueueWriter = %T, want single *pendingWriter", container.QueueWriter)
+	}
+	if _, enabled := container.OutboxBacklog(); enabled {
+		t.Fatalf("OutboxBacklog(), enabled = true, want false")
+	}
+	expectedStatuses := []models.SinkStatus{{Name: "stdout", Ready: true}}
+	if statuses := container.SinksStatus(); !equalStatuses(statuses, expectedStatuses) {
+		t.Fatalf("SinksStatus(), got = %v, want %v", statuses, expectedStatuses)
+	}
+}
+
+func TestNewContainerInvalidConfig(t *testing.T) {
+	changes := map[string]func(config *Config){
+		"unknown writer":       func(c *Config) { c.Sinks.QueueWriter = "carrier_pigeon" },
+		"missing file dir":     func(c *Config) { c.Sinks.QueueWriter = "file" },
+		"missing rabbit creds": func(c *Config) { c.Sinks.QueueWriter, c.Sinks.RabbitMQ.Host = "", "localhost" },
+		"unknown policy":       func(c *Config) { c.Sinks.QueueWriter = "stdout:sometimes" },
+		"duplicated writer":    func(c *Config) { c.Sinks.QueueWriter = "stdout,stdout" },
+		"file format": func(c *Config) {
+			c.Sinks.QueueWriter, c.Sinks.File = "file", FileConfig{Dir: "items", Format: "xml"}
+		},
+		"kafka acks": func(c *Config) {
+			c.Sinks.QueueWriter, c.Sinks.Kafka = "kafka", KafkaConfig{Brokers: []string{"kafka:9092"}, Acks: "some"}
+		},
+		"kafka idempotent acks": func(c *Config) {
+			c.Sinks.QueueWriter = "kafka"
+			c.Sinks.Kafka = KafkaConfig{Brokers: []string{"kafka:9092"}, Acks: "leader", Idempotent: true}
+		},
+		"rabbit routing key": func(c *Config) {
+			c.Sinks.QueueWriter = "rabbitmq"
+			c.Sinks.RabbitMQ = RabbitMQConfig{Host: "rabbitmq", User: "guest", Password: "guest", RoutingKey: "{queue}"}
+		},
+		"rabbit batch format": func(c *Config) {
+			c.Sinks.QueueWriter = "rabbitmq"
+			c.Sinks.RabbitMQ = RabbitMQConfig{Host: "rabbitmq", User: "guest", Password: "guest", BatchFormat: "xml"}
+		},
+		"nats subject": func(c *Config) {
+			c.Sinks.QueueWriter, c.Sinks.Nats = "nats", NatsConfig{Url: "nats://nats", Subject: "{unknown}"}
+		},
+		"redis encoding": func(c *Config) {
+			c.Sinks.QueueWriter, c.Sinks.Redis = "redis", RedisConfig{Address: "redis:6379", Encoding: "xml"}
+		},
+		"duplicates policy": func(c *Config) { c.Pipeline.DuplicatesPolicy = "keep_some" },
+		"routing config":    func(c *Config) { c.Pipeline.RoutingConfigPath = "not-existing.json" },
+		"jwks file":         func(c *Config) { c.Auth.JwksPath = "not-existing.json" },
+	}
+	for name, change := range changes {
+		config := mockedConfig()
+		change(&config)
+		if container, err := NewContainer(config); err == nil {
+			container.Close()
+			t.Fatalf("NewContainer() with %s, err = nil, want error", name)
+		}
+	}
+}
+
+func TestNewContainerAuth(t *testing.T) {
+	container, err := NewContainer(mockedConfig())
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	if container.Auth != nil || container.Quotas != nil {
+		t.Fatalf("NewContainer(), auth = %v, quotas = %v, want both nil", container.Auth, container.Quotas)
+	}
+	container.Close()
+
+	config := mockedConfig()
+	config.Auth.Clients = []ClientConfig{{Name: "shop_a", ApiKey: "key-a", MaxConcurrentJobs: 1}}
+	container, err = NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	defer container.Close()
+
+	request := httptest.NewRequest("POST", "/parse-feed", nil)
+	request.Header.Set("X-API-Key", "key-a")
+	if client, err := container.Auth.Authenticate(request); client != "shop_a" || err != nil {
+		t.Fatalf("Auth.Authenticate(), got = %q, %v, want shop_a, nil", client, err)
+	}
+	if _, err := container.Quotas.Acquire("shop_a", 1); err != nil {
+		t.Fatalf("Quotas.Acquire(), err = %v, want nil", err)
+	}
+	if _, err := container.Quotas.Acquire("shop_a", 1); err == nil {
+		t.Fatalf("Quotas.Acquire() over quota, err = nil, want error")
+	}
+}
+
+func TestContainerCheckFeedUrl(t *testing.T) {
+	config := mockedConfig()
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	defer container.Close()
+
+	if err := container.CheckFeedUrl("http://10.0.0.1/feed.xml"); !errors.Is(err, urlpolicy.ErrForbiddenUrl) {
+		t.Fatalf("CheckFeedUrl(), err = %v, want %v", err, urlpolicy.ErrForbiddenUrl)
+	}
+
+	// Url policy is applied on reload
+	config.Fetcher.UrlPolicy.AllowedNetworks = []string{"10.0.0.0/8"}
+	if err := container.Reload(config); err != nil {
+		t.Fatalf("Reload(), err = %v, want nil", err)
+	}
+	if err := container.CheckFeedUrl("http://10.0.0.1/feed.xml"); err != nil {
+		t.Fatalf("CheckFeedUrl() after reload, err = %v, want nil", err)
+	}
+}
+
+func TestNewContainerDegradedSink(t *testing.T) {
+	config := mockedConfig()
+	config.Sinks.QueueWriter = "nats"
+	config.Sinks.Nats.Url = "nats://127.0.0.1:1"
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+
+	statuses := container.SinksStatus()
+	if len(statuses) != 1 || statuses[0].Ready || len(statuses[0].Error) == 0 {
+		t.Fatalf("SinksStatus(), got = %v, want not ready nats sink with error", statuses)
+	}
+
+	// Writes fail without blocking the pipeline
+	items := itemsChannel(mockedItems)
+	err = container.QueueWriter.WriteToQueue("shop_items", mockedMetadata, items)
+	if err == nil || !strings.Contains(err.Error(), "sink nats is not ready") {
+		t.Fatalf("WriteToQueue(), err = %v, want sink not ready error", err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("WriteToQueue(), items left = %v, want 0", len(items))
+	}
+
+	// Close stops creation retries
+	closed := make(chan error)
+	go func() { closed <- container.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close(), err = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close(), didn't return after 5s")
+	}
+}
+
+func TestNewContainerUnavailableJobBroker(t *testing.T) {
+	config := mockedConfig()
+	config.Sinks.QueueWriter = "stdout"
+	config.Jobs.Mode = "worker"
+	config.Jobs.Host = "127.0.0.1:1"
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	if container.Jobs == nil || container.Worker == nil {
+		t.Fatalf("NewContainer(), jobs = %v, worker = %v, want both", container.Jobs, container.Worker)
+	}
+
+	// Requests can't be enqueued until the broker is reachable
+	if _, err := container.Jobs.Enqueue("client_a", []string{"https://example.com/feed.xml"}, nil); err == nil {
+		t.Fatalf("Jobs.Enqueue(), err = nil, want error")
+	}
+	container.Close()
+}
+
+func TestContainerDrain(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte(mockedFeedXml))
+	}))
+	defer server.Close()
+
+	config := mockedConfig()
+	config.Sinks.QueueWriter = "file"
+	config.Sinks.File.Dir = t.TempDir()
+	config.Server.MaxInFlightFeeds = 1
+	config.Fetcher.UrlPolicy.AllowedNetworks = []string{"127.0.0.0/8"}
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	defer container.Close()
+
+	parsed := make(chan []models.FeedParsingResult)
+	go func() {
+		parsed <- container.Runner.Run([]feedparser.FeedSource{{Url: server.URL}})
+	}()
+	<-started
+
+	// Parsing at the limit of feeds makes the service not ready
+	if checks := checkStatuses(container.HealthChecks()); checks["feeds"] != models.CheckFail {
+		t.Fatalf("HealthChecks(), feeds = %v, want %v", checks["feeds"], models.CheckFail)
+	}
+
+	// Feed being parsed past the timeout is reported
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := container.Drain(ctx); err == nil {
+		t.Fatalf("Drain(), err = nil, want timeout error")
+	}
+	if !container.Draining() {
+		t.Fatalf("Draining(), got = false, want true")
+	}
+	if inFlight := container.Runner.InFlight(); len(inFlight) != 1 || inFlight[0] != server.URL {
+		t.Fatalf("Runner.InFlight(), got = %v, want [%v]", inFlight, server.URL)
+	}
+
+	// Drain returns when the feed is parsed
+	close(release)
+	if err := container.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain(), err = %v, want nil", err)
+	}
+	if results := <-parsed; results[0].Status != models.ParsedSuccessfully {
+		t.Fatalf("Runner.Run(), status = %v, want %v", results[0].Status, models.ParsedSuccessfully)
+	}
+}
+
+func TestContainerHealthChecks(t *testing.T) {
+	config := mockedConfig()
+	config.Sinks.QueueWriter = "nats"
+	config.Sinks.Nats.Url = "nats://127.0.0.1:1"
+	config.Jobs.Mode = "worker"
+	config.Jobs.Host = "127.0.0.1:1"
+	config.Server.MaxInFlightFeeds = 1
+	config.Fetcher.UrlPolicy.AllowedNetworks = []string{"127.0.0.0/8"}
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	defer container.Close()
+
+	// Unavailable broker and sink fail readiness
+	expected := map[string]models.CheckStatus{
+		"sink:nats":   models.CheckFail,
+		"job_broker":  models.CheckFail,
+		"worker_pool": models.CheckPass,
+		"feeds":       models.CheckPass,
+	}
+	if checks := checkStatuses(container.HealthChecks()); !reflect.DeepEqual(checks, expected) {
+		t.Fatalf("HealthChecks(), got = %v, want %v", checks, expected)
+	}
+}
+
+func TestContainerHealthChecksOutbox(t *testing.T) {
+	config := mockedConfig()
+	config.Sinks.QueueWriter = "nats"
+	config.Sinks.Nats.Url = "nats://127.0.0.1:1"
+	config.Sinks.Outbox = OutboxConfig{Dir: t.TempDir(), MaxBacklog: 2}
+	container, err := NewContainer(config)
+	if err != nil {
+		t.Fatalf("NewContainer(), err = %v, want nil", err)
+	}
+	defer container.Close()
+
+	// Unavailable sink only degrades the service while items fit the outbox
+	expected := map[string]models.CheckStatus{
+		"sink:nats": models.CheckWarn,
+		"outbox":    models.CheckPass,
+		"feeds":     models.CheckPass,
+	}
+	if checks := checkStatuses(container.HealthChecks()); !reflect.DeepEqual(checks, expected) {
+		t.Fatalf("HealthChecks(), got = %v, want %v", checks, expected)
+	}
+
+	container.QueueWriter.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems))
+	expected["outbox"] = models.CheckFail
+	if checks := checkStatuses(container.HealthChecks()); !reflect.DeepEqual(checks, expected) {
+		t.Fatalf("HealthChecks() with full outbox, got = %v, want %v", checks, expected)
+	}
+}
+
+func TestPendingWriterRetry(t *testing.T) {
+	attempts := 0
+	writer := newPendingWriter("retried", func() (queuewriter.QueueWriterInterface, error) {
+		attempts++
+		if attempts < 2 {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return &MockedQueueWriter{}, nil
+	})
+	defer writer.Close()
+
+	if writer.IsConnected() {
+		t.Fatalf("IsConnected() before retry, got = true, want false")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !writer.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatalf("IsConnected() after retry, got = false, want true")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := writer.Err(); err != nil {
+		t.Fatalf("Err(), got = %v, want nil", err)
+	}
+	if err := writer.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("WriteToQueue(), err = %v, want nil", err)
+	}
+}
+
+// Returns statuses of checks by their names
+func checkStatuses(checks []models.HealthCheck) map[string]models.CheckStatus {
+	statuses := make(map[string]models.CheckStatus)
+	for _, check := range checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func equalStatuses(statuses []models.SinkStatus, expected []models.SinkStatus) bool {
+	if len(statuses) != len(expected) {
+		return false
+	}
+	for idx := range statuses {
+		if statuses[idx] != expected[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	channel := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		channel <- item
+	}
+	close(channel)
+	return channel
+}
+
+// MOCKED DATA
+
+// Default configuration with stdout writer, which production mode requires explicitly
+func mockedConfig() Config {
+	config := DefaultConfig()
+	config.Sinks.QueueWriter = "stdout"
+	return config
+}
+
+// Queue writer draining its input
+type MockedQueueWriter struct{}
+
+func (w *MockedQueueWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItems chan models.ShopItem,
+) error {
+	for range shopItems {
+	}
+	return nil
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	ShopId:        "example.com",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	SchemaVersion: models.SchemaVersion,
+}
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "1", ProductName: "Product 1"},
+	{ItemID: "2", ProductName: "Product 2"},
+}
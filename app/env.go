@@ -0,0 +1,97 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Reader of environment variables collecting errors of invalid values,
+// so all of them are reported at once
+type envReader struct {
+	errs []string
+}
+
+// Returns variable or defaultValue when it's not set
+func (r *envReader) string(key string, defaultValue string) string {
+	value, isSet := os.LookupEnv(key)
+	if !isSet {
+		return defaultValue
+	}
+	return value
+}
+
+// Returns integer variable or defaultValue when it's not set
+func (r *envReader) int(key string, defaultValue int) int {
+	value, isSet := os.LookupEnv(key)
+	if !isSet {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		r.errs = append(r.errs, fmt.Sprintf("'%s' is not an integer", key))
+		return defaultValue
+	}
+	return parsed
+}
+
+// Returns floating point variable or defaultValue when it's not set
+func (r *envReader) float(key string, defaultValue float64) float64 {
+	value, isSet := os.LookupEnv(key)
+	if !isSet {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Sprintf("'%s' is not a number", key))
+		return defaultValue
+	}
+	return parsed
+}
+
+// Returns boolean variable ("true" or "false", not case-sensitive)
+// or defaultValue when it's not set
+func (r *envReader) bool(key string, defaultValue bool) bool {
+	value, isSet := os.LookupEnv(key)
+	if !isSet {
+		return defaultValue
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	r.errs = append(r.errs, fmt.Sprintf("'%s' is not a boolean", key))
+	return defaultValue
+}
+
+// Returns comma separated list variable without empty elements,
+// defaultValue when it's not set
+func (r *envReader) list(key string, defaultValue []string) []string {
+	value, isSet := os.LookupEnv(key)
+	if !isSet {
+		return defaultValue
+	}
+	return splitList(value)
+}
+
+// Returns error with all invalid variables, nil when all are valid
+func (r *envReader) err() error {
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid environment variables: %s", strings.Join(r.errs, ", "))
+}
+
+// Splits comma separated list, skipping empty elements
+func splitList(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+	return values
+}
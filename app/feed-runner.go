@@ -0,0 +1,275 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/feedregistry"
+	"github.com/MichalMitros/feed-parser/itemrouter"
+	"github.com/MichalMitros/feed-parser/models"
+	"go.uber.org/zap"
+)
+
+// Parses ad-hoc urls and registered feeds, results of registered
+// feeds are stored in the registry. Every registered feed is parsed
+// at most once at a time
+type FeedRunner struct {
+	parser *feedparser.FeedParser
+	// Feed registry, nil when it's not enabled
+	registry feedregistry.FeedRegistryInterface
+	// Routes of routing profiles by name
+	profiles map[string]itemrouter.RoutesProviderInterface
+
+	mutex sync.Mutex
+	// Ids of registered feeds being parsed
+	running map[string]bool
+	// Urls of all feeds being parsed with number of their runs
+	inFlight map[string]int
+	// Closed when the last run finishes, nil when nobody waits
+	idle chan struct{}
+	// Runs are not started anymore
+	draining bool
+}
+
+// Returned by Start after StopAccepting
+var ErrDraining = errors.New("feed runner is draining")
+
+// Creates new FeedRunner instance, registry may be nil
+func NewFeedRunner(
+	parser *feedparser.FeedParser,
+	registry feedregistry.FeedRegistryInterface,
+	profiles map[string]itemrouter.RoutesProviderInterface,
+) *FeedRunner {
+	return &FeedRunner{
+		parser:   parser,
+		registry: registry,
+		profiles: profiles,
+		running:  make(map[string]bool),
+		inFlight: make(map[string]int),
+	}
+}
+
+// Returns sources of registered feeds, error lists unknown
+// and disabled feeds
+func (r *FeedRunner) Resolve(feedIds []string) ([]feedparser.FeedSource, error) {
+	if len(feedIds) == 0 {
+		return nil, nil
+	}
+	if r.registry == nil {
+		return nil, fmt.Errorf("feed registry is not enabled")
+	}
+
+	sources := []feedparser.FeedSource{}
+	problems := []string{}
+	for _, id := range feedIds {
+		feed, err := r.registry.Get(id)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("feed %s: %v", id, err))
+			continue
+		}
+		if !feed.Enabled {
+			problems = append(problems, fmt.Sprintf("feed %s is disabled", id))
+			continue
+		}
+		source, err := r.Source(*feed)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("feed %s: %v", id, err))
+			continue
+		}
+		sources = append(sources, source)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("cannot parse feeds: %s", strings.Join(problems, ", "))
+	}
+	return sources, nil
+}
+
+// Checks if feed can be registered, error when its routing
+// profile is unknown
+func (r *FeedRunner) Validate(feed models.Feed) error {
+	if len(feed.RoutingProfile) > 0 {
+		if _, ok := r.profiles[feed.RoutingProfile]; !ok {
+			return fmt.Errorf("unknown routing profile %q", feed.RoutingProfile)
+		}
+	}
+	return nil
+}
+
+// Returns source of registered feed, error when its routing
+// profile is unknown or it has credentials
+func (r *FeedRunner) Source(feed models.Feed) (feedparser.FeedSource, error) {
+	source := feedparser.FeedSource{
+		Url:    feed.Url,
+		FeedId: feed.Id,
+		ShopId: feed.ShopId,
+	}
+	// Fetcher can't use credentials yet, feed would be fetched without them
+	if len(feed.CredentialsRef) > 0 {
+		return source, fmt.Errorf(
+			"credentialsRef %q can't be resolved, fetching feeds with credentials isn't supported yet",
+			feed.CredentialsRef,
+		)
+	}
+	if len(feed.RoutingProfile) > 0 {
+		routes, ok := r.profiles[feed.RoutingProfile]
+		if !ok {
+			return source, fmt.Errorf("unknown routing profile %q", feed.RoutingProfile)
+		}
+		source.RoutesProvider = routes
+	}
+	return source, nil
+}
+
+// Parses feeds concurently and stores results of registered feeds.
+// Registered feeds already being parsed are not parsed again,
+// their results have ParsingInProgress status
+func (r *FeedRunner) Run(sources []feedparser.FeedSource) []models.FeedParsingResult {
+	r.mutex.Lock()
+	r.begin(sources)
+	r.mutex.Unlock()
+	return r.run(sources)
+}
+
+// Marks feeds as being parsed, so Wait waits for them, and returns
+// function parsing them like Run, which has to be called exactly once.
+// Returns ErrDraining after StopAccepting
+func (r *FeedRunner) Start(sources []feedparser.FeedSource) (func() []models.FeedParsingResult, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.draining {
+		return nil, ErrDraining
+	}
+	r.begin(sources)
+	return func() []models.FeedParsingResult {
+		return r.run(sources)
+	}, nil
+}
+
+// Makes Start reject new runs, feeds started before are still
+// waited for by Wait
+func (r *FeedRunner) StopAccepting() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.draining = true
+}
+
+// Parses feeds marked as being parsed by begin
+func (r *FeedRunner) run(sources []feedparser.FeedSource) []models.FeedParsingResult {
+	defer zap.L().Sync()
+	defer r.end(sources)
+
+	results := make([]models.FeedParsingResult, len(sources))
+	started := []feedparser.FeedSource{}
+	startedIdx := []int{}
+	for idx, source := range sources {
+		if !r.acquire(source.FeedId) {
+			zap.L().Info("Feed is already being parsed", zap.String("feedId", source.FeedId))
+			results[idx] = models.FeedParsingResult{
+				FeedUrl: source.Url,
+				FeedId:  source.FeedId,
+				Status:  models.ParsingInProgress,
+			}
+			continue
+		}
+		started = append(started, source)
+		startedIdx = append(startedIdx, idx)
+	}
+
+	for idx, result := range r.parser.ParseFeedSources(started) {
+		results[startedIdx[idx]] = result
+		r.release(result.FeedId)
+		if len(result.FeedId) == 0 || r.registry == nil {
+			continue
+		}
+		if err := r.registry.SaveResult(result.FeedId, result); err != nil {
+			zap.L().Error(
+				"Cannot store result of registered feed",
+				zap.String("feedId", result.FeedId),
+				zap.Error(err),
+			)
+		}
+	}
+	return results
+}
+
+// Marks registered feed as running,
+// returns false when it's already running
+func (r *FeedRunner) acquire(feedId string) bool {
+	if len(feedId) == 0 {
+		return true
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.running[feedId] {
+		return false
+	}
+	r.running[feedId] = true
+	return true
+}
+
+// Marks registered feed as not running
+func (r *FeedRunner) release(feedId string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.running, feedId)
+}
+
+// Waits until all feeds are parsed, returns error when ctx is done first
+func (r *FeedRunner) Wait(ctx context.Context) error {
+	r.mutex.Lock()
+	if len(r.inFlight) == 0 {
+		r.mutex.Unlock()
+		return nil
+	}
+	if r.idle == nil {
+		r.idle = make(chan struct{})
+	}
+	idle := r.idle
+	r.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns urls of feeds being parsed
+func (r *FeedRunner) InFlight() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	urls := make([]string, 0, len(r.inFlight))
+	for url := range r.inFlight {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// Marks feeds as being parsed, has to be called with locked mutex
+func (r *FeedRunner) begin(sources []feedparser.FeedSource) {
+	for _, source := range sources {
+		r.inFlight[source.Url]++
+	}
+}
+
+// Marks feeds as parsed and wakes up Wait after the last one
+func (r *FeedRunner) end(sources []feedparser.FeedSource) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, source := range sources {
+		if r.inFlight[source.Url]--; r.inFlight[source.Url] <= 0 {
+			delete(r.inFlight, source.Url)
+		}
+	}
+	if len(r.inFlight) == 0 && r.idle != nil {
+		close(r.idle)
+		r.idle = nil
+	}
+}
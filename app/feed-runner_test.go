@@ -0,0 +1,182 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/feedregistry/boltregistry"
+	"github.com/MichalMitros/feed-parser/filefetcher/httpfilefetcher"
+	"github.com/MichalMitros/feed-parser/fileparser/xmlparser"
+	"github.com/MichalMitros/feed-parser/itemrouter"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter/memorywriter"
+)
+
+func TestFeedRunner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mockedFeedXml))
+	}))
+	defer server.Close()
+
+	registry, err := boltregistry.NewBoltRegistry(filepath.Join(t.TempDir(), "feeds.db"))
+	if err != nil {
+		t.Fatalf("boltregistry.NewBoltRegistry(path), err = %v, want nil", err)
+	}
+	defer registry.Close()
+	enabled, _ := registry.Create(models.Feed{Url: server.URL, ShopId: "shop_1", RoutingProfile: "all", Enabled: true})
+	disabled, _ := registry.Create(models.Feed{Url: server.URL})
+	unknownProfile, _ := registry.Create(models.Feed{Url: server.URL, RoutingProfile: "missing", Enabled: true})
+	withCredentials, _ := registry.Create(models.Feed{Url: server.URL, CredentialsRef: "secret/shop_1", Enabled: true})
+
+	routes, _ := itemrouter.NewStaticRoutes(itemrouter.RoutingConfig{
+		Outputs: []itemrouter.OutputConfig{{Name: "all_items", Predicate: "true"}},
+	})
+	writer := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
+	runner := NewFeedRunner(
+		feedparser.NewFeedParserWithOptions(
+			httpfilefetcher.DefaultHttpFileFetcher(),
+			xmlparser.NewXmlFeedParser(),
+			writer,
+			feedparser.FeedParserOptions{},
+		),
+		registry,
+		map[string]itemrouter.RoutesProviderInterface{"all": routes},
+	)
+
+	// Unknown, disabled and not parsable feeds are rejected
+	for _, id := range []string{"unknown", disabled.Id, unknownProfile.Id, withCredentials.Id} {
+		if _, err := runner.Resolve([]string{enabled.Id, id}); err == nil {
+			t.Fatalf("FeedRunner.Resolve(%s), err = nil, want error", id)
+		}
+	}
+	// Feeds with credentials can be registered, only their runs fail
+	if err := runner.Validate(*withCredentials); err != nil {
+		t.Fatalf("FeedRunner.Validate(with credentials), err = %v, want nil", err)
+	}
+	if err := runner.Validate(*unknownProfile); err == nil {
+		t.Fatalf("FeedRunner.Validate(unknown profile), err = nil, want error")
+	}
+
+	sources, err := runner.Resolve([]string{enabled.Id})
+	if err != nil {
+		t.Fatalf("FeedRunner.Resolve(ids), err = %v, want nil", err)
+	}
+	results := runner.Run(append(sources, feedparser.FeedSource{Url: server.URL}))
+	if len(results) != 2 || results[0].FeedId != enabled.Id || len(results[1].FeedId) != 0 {
+		t.Fatalf("FeedRunner.Run(sources), results = %v, want registered and ad-hoc feed", results)
+	}
+
+	// Registered feed uses its routing profile and keeps its result
+	if len(writer.Items("all_items")) != 1 {
+		t.Fatalf("FeedRunner.Run(sources), routed items = %v, want 1", len(writer.Items("all_items")))
+	}
+	feed, _ := registry.Get(enabled.Id)
+	if feed.LastResult == nil || feed.LastResult.JobId != results[0].JobId {
+		t.Fatalf("FeedRunner.Run(sources), last result = %v, want %v", feed.LastResult, results[0])
+	}
+}
+
+func TestFeedRunnerSingleRun(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte(mockedFeedXml))
+	}))
+	defer server.Close()
+
+	runner := NewFeedRunner(
+		feedparser.NewFeedParserWithOptions(
+			httpfilefetcher.DefaultHttpFileFetcher(),
+			xmlparser.NewXmlFeedParser(),
+			memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{}),
+			feedparser.FeedParserOptions{},
+		),
+		nil,
+		nil,
+	)
+	source := feedparser.FeedSource{Url: server.URL, FeedId: "feed_1"}
+
+	first := make(chan []models.FeedParsingResult)
+	go func() {
+		first <- runner.Run([]feedparser.FeedSource{source})
+	}()
+	<-started
+
+	// Feed already being parsed is not parsed again
+	results := runner.Run([]feedparser.FeedSource{source})
+	if len(results) != 1 || results[0].Status != models.ParsingInProgress {
+		t.Fatalf("FeedRunner.Run(sources), results = %v, want %v status", results, models.ParsingInProgress)
+	}
+
+	close(release)
+	if results := <-first; results[0].Status != models.ParsedSuccessfully {
+		t.Fatalf("FeedRunner.Run(sources), status = %v, want %v", results[0].Status, models.ParsedSuccessfully)
+	}
+}
+
+func TestFeedRunnerWithoutRegistry(t *testing.T) {
+	runner := NewFeedRunner(nil, nil, nil)
+	if sources, err := runner.Resolve(nil); err != nil || len(sources) != 0 {
+		t.Fatalf("FeedRunner.Resolve(nil) = %v, %v, want no sources", sources, err)
+	}
+	if _, err := runner.Resolve([]string{"feed_1"}); err == nil {
+		t.Fatalf("FeedRunner.Resolve(ids), err = nil, want registry not enabled error")
+	}
+}
+
+func TestFeedRunnerStart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mockedFeedXml))
+	}))
+	defer server.Close()
+
+	runner := NewFeedRunner(
+		feedparser.NewFeedParserWithOptions(
+			httpfilefetcher.DefaultHttpFileFetcher(),
+			xmlparser.NewXmlFeedParser(),
+			memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{}),
+			feedparser.FeedParserOptions{},
+		),
+		nil,
+		nil,
+	)
+
+	// Started feeds are waited for before they begin parsing
+	run, err := runner.Start([]feedparser.FeedSource{{Url: server.URL}})
+	if err != nil {
+		t.Fatalf("FeedRunner.Start(sources), err = %v, want nil", err)
+	}
+	runner.StopAccepting()
+	if _, err := runner.Start([]feedparser.FeedSource{{Url: server.URL}}); !errors.Is(err, ErrDraining) {
+		t.Fatalf("FeedRunner.Start(sources), err = %v, want %v", err, ErrDraining)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := runner.Wait(ctx); err == nil {
+		t.Fatalf("FeedRunner.Wait(), err = nil, want timeout error before run")
+	}
+
+	if results := run(); results[0].Status != models.ParsedSuccessfully {
+		t.Fatalf("FeedRunner.Start(sources) run, status = %v, want %v", results[0].Status, models.ParsedSuccessfully)
+	}
+	if err := runner.Wait(context.Background()); err != nil {
+		t.Fatalf("FeedRunner.Wait(), err = %v, want nil", err)
+	}
+}
+
+// MOCKED DATA
+
+var mockedFeedXml = `<SHOP>
+	<SHOPITEM>
+		<ITEM_ID>1</ITEM_ID>
+		<PRODUCTNAME>Product 1</PRODUCTNAME>
+	</SHOPITEM>
+</SHOP>`
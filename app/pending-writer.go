@@ -0,0 +1,149 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/outboxwriter"
+	"go.uber.org/zap"
+)
+
+// Queue writer created in the background, so the service can start
+// while its broker is unavailable. Creation is retried with backoff
+// until it succeeds or the writer is closed
+// Implements queuewriter.QueueWriterInterface
+type pendingWriter struct {
+	name    string
+	factory writerFactory
+
+	mu     sync.RWMutex
+	writer queuewriter.QueueWriterInterface
+	err    error
+
+	closing chan struct{}
+	stopped sync.WaitGroup
+}
+
+// Backoff of writer creation retries
+const (
+	minCreateRetryDelay = time.Second
+	maxCreateRetryDelay = 30 * time.Second
+)
+
+// Creates the writer synchronously, when it fails creation is retried
+// in the background
+func newPendingWriter(name string, factory writerFactory) *pendingWriter {
+	w := &pendingWriter{
+		name:    name,
+		factory: factory,
+		closing: make(chan struct{}),
+	}
+	if !w.create() {
+		zap.L().Warn(
+			"Queue writer is not ready, starting in degraded mode",
+			zap.String("sink", name),
+			zap.Error(w.Err()),
+		)
+		w.stopped.Add(1)
+		go w.retry()
+	}
+	return w
+}
+
+// Publishes shop items with created writer, returns error and drains
+// shopItemsInput when the writer is not created yet
+func (w *pendingWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) error {
+	w.mu.RLock()
+	writer, err := w.writer, w.err
+	w.mu.RUnlock()
+
+	if writer == nil {
+		for range shopItemsInput {
+		}
+		return fmt.Errorf("sink %s is not ready: %w", w.name, err)
+	}
+	return writer.WriteToQueue(queueName, metadata, shopItemsInput)
+}
+
+// Checks if the writer is created and connected to its broker
+// Implements outboxwriter.ConnectionStateInterface
+func (w *pendingWriter) IsConnected() bool {
+	w.mu.RLock()
+	writer := w.writer
+	w.mu.RUnlock()
+
+	if writer == nil {
+		return false
+	}
+	if state, ok := writer.(outboxwriter.ConnectionStateInterface); ok {
+		return state.IsConnected()
+	}
+	return true
+}
+
+// Returns last error of writer creation, nil when the writer is created
+func (w *pendingWriter) Err() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.err
+}
+
+// Stops creation retries and closes created writer
+func (w *pendingWriter) Close() error {
+	close(w.closing)
+	w.stopped.Wait()
+
+	w.mu.RLock()
+	writer := w.writer
+	w.mu.RUnlock()
+
+	if closer, ok := writer.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Creates the writer, returns false on failure
+func (w *pendingWriter) create() bool {
+	writer, err := w.factory()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writer, w.err = writer, err
+	return err == nil
+}
+
+// Retries writer creation with doubled delays until it succeeds
+func (w *pendingWriter) retry() {
+	defer w.stopped.Done()
+	defer zap.L().Sync()
+
+	delay := minCreateRetryDelay
+	for {
+		select {
+		case <-w.closing:
+			return
+		case <-time.After(delay):
+		}
+		if w.create() {
+			zap.L().Info("Queue writer is ready", zap.String("sink", w.name))
+			return
+		}
+		if delay *= 2; delay > maxCreateRetryDelay {
+			delay = maxCreateRetryDelay
+		}
+		zap.L().Warn(
+			"Cannot create queue writer",
+			zap.String("sink", w.name),
+			zap.Duration("retryIn", delay),
+			zap.Error(w.Err()),
+		)
+	}
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/filewriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/kafkawriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/multiwriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/natswriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/rabbitwriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/rediswriter"
+	"github.com/MichalMitros/feed-parser/queuewriter/stdoutwriter"
+)
+
+// Creates new queue writer of given name
+type writerFactory func() (queuewriter.QueueWriterInterface, error)
+
+// Single configured queue writer
+type sinkConfig struct {
+	name    string
+	policy  multiwriter.FailurePolicy
+	factory writerFactory
+}
+
+// Whether no queue writer is configured, so items are written to
+// standard output. RabbitMQ writer is used when its host is set
+func (c SinksConfig) fallsBackToStdout() bool {
+	return len(c.QueueWriter) == 0 && len(c.RabbitMQ.Host) == 0
+}
+
+// Parses list of queue writers with their failure policies and checks
+// required configuration of every writer. Writers are not created yet
+func (c SinksConfig) sinkConfigs() ([]sinkConfig, error) {
+	queueWriter := c.QueueWriter
+	if len(queueWriter) == 0 {
+		queueWriter = "rabbitmq"
+		if c.fallsBackToStdout() {
+			queueWriter = "stdout"
+		}
+	}
+
+	sinks := []sinkConfig{}
+	for _, writer := range splitList(queueWriter) {
+		name, policy := writer, ""
+		if idx := strings.Index(name, ":"); idx >= 0 {
+			name, policy = name[:idx], name[idx+1:]
+		}
+		factory, err := c.writerFactory(name)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sinkConfig{
+			name:    name,
+			policy:  multiwriter.FailurePolicy(policy),
+			factory: factory,
+		})
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no queue writers configured")
+	}
+	return sinks, nil
+}
+
+// Returns factory of queue writer of given name, error when its
+// configuration is missing or invalid, so it isn't mistaken for
+// unavailable broker when the writer is created
+func (c SinksConfig) writerFactory(name string) (writerFactory, error) {
+	switch name {
+	case "rabbitmq":
+		if len(c.RabbitMQ.Host) == 0 || len(c.RabbitMQ.User) == 0 || len(c.RabbitMQ.Password) == 0 {
+			return nil, fmt.Errorf("rabbitmq writer requires 'RABBITMQ_HOST', 'RABBITMQ_USER' and 'RABBITMQ_PASSWORD'")
+		}
+		if err := c.rabbitWriterOptions().Validate(); err != nil {
+			return nil, fmt.Errorf("invalid rabbitmq writer: %w", err)
+		}
+		return c.newRabbitWriter, nil
+	case "kafka":
+		if len(c.Kafka.Brokers) == 0 {
+			return nil, fmt.Errorf("kafka writer requires 'KAFKA_BROKERS'")
+		}
+		options, err := c.kafkaWriterOptions()
+		if err == nil {
+			err = options.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid kafka writer: %w", err)
+		}
+		return c.newKafkaWriter, nil
+	case "nats":
+		if len(c.Nats.Url) == 0 {
+			return nil, fmt.Errorf("nats writer requires 'NATS_URL'")
+		}
+		if err := c.natsWriterOptions().Validate(); err != nil {
+			return nil, fmt.Errorf("invalid nats writer: %w", err)
+		}
+		return c.newNatsWriter, nil
+	case "redis":
+		if len(c.Redis.Address) == 0 {
+			return nil, fmt.Errorf("redis writer requires 'REDIS_ADDRESS'")
+		}
+		if err := c.redisWriterOptions().Validate(); err != nil {
+			return nil, fmt.Errorf("invalid redis writer: %w", err)
+		}
+		return c.newRedisWriter, nil
+	case "file":
+		if len(c.File.Dir) == 0 {
+			return nil, fmt.Errorf("file writer requires 'FILE_WRITER_DIR'")
+		}
+		if err := c.fileWriterOptions().Validate(); err != nil {
+			return nil, fmt.Errorf("invalid file writer: %w", err)
+		}
+		return c.newFileWriter, nil
+	case "stdout":
+		return func() (queuewriter.QueueWriterInterface, error) {
+			return stdoutwriter.NewStdoutWriter(), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown queue writer %q", name)
+}
+
+// Returns options of RabbitMQ writer
+func (c SinksConfig) rabbitWriterOptions() rabbitwriter.RabbitWriterOptions {
+	return rabbitwriter.RabbitWriterOptions{
+		Hostname:     c.RabbitMQ.Host,
+		Username:     c.RabbitMQ.User,
+		Password:     c.RabbitMQ.Password,
+		Durable:      c.RabbitMQ.Durable,
+		Persistent:   c.RabbitMQ.Persistent,
+		Exchange:     c.RabbitMQ.Exchange,
+		ExchangeType: c.RabbitMQ.ExchangeType,
+		RoutingKey:   c.RabbitMQ.RoutingKey,
+		Batch: queuewriter.BatchOptions{
+			Size:        c.RabbitMQ.BatchSize,
+			Interval:    time.Duration(c.RabbitMQ.BatchIntervalMs) * time.Millisecond,
+			Format:      queuewriter.BatchFormat(c.RabbitMQ.BatchFormat),
+			Compression: queuewriter.Compression(c.RabbitMQ.Compression),
+		},
+	}
+}
+
+// Creates RabbitMQ writer
+func (c SinksConfig) newRabbitWriter() (queuewriter.QueueWriterInterface, error) {
+	writer, err := rabbitwriter.NewRabbitWriter(c.rabbitWriterOptions())
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Returns options of Kafka writer, topics of queues are read
+// from file when configured
+func (c SinksConfig) kafkaWriterOptions() (kafkawriter.KafkaWriterOptions, error) {
+	options := kafkawriter.KafkaWriterOptions{
+		Brokers:    c.Kafka.Brokers,
+		ClientId:   c.Kafka.ClientId,
+		Acks:       kafkawriter.Acks(c.Kafka.Acks),
+		Idempotent: c.Kafka.Idempotent,
+	}
+	if len(c.Kafka.TopicsConfigPath) > 0 {
+		topics, err := kafkawriter.LoadTopicsConfig(c.Kafka.TopicsConfigPath)
+		if err != nil {
+			return options, fmt.Errorf("cannot load Kafka topics configuration: %w", err)
+		}
+		options.Topics = topics
+	}
+	return options, nil
+}
+
+// Creates Kafka writer
+func (c SinksConfig) newKafkaWriter() (queuewriter.QueueWriterInterface, error) {
+	options, err := c.kafkaWriterOptions()
+	if err != nil {
+		return nil, err
+	}
+	writer, err := kafkawriter.NewKafkaWriter(options)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Returns options of NATS JetStream writer
+func (c SinksConfig) natsWriterOptions() natswriter.NatsWriterOptions {
+	return natswriter.NatsWriterOptions{
+		Url:              c.Nats.Url,
+		Subject:          c.Nats.Subject,
+		Stream:           c.Nats.Stream,
+		StreamSubjects:   c.Nats.StreamSubjects,
+		DuplicatesWindow: time.Duration(c.Nats.DuplicatesWindowS) * time.Second,
+		AckTimeout:       time.Duration(c.Nats.AckTimeoutMs) * time.Millisecond,
+	}
+}
+
+// Creates NATS JetStream writer
+func (c SinksConfig) newNatsWriter() (queuewriter.QueueWriterInterface, error) {
+	writer, err := natswriter.NewNatsWriter(c.natsWriterOptions())
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Returns options of Redis Streams writer
+func (c SinksConfig) redisWriterOptions() rediswriter.RedisWriterOptions {
+	return rediswriter.RedisWriterOptions{
+		Address:         c.Redis.Address,
+		Username:        c.Redis.Username,
+		Password:        c.Redis.Password,
+		DB:              c.Redis.DB,
+		Stream:          c.Redis.Stream,
+		Encoding:        rediswriter.FieldEncoding(c.Redis.Encoding),
+		MaxLen:          int64(c.Redis.MaxLen),
+		ApproximateTrim: c.Redis.ApproximateTrim,
+	}
+}
+
+// Creates Redis Streams writer
+func (c SinksConfig) newRedisWriter() (queuewriter.QueueWriterInterface, error) {
+	writer, err := rediswriter.NewRedisWriter(c.redisWriterOptions())
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Returns options of file writer
+func (c SinksConfig) fileWriterOptions() filewriter.FileWriterOptions {
+	return filewriter.FileWriterOptions{
+		Dir:          c.File.Dir,
+		Format:       filewriter.Format(c.File.Format),
+		Gzip:         c.File.Gzip,
+		MaxFileItems: c.File.MaxItems,
+		MaxFileBytes: int64(c.File.MaxBytes),
+	}
+}
+
+// Creates file writer
+func (c SinksConfig) newFileWriter() (queuewriter.QueueWriterInterface, error) {
+	writer, err := filewriter.NewFileWriter(c.fileWriterOptions())
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"io"
+	"sync"
+
+	"github.com/MichalMitros/feed-parser/filefetcher/httpfilefetcher"
+)
+
+// Fetcher delegating to fetcher replaced on configuration reload.
+// Feeds being downloaded finish with the previous fetcher
+// Implements filefetcher.FileFetcherInterface
+type reloadableFetcher struct {
+	mutex   sync.RWMutex
+	fetcher *httpfilefetcher.HttpFileFetcher
+}
+
+func (f *reloadableFetcher) FetchFile(url string) (*io.ReadCloser, string, error) {
+	f.mutex.RLock()
+	fetcher := f.fetcher
+	f.mutex.RUnlock()
+	return fetcher.FetchFile(url)
+}
+
+// Checks url with url policy of the current fetcher
+func (f *reloadableFetcher) CheckUrl(url string) error {
+	f.mutex.RLock()
+	fetcher := f.fetcher
+	f.mutex.RUnlock()
+	return fetcher.CheckUrl(url)
+}
+
+// Replaces fetcher used by next downloads
+func (f *reloadableFetcher) set(fetcher *httpfilefetcher.HttpFileFetcher) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.fetcher = fetcher
+}
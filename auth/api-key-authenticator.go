@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"net/http"
+)
+
+// Header with API key of the client
+const ApiKeyHeader = "X-API-Key"
+
+// Authenticates clients with static API keys sent in X-API-Key header
+type ApiKeyAuthenticator struct {
+	// Client identities by SHA-256 of their keys, so keys aren't
+	// compared byte by byte
+	clients map[[sha256.Size]byte]string
+}
+
+// Creates new ApiKeyAuthenticator instance from API keys
+// by client identities
+func NewApiKeyAuthenticator(keys map[string]string) *ApiKeyAuthenticator {
+	clients := make(map[[sha256.Size]byte]string, len(keys))
+	for client, key := range keys {
+		clients[sha256.Sum256([]byte(key))] = client
+	}
+	return &ApiKeyAuthenticator{clients: clients}
+}
+
+// Returns identity of the client with API key from the request
+func (a *ApiKeyAuthenticator) Authenticate(request *http.Request) (string, error) {
+	key := request.Header.Get(ApiKeyHeader)
+	if len(key) == 0 {
+		return "", ErrMissingCredentials
+	}
+	client, ok := a.clients[sha256.Sum256([]byte(key))]
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+	return client, nil
+}
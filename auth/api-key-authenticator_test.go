@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApiKeyAuthenticator(t *testing.T) {
+	authenticator := NewChainAuthenticator(NewApiKeyAuthenticator(mockedApiKeys))
+
+	tests := []struct {
+		key        string
+		wantClient string
+		wantErr    error
+	}{
+		{key: "key-a", wantClient: "client_a"},
+		{key: "key-b", wantClient: "client_b"},
+		{key: "key-c", wantErr: ErrInvalidCredentials},
+		{key: "", wantErr: ErrMissingCredentials},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest("POST", "/parse-feed", nil)
+		if len(tt.key) > 0 {
+			request.Header.Set(ApiKeyHeader, tt.key)
+		}
+		client, err := authenticator.Authenticate(request)
+		if client != tt.wantClient || !errors.Is(err, tt.wantErr) {
+			t.Fatalf(
+				"Authenticate(%q), got = %q, %v, want %q, %v",
+				tt.key, client, err, tt.wantClient, tt.wantErr,
+			)
+		}
+	}
+}
+
+// MOCKED DATA
+
+var mockedApiKeys = map[string]string{
+	"client_a": "key-a",
+	"client_b": "key-b",
+}
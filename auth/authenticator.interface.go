@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Identifies clients of the API from credentials of their requests
+type AuthenticatorInterface interface {
+	// Returns identity of the client, ErrMissingCredentials when request
+	// has no credentials of this authenticator and ErrInvalidCredentials
+	// when they are wrong
+	Authenticate(request *http.Request) (string, error)
+}
+
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
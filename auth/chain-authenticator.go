@@ -0,0 +1,26 @@
+package auth
+
+import "net/http"
+
+// Authenticates clients with the first authenticator
+// finding credentials in the request
+type ChainAuthenticator struct {
+	authenticators []AuthenticatorInterface
+}
+
+// Creates new ChainAuthenticator instance trying authenticators in order
+func NewChainAuthenticator(authenticators ...AuthenticatorInterface) *ChainAuthenticator {
+	return &ChainAuthenticator{authenticators: authenticators}
+}
+
+// Returns identity of the client, ErrMissingCredentials
+// when no authenticator finds credentials in the request
+func (a *ChainAuthenticator) Authenticate(request *http.Request) (string, error) {
+	for _, authenticator := range a.authenticators {
+		client, err := authenticator.Authenticate(request)
+		if err != ErrMissingCredentials {
+			return client, err
+		}
+	}
+	return "", ErrMissingCredentials
+}
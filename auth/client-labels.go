@@ -0,0 +1,32 @@
+package auth
+
+// Identity of requests without authentication
+const AnonymousClient = "anonymous"
+
+// Label of clients which are not configured by name,
+// e.g. subjects of JWT tokens
+const OtherClientsLabel = "other"
+
+// Values of client label of metrics. Clients authenticated with JWT may
+// have any identity, so only configured and anonymous clients get their
+// own label and time series of metrics stay bounded
+type ClientLabels struct {
+	known map[string]bool
+}
+
+// Creates new ClientLabels instance of configured clients
+func NewClientLabels(clients ...string) *ClientLabels {
+	known := map[string]bool{AnonymousClient: true}
+	for _, client := range clients {
+		known[client] = true
+	}
+	return &ClientLabels{known: known}
+}
+
+// Returns label of client, OtherClientsLabel for not configured clients
+func (l *ClientLabels) Label(client string) string {
+	if l.known[client] {
+		return client
+	}
+	return OtherClientsLabel
+}
@@ -0,0 +1,19 @@
+package auth
+
+import "testing"
+
+func TestClientLabels(t *testing.T) {
+	labels := NewClientLabels("client_a", "client_b")
+
+	tests := map[string]string{
+		"client_a":      "client_a",
+		AnonymousClient: AnonymousClient,
+		"jwt-subject-1": OtherClientsLabel,
+		"":              OtherClientsLabel,
+	}
+	for client, want := range tests {
+		if got := labels.Label(client); got != want {
+			t.Fatalf("ClientLabels.Label(%q), got = %q, want %q", client, got, want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"go.uber.org/zap"
+)
+
+// Authenticates clients with JWT bearer tokens in Authorization header,
+// signed with RSA or ECDSA keys from JWKS file
+type JwtAuthenticator struct {
+	jwksPath       string
+	issuer         string
+	audience       string
+	clientClaim    string
+	reloadInterval time.Duration
+	parser         *jwt.Parser
+
+	mutex sync.Mutex
+	// Public keys by their ids
+	keys     map[string]interface{}
+	loadedAt time.Time
+}
+
+// Options of JwtAuthenticator
+type JwtAuthenticatorOptions struct {
+	// Path of JSON Web Key Set file with public keys
+	JwksPath string
+	// Expected "iss" and "aud" claims, not checked when empty
+	Issuer   string
+	Audience string
+	// Claim with identity of the client, DefaultClientClaim is used
+	// when empty
+	ClientClaim string
+	// Minimal interval of reading JWKS file again when token is signed
+	// with unknown key, DefaultJwksReloadInterval is used when 0
+	JwksReloadInterval time.Duration
+}
+
+// Default values of JwtAuthenticatorOptions
+const (
+	DefaultClientClaim        = "sub"
+	DefaultJwksReloadInterval = time.Minute
+)
+
+// Creates new JwtAuthenticator instance, returns error when JWKS file
+// can't be read or has no usable keys
+func NewJwtAuthenticator(options JwtAuthenticatorOptions) (*JwtAuthenticator, error) {
+	if len(options.ClientClaim) == 0 {
+		options.ClientClaim = DefaultClientClaim
+	}
+	if options.JwksReloadInterval <= 0 {
+		options.JwksReloadInterval = DefaultJwksReloadInterval
+	}
+	keys, err := readJwks(options.JwksPath)
+	if err != nil {
+		return nil, err
+	}
+	return &JwtAuthenticator{
+		jwksPath:       options.JwksPath,
+		issuer:         options.Issuer,
+		audience:       options.Audience,
+		clientClaim:    options.ClientClaim,
+		reloadInterval: options.JwksReloadInterval,
+		// Only asymmetric algorithms, so public keys can't be used
+		// as HMAC secrets
+		parser: &jwt.Parser{ValidMethods: []string{
+			"RS256", "RS384", "RS512",
+			"PS256", "PS384", "PS512",
+			"ES256", "ES384", "ES512",
+		}},
+		keys:     keys,
+		loadedAt: time.Now(),
+	}, nil
+}
+
+// Returns identity of the client from claim of verified bearer token
+func (a *JwtAuthenticator) Authenticate(request *http.Request) (string, error) {
+	header := request.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", ErrMissingCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(header[7:], claims, a.key); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	// Parser checks expiration only when the claim is present
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return "", fmt.Errorf("%w: missing or expired \"exp\" claim", ErrInvalidCredentials)
+	}
+	if !claims.VerifyIssuer(a.issuer, len(a.issuer) > 0) {
+		return "", fmt.Errorf("%w: unexpected issuer", ErrInvalidCredentials)
+	}
+	if !claims.VerifyAudience(a.audience, len(a.audience) > 0) {
+		return "", fmt.Errorf("%w: unexpected audience", ErrInvalidCredentials)
+	}
+	client, _ := claims[a.clientClaim].(string)
+	if len(client) == 0 {
+		return "", fmt.Errorf("%w: missing %q claim", ErrInvalidCredentials, a.clientClaim)
+	}
+	return client, nil
+}
+
+// Returns public key with id from token header. JWKS file is read again
+// for unknown ids, so rotated keys are used without restart
+func (a *JwtAuthenticator) key(token *jwt.Token) (interface{}, error) {
+	defer zap.L().Sync()
+
+	kid, _ := token.Header["kid"].(string)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if key := a.findKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(a.loadedAt) >= a.reloadInterval {
+		a.loadedAt = time.Now()
+		keys, err := readJwks(a.jwksPath)
+		if err != nil {
+			zap.L().Error("Cannot reload JWKS", zap.String("path", a.jwksPath), zap.Error(err))
+		} else {
+			a.keys = keys
+		}
+		if key := a.findKey(kid); key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// Returns key with id, the only key is used for tokens without id
+func (a *JwtAuthenticator) findKey(kid string) interface{} {
+	if len(kid) == 0 && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key
+		}
+	}
+	return a.keys[kid]
+}
+
+// Key of JSON Web Key Set, only fields of RSA and EC public keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Reads public signing keys by their ids from JWKS file,
+// keys of other types and encryption keys are skipped
+func readJwks(path string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read JWKS file: %w", err)
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return nil, fmt.Errorf("cannot parse JWKS file: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if jwk.Use == "enc" {
+			continue
+		}
+		var key interface{}
+		switch jwk.Kty {
+		case "RSA":
+			key, err = jwk.rsaKey()
+		case "EC":
+			key, err = jwk.ecdsaKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS file has no RSA or EC signing keys")
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jsonWebKey) ecdsaKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unknown curve %q", k.Crv)
+	}
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// Decodes unsigned integer in base64url without padding
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(bytes) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestJwtAuthenticator(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	writeJwks(t, jwksPath, map[string]interface{}{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey})
+
+	authenticator, err := NewJwtAuthenticator(JwtAuthenticatorOptions{
+		JwksPath: jwksPath,
+		Issuer:   "https://auth.example.com",
+		Audience: "feed-parser",
+	})
+	if err != nil {
+		t.Fatalf("NewJwtAuthenticator(), err = %v, want nil", err)
+	}
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "client_a",
+			"iss": "https://auth.example.com",
+			"aud": []string{"feed-parser", "other"},
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	expired := valid()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	otherIssuer := valid()
+	otherIssuer["iss"] = "https://other.example.com"
+	otherAudience := valid()
+	otherAudience["aud"] = "other"
+	noClient := valid()
+	delete(noClient, "sub")
+	noExpiration := valid()
+	delete(noExpiration, "exp")
+
+	tests := []struct {
+		name       string
+		token      string
+		wantClient string
+		wantErr    error
+	}{
+		{name: "RSA", token: signToken(jwt.SigningMethodRS256, "rsa", rsaKey, valid()), wantClient: "client_a"},
+		{name: "ECDSA", token: signToken(jwt.SigningMethodES256, "ec", ecKey, valid()), wantClient: "client_a"},
+		{name: "expired", token: signToken(jwt.SigningMethodRS256, "rsa", rsaKey, expired), wantErr: ErrInvalidCredentials},
+		{name: "no expiration", token: signToken(jwt.SigningMethodRS256, "rsa", rsaKey, noExpiration), wantErr: ErrInvalidCredentials},
+		{name: "issuer", token: signToken(jwt.SigningMethodRS256, "rsa", rsaKey, otherIssuer), wantErr: ErrInvalidCredentials},
+		{name: "audience", token: signToken(jwt.SigningMethodRS256, "rsa", rsaKey, otherAudience), wantErr: ErrInvalidCredentials},
+		{name: "client claim", token: signToken(jwt.SigningMethodRS256, "rsa", rsaKey, noClient), wantErr: ErrInvalidCredentials},
+		{name: "other key", token: signToken(jwt.SigningMethodRS256, "rsa", otherKey, valid()), wantErr: ErrInvalidCredentials},
+		{name: "unknown key", token: signToken(jwt.SigningMethodRS256, "other", otherKey, valid()), wantErr: ErrInvalidCredentials},
+		// Public key can't be used as HMAC secret
+		{name: "HMAC", token: signToken(jwt.SigningMethodHS256, "rsa", []byte("secret"), valid()), wantErr: ErrInvalidCredentials},
+		{name: "missing", wantErr: ErrMissingCredentials},
+	}
+	for _, tt := range tests {
+		request := httptest.NewRequest("POST", "/parse-feed", nil)
+		if len(tt.token) > 0 {
+			request.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		client, err := authenticator.Authenticate(request)
+		if client != tt.wantClient || !errors.Is(err, tt.wantErr) {
+			t.Fatalf(
+				"Authenticate(%s), got = %q, %v, want %q, %v",
+				tt.name, client, err, tt.wantClient, tt.wantErr,
+			)
+		}
+	}
+}
+
+func TestJwtAuthenticatorKeyRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	writeJwks(t, jwksPath, map[string]interface{}{"old": &oldKey.PublicKey})
+
+	authenticator, err := NewJwtAuthenticator(JwtAuthenticatorOptions{
+		JwksPath:           jwksPath,
+		JwksReloadInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewJwtAuthenticator(), err = %v, want nil", err)
+	}
+
+	// Unknown key id reads the file again
+	writeJwks(t, jwksPath, map[string]interface{}{"new": &newKey.PublicKey})
+	time.Sleep(20 * time.Millisecond)
+	request := httptest.NewRequest("POST", "/parse-feed", nil)
+	request.Header.Set("Authorization", "Bearer "+signToken(
+		jwt.SigningMethodRS256, "new", newKey, jwt.MapClaims{"sub": "client_a", "exp": time.Now().Add(time.Hour).Unix()},
+	))
+	if client, err := authenticator.Authenticate(request); client != "client_a" || err != nil {
+		t.Fatalf("Authenticate(), got = %q, %v, want client_a, nil", client, err)
+	}
+}
+
+func TestNewJwtAuthenticatorInvalidJwks(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"no keys":       `{"keys": []}`,
+		"symmetric key": `{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`,
+		"invalid key":   `{"keys": [{"kty": "RSA", "kid": "rsa", "n": "", "e": "AQAB"}]}`,
+		"invalid json":  `{"keys":`,
+	}
+	for name, content := range tests {
+		path := filepath.Join(dir, "jwks.json")
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := NewJwtAuthenticator(JwtAuthenticatorOptions{JwksPath: path}); err == nil {
+			t.Fatalf("NewJwtAuthenticator(%s), err = nil, want error", name)
+		}
+	}
+	if _, err := NewJwtAuthenticator(JwtAuthenticatorOptions{JwksPath: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Fatalf("NewJwtAuthenticator(missing file), err = nil, want error")
+	}
+}
+
+// MOCKED DATA
+
+// Returns token with claims signed with key
+func signToken(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+// Writes public keys by their ids to JWKS file
+func writeJwks(t *testing.T, path string, keys map[string]interface{}) {
+	encode := func(value *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(value.Bytes())
+	}
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	for kid, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, jsonWebKey{
+				Kty: "RSA", Kid: kid, Use: "sig",
+				N: encode(key.N), E: encode(big.NewInt(int64(key.E))),
+			})
+		case *ecdsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, jsonWebKey{
+				Kty: "EC", Kid: kid, Crv: key.Curve.Params().Name,
+				X: encode(key.X), Y: encode(key.Y),
+			})
+		}
+	}
+	content, _ := json.Marshal(jwks)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("WriteFile(), err = %v, want nil", err)
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Limits parse jobs running at once and feeds parsed in the last hour
+// of every client
+type QuotaLimiter struct {
+	defaultQuota Quota
+	clients      map[string]Quota
+	labels       *ClientLabels
+	// Current time, replaced in tests
+	now func() time.Time
+
+	mutex sync.Mutex
+	usage map[string]*clientUsage
+	// Last removal of idle clients
+	sweptAt time.Time
+}
+
+// Limits of a single client, not limited when 0
+type Quota struct {
+	MaxConcurrentJobs int
+	MaxFeedsPerHour   int
+}
+
+// Options of QuotaLimiter
+type QuotaLimiterOptions struct {
+	// Quota of every client
+	Default Quota
+	// Quotas of named clients, default values are used
+	// for limits which are 0
+	Clients map[string]Quota
+	// Client labels of rejection metrics, only clients with
+	// quotas are labelled by name when nil
+	Labels *ClientLabels
+}
+
+var (
+	ErrTooManyJobs         = errors.New("too many parse jobs in progress")
+	ErrFeedsQuotaExhausted = errors.New("hourly quota of parsed feeds exhausted")
+)
+
+// Jobs in progress and accepted feeds of a client
+type clientUsage struct {
+	jobs  int
+	feeds []acceptedFeeds
+}
+
+// Number of feeds of a request accepted at time
+type acceptedFeeds struct {
+	at    time.Time
+	count int
+}
+
+// Creates new QuotaLimiter instance
+func NewQuotaLimiter(options QuotaLimiterOptions) *QuotaLimiter {
+	if options.Labels == nil {
+		clients := make([]string, 0, len(options.Clients))
+		for client := range options.Clients {
+			clients = append(clients, client)
+		}
+		options.Labels = NewClientLabels(clients...)
+	}
+	return &QuotaLimiter{
+		defaultQuota: options.Default,
+		clients:      options.Clients,
+		labels:       options.Labels,
+		now:          time.Now,
+		usage:        make(map[string]*clientUsage),
+		sweptAt:      time.Now(),
+	}
+}
+
+// Starts job of client parsing number of feeds. Returns function ending
+// the job or ErrTooManyJobs and ErrFeedsQuotaExhausted when client
+// has reached its quota, rejected feeds don't count to the quota
+func (l *QuotaLimiter) Acquire(client string, feeds int) (func(), error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	quota := l.quota(client)
+	now := l.now()
+	usage := l.clientUsage(client, now)
+
+	if quota.MaxConcurrentJobs > 0 && usage.jobs >= quota.MaxConcurrentJobs {
+		quotaRejections.WithLabelValues(l.labels.Label(client), "concurrent_jobs").Inc()
+		return nil, ErrTooManyJobs
+	}
+	if quota.MaxFeedsPerHour > 0 && usage.feedsCount()+feeds > quota.MaxFeedsPerHour {
+		quotaRejections.WithLabelValues(l.labels.Label(client), "feeds_per_hour").Inc()
+		return nil, ErrFeedsQuotaExhausted
+	}
+
+	usage.jobs++
+	usage.feeds = append(usage.feeds, acceptedFeeds{at: now, count: feeds})
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			l.clientUsage(client, l.now()).jobs--
+		})
+	}, nil
+}
+
+// Returns quota of client with default values of missing limits
+func (l *QuotaLimiter) quota(client string) Quota {
+	quota := l.clients[client]
+	if quota.MaxConcurrentJobs == 0 {
+		quota.MaxConcurrentJobs = l.defaultQuota.MaxConcurrentJobs
+	}
+	if quota.MaxFeedsPerHour == 0 {
+		quota.MaxFeedsPerHour = l.defaultQuota.MaxFeedsPerHour
+	}
+	return quota
+}
+
+// Returns usage of client without feeds accepted more than an hour
+// before now. Usage of idle clients is removed once an hour, so usage
+// of clients with jobs in progress is never replaced. Has to be called
+// with locked mutex
+func (l *QuotaLimiter) clientUsage(client string, now time.Time) *clientUsage {
+	if now.Sub(l.sweptAt) >= time.Hour {
+		l.sweptAt = now
+		for name, usage := range l.usage {
+			usage.expire(now)
+			if usage.jobs == 0 && len(usage.feeds) == 0 {
+				delete(l.usage, name)
+			}
+		}
+	}
+
+	usage, ok := l.usage[client]
+	if !ok {
+		usage = &clientUsage{}
+		l.usage[client] = usage
+	}
+	usage.expire(now)
+	return usage
+}
+
+// Removes feeds accepted more than an hour before now
+func (u *clientUsage) expire(now time.Time) {
+	expired := 0
+	for expired < len(u.feeds) && now.Sub(u.feeds[expired].at) >= time.Hour {
+		expired++
+	}
+	u.feeds = u.feeds[expired:]
+}
+
+// Returns number of feeds accepted in the last hour
+func (u *clientUsage) feedsCount() int {
+	count := 0
+	for _, feeds := range u.feeds {
+		count += feeds.count
+	}
+	return count
+}
+
+// Prometheus quota rejections
+var (
+	quotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_quota_rejections_total",
+		Help: "The total number of requests rejected because of client quotas",
+	}, []string{"client", "quota"})
+)
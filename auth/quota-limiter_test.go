@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaLimiterConcurrentJobs(t *testing.T) {
+	limiter := NewQuotaLimiter(QuotaLimiterOptions{
+		Default: Quota{MaxConcurrentJobs: 1},
+		Clients: map[string]Quota{"client_b": {MaxConcurrentJobs: 2}},
+	})
+
+	release, err := limiter.Acquire("client_a", 1)
+	if err != nil {
+		t.Fatalf("Acquire(), err = %v, want nil", err)
+	}
+	if _, err := limiter.Acquire("client_a", 1); err != ErrTooManyJobs {
+		t.Fatalf("Acquire(), second job err = %v, want %v", err, ErrTooManyJobs)
+	}
+
+	// Other clients have their own quotas
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Acquire("client_b", 1); err != nil {
+			t.Fatalf("Acquire(), client_b job %d err = %v, want nil", i, err)
+		}
+	}
+
+	// Ended job frees its slot only once
+	release()
+	release()
+	if _, err := limiter.Acquire("client_a", 1); err != nil {
+		t.Fatalf("Acquire(), err after release = %v, want nil", err)
+	}
+	if _, err := limiter.Acquire("client_a", 1); err != ErrTooManyJobs {
+		t.Fatalf("Acquire(), err after repeated release = %v, want %v", err, ErrTooManyJobs)
+	}
+}
+
+func TestQuotaLimiterFeedsPerHour(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewQuotaLimiter(QuotaLimiterOptions{
+		Default: Quota{MaxFeedsPerHour: 5},
+	})
+	limiter.now = func() time.Time { return now }
+
+	acquire := func(feeds int) error {
+		release, err := limiter.Acquire("client_a", feeds)
+		if err == nil {
+			release()
+		}
+		return err
+	}
+
+	if err := acquire(3); err != nil {
+		t.Fatalf("Acquire(), err = %v, want nil", err)
+	}
+	now = now.Add(30 * time.Minute)
+	if err := acquire(2); err != nil {
+		t.Fatalf("Acquire(), err = %v, want nil", err)
+	}
+	// Rejected feeds don't count to the quota
+	if err := acquire(1); err != ErrFeedsQuotaExhausted {
+		t.Fatalf("Acquire(), err = %v, want %v", err, ErrFeedsQuotaExhausted)
+	}
+
+	// Feeds accepted an hour ago don't count anymore
+	now = now.Add(30 * time.Minute)
+	if err := acquire(3); err != nil {
+		t.Fatalf("Acquire(), err after an hour = %v, want nil", err)
+	}
+	if err := acquire(1); err != ErrFeedsQuotaExhausted {
+		t.Fatalf("Acquire(), err = %v, want %v", err, ErrFeedsQuotaExhausted)
+	}
+
+	// Idle clients are removed
+	now = now.Add(2 * time.Hour)
+	if err := acquire(1); err != nil || len(limiter.usage) != 1 {
+		t.Fatalf("Acquire(), err = %v, clients = %v, want nil and 1", err, len(limiter.usage))
+	}
+}
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/MichalMitros/feed-parser/feedregistry"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Checks if client of the request may access registered feed or job
+// of owner. Resources without owner belong to AnonymousClient, admin
+// clients access resources of all clients
+func (h *Handlers) canAccess(c *gin.Context, owner string) bool {
+	if len(owner) == 0 {
+		owner = AnonymousClient
+	}
+	client := Client(c)
+	return client == owner || h.AdminClients[client]
+}
+
+// Returns registered feed accessible by client of the request, responds
+// with 404 Not Found and returns false for feeds of other clients
+func (h *Handlers) getFeed(c *gin.Context, id string) (*models.Feed, bool) {
+	feed, err := h.Registry.Get(id)
+	if err == nil && !h.canAccess(c, feed.Owner) {
+		err = feedregistry.ErrFeedNotFound
+	}
+	if err != nil {
+		respondRegistryError(c, err)
+		return nil, false
+	}
+	return feed, true
+}
+
+// Responds with 400 Bad Request and returns false when any of feeds
+// belongs to other client. Unknown feeds are left to the runner
+func (h *Handlers) checkFeedIds(c *gin.Context, feedIds []string) bool {
+	for _, id := range feedIds {
+		feed, err := h.Registry.Get(id)
+		if err != nil || h.canAccess(c, feed.Owner) {
+			continue
+		}
+		c.IndentedJSON(http.StatusBadRequest, gin.H{
+			"status":  "BAD_REQUEST",
+			"message": fmt.Sprintf("cannot parse feeds: feed %s: %v", id, feedregistry.ErrFeedNotFound),
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/auth"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Key of authenticated client identity in gin context
+const ClientKey = "client"
+
+// Identity of requests without authentication
+const AnonymousClient = auth.AnonymousClient
+
+const (
+	// Interval of checking if queued job holding quota slot has finished
+	jobSlotPollInterval = 5 * time.Second
+	// Maximal time of holding quota slot by queued job, so jobs
+	// with lost statuses don't block their clients forever
+	maxJobSlotDuration = time.Hour
+)
+
+// Returns identity of authenticated client, AnonymousClient
+// when authentication is disabled
+func Client(c *gin.Context) string {
+	if client := c.GetString(ClientKey); len(client) > 0 {
+		return client
+	}
+	return AnonymousClient
+}
+
+// Starts job of the client parsing number of feeds. Responds with
+// 429 Too Many Requests and returns false when client has reached
+// its quota, release has to be called when the job ends
+func (h *Handlers) acquireQuota(c *gin.Context, feeds int) (func(), bool) {
+	defer zap.L().Sync()
+
+	if h.Quotas == nil {
+		return func() {}, true
+	}
+	release, err := h.Quotas.Acquire(Client(c), feeds)
+	if err != nil {
+		zap.L().Warn("Client quota exhausted", zap.String("client", Client(c)), zap.Error(err))
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{
+			"status":  "TOO_MANY_REQUESTS",
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	return release, true
+}
+
+// Calls release when queued job is done or failed, or when its status
+// is no longer known
+func (h *Handlers) releaseWhenFinished(jobId string, release func()) {
+	defer release()
+
+	ticker := time.NewTicker(jobSlotPollInterval)
+	defer ticker.Stop()
+	timeout := time.After(maxJobSlotDuration)
+	for {
+		select {
+		case <-ticker.C:
+			status, ok := h.Jobs.Status(jobId)
+			if !ok || status.State == models.JobDone || status.State == models.JobFailed {
+				return
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package contracts
+
+import "github.com/MichalMitros/feed-parser/models"
+
+// Fields of created or replaced registry feed
+type FeedRequest struct {
+	ShopId         string            `json:"shopId"`
+	Url            string            `json:"url"`
+	Format         models.FeedFormat `json:"format"`
+	CredentialsRef string            `json:"credentialsRef"`
+	Schedule       string            `json:"schedule"`
+	RoutingProfile string            `json:"routingProfile"`
+	// Feed is enabled when not set
+	Enabled *bool `json:"enabled"`
+}
+
+// Returns registry feed with fields of the request
+func (r FeedRequest) Feed(id string) models.Feed {
+	feed := models.Feed{
+		Id:             id,
+		ShopId:         r.ShopId,
+		Url:            r.Url,
+		Format:         r.Format,
+		CredentialsRef: r.CredentialsRef,
+		Schedule:       r.Schedule,
+		RoutingProfile: r.RoutingProfile,
+		Enabled:        true,
+	}
+	if r.Enabled != nil {
+		feed.Enabled = *r.Enabled
+	}
+	return feed
+}
@@ -0,0 +1,7 @@
+package contracts
+
+import "github.com/MichalMitros/feed-parser/models"
+
+type FeedsResponse struct {
+	Feeds []models.Feed `json:"feeds"`
+}
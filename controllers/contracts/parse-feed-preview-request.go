@@ -0,0 +1,13 @@
+package contracts
+
+type ParseFeedPreviewRequest struct {
+	FeedUrl string `json:"feedUrl"`
+	// Number of items per output, DefaultPreviewLimit is used when 0
+	Limit int `json:"limit"`
+}
+
+// Default and maximal number of items per output in preview
+const (
+	DefaultPreviewLimit = 10
+	MaxPreviewLimit     = 100
+)
@@ -0,0 +1,14 @@
+package contracts
+
+import "github.com/MichalMitros/feed-parser/models"
+
+type ParseFeedPreviewResponse struct {
+	Result  models.FeedParsingResult `json:"result"`
+	Outputs map[string]OutputPreview `json:"outputs"`
+}
+
+// First items of a single output
+type OutputPreview struct {
+	TotalItems int               `json:"totalItems"`
+	Items      []models.ShopItem `json:"items"`
+}
@@ -2,4 +2,6 @@ package contracts
 
 type ParseFeedRequest struct {
 	FeedUrls []string `json:"feedUrls"`
+	// Identifiers of feeds from the feed registry
+	FeedIds []string `json:"feedIds"`
 }
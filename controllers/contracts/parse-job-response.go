@@ -0,0 +1,7 @@
+package contracts
+
+type ParseJobResponse struct {
+	Status string `json:"status"`
+	// Identifier of queued job, see GET /jobs/:id
+	JobId string `json:"jobId"`
+}
@@ -0,0 +1,7 @@
+package contracts
+
+import "github.com/MichalMitros/feed-parser/models"
+
+type SchedulesResponse struct {
+	Schedules []models.FeedSchedule `json:"schedules"`
+}
@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Parser of ad-hoc urls and registered feeds, e.g. app.FeedRunner
+type FeedRunnerInterface interface {
+	// Returns sources of registered feeds, error for unknown
+	// and disabled feeds
+	Resolve(feedIds []string) ([]feedparser.FeedSource, error)
+	// Checks if feed can be registered, e.g. its routing profile exists
+	Validate(feed models.Feed) error
+	// Marks feeds as being parsed, so shutdown waits for them, and returns
+	// function parsing them and storing results of registered feeds.
+	// Returns error when shutdown has started
+	Start(sources []feedparser.FeedSource) (func() []models.FeedParsingResult, error)
+}
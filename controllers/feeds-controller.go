@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MichalMitros/feed-parser/controllers/contracts"
+	"github.com/MichalMitros/feed-parser/feedregistry"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (h *Handlers) GetFeeds(c *gin.Context) {
+	feeds, err := h.Registry.List()
+	if err != nil {
+		respondRegistryError(c, err)
+		return
+	}
+	// Only feeds of the client are listed
+	accessible := make([]models.Feed, 0, len(feeds))
+	for _, feed := range feeds {
+		if h.canAccess(c, feed.Owner) {
+			accessible = append(accessible, feed)
+		}
+	}
+	c.IndentedJSON(http.StatusOK, contracts.FeedsResponse{
+		Feeds: accessible,
+	})
+}
+
+func (h *Handlers) GetFeed(c *gin.Context) {
+	feed, ok := h.getFeed(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	c.IndentedJSON(http.StatusOK, feed)
+}
+
+func (h *Handlers) PostFeed(c *gin.Context) {
+	feed, ok := h.bindFeed(c, "")
+	if !ok {
+		return
+	}
+	feed.Owner = Client(c)
+	created, err := h.Registry.Create(feed)
+	if err != nil {
+		respondRegistryError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, created)
+}
+
+func (h *Handlers) PutFeed(c *gin.Context) {
+	if _, ok := h.getFeed(c, c.Param("id")); !ok {
+		return
+	}
+	feed, ok := h.bindFeed(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	updated, err := h.Registry.Update(feed)
+	if err != nil {
+		respondRegistryError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, updated)
+}
+
+func (h *Handlers) DeleteFeed(c *gin.Context) {
+	if _, ok := h.getFeed(c, c.Param("id")); !ok {
+		return
+	}
+	if err := h.Registry.Delete(c.Param("id")); err != nil {
+		respondRegistryError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Parses request json into feed with given id, responds with
+// 400 Bad Request and returns false when it's invalid
+func (h *Handlers) bindFeed(c *gin.Context, id string) (models.Feed, bool) {
+	defer zap.L().Sync()
+
+	var request contracts.FeedRequest
+	if err := c.BindJSON(&request); err != nil {
+		zap.L().Warn("Feed registry Bad Request", zap.Error(err))
+		c.IndentedJSON(http.StatusBadRequest, gin.H{
+			"status":  "BAD_REQUEST",
+			"message": "Request should contain feed with field 'url'",
+		})
+		return models.Feed{}, false
+	}
+	feed := request.Feed(id)
+	if len(feed.Url) > 0 && !h.checkFeedUrl(c, feed.Url) {
+		return models.Feed{}, false
+	}
+
+	// Check if the feed can be parsed, e.g. its routing profile exists
+	if err := h.Runner.Validate(feed); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{
+			"status":  "BAD_REQUEST",
+			"message": err.Error(),
+		})
+		return models.Feed{}, false
+	}
+	return feed, true
+}
+
+// Responds with status matching error of the feed registry
+func respondRegistryError(c *gin.Context, err error) {
+	defer zap.L().Sync()
+
+	var validationErr *feedregistry.ValidationError
+	switch {
+	case errors.Is(err, feedregistry.ErrFeedNotFound):
+		c.IndentedJSON(http.StatusNotFound, gin.H{
+			"status":  "NOT_FOUND",
+			"message": err.Error(),
+		})
+	case errors.As(err, &validationErr):
+		c.IndentedJSON(http.StatusBadRequest, gin.H{
+			"status":  "BAD_REQUEST",
+			"message": err.Error(),
+		})
+	default:
+		zap.L().Error("Feed registry error", zap.Error(err))
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{
+			"status":  "INTERNAL_ERROR",
+			"message": err.Error(),
+		})
+	}
+}
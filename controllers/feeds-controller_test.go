@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/MichalMitros/feed-parser/controllers/contracts"
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/feedregistry/boltregistry"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestFeedsAreScopedToClient(t *testing.T) {
+	registry, err := boltregistry.NewBoltRegistry(filepath.Join(t.TempDir(), "feeds.db"))
+	if err != nil {
+		t.Fatalf("NewBoltRegistry(), err = %v, want nil", err)
+	}
+	defer registry.Close()
+	jobs := &mockedJobs{statuses: map[string]models.JobStatus{}}
+	router := mockedRouter(&Handlers{
+		Runner:       mockedRunner{},
+		Registry:     registry,
+		Jobs:         jobs,
+		UrlPolicy:    mockedUrlPolicy{},
+		Health:       mockedHealth{},
+		AdminClients: map[string]bool{"admin": true},
+	})
+
+	// Client A registers feed
+	response := request(router, "client_a", "POST", "/feeds", `{"shopId": "shop_a", "url": "https://shop-a.example.com/feed.xml"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("POST /feeds, code = %v, want %v", response.Code, http.StatusCreated)
+	}
+	var feed models.Feed
+	json.Unmarshal(response.Body.Bytes(), &feed)
+	if feed.Owner != "client_a" {
+		t.Fatalf("POST /feeds, owner = %v, want %v", feed.Owner, "client_a")
+	}
+	path := "/feeds/" + feed.Id
+
+	// Client B doesn't see nor change it
+	if feeds := listFeeds(router, "client_b"); len(feeds) != 0 {
+		t.Fatalf("GET /feeds of client_b, feeds = %v, want none", feeds)
+	}
+	tests := map[string]string{
+		"GET":    "",
+		"PUT":    `{"shopId": "shop_b", "url": "https://shop-b.example.com/feed.xml"}`,
+		"DELETE": "",
+	}
+	for method, body := range tests {
+		if response := request(router, "client_b", method, path, body); response.Code != http.StatusNotFound {
+			t.Fatalf("%s %s of client_b, code = %v, want %v", method, path, response.Code, http.StatusNotFound)
+		}
+	}
+	response = request(router, "client_b", "POST", "/parse-feed", `{"feedIds": ["`+feed.Id+`"]}`)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("POST /parse-feed of client_b, code = %v, want %v", response.Code, http.StatusBadRequest)
+	}
+
+	// Client A and admin see it
+	if feeds := listFeeds(router, "client_a"); len(feeds) != 1 {
+		t.Fatalf("GET /feeds of client_a, feeds = %v, want 1 feed", feeds)
+	}
+	if feeds := listFeeds(router, "admin"); len(feeds) != 1 {
+		t.Fatalf("GET /feeds of admin, feeds = %v, want 1 feed", feeds)
+	}
+	response = request(router, "admin", "PUT", path, `{"shopId": "shop_a", "url": "https://shop-a.example.com/feed-v2.xml"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("PUT %s of admin, code = %v, want %v", path, response.Code, http.StatusOK)
+	}
+	stored, _ := registry.Get(feed.Id)
+	if stored.Owner != "client_a" {
+		t.Fatalf("PUT %s of admin, owner = %v, want %v", path, stored.Owner, "client_a")
+	}
+
+	// Jobs of client A are not found by client B
+	response = request(router, "client_a", "POST", "/parse-feed", `{"feedIds": ["`+feed.Id+`"]}`)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("POST /parse-feed of client_a, code = %v, want %v", response.Code, http.StatusAccepted)
+	}
+	var job contracts.ParseJobResponse
+	json.Unmarshal(response.Body.Bytes(), &job)
+	if response := request(router, "client_b", "GET", "/jobs/"+job.JobId, ""); response.Code != http.StatusNotFound {
+		t.Fatalf("GET /jobs of client_b, code = %v, want %v", response.Code, http.StatusNotFound)
+	}
+	if response := request(router, "client_a", "GET", "/jobs/"+job.JobId, ""); response.Code != http.StatusOK {
+		t.Fatalf("GET /jobs of client_a, code = %v, want %v", response.Code, http.StatusOK)
+	}
+
+	if response := request(router, "client_a", "DELETE", path, ""); response.Code != http.StatusNoContent {
+		t.Fatalf("DELETE %s of client_a, code = %v, want %v", path, response.Code, http.StatusNoContent)
+	}
+}
+
+// Sends request of client to the router
+func request(router *gin.Engine, client string, method string, path string, body string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	request.Header.Set("X-Client", client)
+	router.ServeHTTP(response, request)
+	return response
+}
+
+// Returns registered feeds listed for client
+func listFeeds(router *gin.Engine, client string) []models.Feed {
+	var feeds contracts.FeedsResponse
+	json.Unmarshal(request(router, client, "GET", "/feeds", "").Body.Bytes(), &feeds)
+	return feeds.Feeds
+}
+
+// MOCKED DATA
+
+// Creates router of handlers authenticating client by header
+func mockedRouter(handlers *Handlers) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(ClientKey, c.GetHeader("X-Client"))
+	})
+	router.GET("/feeds", handlers.GetFeeds)
+	router.POST("/feeds", handlers.PostFeed)
+	router.GET("/feeds/:id", handlers.GetFeed)
+	router.PUT("/feeds/:id", handlers.PutFeed)
+	router.DELETE("/feeds/:id", handlers.DeleteFeed)
+	router.POST("/parse-feed", handlers.PostParseFeedAsync)
+	router.GET("/jobs/:id", handlers.GetJob)
+	return router
+}
+
+type mockedRunner struct{}
+
+func (mockedRunner) Resolve(feedIds []string) ([]feedparser.FeedSource, error) {
+	sources := make([]feedparser.FeedSource, 0, len(feedIds))
+	for _, id := range feedIds {
+		sources = append(sources, feedparser.FeedSource{FeedId: id})
+	}
+	return sources, nil
+}
+
+func (mockedRunner) Validate(feed models.Feed) error {
+	return nil
+}
+
+func (mockedRunner) Start(sources []feedparser.FeedSource) (func() []models.FeedParsingResult, error) {
+	return func() []models.FeedParsingResult { return nil }, nil
+}
+
+type mockedJobs struct {
+	statuses map[string]models.JobStatus
+}
+
+func (j *mockedJobs) Enqueue(client string, feedUrls []string, feedIds []string) (models.JobStatus, error) {
+	status := models.JobStatus{JobId: "job_1", Client: client, State: models.JobQueued}
+	j.statuses[status.JobId] = status
+	return status, nil
+}
+
+func (j *mockedJobs) Status(jobId string) (models.JobStatus, bool) {
+	status, ok := j.statuses[jobId]
+	return status, ok
+}
+
+// Service which is not shutting down, other checks aren't used
+type mockedHealth struct {
+	HealthProviderInterface
+}
+
+func (mockedHealth) Draining() bool {
+	return false
+}
+
+type mockedUrlPolicy struct{}
+
+func (mockedUrlPolicy) CheckFeedUrl(url string) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"github.com/MichalMitros/feed-parser/app"
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/feedregistry"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+)
+
+// HTTP handlers of the service with their dependencies
+type Handlers struct {
+	Runner FeedRunnerInterface
+	// Registry of managed feeds, nil when it's not enabled
+	Registry feedregistry.FeedRegistryInterface
+	// Scheduled runs of registered feeds, nil when it's not enabled
+	Scheduler SchedulerInterface
+	// Work queue of parse requests, nil when requests are parsed locally
+	Jobs JobDispatcherInterface
+	// Quotas of API clients, nil when no quotas are set
+	Quotas QuotaLimiterInterface
+	// Clients accessing registered feeds and jobs of all clients
+	AdminClients map[string]bool
+	// Creates parser of previews publishing to given writer
+	NewPreviewParser func(writer queuewriter.QueueWriterInterface) *feedparser.FeedParser
+	Health           HealthProviderInterface
+	// Policy of feed urls in requests
+	UrlPolicy UrlPolicyInterface
+}
+
+// Creates handlers using dependencies of the container
+func NewHandlers(container *app.Container) *Handlers {
+	handlers := &Handlers{
+		Runner:           container.Runner,
+		Registry:         container.Registry,
+		NewPreviewParser: container.NewPreviewParser,
+		Health:           container,
+		UrlPolicy:        container,
+		AdminClients:     make(map[string]bool),
+	}
+	for _, client := range container.Config.Auth.AdminClients {
+		handlers.AdminClients[client] = true
+	}
+	if container.Scheduler != nil {
+		handlers.Scheduler = container.Scheduler
+	}
+	if container.Jobs != nil {
+		handlers.Jobs = container.Jobs
+	}
+	if container.Quotas != nil {
+		handlers.Quotas = container.Quotas
+	}
+	return handlers
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Outbox state of health response
+type outboxHealth struct {
+	Enabled      bool `json:"enabled"`
+	BacklogItems int  `json:"backlogItems"`
+}
+
+// Liveness of the service, responds with 200 OK also when dependencies
+// fail, so the service isn't restarted because of broker outage
+func (h *Handlers) GetHealth(c *gin.Context) {
+	health := outboxHealth{}
+	health.BacklogItems, health.Enabled = h.Health.OutboxBacklog()
+
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"status": "OK",
+		"checks": h.Health.HealthChecks(),
+		"outbox": health,
+		"sinks":  h.Health.SinksStatus(),
+	})
+}
+
+// Readiness of the service, responds with 503 Service Unavailable when
+// any dependency check fails and after shutdown has started
+func (h *Handlers) GetReady(c *gin.Context) {
+	checks := h.Health.HealthChecks()
+	status, code := "READY", http.StatusOK
+	if h.Health.Draining() {
+		status, code = "DRAINING", http.StatusServiceUnavailable
+	} else {
+		for _, check := range checks {
+			if check.Status == models.CheckFail {
+				status, code = "NOT_READY", http.StatusServiceUnavailable
+				break
+			}
+		}
+	}
+
+	c.IndentedJSON(code, gin.H{
+		"status": status,
+		"checks": checks,
+		"sinks":  h.Health.SinksStatus(),
+	})
+}
+
+// Responds with 503 Service Unavailable and returns true
+// when shutdown has started
+func (h *Handlers) rejectDraining(c *gin.Context) bool {
+	if !h.Health.Draining() {
+		return false
+	}
+	h.respondDraining(c)
+	return true
+}
+
+// Responds with 503 Service Unavailable because of shutdown
+func (h *Handlers) respondDraining(c *gin.Context) {
+	c.IndentedJSON(http.StatusServiceUnavailable, gin.H{
+		"status":  "SERVICE_UNAVAILABLE",
+		"message": "Service is shutting down",
+	})
+}
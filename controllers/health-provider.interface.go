@@ -0,0 +1,17 @@
+package controllers
+
+import "github.com/MichalMitros/feed-parser/models"
+
+// Source of service state reported by health and readiness endpoints,
+// e.g. app.Container
+type HealthProviderInterface interface {
+	// Readiness of all queue writers
+	SinksStatus() []models.SinkStatus
+	// Number of items waiting in the outbox,
+	// false when the outbox is not enabled
+	OutboxBacklog() (int, bool)
+	// Checks if shutdown has started
+	Draining() bool
+	// Checks of dependencies, any failed check makes the service not ready
+	HealthChecks() []models.HealthCheck
+}
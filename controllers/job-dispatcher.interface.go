@@ -0,0 +1,11 @@
+package controllers
+
+import "github.com/MichalMitros/feed-parser/models"
+
+// Queue of parse requests processed by workers, e.g. jobqueue.Dispatcher
+type JobDispatcherInterface interface {
+	// Adds job of client parsing urls and registered feeds to the work queue
+	Enqueue(client string, feedUrls []string, feedIds []string) (models.JobStatus, error)
+	// Returns the latest status of job, false when it's unknown
+	Status(jobId string) (models.JobStatus, bool)
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/MichalMitros/feed-parser/controllers/contracts"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (h *Handlers) GetJob(c *gin.Context) {
+	// Jobs of other clients are not found
+	status, ok := h.Jobs.Status(c.Param("id"))
+	if !ok || !h.canAccess(c, status.Client) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{
+			"status":  "NOT_FOUND",
+			"message": "job not found",
+		})
+		return
+	}
+	c.IndentedJSON(http.StatusOK, status)
+}
+
+// Adds parse request to the work queue and responds with id of the job.
+// Registered feeds are checked only when this instance has the registry,
+// otherwise workers report unknown feeds in the job status
+func (h *Handlers) enqueueParseFeed(c *gin.Context) {
+	defer zap.L().Sync()
+
+	request, ok := h.bindParseFeedRequest(c)
+	if !ok {
+		return
+	}
+	if h.Registry != nil {
+		if _, err := h.Runner.Resolve(request.FeedIds); err != nil {
+			zap.L().Warn("POST /parse-feed Bad Request", zap.Error(err))
+			c.IndentedJSON(http.StatusBadRequest, gin.H{
+				"status":  "BAD_REQUEST",
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	release, ok := h.acquireQuota(c, len(request.FeedUrls)+len(request.FeedIds))
+	if !ok {
+		return
+	}
+
+	status, err := h.Jobs.Enqueue(Client(c), request.FeedUrls, request.FeedIds)
+	if err != nil {
+		release()
+		zap.L().Error("Cannot enqueue parse job", zap.Error(err))
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "SERVICE_UNAVAILABLE",
+			"message": err.Error(),
+		})
+		return
+	}
+	// Job holds quota slot of the client until workers finish it
+	go h.releaseWhenFinished(status.JobId, release)
+
+	c.IndentedJSON(http.StatusAccepted, contracts.ParseJobResponse{
+		Status: "ACCEPTED",
+		JobId:  status.JobId,
+	})
+}
@@ -3,102 +3,152 @@ package controllers
 import (
 	"fmt"
 	"net/http"
-	"os"
 
 	"github.com/MichalMitros/feed-parser/controllers/contracts"
 	"github.com/MichalMitros/feed-parser/feedparser"
-	"github.com/MichalMitros/feed-parser/filefetcher/httpfilefetcher"
-	"github.com/MichalMitros/feed-parser/fileparser/xmlparser"
-	"github.com/MichalMitros/feed-parser/queuewriter/rabbitwriter"
 	"github.com/gin-gonic/gin"
-	_ "github.com/joho/godotenv/autoload"
 	"go.uber.org/zap"
 )
 
-// Feed parser instance
-var feedParser *feedparser.FeedParser
-
-// Initialize feedParser
-func init() {
-	defer zap.L().Sync()
+func (h *Handlers) PostParseFeedAsync(c *gin.Context) {
+	if h.rejectDraining(c) {
+		return
+	}
 
-	fetcher := httpfilefetcher.DefaultHttpFileFetcher()
+	// Only enqueue the request when workers parse feeds
+	if h.Jobs != nil {
+		h.enqueueParseFeed(c)
+		return
+	}
 
-	queueWriter, err := rabbitwriter.NewRabbitWriter(
-		rabbitwriter.RabbitWriterOptions{
-			Hostname: getEnvVarOrPanic("RABBITMQ_HOST"),
-			Username: getEnvVarOrPanic("RABBITMQ_USER"),
-			Password: getEnvVarOrPanic("RABBITMQ_PASSWORD"),
-		},
-	)
+	sources, ok := h.bindFeedSources(c)
+	if !ok {
+		return
+	}
+	release, ok := h.acquireQuota(c, len(sources))
+	if !ok {
+		return
+	}
+	// Feeds are registered before responding, so shutdown waits for them
+	run, err := h.Runner.Start(sources)
 	if err != nil {
-		zap.L().Panic(
-			"Cannot establish connection to RabbitMQ",
-			zap.Error(err),
-		)
+		release()
+		h.respondDraining(c)
+		return
 	}
 
-	fileParser := xmlparser.NewXmlFeedParser()
+	// Parse all feeds from the request
+	go func() {
+		defer release()
+		run()
+	}()
 
-	// Create FeedParser instance for controllers usage
-	feedParser = feedparser.NewFeedParser(fetcher, fileParser, queueWriter)
+	// Send response
+	c.IndentedJSON(http.StatusAccepted, gin.H{
+		"status": "ACCEPTED",
+	})
 }
 
-func PostParseFeedAsync(c *gin.Context) {
-	defer zap.L().Sync()
+func (h *Handlers) PostParseFeed(c *gin.Context) {
+	if h.rejectDraining(c) {
+		return
+	}
 
-	// Parse request json to object
-	var request contracts.ParseFeedRequest
-	if err := c.BindJSON(&request); err != nil || len(request.FeedUrls) == 0 {
-		zap.L().Warn("POST /parse-feed Bad Request", zap.Error(err))
-		c.IndentedJSON(http.StatusBadRequest, gin.H{
-			"status":  "BAD_REQUEST",
-			"message": "Request should contain field 'feedUrls' with not empty list of urls",
-		})
+	// Only enqueue the request when workers parse feeds
+	if h.Jobs != nil {
+		h.enqueueParseFeed(c)
+		return
+	}
+
+	sources, ok := h.bindFeedSources(c)
+	if !ok {
+		return
+	}
+	release, ok := h.acquireQuota(c, len(sources))
+	if !ok {
+		return
+	}
+	defer release()
+	run, err := h.Runner.Start(sources)
+	if err != nil {
+		h.respondDraining(c)
 		return
 	}
 
 	// Parse all feeds from the request
-	go feedParser.ParseFeedFiles(request.FeedUrls)
+	statuses := run()
 
 	// Send response
-	c.IndentedJSON(http.StatusAccepted, gin.H{
-		"status": "ACCEPTED",
+	c.IndentedJSON(http.StatusOK, contracts.ParseFeedResponse{
+		Statuses: statuses,
 	})
 }
 
-func PostParseFeed(c *gin.Context) {
+// Parses request json into sources of urls and registered feeds,
+// responds with 400 Bad Request and returns false when it's invalid
+func (h *Handlers) bindFeedSources(c *gin.Context) ([]feedparser.FeedSource, bool) {
 	defer zap.L().Sync()
 
-	// Parse request json to object
-	var request contracts.ParseFeedRequest
-	if err := c.BindJSON(&request); err != nil || len(request.FeedUrls) == 0 {
+	request, ok := h.bindParseFeedRequest(c)
+	if !ok {
+		return nil, false
+	}
+
+	// Find registered feeds
+	registered, err := h.Runner.Resolve(request.FeedIds)
+	if err != nil {
 		zap.L().Warn("POST /parse-feed Bad Request", zap.Error(err))
 		c.IndentedJSON(http.StatusBadRequest, gin.H{
 			"status":  "BAD_REQUEST",
-			"message": "Request should contain field 'feedUrls' with not empty list of urls",
+			"message": err.Error(),
 		})
-		return
+		return nil, false
 	}
 
-	// Parse all feeds from the request
-	statuses := feedParser.ParseFeedFiles(request.FeedUrls)
+	sources := make([]feedparser.FeedSource, 0, len(request.FeedUrls)+len(registered))
+	for _, url := range request.FeedUrls {
+		sources = append(sources, feedparser.FeedSource{Url: url})
+	}
+	return append(sources, registered...), true
+}
 
-	// Send response
-	c.IndentedJSON(http.StatusOK, contracts.ParseFeedResponse{
-		Statuses: statuses,
-	})
+// Parses request json, responds with 400 Bad Request
+// and returns false when it's invalid or has forbidden urls
+func (h *Handlers) bindParseFeedRequest(c *gin.Context) (contracts.ParseFeedRequest, bool) {
+	defer zap.L().Sync()
+
+	var request contracts.ParseFeedRequest
+	if err := c.BindJSON(&request); err != nil || len(request.FeedUrls)+len(request.FeedIds) == 0 {
+		zap.L().Warn("POST /parse-feed Bad Request", zap.Error(err))
+		c.IndentedJSON(http.StatusBadRequest, gin.H{
+			"status":  "BAD_REQUEST",
+			"message": "Request should contain field 'feedUrls' or 'feedIds' with not empty list",
+		})
+		return request, false
+	}
+	for _, url := range request.FeedUrls {
+		if !h.checkFeedUrl(c, url) {
+			return request, false
+		}
+	}
+	if h.Registry != nil && !h.checkFeedIds(c, request.FeedIds) {
+		return request, false
+	}
+	return request, true
 }
 
-// Get environment variable or panic when variable is not set
-func getEnvVarOrPanic(key string) string {
+// Responds with 400 Bad Request and returns false when url
+// is forbidden by url policy
+func (h *Handlers) checkFeedUrl(c *gin.Context, url string) bool {
 	defer zap.L().Sync()
 
-	envVar, isEnvSet := os.LookupEnv(key)
-	if !isEnvSet {
-		zap.L().Panic(
-			fmt.Sprintf("Required '%s' environment variable is not set", key),
-		)
+	if err := h.UrlPolicy.CheckFeedUrl(url); err != nil {
+		zap.L().Warn("Forbidden feed url", zap.String("feedUrl", url), zap.Error(err))
+		c.IndentedJSON(http.StatusBadRequest, gin.H{
+			"status":  "BAD_REQUEST",
+			"message": fmt.Sprintf("Feed url %q: %v", url, err),
+		})
+		return false
 	}
-	return envVar
+	return true
 }
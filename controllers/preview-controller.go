@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/MichalMitros/feed-parser/controllers/contracts"
+	"github.com/MichalMitros/feed-parser/queuewriter/memorywriter"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (h *Handlers) PostParseFeedPreview(c *gin.Context) {
+	defer zap.L().Sync()
+
+	// Parse request json to object
+	var request contracts.ParseFeedPreviewRequest
+	if err := c.BindJSON(&request); err != nil || len(request.FeedUrl) == 0 ||
+		request.Limit < 0 || request.Limit > contracts.MaxPreviewLimit {
+		zap.L().Warn("POST /parse-feed/preview Bad Request", zap.Error(err))
+		c.IndentedJSON(http.StatusBadRequest, gin.H{
+			"status":  "BAD_REQUEST",
+			"message": "Request should contain field 'feedUrl' and optional 'limit' up to 100",
+		})
+		return
+	}
+	if !h.checkFeedUrl(c, request.FeedUrl) {
+		return
+	}
+	release, ok := h.acquireQuota(c, 1)
+	if !ok {
+		return
+	}
+	defer release()
+
+	limit := request.Limit
+	if limit == 0 {
+		limit = contracts.DefaultPreviewLimit
+	}
+
+	// Parse feed into memory without publishing
+	writer := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{
+		MaxItemsPerQueue: limit,
+	})
+	result, err := h.NewPreviewParser(writer).ParseFeed(request.FeedUrl)
+	if err != nil {
+		c.IndentedJSON(http.StatusUnprocessableEntity, gin.H{
+			"status":  "PARSING_ERROR",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// Send response
+	outputs := make(map[string]contracts.OutputPreview)
+	for _, queueName := range writer.Queues() {
+		outputs[queueName] = contracts.OutputPreview{
+			TotalItems: writer.Total(queueName),
+			Items:      writer.Items(queueName),
+		}
+	}
+	c.IndentedJSON(http.StatusOK, contracts.ParseFeedPreviewResponse{
+		Result:  *result,
+		Outputs: outputs,
+	})
+}
@@ -0,0 +1,8 @@
+package controllers
+
+// Quotas of API clients, e.g. auth.QuotaLimiter
+type QuotaLimiterInterface interface {
+	// Starts job of client parsing number of feeds, returns function
+	// ending the job or error when client has reached its quota
+	Acquire(client string, feeds int) (func(), error)
+}
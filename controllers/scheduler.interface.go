@@ -0,0 +1,9 @@
+package controllers
+
+import "github.com/MichalMitros/feed-parser/models"
+
+// Source of scheduling state of registered feeds, e.g. scheduler.Scheduler
+type SchedulerInterface interface {
+	// Schedules of enabled feeds with next and last runs
+	Schedules() []models.FeedSchedule
+}
@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/MichalMitros/feed-parser/controllers/contracts"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handlers) GetSchedules(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, contracts.SchedulesResponse{
+		Schedules: h.Scheduler.Schedules(),
+	})
+}
@@ -0,0 +1,7 @@
+package controllers
+
+// Policy of feed urls which may be fetched, e.g. app.Container
+type UrlPolicyInterface interface {
+	// Returns error when url is forbidden
+	CheckFeedUrl(url string) error
+}
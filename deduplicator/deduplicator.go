@@ -0,0 +1,211 @@
+package deduplicator
+
+import (
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Policy of handling shop items with repeated ITEM_ID
+type Policy string
+
+const (
+	// Publish only the first occurrence of the item
+	KeepFirst Policy = "keep_first"
+	// Publish only the last occurrence of the item
+	KeepLast Policy = "keep_last"
+	// Drop all occurrences of the duplicated item
+	DropAll Policy = "drop_all"
+	// Publish all occurrences, repeated ones with Duplicate flag set
+	Flag Policy = "flag"
+)
+
+// Parses policy name, returns error for unknown policies
+func ParsePolicy(name string) (Policy, error) {
+	switch policy := Policy(name); policy {
+	case KeepFirst, KeepLast, DropAll, Flag:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown duplicates policy %q", name)
+}
+
+// Options of Deduplicator
+type DeduplicatorOptions struct {
+	Policy Policy
+	// Directory for temporary spill files used by KeepLast and DropAll
+	// policies, os.TempDir() is used when empty
+	SpillDir string
+}
+
+// Deduplicator removing or flagging shop items with repeated ITEM_ID.
+// Item ids are stored as 64-bit FNV-1a hashes to keep memory usage
+// low for feeds with millions of items.
+// KeepLast and DropAll policies need to see the whole feed before
+// publishing, so items are spilled to temporary file on disk
+type Deduplicator struct {
+	policy   Policy
+	spillDir string
+}
+
+// Creates new Deduplicator instance
+func NewDeduplicator(options DeduplicatorOptions) (*Deduplicator, error) {
+	policy, err := ParsePolicy(string(options.Policy))
+	if err != nil {
+		return nil, err
+	}
+	return &Deduplicator{
+		policy:   policy,
+		spillDir: options.SpillDir,
+	}, nil
+}
+
+// Reads all items from input and sends deduplicated items to output.
+// Closes output when finished. Input is always drained, also on error.
+// Safe for concurrent use
+func (d *Deduplicator) Deduplicate(
+	input chan models.ShopItem,
+	output chan models.ShopItem,
+) (*models.DuplicatesReport, error) {
+	// Close output channel when finished
+	defer close(output)
+
+	var report *models.DuplicatesReport
+	var err error
+	switch d.policy {
+	case KeepFirst, Flag:
+		report = d.deduplicateStreaming(input, output)
+	default:
+		report, err = d.deduplicateSpilled(input, output)
+	}
+	if err != nil {
+		// Drain input so upstream stages don't block
+		for range input {
+		}
+		return nil, err
+	}
+
+	duplicateItems.WithLabelValues(string(d.policy)).Add(float64(report.DuplicateItems))
+	return report, nil
+}
+
+// Deduplicate items in single pass for KeepFirst and Flag policies
+func (d *Deduplicator) deduplicateStreaming(
+	input chan models.ShopItem,
+	output chan models.ShopItem,
+) *models.DuplicatesReport {
+	report := &models.DuplicatesReport{Policy: string(d.policy)}
+	seen := make(map[uint64]struct{})
+	duplicatedIds := make(map[uint64]struct{})
+
+	for item := range input {
+		key := hashItemId(item.ItemID)
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			output <- item
+			continue
+		}
+		report.DuplicateItems++
+		duplicatedIds[key] = struct{}{}
+		if d.policy == Flag {
+			item.Duplicate = true
+			output <- item
+		} else {
+			report.DroppedItems++
+		}
+	}
+	report.DuplicateIds = len(duplicatedIds)
+
+	return report
+}
+
+// Deduplicate items for KeepLast and DropAll policies.
+// First pass writes items to spill file and counts occurrences of ids,
+// second pass reads spill file and sends selected items to output
+func (d *Deduplicator) deduplicateSpilled(
+	input chan models.ShopItem,
+	output chan models.ShopItem,
+) (*models.DuplicatesReport, error) {
+	spillFile, err := os.CreateTemp(d.spillDir, "feedparser-dedup-*.gob")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spillFile.Name())
+	defer spillFile.Close()
+
+	// Index of the last occurrence and number of occurrences per id
+	type occurrences struct {
+		lastIdx uint32
+		count   uint32
+	}
+	ids := make(map[uint64]occurrences)
+
+	// First pass
+	encoder := gob.NewEncoder(spillFile)
+	var idx uint32
+	for item := range input {
+		if err := encoder.Encode(&item); err != nil {
+			return nil, err
+		}
+		key := hashItemId(item.ItemID)
+		o := ids[key]
+		o.lastIdx = idx
+		o.count++
+		ids[key] = o
+		idx++
+	}
+
+	if _, err := spillFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// Second pass
+	report := &models.DuplicatesReport{Policy: string(d.policy)}
+	decoder := gob.NewDecoder(spillFile)
+	for itemIdx := uint32(0); itemIdx < idx; itemIdx++ {
+		var item models.ShopItem
+		if err := decoder.Decode(&item); err != nil {
+			return nil, err
+		}
+		o := ids[hashItemId(item.ItemID)]
+		if o.count == 1 {
+			output <- item
+			continue
+		}
+		if o.lastIdx == itemIdx {
+			report.DuplicateIds++
+			if d.policy == KeepLast {
+				output <- item
+				continue
+			}
+		}
+		report.DroppedItems++
+	}
+	for _, o := range ids {
+		if o.count > 1 {
+			report.DuplicateItems += int(o.count) - 1
+		}
+	}
+
+	return report, nil
+}
+
+// Returns 64-bit FNV-1a hash of itemId
+func hashItemId(itemId string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(itemId))
+	return h.Sum64()
+}
+
+// Prometheus duplicate items counter
+var (
+	duplicateItems = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_duplicate_items_total",
+		Help: "The total number of repeated ITEM_ID occurrences in parsed feeds",
+	}, []string{"policy"})
+)
@@ -0,0 +1,154 @@
+package deduplicator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestDeduplicatePolicies(t *testing.T) {
+	testCases := []struct {
+		policy         Policy
+		expectedItems  []models.ShopItem
+		expectedReport models.DuplicatesReport
+	}{
+		{
+			policy: KeepFirst,
+			expectedItems: []models.ShopItem{
+				{ItemID: "a", ProductName: "a_1"},
+				{ItemID: "b", ProductName: "b_1"},
+				{ItemID: "c", ProductName: "c_1"},
+			},
+			expectedReport: models.DuplicatesReport{Policy: "keep_first", DuplicateIds: 2, DuplicateItems: 3, DroppedItems: 3},
+		},
+		{
+			policy: KeepLast,
+			expectedItems: []models.ShopItem{
+				{ItemID: "c", ProductName: "c_1"},
+				{ItemID: "b", ProductName: "b_2"},
+				{ItemID: "a", ProductName: "a_3"},
+			},
+			expectedReport: models.DuplicatesReport{Policy: "keep_last", DuplicateIds: 2, DuplicateItems: 3, DroppedItems: 3},
+		},
+		{
+			policy: DropAll,
+			expectedItems: []models.ShopItem{
+				{ItemID: "c", ProductName: "c_1"},
+			},
+			expectedReport: models.DuplicatesReport{Policy: "drop_all", DuplicateIds: 2, DuplicateItems: 3, DroppedItems: 5},
+		},
+		{
+			policy: Flag,
+			expectedItems: []models.ShopItem{
+				{ItemID: "a", ProductName: "a_1"},
+				{ItemID: "b", ProductName: "b_1"},
+				{ItemID: "a", ProductName: "a_2", Duplicate: true},
+				{ItemID: "c", ProductName: "c_1"},
+				{ItemID: "b", ProductName: "b_2", Duplicate: true},
+				{ItemID: "a", ProductName: "a_3", Duplicate: true},
+			},
+			expectedReport: models.DuplicatesReport{Policy: "flag", DuplicateIds: 2, DuplicateItems: 3, DroppedItems: 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		d, err := NewDeduplicator(DeduplicatorOptions{
+			Policy:   tc.policy,
+			SpillDir: t.TempDir(),
+		})
+		if err != nil {
+			t.Fatalf("NewDeduplicator(%s), err = %v, want nil", tc.policy, err)
+		}
+
+		items, report, err := runDeduplicate(d, mockedItems)
+		if err != nil {
+			t.Fatalf("Deduplicator(%s).Deduplicate(), err = %v, want nil", tc.policy, err)
+		}
+
+		if !reflect.DeepEqual(items, tc.expectedItems) {
+			t.Fatalf(
+				"Deduplicator(%s).Deduplicate(), items = %v, want %v",
+				tc.policy,
+				items,
+				tc.expectedItems,
+			)
+		}
+		if !reflect.DeepEqual(*report, tc.expectedReport) {
+			t.Fatalf(
+				"Deduplicator(%s).Deduplicate(), report = %+v, want %+v",
+				tc.policy,
+				*report,
+				tc.expectedReport,
+			)
+		}
+	}
+}
+
+func TestDeduplicateSpillFailure(t *testing.T) {
+	d, _ := NewDeduplicator(DeduplicatorOptions{
+		Policy:   KeepLast,
+		SpillDir: "/nonexistent/spill/dir",
+	})
+
+	items, _, err := runDeduplicate(d, mockedItems)
+
+	if err == nil {
+		t.Fatalf("Deduplicator.Deduplicate() with wrong spill dir, expected error, got nil")
+	}
+	if len(items) != 0 {
+		t.Fatalf("Deduplicator.Deduplicate() with wrong spill dir, got %d items, want 0", len(items))
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	if _, err := ParsePolicy("keep_first"); err != nil {
+		t.Fatalf(`ParsePolicy("keep_first"), err = %v, want nil`, err)
+	}
+	if _, err := ParsePolicy("keep_random"); err == nil {
+		t.Fatalf(`ParsePolicy("keep_random"), expected error, got nil`)
+	}
+}
+
+// Sends items through the deduplicator and collects results
+func runDeduplicate(
+	d *Deduplicator,
+	items []models.ShopItem,
+) ([]models.ShopItem, *models.DuplicatesReport, error) {
+	input := make(chan models.ShopItem)
+	output := make(chan models.ShopItem)
+	go func() {
+		defer close(input)
+		for _, item := range items {
+			input <- item
+		}
+	}()
+
+	var report *models.DuplicatesReport
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		report, err = d.Deduplicate(input, output)
+	}()
+
+	results := []models.ShopItem{}
+	for item := range output {
+		results = append(results, item)
+	}
+	<-done
+
+	return results, report, err
+}
+
+// MOCKED DATA
+
+// Items with "a" repeated three times and "b" repeated twice
+var mockedItems = []models.ShopItem{
+	{ItemID: "a", ProductName: "a_1"},
+	{ItemID: "b", ProductName: "b_1"},
+	{ItemID: "a", ProductName: "a_2"},
+	{ItemID: "c", ProductName: "c_1"},
+	{ItemID: "b", ProductName: "b_2"},
+	{ItemID: "a", ProductName: "a_3"},
+}
@@ -0,0 +1,199 @@
+package deltadetector
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/snapshotstore"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Detector of shop items added, updated and removed since the last
+// successful feed run. Compares content hashes of items with the
+// feed snapshot stored in snapshotStore
+type DeltaDetector struct {
+	store snapshotstore.SnapshotStoreInterface
+
+	mutex sync.Mutex
+	// Feeds being detected, channels are closed when their snapshots
+	// are committed or aborted
+	running map[string]chan struct{}
+}
+
+// Feed snapshot created during detection, not stored until committed
+type Snapshot struct {
+	feedUrl string
+	hashes  map[string]uint64
+	unlock  sync.Once
+}
+
+// Creates new DeltaDetector instance
+func NewDeltaDetector(store snapshotstore.SnapshotStoreInterface) *DeltaDetector {
+	return &DeltaDetector{
+		store:   store,
+		running: make(map[string]chan struct{}),
+	}
+}
+
+// Reads all items from input and:
+// - sends every item to fullOutput (if not nil),
+// - sends added and updated items and tombstones of removed items
+// to eventsOutput with ChangeType set.
+// Closes outputs when finished. Input is always drained, also on error.
+// Returned snapshot should be committed when all events are published
+// or aborted otherwise. Detection of a feed waits until the snapshot of
+// its previous detection is committed or aborted, so concurrent runs
+// of the same feed don't compare against the same snapshot
+func (d *DeltaDetector) Detect(
+	feedUrl string,
+	input chan models.ShopItem,
+	fullOutput chan models.ShopItem,
+	eventsOutput chan models.ShopItem,
+) (*models.DeltaReport, *Snapshot, error) {
+	// Close channels after detection
+	defer close(eventsOutput)
+	if fullOutput != nil {
+		defer close(fullOutput)
+	}
+
+	d.lock(feedUrl)
+	previous, err := d.store.LoadSnapshot(feedUrl)
+	if err != nil {
+		d.unlock(feedUrl)
+		// Drain input so upstream stages don't block
+		for range input {
+		}
+		return nil, nil, err
+	}
+
+	report := &models.DeltaReport{}
+	current := make(map[string]uint64)
+	for item := range input {
+		if fullOutput != nil {
+			fullOutput <- item
+		}
+
+		// Items without id can't be tracked, so they are always added
+		if len(item.ItemID) == 0 {
+			item.ChangeType = models.ItemAdded
+			report.Added++
+			eventsOutput <- item
+			continue
+		}
+
+		// Repeated id was already compared with the previous snapshot
+		if _, seen := current[item.ItemID]; seen {
+			report.Duplicates++
+			continue
+		}
+
+		hash := HashItem(item)
+		current[item.ItemID] = hash
+		previousHash, existed := previous[item.ItemID]
+		// Items left in previous snapshot after the loop are removed
+		delete(previous, item.ItemID)
+
+		switch {
+		case !existed:
+			item.ChangeType = models.ItemAdded
+			report.Added++
+		case previousHash != hash:
+			item.ChangeType = models.ItemUpdated
+			report.Updated++
+		default:
+			report.Unchanged++
+			continue
+		}
+		eventsOutput <- item
+	}
+
+	// Send removed items tombstones
+	removedIds := make([]string, 0, len(previous))
+	for itemId := range previous {
+		removedIds = append(removedIds, itemId)
+	}
+	sort.Strings(removedIds)
+	for _, itemId := range removedIds {
+		report.Removed++
+		eventsOutput <- models.ShopItem{
+			ItemID:     itemId,
+			ChangeType: models.ItemRemoved,
+		}
+	}
+
+	deltaItems.WithLabelValues(string(models.ItemAdded)).Add(float64(report.Added))
+	deltaItems.WithLabelValues(string(models.ItemUpdated)).Add(float64(report.Updated))
+	deltaItems.WithLabelValues(string(models.ItemRemoved)).Add(float64(report.Removed))
+
+	return report, &Snapshot{feedUrl: feedUrl, hashes: current}, nil
+}
+
+// Stores snapshot created by Detect, so the next run
+// is compared against it
+func (d *DeltaDetector) Commit(snapshot *Snapshot) error {
+	defer d.release(snapshot)
+	return d.store.SaveSnapshot(snapshot.feedUrl, snapshot.hashes)
+}
+
+// Drops snapshot created by Detect when its events can't be published,
+// so the next run is compared against the last committed snapshot
+func (d *DeltaDetector) Abort(snapshot *Snapshot) {
+	d.release(snapshot)
+}
+
+// Lets the next detection of snapshot's feed start, only once
+func (d *DeltaDetector) release(snapshot *Snapshot) {
+	snapshot.unlock.Do(func() {
+		d.unlock(snapshot.feedUrl)
+	})
+}
+
+// Waits until feed isn't being detected and marks it as being detected
+func (d *DeltaDetector) lock(feedUrl string) {
+	for {
+		d.mutex.Lock()
+		done, ok := d.running[feedUrl]
+		if !ok {
+			d.running[feedUrl] = make(chan struct{})
+			d.mutex.Unlock()
+			return
+		}
+		d.mutex.Unlock()
+		<-done
+	}
+}
+
+// Marks feed as not being detected and wakes up waiting detections
+func (d *DeltaDetector) unlock(feedUrl string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if done, ok := d.running[feedUrl]; ok {
+		close(done)
+		delete(d.running, feedUrl)
+	}
+}
+
+// Returns 64-bit FNV-1a hash of item content.
+// Pipeline flags (Duplicate, ChangeType) and feed metadata
+// are not part of the content
+func HashItem(item models.ShopItem) uint64 {
+	item.Duplicate = false
+	item.ChangeType = ""
+	item.Metadata = nil
+	body, _ := json.Marshal(item)
+	h := fnv.New64a()
+	h.Write(body)
+	return h.Sum64()
+}
+
+// Prometheus changed items counter
+var (
+	deltaItems = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_delta_items_total",
+		Help: "The total number of shop items changed since the last feed run",
+	}, []string{"change"})
+)
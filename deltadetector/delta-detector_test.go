@@ -0,0 +1,202 @@
+package deltadetector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestDetectChanges(t *testing.T) {
+	store := NewMockedSnapshotStore()
+	detector := NewDeltaDetector(store)
+
+	// First run, all items are added
+	firstRun := []models.ShopItem{
+		{ItemID: "a", PriceVat: "10"},
+		{ItemID: "b", PriceVat: "20"},
+		{ItemID: "c", PriceVat: "30"},
+	}
+	full, events, report, err := runDetect(detector, firstRun)
+	if err != nil {
+		t.Fatalf("DeltaDetector.Detect(firstRun), err = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(full, firstRun) {
+		t.Fatalf("DeltaDetector.Detect(firstRun), full output = %v, want %v", full, firstRun)
+	}
+	expectedReport := models.DeltaReport{Added: 3}
+	if !reflect.DeepEqual(*report, expectedReport) || len(events) != 3 {
+		t.Fatalf(
+			"DeltaDetector.Detect(firstRun), report = %+v with %d events, want %+v",
+			*report,
+			len(events),
+			expectedReport,
+		)
+	}
+
+	// Second run compared with the first one
+	secondRun := []models.ShopItem{
+		{ItemID: "a", PriceVat: "10"},
+		{ItemID: "b", PriceVat: "25"},
+		{ItemID: "d", PriceVat: "40"},
+	}
+	_, events, report, err = runDetect(detector, secondRun)
+	if err != nil {
+		t.Fatalf("DeltaDetector.Detect(secondRun), err = %v, want nil", err)
+	}
+	expectedEvents := []models.ShopItem{
+		{ItemID: "b", PriceVat: "25", ChangeType: models.ItemUpdated},
+		{ItemID: "d", PriceVat: "40", ChangeType: models.ItemAdded},
+		{ItemID: "c", ChangeType: models.ItemRemoved},
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Fatalf("DeltaDetector.Detect(secondRun), events = %v, want %v", events, expectedEvents)
+	}
+	expectedReport = models.DeltaReport{Added: 1, Updated: 1, Removed: 1, Unchanged: 1}
+	if !reflect.DeepEqual(*report, expectedReport) {
+		t.Fatalf("DeltaDetector.Detect(secondRun), report = %+v, want %+v", *report, expectedReport)
+	}
+}
+
+func TestDetectWithoutCommit(t *testing.T) {
+	store := NewMockedSnapshotStore()
+	detector := NewDeltaDetector(store)
+	items := []models.ShopItem{{ItemID: "a"}}
+
+	// Snapshot is not stored until committed
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	_, snapshot, _ := detector.Detect("test_url", input, nil, make(chan models.ShopItem, len(items)))
+
+	// Next run of the feed waits until the snapshot is aborted
+	detected := make(chan []models.ShopItem)
+	go func() {
+		_, events, _, _ := runDetect(detector, items)
+		detected <- events
+	}()
+	select {
+	case <-detected:
+		t.Fatalf("DeltaDetector.Detect() before abort, returned, want waiting for previous run")
+	case <-time.After(20 * time.Millisecond):
+	}
+	detector.Abort(snapshot)
+
+	if events := <-detected; len(events) != 1 || events[0].ChangeType != models.ItemAdded {
+		t.Fatalf("DeltaDetector.Detect() after aborted run, events = %v, want single added item", events)
+	}
+}
+
+func TestDetectRepeatedItemId(t *testing.T) {
+	detector := NewDeltaDetector(NewMockedSnapshotStore())
+	runDetect(detector, []models.ShopItem{{ItemID: "a", PriceVat: "10"}})
+
+	// Repeated id is neither added nor compared again
+	_, events, report, _ := runDetect(detector, []models.ShopItem{
+		{ItemID: "a", PriceVat: "10"},
+		{ItemID: "a", PriceVat: "15"},
+		{ItemID: "b", PriceVat: "20"},
+		{ItemID: "b", PriceVat: "20"},
+	})
+	expectedEvents := []models.ShopItem{{ItemID: "b", PriceVat: "20", ChangeType: models.ItemAdded}}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Fatalf("DeltaDetector.Detect(repeated), events = %v, want %v", events, expectedEvents)
+	}
+	expectedReport := models.DeltaReport{Added: 1, Unchanged: 1, Duplicates: 2}
+	if !reflect.DeepEqual(*report, expectedReport) {
+		t.Fatalf("DeltaDetector.Detect(repeated), report = %+v, want %+v", *report, expectedReport)
+	}
+}
+
+func TestDetectStoreFailure(t *testing.T) {
+	store := NewMockedSnapshotStore()
+	store.err = errors.New("mocked store error")
+	detector := NewDeltaDetector(store)
+
+	_, events, _, err := runDetect(detector, []models.ShopItem{{ItemID: "a"}})
+
+	if err == nil {
+		t.Fatalf("DeltaDetector.Detect() with failing store, expected error, got nil")
+	}
+	if len(events) != 0 {
+		t.Fatalf("DeltaDetector.Detect() with failing store, events = %v, want none", events)
+	}
+}
+
+func TestHashItemIgnoresFlags(t *testing.T) {
+	item := models.ShopItem{ItemID: "a", PriceVat: "10"}
+	flagged := item
+	flagged.Duplicate = true
+	flagged.ChangeType = models.ItemUpdated
+
+	if HashItem(item) != HashItem(flagged) {
+		t.Fatalf("HashItem(flagged) != HashItem(item), want equal hashes")
+	}
+}
+
+// Sends items through the detector, collects results and commits snapshot
+func runDetect(
+	detector *DeltaDetector,
+	items []models.ShopItem,
+) ([]models.ShopItem, []models.ShopItem, *models.DeltaReport, error) {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	full := make(chan models.ShopItem, len(items))
+	events := make(chan models.ShopItem, 2*len(items))
+
+	report, snapshot, err := detector.Detect("test_url", input, full, events)
+	if err == nil {
+		err = detector.Commit(snapshot)
+	}
+
+	fullItems := []models.ShopItem{}
+	for item := range full {
+		fullItems = append(fullItems, item)
+	}
+	eventItems := []models.ShopItem{}
+	for item := range events {
+		eventItems = append(eventItems, item)
+	}
+
+	return fullItems, eventItems, report, err
+}
+
+// MOCKED DATA
+
+// Mocked in-memory SnapshotStore
+type MockedSnapshotStore struct {
+	snapshots map[string]map[string]uint64
+	err       error
+}
+
+func NewMockedSnapshotStore() *MockedSnapshotStore {
+	return &MockedSnapshotStore{
+		snapshots: make(map[string]map[string]uint64),
+	}
+}
+
+func (s *MockedSnapshotStore) LoadSnapshot(feedUrl string) (map[string]uint64, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	snapshot := make(map[string]uint64)
+	for k, v := range s.snapshots[feedUrl] {
+		snapshot[k] = v
+	}
+	return snapshot, nil
+}
+
+func (s *MockedSnapshotStore) SaveSnapshot(feedUrl string, snapshot map[string]uint64) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.snapshots[feedUrl] = snapshot
+	return nil
+}
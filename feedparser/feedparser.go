@@ -1,13 +1,20 @@
 package feedparser
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"sync"
 	"time"
 
+	"github.com/MichalMitros/feed-parser/deduplicator"
+	"github.com/MichalMitros/feed-parser/deltadetector"
 	"github.com/MichalMitros/feed-parser/filefetcher"
 	"github.com/MichalMitros/feed-parser/fileparser"
+	"github.com/MichalMitros/feed-parser/itemrouter"
+	"github.com/MichalMitros/feed-parser/itemtransformer"
+	"github.com/MichalMitros/feed-parser/itemvalidator"
 	"github.com/MichalMitros/feed-parser/models"
 	"github.com/MichalMitros/feed-parser/queuewriter"
 	"github.com/prometheus/client_golang/prometheus"
@@ -17,21 +24,108 @@ import (
 )
 
 type FeedParser struct {
-	fetcher     filefetcher.FileFetcherInterface
-	fileParser  fileparser.FeedFileParserInterface
-	queueWriter queuewriter.QueueWriterInterface
+	fetcher          filefetcher.FileFetcherInterface
+	fileParser       fileparser.FeedFileParserInterface
+	queueWriter      queuewriter.QueueWriterInterface
+	validator        itemvalidator.ItemValidatorInterface
+	diagnosticsSink  itemvalidator.DiagnosticsSinkInterface
+	reportSampleSize int
+	deduplicator     *deduplicator.Deduplicator
+	deltaDetector    *deltadetector.DeltaDetector
+	deltaQueueName   string
+	skipFullStream   bool
+	routesProvider   itemrouter.RoutesProviderInterface
+	transforms       *itemtransformer.FeedTransforms
 }
 
+// Feed to parse with its registry data
+type FeedSource struct {
+	Url string
+	// Identifier of registered feed, empty for ad-hoc urls
+	FeedId string
+	// Shop of the feed, shop from transforms configuration
+	// is used when empty
+	ShopId string
+	// Outputs of the feed, routes of FeedParser are used when nil
+	RoutesProvider itemrouter.RoutesProviderInterface
+}
+
+// Optional stages of FeedParser pipeline
+type FeedParserOptions struct {
+	// Shop items validator, validation stage is skipped when nil
+	Validator itemvalidator.ItemValidatorInterface
+	// Sink for invalid shop items, invalid items are dropped when nil
+	DiagnosticsSink itemvalidator.DiagnosticsSinkInterface
+	// Number of offending item ids per rule in the quality report,
+	// itemvalidator.DefaultSampleSize is used when 0
+	ReportSampleSize int
+	// Deduplicator of repeated ITEM_IDs, duplicates are published when nil
+	Deduplicator *deduplicator.Deduplicator
+	// Detector of changes since the last run, delta events are not
+	// published when nil
+	DeltaDetector *deltadetector.DeltaDetector
+	// Queue for delta events, DefaultDeltaQueueName is used when empty
+	DeltaQueueName string
+	// Publish only delta events without the full items stream,
+	// used only with DeltaDetector
+	SkipFullStream bool
+	// Provider of named outputs for the full items stream,
+	// itemrouter.DefaultRoutingConfig is used when nil
+	RoutesProvider itemrouter.RoutesProviderInterface
+	// Per-feed transform chains, transform stage is skipped when nil
+	Transforms *itemtransformer.FeedTransforms
+}
+
+// Default name of the queue for delta events
+const DefaultDeltaQueueName = "shop_items_delta"
+
 // Creates new FeedParser instance
 func NewFeedParser(
 	fetcher filefetcher.FileFetcherInterface,
 	fileParser fileparser.FeedFileParserInterface,
 	queueWriter queuewriter.QueueWriterInterface,
 ) *FeedParser {
+	return NewFeedParserWithOptions(
+		fetcher,
+		fileParser,
+		queueWriter,
+		FeedParserOptions{},
+	)
+}
+
+// Creates new FeedParser instance with optional pipeline stages
+func NewFeedParserWithOptions(
+	fetcher filefetcher.FileFetcherInterface,
+	fileParser fileparser.FeedFileParserInterface,
+	queueWriter queuewriter.QueueWriterInterface,
+	options FeedParserOptions,
+) *FeedParser {
+	reportSampleSize := options.ReportSampleSize
+	if reportSampleSize == 0 {
+		reportSampleSize = itemvalidator.DefaultSampleSize
+	}
+	deltaQueueName := options.DeltaQueueName
+	if len(deltaQueueName) == 0 {
+		deltaQueueName = DefaultDeltaQueueName
+	}
+	routesProvider := options.RoutesProvider
+	if routesProvider == nil {
+		// Default routing configuration is always valid
+		routesProvider, _ = itemrouter.NewStaticRoutes(itemrouter.DefaultRoutingConfig)
+	}
 	return &FeedParser{
-		fetcher:     fetcher,
-		fileParser:  fileParser,
-		queueWriter: queueWriter,
+		fetcher:          fetcher,
+		fileParser:       fileParser,
+		queueWriter:      queueWriter,
+		validator:        options.Validator,
+		diagnosticsSink:  options.DiagnosticsSink,
+		reportSampleSize: reportSampleSize,
+		deduplicator:     options.Deduplicator,
+		deltaDetector:    options.DeltaDetector,
+		deltaQueueName:   deltaQueueName,
+		skipFullStream:   options.DeltaDetector != nil && options.SkipFullStream,
+		routesProvider:   routesProvider,
+		transforms:       options.Transforms,
 	}
 }
 
@@ -40,40 +134,58 @@ func NewFeedParser(
 // Save for concurrent use.
 // For large feed files in feedUrls should be called as separate routine.
 func (p *FeedParser) ParseFeedFiles(feedUrls []string) []models.FeedParsingResult {
+	sources := make([]FeedSource, len(feedUrls))
+	for idx, url := range feedUrls {
+		sources[idx] = FeedSource{Url: url}
+	}
+	return p.ParseFeedSources(sources)
+}
+
+// Parse many feeds concurently and wait for parsing results.
+// Returns array of parsing results for each source.
+// Save for concurrent use
+func (p *FeedParser) ParseFeedSources(sources []FeedSource) []models.FeedParsingResult {
 	var wg sync.WaitGroup
-	parsingStatuses := []models.FeedParsingResult{}
-	for _, url := range feedUrls {
+	parsingStatuses := make([]models.FeedParsingResult, len(sources))
+	for idx, source := range sources {
 		wg.Add(1)
 		parsingFeeds.Inc()
-		go func(url string, parsingStatus []models.FeedParsingResult, wg *sync.WaitGroup) {
+		go func(idx int, source FeedSource, wg *sync.WaitGroup) {
 			defer wg.Done()
-			parsingResult := models.FeedParsingResult{
-				FeedUrl: url,
-				Status:  models.ParsingErrors,
-			}
-			processingTime, err := p.ParseFeed(url)
-			if err == nil {
-				parsingResult.Status = models.ParsedSuccessfully
-				if processingTime != nil {
-					parsingResult.ParsingTime = processingTime.String()
+			defer parsingFeeds.Dec()
+			parsingResult, err := p.ParseFeedSource(source)
+			if err != nil {
+				parsingResult = &models.FeedParsingResult{
+					FeedUrl: source.Url,
+					FeedId:  source.FeedId,
+					Status:  models.ParsingErrors,
 				}
 			}
-			parsingStatuses = append(parsingStatuses, parsingResult)
-			parsingFeeds.Dec()
-		}(url, parsingStatuses, &wg)
+			parsingStatuses[idx] = *parsingResult
+		}(idx, source, &wg)
 	}
 	wg.Wait()
 	return parsingStatuses
 }
 
 // Parse single feed file from feedUrl
-// and send filtered results to queueWriter
+// and send filtered results to queueWriter.
+// Returns parsing result with processing time and quality report.
 // Save for concurrent
 func (p *FeedParser) ParseFeed(
 	feedUrl string,
-) (processingTime *time.Duration, err error) {
+) (*models.FeedParsingResult, error) {
+	return p.ParseFeedSource(FeedSource{Url: feedUrl})
+}
+
+// Parse single feed with its registry data,
+// see ParseFeed. Save for concurrent use
+func (p *FeedParser) ParseFeedSource(
+	source FeedSource,
+) (*models.FeedParsingResult, error) {
 	defer zap.L().Sync()
 
+	feedUrl := source.Url
 	start := time.Now()
 	g := new(errgroup.Group)
 
@@ -82,6 +194,21 @@ func (p *FeedParser) ParseFeed(
 		zap.String("feedUrl", feedUrl),
 	)
 
+	// Get current outputs of the items stream
+	routesProvider := p.routesProvider
+	if source.RoutesProvider != nil {
+		routesProvider = source.RoutesProvider
+	}
+	routes, err := routesProvider.GetRoutes()
+	if err != nil {
+		zap.L().Error(
+			"Error while getting routing configuration",
+			zap.String("feedUrl", feedUrl),
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
 	// Fetch feed file from url
 	feedFile, lastModified, err := p.fetcher.FetchFile(feedUrl)
 	if err != nil {
@@ -92,6 +219,14 @@ func (p *FeedParser) ParseFeed(
 		)
 		return nil, err
 	}
+	fetchedAt := time.Now()
+	metadata := models.PublishMetadata{
+		FeedUrl:       feedUrl,
+		ShopId:        source.ShopId,
+		JobId:         newJobId(),
+		ParsedAt:      fetchedAt,
+		SchemaVersion: models.SchemaVersion,
+	}
 	// Check if feed has last modified value
 	logFeedLastModification(feedUrl, lastModified)
 
@@ -108,26 +243,96 @@ func (p *FeedParser) ParseFeed(
 	}
 	p.parseFeedFileAsync(feedFile, parsedShopItems, g)
 
-	// Create channels for filtered shop items
-	allItems := make(chan models.ShopItem)
-	biddingItems := make(chan models.ShopItem)
-
-	// Filter items
-	zap.L().Info("Filtering shop items", zap.String("feedUrl", feedUrl))
-	p.filterItemsAsync(
-		parsedShopItems,
-		allItems,
-		biddingItems,
-		g,
-	)
+	// Transform items
+	transformedShopItems := parsedShopItems
+	if p.transforms != nil {
+		chain, shopId := p.transforms.ForFeed(feedUrl)
+		if len(metadata.ShopId) == 0 {
+			metadata.ShopId = shopId
+		}
+		if len(chain) > 0 {
+			zap.L().Info("Transforming shop items", zap.String("feedUrl", feedUrl))
+			transformedShopItems = make(chan models.ShopItem)
+			p.transformItemsAsync(
+				chain,
+				models.FeedMetadata{
+					ShopId:    metadata.ShopId,
+					FeedUrl:   feedUrl,
+					FetchedAt: fetchedAt,
+				},
+				parsedShopItems,
+				transformedShopItems,
+				g,
+			)
+		}
+	}
+
+	// Validate items
+	validShopItems := transformedShopItems
+	var report *itemvalidator.QualityReportBuilder
+	if p.validator != nil {
+		zap.L().Info("Validating shop items", zap.String("feedUrl", feedUrl))
+		validShopItems = make(chan models.ShopItem)
+		report = itemvalidator.NewQualityReportBuilder(p.reportSampleSize)
+		p.validateItemsAsync(feedUrl, transformedShopItems, validShopItems, report, g)
+	}
 
-	// Publishing shop item to the queue
-	zap.L().Info("Publishing shop items", zap.String("feedUrl", feedUrl))
-	p.writeItemsToQueueAsync("shop_items", allItems, g)
-	p.writeItemsToQueueAsync("shop_items_bidding", biddingItems, g)
+	// Remove duplicated items
+	uniqueShopItems := validShopItems
+	var duplicatesReport *models.DuplicatesReport
+	if p.deduplicator != nil {
+		zap.L().Info("Removing duplicated shop items", zap.String("feedUrl", feedUrl))
+		uniqueShopItems = make(chan models.ShopItem)
+		p.deduplicateItemsAsync(validShopItems, uniqueShopItems, &duplicatesReport, g)
+	}
+
+	// Detect changes since the last run
+	fullShopItems := uniqueShopItems
+	var deltaReport *models.DeltaReport
+	var snapshot *deltadetector.Snapshot
+	if p.deltaDetector != nil {
+		zap.L().Info("Detecting shop items changes", zap.String("feedUrl", feedUrl))
+		deltaEvents := make(chan models.ShopItem)
+		fullShopItems = nil
+		if !p.skipFullStream {
+			fullShopItems = make(chan models.ShopItem)
+		}
+		p.detectDeltaAsync(
+			feedUrl,
+			uniqueShopItems,
+			fullShopItems,
+			deltaEvents,
+			&deltaReport,
+			&snapshot,
+			g,
+		)
+		p.writeItemsToQueueAsync(p.deltaQueueName, metadata, deltaEvents, g)
+	}
+
+	if fullShopItems != nil {
+		// Create channels for routed shop items
+		routedItems := make([]chan models.ShopItem, len(routes))
+		for idx := range routes {
+			routedItems[idx] = make(chan models.ShopItem)
+		}
+
+		// Route items
+		zap.L().Info("Routing shop items", zap.String("feedUrl", feedUrl))
+		p.routeItemsAsync(routes, fullShopItems, routedItems, g)
+
+		// Publishing shop item to the queues
+		zap.L().Info("Publishing shop items", zap.String("feedUrl", feedUrl))
+		for idx, route := range routes {
+			p.writeItemsToQueueAsync(route.Name, metadata, routedItems[idx], g)
+		}
+	}
 
 	// Wait for all routines to complete
 	if err := g.Wait(); err != nil {
+		// Next run is compared with the last committed snapshot
+		if snapshot != nil {
+			p.deltaDetector.Abort(snapshot)
+		}
 		zap.L().Error(
 			fmt.Sprintf("Error during parsing feed from %s", feedUrl),
 			zap.String("feedUrl", feedUrl),
@@ -136,54 +341,192 @@ func (p *FeedParser) ParseFeed(
 		return nil, err
 	}
 
+	// Store feed snapshot only when all delta events are published
+	if snapshot != nil {
+		if err := p.deltaDetector.Commit(snapshot); err != nil {
+			zap.L().Error(
+				"Error while storing feed snapshot",
+				zap.String("feedUrl", feedUrl),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+	}
+
 	elapsed := time.Since(start)
-	processingTime = &elapsed
+	result := &models.FeedParsingResult{
+		FeedUrl:     feedUrl,
+		FeedId:      source.FeedId,
+		Status:      models.ParsedSuccessfully,
+		ParsingTime: elapsed.String(),
+		Duplicates:  duplicatesReport,
+		Delta:       deltaReport,
+		JobId:       metadata.JobId,
+	}
+	if report != nil {
+		result.QualityReport = report.Build()
+		logQualityReport(feedUrl, result.QualityReport)
+	}
+	if duplicatesReport != nil && duplicatesReport.DuplicateItems > 0 {
+		zap.L().Warn(
+			fmt.Sprintf(
+				"Feed %s contains %d duplicated shop items",
+				feedUrl,
+				duplicatesReport.DuplicateItems,
+			),
+			zap.String("feedUrl", feedUrl),
+			zap.Any("duplicates", duplicatesReport),
+		)
+	}
 	zap.L().Info(
 		fmt.Sprintf("Successfully finished parsing feed from %s", feedUrl),
 		zap.String("feedUrl", feedUrl),
 		zap.String("processingTime", elapsed.String()),
 	)
 
-	return processingTime, nil
+	return result, nil
 }
 
-// Run routine for shop items filtering
-func (p *FeedParser) filterItemsAsync(
+// Run routine applying transform chain to shop items from input
+// and sending transformed items to output
+func (p *FeedParser) transformItemsAsync(
+	chain itemtransformer.Chain,
+	feed models.FeedMetadata,
 	input chan models.ShopItem,
-	allItemsOutput chan models.ShopItem,
-	biddingItemsOutput chan models.ShopItem,
+	output chan models.ShopItem,
 	g *errgroup.Group,
 ) {
 	g.Go(
 		func() error {
-			p.filterItems(input, allItemsOutput, biddingItemsOutput)
+			// Close channel after transformation
+			defer close(output)
+			for item := range input {
+				output <- chain.Transform(item, feed)
+			}
 			return nil
 		},
 	)
 }
 
-// Filter shop items from input and send:
-// - all items to allItemsOutput
-// - items with bidding set to biddingItemsOutput
-func (p FeedParser) filterItems(
+// Run routine validating shop items from input
+// and send valid items to output and invalid ones to diagnosticsSink
+func (p *FeedParser) validateItemsAsync(
+	feedUrl string,
 	input chan models.ShopItem,
-	allItemsOutput chan models.ShopItem,
-	biddingItemsOutput chan models.ShopItem,
+	output chan models.ShopItem,
+	report *itemvalidator.QualityReportBuilder,
+	g *errgroup.Group,
 ) {
-	// Close channels after filtering
-	defer close(allItemsOutput)
-	defer close(biddingItemsOutput)
+	var diagnostics chan models.ItemDiagnostics
+	if p.diagnosticsSink != nil {
+		diagnostics = make(chan models.ItemDiagnostics)
+		g.Go(
+			func() error {
+				return p.diagnosticsSink.WriteDiagnostics(diagnostics)
+			},
+		)
+	}
+	g.Go(
+		func() error {
+			p.validateItems(feedUrl, input, output, diagnostics, report)
+			return nil
+		},
+	)
+}
+
+// Validate shop items from input and send:
+// - valid items to output
+// - invalid items to diagnostics (if not nil)
+// Every validation result is added to the report
+func (p *FeedParser) validateItems(
+	feedUrl string,
+	input chan models.ShopItem,
+	output chan models.ShopItem,
+	diagnostics chan models.ItemDiagnostics,
+	report *itemvalidator.QualityReportBuilder,
+) {
+	// Close channels after validation
+	defer close(output)
+	if diagnostics != nil {
+		defer close(diagnostics)
+	}
 
 	for item := range input {
-		// Send items with HeurekaCPC to biddingItemsOutput
-		if len(item.HeurekaCPC) > 0 {
-			biddingItemsOutput <- item
+		violations := p.validator.Validate(item)
+		report.Add(item, violations)
+		if len(violations) == 0 {
+			output <- item
+			continue
+		}
+		invalidItems.Inc()
+		if diagnostics != nil {
+			diagnostics <- models.ItemDiagnostics{
+				FeedUrl:    feedUrl,
+				Item:       item,
+				Violations: violations,
+			}
 		}
-		// Send all items to allItemsOutput
-		allItemsOutput <- item
 	}
 }
 
+// Run routine removing or flagging items with repeated ITEM_ID,
+// report is set when deduplication is finished
+func (p *FeedParser) deduplicateItemsAsync(
+	input chan models.ShopItem,
+	output chan models.ShopItem,
+	report **models.DuplicatesReport,
+	g *errgroup.Group,
+) {
+	g.Go(
+		func() error {
+			var err error
+			*report, err = p.deduplicator.Deduplicate(input, output)
+			return err
+		},
+	)
+}
+
+// Run routine detecting changes since the last run,
+// report and snapshot are set when detection is finished
+func (p *FeedParser) detectDeltaAsync(
+	feedUrl string,
+	input chan models.ShopItem,
+	fullOutput chan models.ShopItem,
+	eventsOutput chan models.ShopItem,
+	report **models.DeltaReport,
+	snapshot **deltadetector.Snapshot,
+	g *errgroup.Group,
+) {
+	g.Go(
+		func() error {
+			var err error
+			*report, *snapshot, err = p.deltaDetector.Detect(
+				feedUrl,
+				input,
+				fullOutput,
+				eventsOutput,
+			)
+			return err
+		},
+	)
+}
+
+// Run routine sending shop items from input
+// to outputs of all matching routes
+func (p *FeedParser) routeItemsAsync(
+	routes []itemrouter.Route,
+	input chan models.ShopItem,
+	outputs []chan models.ShopItem,
+	g *errgroup.Group,
+) {
+	g.Go(
+		func() error {
+			itemrouter.RouteItems(routes, input, outputs)
+			return nil
+		},
+	)
+}
+
 // Run routine parsing feed file from feedFile *io.ReadCloser
 // and send parsed items to parsedShopItems output channel
 func (p *FeedParser) parseFeedFileAsync(
@@ -202,16 +545,24 @@ func (p *FeedParser) parseFeedFileAsync(
 // to queue with name queueName
 func (p *FeedParser) writeItemsToQueueAsync(
 	queueName string,
+	metadata models.PublishMetadata,
 	shopItemsInput chan models.ShopItem,
 	g *errgroup.Group,
 ) {
 	g.Go(
 		func() error {
-			return p.queueWriter.WriteToQueue(queueName, shopItemsInput)
+			return p.queueWriter.WriteToQueue(queueName, metadata, shopItemsInput)
 		},
 	)
 }
 
+// Returns random identifier of the feed run
+func newJobId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
 // Print last modification time of the feed for debug purposes
 // or log warning about missing last modification data
 func logFeedLastModification(feedUrl string, lastModified string) {
@@ -226,10 +577,36 @@ func logFeedLastModification(feedUrl string, lastModified string) {
 	}
 }
 
-// Prometheus feeds during parsing gauge
+// Log feed quality report created by the validation stage
+func logQualityReport(feedUrl string, report *models.QualityReport) {
+	if report.InvalidItems == 0 {
+		zap.L().Info(
+			fmt.Sprintf("All %d shop items from %s are valid", report.ValidItems, feedUrl),
+			zap.String("feedUrl", feedUrl),
+		)
+		return
+	}
+	zap.L().Warn(
+		fmt.Sprintf(
+			"Feed %s contains %d invalid shop items",
+			feedUrl,
+			report.InvalidItems,
+		),
+		zap.String("feedUrl", feedUrl),
+		zap.Int("validItems", report.ValidItems),
+		zap.Int("invalidItems", report.InvalidItems),
+		zap.Any("rules", report.Rules),
+	)
+}
+
+// Prometheus feeds during parsing gauge and invalid items counter
 var (
 	parsingFeeds = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "feedparser_parsing_feeds_jobs_current",
 		Help: "Current number of feeds being processed",
 	})
+	invalidItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_invalid_items_total",
+		Help: "The total number of shop items rejected by the validation stage",
+	})
 )
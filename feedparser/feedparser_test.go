@@ -4,24 +4,31 @@ import (
 	"encoding/xml"
 	"io"
 	"net/http"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/MichalMitros/feed-parser/deduplicator"
+	"github.com/MichalMitros/feed-parser/deltadetector"
 	"github.com/MichalMitros/feed-parser/filefetcher/httpfilefetcher"
 	"github.com/MichalMitros/feed-parser/fileparser/xmlparser"
+	"github.com/MichalMitros/feed-parser/itemrouter"
+	"github.com/MichalMitros/feed-parser/itemtransformer"
 	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter/memorywriter"
+	"github.com/MichalMitros/feed-parser/snapshotstore/boltstore"
 )
 
 func TestFeedParserFunctionsCalling(t *testing.T) {
 	// Prepare mocked data
 	mockedFetcher := MockedFileFetcher{}
 	mockedFileParser := MockedFileParser{}
-	mockedWriter := MockedQueueWriter{}
+	mockedWriter := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
 	mockedFeedParser := NewFeedParser(
 		&mockedFetcher,
 		&mockedFileParser,
-		&mockedWriter,
+		mockedWriter,
 	)
 	testUrls := []string{"test_url_1", "test_url_2"}
 
@@ -43,10 +50,10 @@ func TestFeedParserFunctionsCalling(t *testing.T) {
 			len(testUrls),
 		)
 	}
-	if mockedWriter.NumOfFuncCalls != 2*len(testUrls) {
+	if mockedWriter.Writes() != 2*len(testUrls) {
 		t.Fatalf(
 			`FeedParser.ParseFeedsAsync(testUrls), number of queue writer calls = %d, want %d`,
-			mockedWriter.NumOfFuncCalls,
+			mockedWriter.Writes(),
 			2*len(testUrls),
 		)
 	}
@@ -58,7 +65,7 @@ func TestFeedParserResults(t *testing.T) {
 		MockedHttpClient{},
 	)
 	mockedFileParser := xmlparser.NewXmlFeedParser()
-	mockedWriter := NewMockedQueueWriter()
+	mockedWriter := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
 	mockedFeedParser := NewFeedParser(
 		mockedFetcher,
 		mockedFileParser,
@@ -79,10 +86,10 @@ func TestFeedParserResults(t *testing.T) {
 	// Use ParseFeed function
 	results := mockedFeedParser.ParseFeedFiles(testUrls)
 
-	// Check if mockedQueueWriter has proper queues
+	// Check if mockedWriter has proper queues
 	isBiddingItemsQueueCreated := false
 	isAllItemsQueueCreated := false
-	for k := range mockedWriter.queues {
+	for _, k := range mockedWriter.Queues() {
 		switch k {
 		case "shop_items":
 			isAllItemsQueueCreated = true
@@ -102,7 +109,7 @@ func TestFeedParserResults(t *testing.T) {
 	}
 
 	// Check if all bidding items are stored in the queue
-	allItemsResult := mockedWriter.queues["shop_items"]
+	allItemsResult := mockedWriter.Items("shop_items")
 	if !reflect.DeepEqual(allItemsResult, expectedAllItems) {
 		t.Fatalf(
 			"FeedParser.ParseFeedsAsync(testUrls), \"shop_items\" queue contains \n%v\n wanted\n%v\n",
@@ -112,7 +119,7 @@ func TestFeedParserResults(t *testing.T) {
 	}
 
 	// Check if all bidding items are stored in the queue
-	biddingItemsResult := mockedWriter.queues["shop_items_bidding"]
+	biddingItemsResult := mockedWriter.Items("shop_items_bidding")
 	if !reflect.DeepEqual(biddingItemsResult, expectedBiddingItems) {
 		t.Fatalf(
 			"FeedParser.ParseFeedsAsync(testUrls), \"shop_items_bidding\" queue contains \n%v\n wanted\n%v\n",
@@ -133,36 +140,363 @@ func TestFeedParserResults(t *testing.T) {
 	}
 }
 
+func TestFeedParserValidation(t *testing.T) {
+	// Prepare mocked data
+	mockedWriter := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
+	mockedSink := &MockedDiagnosticsSink{}
+	mockedFeedParser := NewFeedParserWithOptions(
+		&MockedXmlFileFetcher{},
+		xmlparser.NewXmlFeedParser(),
+		mockedWriter,
+		FeedParserOptions{
+			Validator:       MockedValidator{invalidItemId: "testId_3"},
+			DiagnosticsSink: mockedSink,
+		},
+	)
+
+	// Use ParseFeed function
+	result, err := mockedFeedParser.ParseFeed("test_url_1")
+	if err != nil {
+		t.Fatalf(`FeedParser.ParseFeed("test_url_1"), err = %v, want nil`, err)
+	}
+
+	// Check if invalid items are not published
+	for _, item := range mockedWriter.Items("shop_items") {
+		if item.ItemID == "testId_3" {
+			t.Fatalf(
+				`FeedParser.ParseFeed("test_url_1"), invalid item %s published to "shop_items"`,
+				item.ItemID,
+			)
+		}
+	}
+	if len(mockedWriter.Items("shop_items")) != 2 {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), "shop_items" contains %d items, want %d`,
+			len(mockedWriter.Items("shop_items")),
+			2,
+		)
+	}
+
+	// Check if invalid items are sent to diagnostics sink
+	if len(mockedSink.diagnostics) != 2 {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), diagnostics sink received %d items, want %d`,
+			len(mockedSink.diagnostics),
+			2,
+		)
+	}
+
+	// Check quality report
+	expectedReport := &models.QualityReport{
+		ValidItems:   2,
+		InvalidItems: 2,
+		Rules: []models.RuleReport{
+			{Rule: "mocked_rule", Count: 2, SampleItemIds: []string{"testId_3", "testId_3"}},
+		},
+	}
+	if !reflect.DeepEqual(result.QualityReport, expectedReport) {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), quality report = %+v, want %+v`,
+			result.QualityReport,
+			expectedReport,
+		)
+	}
+}
+
+func TestFeedParserDeduplication(t *testing.T) {
+	// Prepare mocked data
+	mockedWriter := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
+	dedup, _ := deduplicator.NewDeduplicator(deduplicator.DeduplicatorOptions{
+		Policy: deduplicator.KeepFirst,
+	})
+	mockedFeedParser := NewFeedParserWithOptions(
+		&MockedXmlFileFetcher{},
+		xmlparser.NewXmlFeedParser(),
+		mockedWriter,
+		FeedParserOptions{
+			Deduplicator: dedup,
+		},
+	)
+
+	// Use ParseFeed function
+	result, err := mockedFeedParser.ParseFeed("test_url_1")
+	if err != nil {
+		t.Fatalf(`FeedParser.ParseFeed("test_url_1"), err = %v, want nil`, err)
+	}
+
+	// Check if repeated "testId_3" item is published only once
+	expectedItems := mockedCorrectShop.ShopItems[:3]
+	if !reflect.DeepEqual(mockedWriter.Items("shop_items"), expectedItems) {
+		t.Fatalf(
+			"FeedParser.ParseFeed(\"test_url_1\"), \"shop_items\" contains \n%v\n wanted\n%v\n",
+			mockedWriter.Items("shop_items"),
+			expectedItems,
+		)
+	}
+
+	// Check duplicates in the result
+	if result.Duplicates == nil || result.Duplicates.DuplicateItems != 1 {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), duplicates = %+v, want 1 duplicate item`,
+			result.Duplicates,
+		)
+	}
+}
+
+func TestFeedParserDelta(t *testing.T) {
+	// Prepare mocked data
+	store, err := boltstore.NewBoltStore(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatalf("boltstore.NewBoltStore(path), err = %v, want nil", err)
+	}
+	defer store.Close()
+	dedup, _ := deduplicator.NewDeduplicator(deduplicator.DeduplicatorOptions{
+		Policy: deduplicator.KeepFirst,
+	})
+	options := FeedParserOptions{
+		Deduplicator:   dedup,
+		DeltaDetector:  deltadetector.NewDeltaDetector(store),
+		SkipFullStream: true,
+	}
+
+	// First run publishes all items as added
+	firstWriter := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
+	result, err := NewFeedParserWithOptions(
+		&MockedXmlFileFetcher{},
+		xmlparser.NewXmlFeedParser(),
+		firstWriter,
+		options,
+	).ParseFeed("test_url_1")
+	if err != nil {
+		t.Fatalf(`FeedParser.ParseFeed("test_url_1"), err = %v, want nil`, err)
+	}
+	if firstWriter.Items("shop_items") != nil {
+		t.Fatalf(`FeedParser.ParseFeed("test_url_1"), "shop_items" created, but full stream is skipped`)
+	}
+	events := firstWriter.Items(DefaultDeltaQueueName)
+	if len(events) != 3 || result.Delta == nil || result.Delta.Added != 3 {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), delta = %+v with %d events, want 3 added items`,
+			result.Delta,
+			len(events),
+		)
+	}
+
+	// Second run of unchanged feed publishes nothing
+	secondWriter := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
+	result, err = NewFeedParserWithOptions(
+		&MockedXmlFileFetcher{},
+		xmlparser.NewXmlFeedParser(),
+		secondWriter,
+		options,
+	).ParseFeed("test_url_1")
+	if err != nil {
+		t.Fatalf(`FeedParser.ParseFeed("test_url_1"), err = %v, want nil`, err)
+	}
+	expectedDelta := &models.DeltaReport{Unchanged: 3}
+	if !reflect.DeepEqual(result.Delta, expectedDelta) {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), second run delta = %+v, want %+v`,
+			result.Delta,
+			expectedDelta,
+		)
+	}
+	if len(secondWriter.Items(DefaultDeltaQueueName)) != 0 {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), second run published %d events, want 0`,
+			len(secondWriter.Items(DefaultDeltaQueueName)),
+		)
+	}
+}
+
+func TestFeedParserRouting(t *testing.T) {
+	// Prepare mocked data
+	mockedWriter := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
+	routes, err := itemrouter.NewStaticRoutes(itemrouter.RoutingConfig{
+		Outputs: []itemrouter.OutputConfig{
+			{Name: "shop_items_first", Predicate: `itemId == "testId_1"`},
+			{Name: "shop_items_other", Predicate: `!(itemId == "testId_1")`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("itemrouter.NewStaticRoutes(config), err = %v, want nil", err)
+	}
+	mockedFeedParser := NewFeedParserWithOptions(
+		&MockedXmlFileFetcher{},
+		xmlparser.NewXmlFeedParser(),
+		mockedWriter,
+		FeedParserOptions{
+			RoutesProvider: routes,
+		},
+	)
+
+	// Use ParseFeed function
+	if _, err := mockedFeedParser.ParseFeed("test_url_1"); err != nil {
+		t.Fatalf(`FeedParser.ParseFeed("test_url_1"), err = %v, want nil`, err)
+	}
+
+	// Check if items are published only to configured outputs
+	if len(mockedWriter.Queues()) != 2 {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), published to %d queues, want 2`,
+			len(mockedWriter.Queues()),
+		)
+	}
+	if len(mockedWriter.Items("shop_items_first")) != 1 ||
+		len(mockedWriter.Items("shop_items_other")) != 3 {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), published %d and %d items, want 1 and 3`,
+			len(mockedWriter.Items("shop_items_first")),
+			len(mockedWriter.Items("shop_items_other")),
+		)
+	}
+}
+
+func TestFeedParserFeedSource(t *testing.T) {
+	// Prepare mocked data
+	mockedWriter := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
+	routes, err := itemrouter.NewStaticRoutes(itemrouter.RoutingConfig{
+		Outputs: []itemrouter.OutputConfig{{Name: "shop_items_profile", Predicate: "true"}},
+	})
+	if err != nil {
+		t.Fatalf("itemrouter.NewStaticRoutes(config), err = %v, want nil", err)
+	}
+	mockedFeedParser := NewFeedParserWithOptions(
+		&MockedXmlFileFetcher{},
+		xmlparser.NewXmlFeedParser(),
+		mockedWriter,
+		FeedParserOptions{},
+	)
+
+	// Use ParseFeedSources function
+	results := mockedFeedParser.ParseFeedSources([]FeedSource{{
+		Url:            "test_url_1",
+		FeedId:         "feed_1",
+		ShopId:         "shop_1",
+		RoutesProvider: routes,
+	}})
+
+	// Check if result and metadata belong to the registered feed
+	if len(results) != 1 || results[0].FeedId != "feed_1" || results[0].Status != models.ParsedSuccessfully {
+		t.Fatalf("FeedParser.ParseFeedSources(sources), results = %v, want parsed feed_1", results)
+	}
+	if len(mockedWriter.Items("shop_items_profile")) != 4 {
+		t.Fatalf(
+			"FeedParser.ParseFeedSources(sources), published %d items to feed routes, want 4",
+			len(mockedWriter.Items("shop_items_profile")),
+		)
+	}
+	if metadata, _ := mockedWriter.Metadata("shop_items_profile"); metadata.ShopId != "shop_1" {
+		t.Fatalf(
+			"FeedParser.ParseFeedSources(sources), shopId = %v, want shop_1",
+			metadata.ShopId,
+		)
+	}
+}
+
+func TestFeedParserTransforms(t *testing.T) {
+	// Prepare mocked data
+	mockedWriter := memorywriter.NewMemoryWriter(memorywriter.MemoryWriterOptions{})
+	transforms, err := itemtransformer.NewFeedTransforms(itemtransformer.TransformsConfig{
+		Feeds: map[string]itemtransformer.FeedTransformsConfig{
+			"test_url_1": {
+				ShopId:     "shop_1",
+				Transforms: []itemtransformer.TransformConfig{{Name: itemtransformer.FeedMetadata}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("itemtransformer.NewFeedTransforms(config), err = %v, want nil", err)
+	}
+	mockedFeedParser := NewFeedParserWithOptions(
+		&MockedXmlFileFetcher{},
+		xmlparser.NewXmlFeedParser(),
+		mockedWriter,
+		FeedParserOptions{
+			Transforms: transforms,
+		},
+	)
+
+	// Use ParseFeed function
+	result, err := mockedFeedParser.ParseFeed("test_url_1")
+	if err != nil {
+		t.Fatalf(`FeedParser.ParseFeed("test_url_1"), err = %v, want nil`, err)
+	}
+
+	// Check if feed run metadata is passed to queue writer
+	metadata, _ := mockedWriter.Metadata("shop_items")
+	if metadata.ShopId != "shop_1" ||
+		metadata.FeedUrl != "test_url_1" ||
+		metadata.JobId != result.JobId ||
+		len(metadata.JobId) == 0 ||
+		metadata.SchemaVersion != models.SchemaVersion ||
+		metadata.ParsedAt.IsZero() {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), publish metadata = %+v, want feed run metadata with job id %q`,
+			metadata,
+			result.JobId,
+		)
+	}
+
+	// Check if all items contain feed metadata
+	items := mockedWriter.Items("shop_items")
+	if len(items) != len(mockedCorrectShop.ShopItems) {
+		t.Fatalf(
+			`FeedParser.ParseFeed("test_url_1"), published %d items, want %d`,
+			len(items),
+			len(mockedCorrectShop.ShopItems),
+		)
+	}
+	for _, item := range items {
+		if item.Metadata == nil ||
+			item.Metadata.ShopId != "shop_1" ||
+			item.Metadata.FeedUrl != "test_url_1" ||
+			item.Metadata.FetchedAt.IsZero() {
+			t.Fatalf(
+				`FeedParser.ParseFeed("test_url_1"), item %s metadata = %+v, want feed metadata`,
+				item.ItemID,
+				item.Metadata,
+			)
+		}
+	}
+}
+
 // MOCKED DATA
 
-// Mocked QueueWriter with HasBeenCalled value for checking functions calling
-type MockedQueueWriter struct {
-	queues         map[string][]models.ShopItem
-	NumOfFuncCalls int
+// Mocked ItemValidator rejecting items with invalidItemId
+type MockedValidator struct {
+	invalidItemId string
 }
 
-func NewMockedQueueWriter() *MockedQueueWriter {
-	return &MockedQueueWriter{
-		queues:         make(map[string][]models.ShopItem),
-		NumOfFuncCalls: 0,
+func (v MockedValidator) Validate(item models.ShopItem) []models.RuleViolation {
+	if item.ItemID == v.invalidItemId {
+		return []models.RuleViolation{{Rule: "mocked_rule", Message: "mocked violation"}}
 	}
+	return nil
+}
+
+// Mocked DiagnosticsSink storing received diagnostics
+type MockedDiagnosticsSink struct {
+	diagnostics []models.ItemDiagnostics
 }
 
-func (w *MockedQueueWriter) WriteToQueue(
-	queueName string,
-	shopItems chan models.ShopItem,
+func (s *MockedDiagnosticsSink) WriteDiagnostics(
+	diagnostics chan models.ItemDiagnostics,
 ) error {
-	w.NumOfFuncCalls++
-	for item := range shopItems {
-		queueItems := w.queues[queueName]
-		if queueItems == nil {
-			queueItems = []models.ShopItem{}
-		}
-		w.queues[queueName] = append(queueItems, item)
+	for d := range diagnostics {
+		s.diagnostics = append(s.diagnostics, d)
 	}
 	return nil
 }
 
+// Mocked FileFetcher returning new io.ReadCloser with mockedCorrectShop on each call
+type MockedXmlFileFetcher struct{}
+
+func (f *MockedXmlFileFetcher) FetchFile(url string) (*io.ReadCloser, string, error) {
+	readCloser := io.NopCloser(strings.NewReader(string(mockedXmlFileBytes)))
+	return &readCloser, "", nil
+}
+
 // Mocked FileParser with HasBeenCalled value for checking functions calling
 type MockedFileFetcher struct {
 	NumOfFuncCalls int
@@ -0,0 +1,170 @@
+package boltregistry
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/feedregistry"
+	"github.com/MichalMitros/feed-parser/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Name of the bucket with JSON encoded feeds keyed by id
+var feedsBucket = []byte("feeds")
+
+// Feed registry persisted in embedded BoltDB file
+// Implements feedregistry.FeedRegistryInterface
+type BoltRegistry struct {
+	db *bolt.DB
+}
+
+// Opens or creates BoltDB file at path and creates new BoltRegistry instance
+func NewBoltRegistry(path string) (*BoltRegistry, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(feedsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltRegistry{
+		db: db,
+	}, nil
+}
+
+// Returns all feeds ordered by id
+func (r *BoltRegistry) List() ([]models.Feed, error) {
+	feeds := []models.Feed{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(feedsBucket).ForEach(func(k, v []byte) error {
+			var feed models.Feed
+			if err := json.Unmarshal(v, &feed); err != nil {
+				return err
+			}
+			feeds = append(feeds, feed)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return feeds, nil
+}
+
+// Returns feed with given id or feedregistry.ErrFeedNotFound
+func (r *BoltRegistry) Get(id string) (*models.Feed, error) {
+	var feed *models.Feed
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		feed, err = getFeed(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// Validates and stores new feed with generated id
+func (r *BoltRegistry) Create(feed models.Feed) (*models.Feed, error) {
+	if err := feedregistry.ValidateFeed(&feed); err != nil {
+		return nil, err
+	}
+	feed.Id = feedregistry.NewFeedId()
+	feed.CreatedAt = time.Now().UTC()
+	feed.UpdatedAt = feed.CreatedAt
+	feed.LastResult = nil
+	feed.LastRunAt = nil
+
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		return putFeed(tx, &feed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// Validates and replaces stored feed, creation time and the last
+// result are kept. Returns feedregistry.ErrFeedNotFound for unknown ids
+func (r *BoltRegistry) Update(feed models.Feed) (*models.Feed, error) {
+	if err := feedregistry.ValidateFeed(&feed); err != nil {
+		return nil, err
+	}
+
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		stored, err := getFeed(tx, feed.Id)
+		if err != nil {
+			return err
+		}
+		feed.CreatedAt = stored.CreatedAt
+		feed.Owner = stored.Owner
+		feed.UpdatedAt = time.Now().UTC()
+		feed.LastResult = stored.LastResult
+		feed.LastRunAt = stored.LastRunAt
+		return putFeed(tx, &feed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// Removes feed or returns feedregistry.ErrFeedNotFound
+func (r *BoltRegistry) Delete(id string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if _, err := getFeed(tx, id); err != nil {
+			return err
+		}
+		return tx.Bucket(feedsBucket).Delete([]byte(id))
+	})
+}
+
+// Stores result of the last parsing of the feed with current time,
+// returns feedregistry.ErrFeedNotFound for unknown ids
+func (r *BoltRegistry) SaveResult(id string, result models.FeedParsingResult) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		feed, err := getFeed(tx, id)
+		if err != nil {
+			return err
+		}
+		finishedAt := time.Now().UTC()
+		feed.LastResult = &result
+		feed.LastRunAt = &finishedAt
+		return putFeed(tx, feed)
+	})
+}
+
+// Closes underlying BoltDB file
+func (r *BoltRegistry) Close() error {
+	return r.db.Close()
+}
+
+// Reads feed in transaction tx
+func getFeed(tx *bolt.Tx, id string) (*models.Feed, error) {
+	value := tx.Bucket(feedsBucket).Get([]byte(id))
+	if value == nil {
+		return nil, feedregistry.ErrFeedNotFound
+	}
+	var feed models.Feed
+	if err := json.Unmarshal(value, &feed); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// Writes feed in transaction tx
+func putFeed(tx *bolt.Tx, feed *models.Feed) error {
+	value, err := json.Marshal(feed)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(feedsBucket).Put([]byte(feed.Id), value)
+}
@@ -0,0 +1,115 @@
+package boltregistry
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/MichalMitros/feed-parser/feedregistry"
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestBoltRegistryCrud(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feeds.db")
+	registry, err := NewBoltRegistry(path)
+	if err != nil {
+		t.Fatalf("NewBoltRegistry(path), err = %v, want nil", err)
+	}
+
+	// Create feed with default format
+	created, err := registry.Create(mockedFeed)
+	if err != nil {
+		t.Fatalf("BoltRegistry.Create(feed), err = %v, want nil", err)
+	}
+	if len(created.Id) == 0 || created.Format != models.HeurekaXml || created.CreatedAt.IsZero() {
+		t.Fatalf("BoltRegistry.Create(feed), got = %+v, want feed with id, format and creation time", created)
+	}
+
+	// Update keeps creation time and the last result
+	result := models.FeedParsingResult{FeedUrl: mockedFeed.Url, FeedId: created.Id, Status: models.ParsedSuccessfully}
+	if err := registry.SaveResult(created.Id, result); err != nil {
+		t.Fatalf("BoltRegistry.SaveResult(id, result), err = %v, want nil", err)
+	}
+	changed := *created
+	changed.Enabled = false
+	changed.CreatedAt = changed.CreatedAt.AddDate(-1, 0, 0)
+	updated, err := registry.Update(changed)
+	if err != nil {
+		t.Fatalf("BoltRegistry.Update(feed), err = %v, want nil", err)
+	}
+	if updated.Enabled || !updated.CreatedAt.Equal(created.CreatedAt) ||
+		!reflect.DeepEqual(updated.LastResult, &result) || updated.LastRunAt == nil {
+		t.Fatalf("BoltRegistry.Update(feed), got = %+v, want disabled feed with kept creation time and result", updated)
+	}
+
+	// Feeds are persisted
+	registry.Close()
+	registry, err = NewBoltRegistry(path)
+	if err != nil {
+		t.Fatalf("NewBoltRegistry(path), err = %v, want nil", err)
+	}
+	defer registry.Close()
+	feeds, err := registry.List()
+	if err != nil || len(feeds) != 1 || feeds[0].Id != created.Id {
+		t.Fatalf("BoltRegistry.List() = %v, %v, want created feed", feeds, err)
+	}
+	stored, err := registry.Get(created.Id)
+	if err != nil || stored.Enabled || !reflect.DeepEqual(stored.LastResult, &result) {
+		t.Fatalf("BoltRegistry.Get(id) = %+v, %v, want updated feed", stored, err)
+	}
+
+	// Delete feed
+	if err := registry.Delete(created.Id); err != nil {
+		t.Fatalf("BoltRegistry.Delete(id), err = %v, want nil", err)
+	}
+	if _, err := registry.Get(created.Id); !errors.Is(err, feedregistry.ErrFeedNotFound) {
+		t.Fatalf("BoltRegistry.Get(deleted), err = %v, want ErrFeedNotFound", err)
+	}
+}
+
+func TestBoltRegistryErrors(t *testing.T) {
+	registry, err := NewBoltRegistry(filepath.Join(t.TempDir(), "feeds.db"))
+	if err != nil {
+		t.Fatalf("NewBoltRegistry(path), err = %v, want nil", err)
+	}
+	defer registry.Close()
+
+	// Invalid feeds are rejected
+	invalidFeeds := []models.Feed{
+		{Url: "ftp://example.com/feed.xml"},
+		{Url: "/feed.xml"},
+		{Url: "https://example.com/feed.xml", Format: "csv"},
+		{Url: "https://example.com/feed.xml", Schedule: "every hour"},
+	}
+	for _, feed := range invalidFeeds {
+		var validationErr *feedregistry.ValidationError
+		if _, err := registry.Create(feed); !errors.As(err, &validationErr) {
+			t.Fatalf("BoltRegistry.Create(%+v), err = %v, want ValidationError", feed, err)
+		}
+	}
+
+	// Unknown ids are not found
+	unknown := mockedFeed
+	unknown.Id = "unknown"
+	if _, err := registry.Update(unknown); !errors.Is(err, feedregistry.ErrFeedNotFound) {
+		t.Fatalf("BoltRegistry.Update(unknown), err = %v, want ErrFeedNotFound", err)
+	}
+	if err := registry.Delete("unknown"); !errors.Is(err, feedregistry.ErrFeedNotFound) {
+		t.Fatalf("BoltRegistry.Delete(unknown), err = %v, want ErrFeedNotFound", err)
+	}
+	if err := registry.SaveResult("unknown", models.FeedParsingResult{}); !errors.Is(err, feedregistry.ErrFeedNotFound) {
+		t.Fatalf("BoltRegistry.SaveResult(unknown), err = %v, want ErrFeedNotFound", err)
+	}
+}
+
+// MOCKED DATA
+
+var mockedFeed = models.Feed{
+	ShopId:         "shop_1",
+	Url:            "https://example.com/feed.xml",
+	CredentialsRef: "secret/shop_1",
+	Schedule:       "@every 1h",
+	RoutingProfile: "bidding",
+	Enabled:        true,
+}
@@ -0,0 +1,20 @@
+package feedregistry
+
+import "github.com/MichalMitros/feed-parser/models"
+
+// Persistent registry of managed feed sources
+type FeedRegistryInterface interface {
+	// Returns all feeds ordered by id
+	List() ([]models.Feed, error)
+	// Returns feed with given id or ErrFeedNotFound
+	Get(id string) (*models.Feed, error)
+	// Validates and stores new feed with generated id
+	Create(feed models.Feed) (*models.Feed, error)
+	// Validates and replaces stored feed, creation time, owner and
+	// the last run are kept. Returns ErrFeedNotFound for unknown ids
+	Update(feed models.Feed) (*models.Feed, error)
+	// Removes feed or returns ErrFeedNotFound
+	Delete(id string) error
+	// Stores result of the last parsing of the feed with current time
+	SaveResult(id string, result models.FeedParsingResult) error
+}
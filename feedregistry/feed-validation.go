@@ -0,0 +1,59 @@
+package feedregistry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/robfig/cron/v3"
+)
+
+// Returned for ids of feeds missing in the registry
+var ErrFeedNotFound = errors.New("feed not found")
+
+// Error of invalid feed fields
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid feed field '%s': %s", e.Field, e.Message)
+}
+
+// Checks feed fields and sets default format
+func ValidateFeed(feed *models.Feed) error {
+	parsed, err := url.Parse(feed.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return &ValidationError{Field: "url", Message: "has to be absolute http or https url"}
+	}
+	switch feed.Format {
+	case "":
+		feed.Format = models.HeurekaXml
+	case models.HeurekaXml:
+	default:
+		return &ValidationError{Field: "format", Message: fmt.Sprintf("unknown format %q", feed.Format)}
+	}
+	if len(feed.Schedule) > 0 {
+		if _, err := ParseSchedule(feed.Schedule); err != nil {
+			return &ValidationError{Field: "schedule", Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// Parses schedule of a feed, standard cron expression with 5 fields,
+// descriptor like "@hourly" or interval like "@every 1h30m"
+func ParseSchedule(schedule string) (cron.Schedule, error) {
+	return cron.ParseStandard(schedule)
+}
+
+// Returns random identifier of a new feed
+func NewFeedId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,37 @@
+package httpfilefetcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter of requests to a single host, requests are spread evenly
+// with minimal interval between them
+type hostLimiter struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	// Earliest time of the next request to every host
+	next map[string]time.Time
+}
+
+// Creates new hostLimiter allowing rate requests per second to every host
+func newHostLimiter(rate float64) *hostLimiter {
+	return &hostLimiter{
+		interval: time.Duration(float64(time.Second) / rate),
+		next:     make(map[string]time.Time),
+	}
+}
+
+// Blocks until the next request to host is allowed
+func (l *hostLimiter) wait(host string) {
+	l.mutex.Lock()
+	now := time.Now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+	l.mutex.Unlock()
+
+	time.Sleep(time.Until(at))
+}
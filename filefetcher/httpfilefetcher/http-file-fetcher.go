@@ -1,9 +1,16 @@
 package httpfilefetcher
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
 
+	"github.com/MichalMitros/feed-parser/urlpolicy"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/zap"
@@ -13,8 +20,40 @@ import (
 // Implements FileFetcher interface
 type HttpFileFetcher struct {
 	httpClient HttpClientInterface
+	retries    int
+	retryDelay time.Duration
+	// Limiter of requests to a single host, nil when not limited
+	limiter *hostLimiter
+	// Policy of fetched urls, nil when all urls are allowed
+	policy *urlpolicy.UrlPolicy
 }
 
+// Options of HttpFileFetcher
+type HttpFileFetcherOptions struct {
+	// Timeout of connecting and waiting for response headers,
+	// feed download itself isn't limited. Not limited when 0
+	Timeout time.Duration
+	// Number of retries of failed requests and 429 or 5xx responses
+	Retries int
+	// Delay before the first retry, doubled with every next retry.
+	// DefaultRetryDelay is used when 0
+	RetryDelay time.Duration
+	// Maximal number of requests per second to a single host,
+	// not limited when 0
+	RateLimit float64
+	// Url of HTTP proxy, proxy from HTTP_PROXY and HTTPS_PROXY
+	// environment variables is used when empty and there is no UrlPolicy
+	Proxy string
+	// Policy checked before requests, on every redirect and on every
+	// opened connection. All urls are allowed when nil. Addresses of
+	// connections through Proxy can't be checked, so hosts which can't
+	// be resolved are rejected and rebinding of host names isn't blocked
+	UrlPolicy *urlpolicy.UrlPolicy
+}
+
+// Default values of HttpFileFetcherOptions
+const DefaultRetryDelay = time.Second
+
 // Creates new FileFetcher instance
 func NewHttpFileFetcher(
 	httpClient HttpClientInterface,
@@ -31,6 +70,72 @@ func DefaultHttpFileFetcher() *HttpFileFetcher {
 	}
 }
 
+// Creates new FileFetcher instance with http client configured
+// with options
+func NewHttpFileFetcherWithOptions(
+	options HttpFileFetcherOptions,
+) (*HttpFileFetcher, error) {
+	if options.Timeout < 0 || options.Retries < 0 || options.RetryDelay < 0 || options.RateLimit < 0 {
+		return nil, fmt.Errorf("fetcher options can't be negative")
+	}
+	if options.RetryDelay == 0 {
+		options.RetryDelay = DefaultRetryDelay
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(options.Proxy) > 0 {
+		proxyUrl, err := url.Parse(options.Proxy)
+		if err != nil || len(proxyUrl.Host) == 0 {
+			return nil, fmt.Errorf("invalid proxy url %q", options.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	} else if options.UrlPolicy != nil {
+		// Proxy from environment would resolve host names bypassing the policy
+		transport.Proxy = nil
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if options.Timeout > 0 {
+		dialer.Timeout = options.Timeout
+		transport.TLSHandshakeTimeout = options.Timeout
+		transport.ResponseHeaderTimeout = options.Timeout
+	}
+	transport.DialContext = dialer.DialContext
+	client := &http.Client{Transport: transport}
+
+	// Check resolved address of every connection, connections
+	// to proxies are not checked
+	policy := options.UrlPolicy
+	if policy != nil {
+		if transport.Proxy != nil {
+			policy = policy.FailClosed()
+		}
+		proxies := proxyAddresses(transport)
+		policyDialer := *dialer
+		policyDialer.Control = policy.Control
+		transport.DialContext = func(ctx context.Context, network string, address string) (net.Conn, error) {
+			if proxies[address] {
+				return dialer.DialContext(ctx, network, address)
+			}
+			return policyDialer.DialContext(ctx, network, address)
+		}
+		client.CheckRedirect = policy.CheckRedirect
+	}
+
+	fetcher := &HttpFileFetcher{
+		httpClient: client,
+		retries:    options.Retries,
+		retryDelay: options.RetryDelay,
+		policy:     policy,
+	}
+	if options.RateLimit > 0 {
+		fetcher.limiter = newHostLimiter(options.RateLimit)
+	}
+	return fetcher, nil
+}
+
 // Fetch file and returns response body as io.ReadCloser,
 // "Last-Modified" header as string and potentially an error
 func (f *HttpFileFetcher) FetchFile(
@@ -38,8 +143,11 @@ func (f *HttpFileFetcher) FetchFile(
 ) (*io.ReadCloser, string, error) {
 	defer zap.L().Sync()
 
-	resp, err := f.httpClient.Get(url)
+	resp, err := f.get(url)
 	if err != nil {
+		if errors.Is(err, urlpolicy.ErrForbiddenUrl) {
+			filesFetchForbidden.Inc()
+		}
 		filesFetchedFailures.Inc()
 		return nil, "", err
 	}
@@ -51,6 +159,84 @@ func (f *HttpFileFetcher) FetchFile(
 	return &resp.Body, lastModified, nil
 }
 
+// Checks url with policy of the fetcher, nil when there is no policy
+func (f *HttpFileFetcher) CheckUrl(feedUrl string) error {
+	if f.policy == nil {
+		return nil
+	}
+	return f.policy.Check(feedUrl)
+}
+
+// Sends GET request, failed requests and 429 or 5xx responses
+// are retried. Response of the last retry is returned
+func (f *HttpFileFetcher) get(feedUrl string) (*http.Response, error) {
+	if err := f.CheckUrl(feedUrl); err != nil {
+		return nil, err
+	}
+	host := feedUrl
+	if parsed, err := url.Parse(feedUrl); err == nil {
+		host = parsed.Host
+	}
+
+	delay := f.retryDelay
+	for attempt := 0; ; attempt++ {
+		if f.limiter != nil {
+			f.limiter.wait(host)
+		}
+		resp, err := f.httpClient.Get(feedUrl)
+		if attempt >= f.retries || !isRetryable(resp, err) {
+			return resp, err
+		}
+
+		reason := err
+		if resp != nil {
+			resp.Body.Close()
+			reason = fmt.Errorf("response status %s", resp.Status)
+		}
+		zap.L().Warn(
+			"Cannot fetch feed file, retrying",
+			zap.String("feedUrl", feedUrl),
+			zap.Duration("retryIn", delay),
+			zap.Error(reason),
+		)
+		filesFetchRetries.Inc()
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Checks if request may succeed when it's sent again
+func isRetryable(resp *http.Response, err error) bool {
+	if errors.Is(err, urlpolicy.ErrForbiddenUrl) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// Returns addresses of proxies used by transport for http and https urls
+func proxyAddresses(transport *http.Transport) map[string]bool {
+	addresses := make(map[string]bool)
+	if transport.Proxy == nil {
+		return addresses
+	}
+	for _, scheme := range []string{"http", "https"} {
+		request := &http.Request{URL: &url.URL{Scheme: scheme, Host: "feed.example.com"}}
+		proxyUrl, err := transport.Proxy(request)
+		if err != nil || proxyUrl == nil {
+			continue
+		}
+		port := proxyUrl.Port()
+		if len(port) == 0 {
+			port = map[string]string{"http": "80", "https": "443", "socks5": "1080"}[proxyUrl.Scheme]
+		}
+		addresses[net.JoinHostPort(proxyUrl.Hostname(), port)] = true
+	}
+	return addresses
+}
+
 // Prometheus fetched xml files counter
 var (
 	filesFetched = promauto.NewCounter(prometheus.CounterOpts{
@@ -61,4 +247,12 @@ var (
 		Name: "feedparser_fetched_xml_files_failures_total",
 		Help: "The total number of failures in fetching XML files",
 	})
+	filesFetchForbidden = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_fetched_xml_files_forbidden_total",
+		Help: "The total number of XML file urls rejected by url policy",
+	})
+	filesFetchRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_fetched_xml_files_retries_total",
+		Help: "The total number of retried requests for XML files",
+	})
 )
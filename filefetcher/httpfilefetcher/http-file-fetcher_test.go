@@ -2,13 +2,17 @@ package httpfilefetcher
 
 import (
 	"encoding/xml"
+	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/urlpolicy"
 )
 
 func TestFetchFile(t *testing.T) {
@@ -55,6 +59,175 @@ func TestNewHttpFileFetcher(t *testing.T) {
 	}
 }
 
+func TestFetchFileRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Last-Modified", "Tue, 01 Mar 2022 12:00:00 GMT")
+		w.Write(mockedXmlFileBytes)
+	}))
+	defer server.Close()
+
+	fetcher, err := NewHttpFileFetcherWithOptions(HttpFileFetcherOptions{
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewHttpFileFetcherWithOptions(), err = %v, want nil", err)
+	}
+	result, lastModified, err := fetcher.FetchFile(server.URL)
+	if err != nil {
+		t.Fatalf("FetchFile(string), err = %v, want nil", err)
+	}
+	defer (*result).Close()
+
+	body, _ := io.ReadAll(*result)
+	if string(body) != string(mockedXmlFileBytes) {
+		t.Fatalf("FetchFile(string), body = %s, want %s", body, mockedXmlFileBytes)
+	}
+	if lastModified != "Tue, 01 Mar 2022 12:00:00 GMT" {
+		t.Fatalf("FetchFile(string), lastModified = %v, want Tue, 01 Mar 2022 12:00:00 GMT", lastModified)
+	}
+	if requests != 3 {
+		t.Fatalf("FetchFile(string), requests = %v, want 3", requests)
+	}
+}
+
+func TestFetchFileRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(mockedXmlFileBytes)
+	}))
+	defer server.Close()
+
+	fetcher, err := NewHttpFileFetcherWithOptions(HttpFileFetcherOptions{
+		RateLimit: 20,
+	})
+	if err != nil {
+		t.Fatalf("NewHttpFileFetcherWithOptions(), err = %v, want nil", err)
+	}
+
+	// 5 requests are spread over at least 200ms
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		result, _, err := fetcher.FetchFile(server.URL)
+		if err != nil {
+			t.Fatalf("FetchFile(string), err = %v, want nil", err)
+		}
+		(*result).Close()
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("FetchFile(string) 5 times, elapsed = %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestNewHttpFileFetcherWithInvalidOptions(t *testing.T) {
+	invalidOptions := []HttpFileFetcherOptions{
+		{Timeout: -time.Second},
+		{Retries: -1},
+		{RateLimit: -1},
+		{Proxy: "not a url"},
+	}
+	for _, options := range invalidOptions {
+		if _, err := NewHttpFileFetcherWithOptions(options); err == nil {
+			t.Fatalf("NewHttpFileFetcherWithOptions(%v), err = nil, want error", options)
+		}
+	}
+}
+
+func TestFetchFileUrlPolicy(t *testing.T) {
+	requests := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/internal":
+			// Same server under denied host name
+			http.Redirect(w, r, strings.Replace(server.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+		default:
+			w.Write(mockedXmlFileBytes)
+		}
+	}))
+	defer server.Close()
+
+	// Loopback address of the test server is blocked by default
+	policy, _ := urlpolicy.NewUrlPolicy(urlpolicy.UrlPolicyOptions{})
+	fetcher, _ := NewHttpFileFetcherWithOptions(HttpFileFetcherOptions{
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+		UrlPolicy:  policy,
+	})
+	if _, _, err := fetcher.FetchFile(server.URL); !errors.Is(err, urlpolicy.ErrForbiddenUrl) || requests != 0 {
+		t.Fatalf("FetchFile(string), err = %v, requests = %v, want %v and 0", err, requests, urlpolicy.ErrForbiddenUrl)
+	}
+
+	policy, _ = urlpolicy.NewUrlPolicy(urlpolicy.UrlPolicyOptions{
+		DeniedHosts:     []string{"localhost"},
+		AllowedNetworks: []string{"127.0.0.0/8"},
+		MaxRedirects:    2,
+	})
+	fetcher, _ = NewHttpFileFetcherWithOptions(HttpFileFetcherOptions{
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+		UrlPolicy:  policy,
+	})
+	result, _, err := fetcher.FetchFile(server.URL)
+	if err != nil {
+		t.Fatalf("FetchFile(string) of allowed network, err = %v, want nil", err)
+	}
+	(*result).Close()
+
+	// Redirects are limited and checked, forbidden urls aren't retried
+	tests := map[string]int{"/loop": 3, "/internal": 1}
+	for path, wantRequests := range tests {
+		requests = 0
+		if _, _, err := fetcher.FetchFile(server.URL + path); !errors.Is(err, urlpolicy.ErrForbiddenUrl) {
+			t.Fatalf("FetchFile(%s), err = %v, want %v", path, err, urlpolicy.ErrForbiddenUrl)
+		}
+		if requests != wantRequests {
+			t.Fatalf("FetchFile(%s), requests = %v, want %v", path, requests, wantRequests)
+		}
+	}
+}
+
+func TestFetchFileUrlPolicyWithProxy(t *testing.T) {
+	// Proxy responding with the feed instead of forwarding requests
+	proxied := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.Host)
+		w.Write(mockedXmlFileBytes)
+	}))
+	defer proxy.Close()
+
+	policy, _ := urlpolicy.NewUrlPolicy(urlpolicy.UrlPolicyOptions{AllowedNetworks: []string{"10.20.0.0/16"}})
+	fetcher, err := NewHttpFileFetcherWithOptions(HttpFileFetcherOptions{Proxy: proxy.URL, UrlPolicy: policy})
+	if err != nil {
+		t.Fatalf("NewHttpFileFetcherWithOptions(), err = %v, want nil", err)
+	}
+
+	// Host resolved only by the proxy can't be checked, so it's rejected
+	if _, _, err := fetcher.FetchFile("http://feeds.invalid/feed.xml"); !errors.Is(err, urlpolicy.ErrForbiddenUrl) || len(proxied) != 0 {
+		t.Fatalf("FetchFile(unresolvable), err = %v, proxied = %v, want %v and none", err, proxied, urlpolicy.ErrForbiddenUrl)
+	}
+	result, _, err := fetcher.FetchFile("http://10.20.0.1/feed.xml")
+	if err != nil || len(proxied) != 1 {
+		t.Fatalf("FetchFile(allowed), err = %v, proxied = %v, want nil and 1 request", err, proxied)
+	}
+	(*result).Close()
+
+	// Proxy from environment isn't used with policy
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	fetcher, _ = NewHttpFileFetcherWithOptions(HttpFileFetcherOptions{UrlPolicy: policy})
+	if transport := fetcher.httpClient.(*http.Client).Transport.(*http.Transport); transport.Proxy != nil {
+		t.Fatalf("NewHttpFileFetcherWithOptions(), transport proxy set, want nil")
+	}
+}
+
 // MOCKED DATA
 
 // Mocked http.Client as struct implementing FileFetcher interface
@@ -0,0 +1,548 @@
+package itemrouter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Compiled predicate over shop items.
+//
+// Supported syntax:
+//   - literals: numbers (10, 99.9), strings ("text"), true, false
+//   - shop item fields by their JSON names (priceVat, categoryText, ...)
+//   - functions: param("name") - value of item PARAM, len(value) - length of value
+//   - comparison: ==, !=, <, <=, >, >=
+//   - string operators: contains, startsWith, endsWith, matches (regexp)
+//   - logical operators: &&, ||, ! and parentheses
+//
+// Numeric comparison is used when compared values are numbers
+// (comma is accepted as decimal separator), otherwise values
+// are compared as strings
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parses and compiles predicate expression
+func CompileExpression(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// Checks if item matches the expression
+func (e *Expression) Match(item models.ShopItem) bool {
+	return e.root.eval(item).truthy()
+}
+
+// Returns source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// VALUES
+
+type valueKind int
+
+const (
+	stringValue valueKind = iota
+	numberValue
+	boolValue
+)
+
+// Dynamically typed expression value
+type value struct {
+	kind valueKind
+	s    string
+	n    float64
+	b    bool
+}
+
+func (v value) truthy() bool {
+	switch v.kind {
+	case boolValue:
+		return v.b
+	case numberValue:
+		return v.n != 0
+	}
+	return len(v.s) > 0
+}
+
+func (v value) string() string {
+	switch v.kind {
+	case boolValue:
+		return strconv.FormatBool(v.b)
+	case numberValue:
+		return strconv.FormatFloat(v.n, 'f', -1, 64)
+	}
+	return v.s
+}
+
+func (v value) number() (float64, bool) {
+	switch v.kind {
+	case numberValue:
+		return v.n, true
+	case stringValue:
+		n, err := strconv.ParseFloat(
+			strings.ReplaceAll(strings.TrimSpace(v.s), ",", "."),
+			64,
+		)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// Item fields available in expressions by their JSON names
+var itemFields = map[string]func(item models.ShopItem) value{
+	"itemId":            func(i models.ShopItem) value { return value{s: i.ItemID} },
+	"productName":       func(i models.ShopItem) value { return value{s: i.ProductName} },
+	"product":           func(i models.ShopItem) value { return value{s: i.Product} },
+	"description":       func(i models.ShopItem) value { return value{s: i.Description} },
+	"url":               func(i models.ShopItem) value { return value{s: i.Url} },
+	"imgUrl":            func(i models.ShopItem) value { return value{s: i.ImgUrl} },
+	"imgUrlAlternative": func(i models.ShopItem) value { return value{s: i.ImgUrlAlternative} },
+	"videoUrl":          func(i models.ShopItem) value { return value{s: i.VideoUrl} },
+	"priceVat":          func(i models.ShopItem) value { return value{s: i.PriceVat} },
+	"heurekaCPC":        func(i models.ShopItem) value { return value{s: i.HeurekaCPC} },
+	"categoryText":      func(i models.ShopItem) value { return value{s: i.CategoryText} },
+	"ean":               func(i models.ShopItem) value { return value{s: i.EAN} },
+	"productNo":         func(i models.ShopItem) value { return value{s: i.ProductNo} },
+	"deliveryDate":      func(i models.ShopItem) value { return value{s: i.DelivaryDate} },
+	"itemGroupId":       func(i models.ShopItem) value { return value{s: i.ItemGroupId} },
+	"accessory":         func(i models.ShopItem) value { return value{s: i.Accessory} },
+	"gift":              func(i models.ShopItem) value { return value{s: i.Gift} },
+	"specialService":    func(i models.ShopItem) value { return value{s: i.SpecialService} },
+	"changeType":        func(i models.ShopItem) value { return value{s: string(i.ChangeType)} },
+	"duplicate":         func(i models.ShopItem) value { return value{kind: boolValue, b: i.Duplicate} },
+}
+
+// AST
+
+type node interface {
+	eval(item models.ShopItem) value
+}
+
+type literalNode struct {
+	v value
+}
+
+func (n literalNode) eval(models.ShopItem) value {
+	return n.v
+}
+
+type fieldNode struct {
+	get func(item models.ShopItem) value
+}
+
+func (n fieldNode) eval(item models.ShopItem) value {
+	return n.get(item)
+}
+
+type paramNode struct {
+	name string
+}
+
+func (n paramNode) eval(item models.ShopItem) value {
+	for _, param := range item.Params {
+		if param.ParamName == n.name {
+			return value{s: param.Val}
+		}
+	}
+	return value{}
+}
+
+type lenNode struct {
+	arg node
+}
+
+func (n lenNode) eval(item models.ShopItem) value {
+	return value{kind: numberValue, n: float64(len([]rune(n.arg.eval(item).string())))}
+}
+
+type notNode struct {
+	arg node
+}
+
+func (n notNode) eval(item models.ShopItem) value {
+	return value{kind: boolValue, b: !n.arg.eval(item).truthy()}
+}
+
+type logicalNode struct {
+	and         bool
+	left, right node
+}
+
+func (n logicalNode) eval(item models.ShopItem) value {
+	left := n.left.eval(item).truthy()
+	if n.and && !left {
+		return value{kind: boolValue, b: false}
+	}
+	if !n.and && left {
+		return value{kind: boolValue, b: true}
+	}
+	return value{kind: boolValue, b: n.right.eval(item).truthy()}
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(item models.ShopItem) value {
+	return value{kind: boolValue, b: compare(n.op, n.left.eval(item), n.right.eval(item))}
+}
+
+type matchesNode struct {
+	left node
+	re   *regexp.Regexp
+}
+
+func (n matchesNode) eval(item models.ShopItem) value {
+	return value{kind: boolValue, b: n.re.MatchString(n.left.eval(item).string())}
+}
+
+// Compares two values with comparison or string operator
+func compare(op string, left value, right value) bool {
+	switch op {
+	case "contains":
+		return strings.Contains(left.string(), right.string())
+	case "startsWith":
+		return strings.HasPrefix(left.string(), right.string())
+	case "endsWith":
+		return strings.HasSuffix(left.string(), right.string())
+	}
+
+	// Compare as booleans
+	if left.kind == boolValue || right.kind == boolValue {
+		equal := left.truthy() == right.truthy()
+		switch op {
+		case "==":
+			return equal
+		case "!=":
+			return !equal
+		}
+		return false
+	}
+
+	// Compare as numbers when any side is a number
+	if left.kind == numberValue || right.kind == numberValue || op[0] == '<' || op[0] == '>' {
+		l, lok := left.number()
+		r, rok := right.number()
+		if !lok || !rok {
+			return op == "!="
+		}
+		switch op {
+		case "==":
+			return l == r
+		case "!=":
+			return l != r
+		case "<":
+			return l < r
+		case "<=":
+			return l <= r
+		case ">":
+			return l > r
+		case ">=":
+			return l >= r
+		}
+		return false
+	}
+
+	// Compare as strings
+	switch op {
+	case "==":
+		return left.s == right.s
+	case "!=":
+		return left.s != right.s
+	}
+	return false
+}
+
+// LEXER
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Splits expression source into tokens
+func tokenize(source string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(source)
+	for pos := 0; pos < len(runes); {
+		r := runes[pos]
+		switch {
+		case unicode.IsSpace(r):
+			pos++
+		case unicode.IsLetter(r) || r == '_':
+			start := pos
+			for pos < len(runes) && (unicode.IsLetter(runes[pos]) || unicode.IsDigit(runes[pos]) || runes[pos] == '_') {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:pos]), pos: start})
+		case unicode.IsDigit(r) || (r == '-' && pos+1 < len(runes) && unicode.IsDigit(runes[pos+1])):
+			// Digits with optional sign and fraction, e.g. -12.5
+			start := pos
+			pos = scanDigits(runes, pos+1)
+			if pos+1 < len(runes) && runes[pos] == '.' && unicode.IsDigit(runes[pos+1]) {
+				pos = scanDigits(runes, pos+1)
+			}
+			if pos < len(runes) && (runes[pos] == '.' || runes[pos] == '_' || unicode.IsLetter(runes[pos])) {
+				return nil, fmt.Errorf("invalid number at position %d", start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:pos]), pos: start})
+		case r == '"':
+			start := pos
+			pos++
+			for pos < len(runes) && runes[pos] != '"' {
+				if runes[pos] == '\\' {
+					pos++
+				}
+				pos++
+			}
+			if pos >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			pos++
+			text, err := strconv.Unquote(string(runes[start:pos]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %w", start, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: start})
+		default:
+			start := pos
+			op := ""
+			if pos+1 < len(runes) {
+				switch two := string(runes[pos : pos+2]); two {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					op = two
+				}
+			}
+			if len(op) == 0 && strings.ContainsRune("!<>(),", r) {
+				op = string(r)
+			}
+			if len(op) == 0 {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, pos)
+			}
+			pos += len(op)
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: start})
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// Returns position of the first non-digit rune from pos
+func scanDigits(runes []rune, pos int) int {
+	for pos < len(runes) && unicode.IsDigit(runes[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// PARSER
+
+// Recursive descent parser of expressions
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isOperator(text string) bool {
+	t := p.peek()
+	return t.kind == tokenOperator && t.text == text
+}
+
+func (p *exprParser) expectOperator(text string) error {
+	if !p.isOperator(text) {
+		return fmt.Errorf("expected %q at position %d", text, p.peek().pos)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("&&") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (node, error) {
+	if p.isOperator("!") {
+		p.next()
+		arg, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{arg: arg}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	op := ""
+	if t.kind == tokenOperator || t.kind == tokenIdent {
+		switch t.text {
+		case "==", "!=", "<", "<=", ">", ">=",
+			"contains", "startsWith", "endsWith", "matches":
+			op = t.text
+		}
+	}
+	if len(op) == 0 {
+		return left, nil
+	}
+	p.next()
+
+	if op == "matches" {
+		pattern := p.next()
+		if pattern.kind != tokenString {
+			return nil, fmt.Errorf("matches requires string pattern at position %d", pattern.pos)
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern at position %d: %w", pattern.pos, err)
+		}
+		return matchesNode{left: left, re: re}, nil
+	}
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *exprParser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return literalNode{v: value{kind: numberValue, n: n}}, nil
+	case tokenString:
+		return literalNode{v: value{s: t.text}}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			return literalNode{v: value{kind: boolValue, b: t.text == "true"}}, nil
+		case "param":
+			return p.parseParam()
+		case "len":
+			if err := p.expectOperator("("); err != nil {
+				return nil, err
+			}
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOperator(")"); err != nil {
+				return nil, err
+			}
+			return lenNode{arg: arg}, nil
+		}
+		get, ok := itemFields[t.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q at position %d", t.text, t.pos)
+		}
+		return fieldNode{get: get}, nil
+	case tokenOperator:
+		if t.text == "(" {
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOperator(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+func (p *exprParser) parseParam() (node, error) {
+	if err := p.expectOperator("("); err != nil {
+		return nil, err
+	}
+	name := p.next()
+	if name.kind != tokenString {
+		return nil, fmt.Errorf("param requires string name at position %d", name.pos)
+	}
+	if err := p.expectOperator(")"); err != nil {
+		return nil, err
+	}
+	return paramNode{name: name.text}, nil
+}
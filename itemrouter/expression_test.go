@@ -0,0 +1,129 @@
+package itemrouter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestExpressionMatch(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   bool
+	}{
+		{`true`, true},
+		{`false`, false},
+		{`heurekaCPC != ""`, true},
+		{`videoUrl != ""`, false},
+		{`priceVat > 1000`, true},
+		{`priceVat > 1000 && categoryText startsWith "Elektronika"`, true},
+		{`priceVat > 2000 && categoryText startsWith "Elektronika"`, false},
+		{`priceVat > 2000 || categoryText startsWith "Elektronika"`, true},
+		{`priceVat >= 1299.9 && priceVat <= 1299.9`, true},
+		{`priceVat == 1299.90`, true},
+		{`priceVat > -1`, true},
+		{`priceVat < -1.5`, false},
+		{`heurekaCPC < 5`, false},
+		{`productName > 10`, false},
+		{`productName == "Notebook Lenovo"`, true},
+		{`productName contains "Lenovo"`, true},
+		{`productName endsWith "Dell"`, false},
+		{`ean matches "^[0-9]{13}$"`, true},
+		{`!(ean matches "^[0-9]{8}$")`, true},
+		{`param("Barva") == "černá"`, true},
+		{`param("Hmotnost") == ""`, true},
+		{`len(itemId) == 5`, true},
+		{`duplicate`, false},
+		{`!duplicate && changeType == ""`, true},
+		{`(priceVat < 100 || priceVat > 1000) && !(heurekaCPC == "")`, true},
+	}
+
+	for _, tc := range testCases {
+		expression, err := CompileExpression(tc.expression)
+		if err != nil {
+			t.Fatalf("CompileExpression(%s), err = %v, want nil", tc.expression, err)
+		}
+		if result := expression.Match(mockedItem); result != tc.expected {
+			t.Fatalf(
+				"CompileExpression(%s).Match(mockedItem) = %v, want %v",
+				tc.expression,
+				result,
+				tc.expected,
+			)
+		}
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	invalidExpressions := []string{
+		``,
+		`unknownField == 1`,
+		`priceVat >`,
+		`(priceVat > 1`,
+		`priceVat > 1)`,
+		`productName == "unterminated`,
+		`ean matches productName`,
+		`ean matches "[0-9"`,
+		`param(productName)`,
+		`priceVat # 1`,
+		`priceVat > 1.2.3`,
+		`priceVat > 1.`,
+		`priceVat > 10abc`,
+		`priceVat > --1`,
+		`priceVat > - 1`,
+	}
+
+	for _, source := range invalidExpressions {
+		if _, err := CompileExpression(source); err == nil {
+			t.Fatalf("CompileExpression(%s), expected error, got nil", source)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	testCases := []struct {
+		source   string
+		expected []string
+	}{
+		{`priceVat > -1`, []string{"priceVat", ">", "-1"}},
+		{`priceVat>=-12.5`, []string{"priceVat", ">=", "-12.5"}},
+		{`len(itemId) == 10`, []string{"len", "(", "itemId", ")", "==", "10"}},
+	}
+	for _, tc := range testCases {
+		tokens, err := tokenize(tc.source)
+		if err != nil {
+			t.Fatalf("tokenize(%s), err = %v, want nil", tc.source, err)
+		}
+		texts := []string{}
+		for _, token := range tokens[:len(tokens)-1] {
+			texts = append(texts, token.text)
+		}
+		if !reflect.DeepEqual(texts, tc.expected) {
+			t.Fatalf("tokenize(%s), got = %q, want %q", tc.source, texts, tc.expected)
+		}
+		if last := tokens[len(tokens)-2]; last.kind != tokenNumber {
+			t.Fatalf("tokenize(%s), last token kind = %v, want number", tc.source, last.kind)
+		}
+	}
+
+	for _, source := range []string{`1.2.3`, `1.`, `1.x`, `12ab`} {
+		if _, err := tokenize(source); err == nil {
+			t.Fatalf("tokenize(%s), err = nil, want error", source)
+		}
+	}
+}
+
+// MOCKED DATA
+
+var mockedItem = models.ShopItem{
+	ItemID:       "nb_01",
+	ProductName:  "Notebook Lenovo",
+	PriceVat:     "1299,90",
+	HeurekaCPC:   "12,50",
+	CategoryText: "Elektronika | Počítače | Notebooky",
+	EAN:          "4006381333931",
+	Params: []models.ShopItemParam{
+		{ParamName: "Barva", Val: "černá"},
+	},
+}
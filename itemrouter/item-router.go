@@ -0,0 +1,207 @@
+package itemrouter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"go.uber.org/zap"
+)
+
+// Named output with predicate selecting items sent to it
+type Route struct {
+	// Name of the output queue
+	Name      string
+	Predicate *Expression
+}
+
+// Configuration of a single output
+type OutputConfig struct {
+	Name      string `json:"name"`
+	Predicate string `json:"predicate"`
+}
+
+// Routing configuration with list of named outputs
+type RoutingConfig struct {
+	Outputs []OutputConfig `json:"outputs"`
+}
+
+// Default routing sending all items to "shop_items"
+// and items with HEUREKA_CPC to "shop_items_bidding"
+var DefaultRoutingConfig = RoutingConfig{
+	Outputs: []OutputConfig{
+		{Name: "shop_items", Predicate: "true"},
+		{Name: "shop_items_bidding", Predicate: `heurekaCPC != ""`},
+	},
+}
+
+// Provider of current routes, called once per parsed feed
+type RoutesProviderInterface interface {
+	GetRoutes() ([]Route, error)
+}
+
+// Compiles routing configuration into list of routes
+func CompileRoutes(config RoutingConfig) ([]Route, error) {
+	if len(config.Outputs) == 0 {
+		return nil, fmt.Errorf("routing configuration has no outputs")
+	}
+
+	routes := make([]Route, 0, len(config.Outputs))
+	names := make(map[string]bool)
+	for _, output := range config.Outputs {
+		if len(output.Name) == 0 {
+			return nil, fmt.Errorf("routing output has no name")
+		}
+		if names[output.Name] {
+			return nil, fmt.Errorf("routing output %q is defined more than once", output.Name)
+		}
+		names[output.Name] = true
+
+		predicate, err := CompileExpression(output.Predicate)
+		if err != nil {
+			return nil, fmt.Errorf("routing output %q: %w", output.Name, err)
+		}
+		routes = append(routes, Route{Name: output.Name, Predicate: predicate})
+	}
+
+	return routes, nil
+}
+
+// Sends every item from input to outputs of all matching routes.
+// outputs[i] belongs to routes[i]. Closes outputs when finished
+func RouteItems(
+	routes []Route,
+	input chan models.ShopItem,
+	outputs []chan models.ShopItem,
+) {
+	// Close channels after routing
+	defer func() {
+		for _, output := range outputs {
+			close(output)
+		}
+	}()
+
+	for item := range input {
+		for idx, route := range routes {
+			if route.Predicate.Match(item) {
+				outputs[idx] <- item
+			}
+		}
+	}
+}
+
+// Routes provider with fixed list of routes, replaced only with Update
+// Implements RoutesProviderInterface
+type StaticRoutes struct {
+	mutex  sync.RWMutex
+	routes []Route
+}
+
+// Creates new StaticRoutes instance from routing configuration
+func NewStaticRoutes(config RoutingConfig) (*StaticRoutes, error) {
+	routes, err := CompileRoutes(config)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticRoutes{
+		routes: routes,
+	}, nil
+}
+
+// Returns current list of routes. Safe for concurrent use
+func (r *StaticRoutes) GetRoutes() ([]Route, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.routes, nil
+}
+
+// Replaces routes with compiled config, previous routes are kept
+// when config is invalid. Feeds being parsed keep their routes
+func (r *StaticRoutes) Update(config RoutingConfig) error {
+	routes, err := CompileRoutes(config)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.routes = routes
+	return nil
+}
+
+// Routes provider reading routing configuration from JSON file.
+// File is read again when its modification time changes,
+// so outputs can be changed without restarting the service
+// Implements RoutesProviderInterface
+type FileRoutes struct {
+	path    string
+	mutex   sync.Mutex
+	modTime time.Time
+	routes  []Route
+}
+
+// Creates new FileRoutes instance, returns error
+// when configuration file is missing or invalid
+func NewFileRoutes(path string) (*FileRoutes, error) {
+	r := &FileRoutes{
+		path: path,
+	}
+	if _, err := r.GetRoutes(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Returns routes from the configuration file. When changed file is
+// invalid, error is logged and previously loaded routes are returned.
+// Safe for concurrent use
+func (r *FileRoutes) GetRoutes() ([]Route, error) {
+	defer zap.L().Sync()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return r.fallback(err)
+	}
+	if r.routes != nil && info.ModTime().Equal(r.modTime) {
+		return r.routes, nil
+	}
+
+	content, err := os.ReadFile(r.path)
+	if err != nil {
+		return r.fallback(err)
+	}
+	var config RoutingConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return r.fallback(err)
+	}
+	routes, err := CompileRoutes(config)
+	if err != nil {
+		return r.fallback(err)
+	}
+
+	r.routes = routes
+	r.modTime = info.ModTime()
+	zap.L().Info(
+		fmt.Sprintf("Loaded %d routing outputs from %s", len(routes), r.path),
+		zap.String("path", r.path),
+	)
+	return r.routes, nil
+}
+
+// Returns previously loaded routes or err when there are none
+func (r *FileRoutes) fallback(err error) ([]Route, error) {
+	if r.routes == nil {
+		return nil, err
+	}
+	zap.L().Error(
+		"Cannot reload routing configuration, using previous one",
+		zap.String("path", r.path),
+		zap.Error(err),
+	)
+	return r.routes, nil
+}
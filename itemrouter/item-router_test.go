@@ -0,0 +1,137 @@
+package itemrouter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestRouteItems(t *testing.T) {
+	routes, err := CompileRoutes(DefaultRoutingConfig)
+	if err != nil {
+		t.Fatalf("CompileRoutes(DefaultRoutingConfig), err = %v, want nil", err)
+	}
+	items := []models.ShopItem{
+		{ItemID: "a", HeurekaCPC: "1"},
+		{ItemID: "b"},
+	}
+
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	outputs := []chan models.ShopItem{
+		make(chan models.ShopItem, len(items)),
+		make(chan models.ShopItem, len(items)),
+	}
+	RouteItems(routes, input, outputs)
+
+	expected := [][]models.ShopItem{items, items[:1]}
+	for idx, output := range outputs {
+		results := []models.ShopItem{}
+		for item := range output {
+			results = append(results, item)
+		}
+		if !reflect.DeepEqual(results, expected[idx]) {
+			t.Fatalf(
+				"RouteItems(), output %q = %v, want %v",
+				routes[idx].Name,
+				results,
+				expected[idx],
+			)
+		}
+	}
+}
+
+func TestCompileRoutesErrors(t *testing.T) {
+	invalidConfigs := []RoutingConfig{
+		{},
+		{Outputs: []OutputConfig{{Name: "", Predicate: "true"}}},
+		{Outputs: []OutputConfig{{Name: "a", Predicate: "true"}, {Name: "a", Predicate: "false"}}},
+		{Outputs: []OutputConfig{{Name: "a", Predicate: "priceVat >"}}},
+	}
+
+	for _, config := range invalidConfigs {
+		if _, err := CompileRoutes(config); err == nil {
+			t.Fatalf("CompileRoutes(%+v), expected error, got nil", config)
+		}
+	}
+}
+
+func TestFileRoutesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routing.json")
+	writeConfig(t, path, `{"outputs": [{"name": "all", "predicate": "true"}]}`, time.Now().Add(-time.Minute))
+
+	provider, err := NewFileRoutes(path)
+	if err != nil {
+		t.Fatalf("NewFileRoutes(path), err = %v, want nil", err)
+	}
+	checkRouteNames(t, provider, []string{"all"})
+
+	// Changed file is reloaded
+	writeConfig(t, path, `{"outputs": [{"name": "all", "predicate": "true"}, {"name": "cheap", "predicate": "priceVat < 100"}]}`, time.Now())
+	checkRouteNames(t, provider, []string{"all", "cheap"})
+
+	// Invalid file is ignored
+	writeConfig(t, path, `{"outputs": [{"name": "broken", "predicate": "priceVat <"}]}`, time.Now().Add(time.Minute))
+	checkRouteNames(t, provider, []string{"all", "cheap"})
+}
+
+func TestStaticRoutesUpdate(t *testing.T) {
+	provider, err := NewStaticRoutes(RoutingConfig{
+		Outputs: []OutputConfig{{Name: "all", Predicate: "true"}},
+	})
+	if err != nil {
+		t.Fatalf("NewStaticRoutes(config), err = %v, want nil", err)
+	}
+
+	err = provider.Update(RoutingConfig{
+		Outputs: []OutputConfig{{Name: "cheap", Predicate: "priceVat < 100"}},
+	})
+	if err != nil {
+		t.Fatalf("Update(config), err = %v, want nil", err)
+	}
+	checkRouteNames(t, provider, []string{"cheap"})
+
+	// Invalid config is ignored
+	if err := provider.Update(RoutingConfig{}); err == nil {
+		t.Fatalf("Update(empty), expected error, got nil")
+	}
+	checkRouteNames(t, provider, []string{"cheap"})
+}
+
+func TestNewFileRoutesMissingFile(t *testing.T) {
+	if _, err := NewFileRoutes(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatalf("NewFileRoutes(missing), expected error, got nil")
+	}
+}
+
+// Writes routing configuration file with given modification time
+func writeConfig(t *testing.T, path string, content string, modTime time.Time) {
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("os.WriteFile(%s), err = %v", path, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("os.Chtimes(%s), err = %v", path, err)
+	}
+}
+
+// Checks names of routes returned by provider
+func checkRouteNames(t *testing.T, provider RoutesProviderInterface, expected []string) {
+	routes, err := provider.GetRoutes()
+	if err != nil {
+		t.Fatalf("GetRoutes(), err = %v, want nil", err)
+	}
+	names := []string{}
+	for _, route := range routes {
+		names = append(names, route.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("GetRoutes() names = %v, want %v", names, expected)
+	}
+}
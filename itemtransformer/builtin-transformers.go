@@ -0,0 +1,261 @@
+package itemtransformer
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Names of built-in transformers
+const (
+	StripHtml           = "strip_html"
+	NormalizeWhitespace = "normalize_whitespace"
+	CanonicalizeUrls    = "canonicalize_urls"
+	CategoryMapping     = "category_mapping"
+	FeedMetadata        = "feed_metadata"
+)
+
+// Register built-in transformers
+func init() {
+	RegisterTransformer(StripHtml, newStripHtmlTransformer)
+	RegisterTransformer(NormalizeWhitespace, newNormalizeWhitespaceTransformer)
+	RegisterTransformer(CanonicalizeUrls, newCanonicalizeUrlsTransformer)
+	RegisterTransformer(CategoryMapping, newCategoryMappingTransformer)
+	RegisterTransformer(FeedMetadata, newFeedMetadataTransformer)
+}
+
+// STRIP HTML
+
+var (
+	htmlBlockTags = regexp.MustCompile(`(?i)<\s*(br|/p|/div|/li|/h[1-6]|/tr)\b[^>]*>`)
+	htmlTags      = regexp.MustCompile(`<[^>]*>`)
+	htmlComments  = regexp.MustCompile(`(?s)<!--.*?-->`)
+)
+
+// Removes HTML tags and decodes HTML entities in DESCRIPTION.
+// Options:
+//   - fields: comma separated JSON names of fields to strip (default "description")
+func newStripHtmlTransformer(options map[string]string) (ItemTransformerInterface, error) {
+	fields, err := stringFields(options["fields"], []string{"description"})
+	if err != nil {
+		return nil, err
+	}
+	return TransformerFunc(func(item models.ShopItem, _ models.FeedMetadata) models.ShopItem {
+		for _, field := range fields {
+			value := field(&item)
+			*value = stripHtml(*value)
+		}
+		return item
+	}), nil
+}
+
+// Returns text content of HTML fragment
+func stripHtml(text string) string {
+	text = htmlComments.ReplaceAllString(text, "")
+	text = htmlBlockTags.ReplaceAllString(text, " ")
+	text = htmlTags.ReplaceAllString(text, "")
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// NORMALIZE WHITESPACE
+
+// Trims all text fields and replaces whitespace sequences with single space
+func newNormalizeWhitespaceTransformer(map[string]string) (ItemTransformerInterface, error) {
+	return TransformerFunc(func(item models.ShopItem, _ models.FeedMetadata) models.ShopItem {
+		for _, field := range itemStringFields {
+			value := field(&item)
+			*value = strings.Join(strings.Fields(*value), " ")
+		}
+		if item.Params != nil {
+			// Copy params, so items sharing the slice are not changed
+			params := make([]models.ShopItemParam, len(item.Params))
+			for idx, param := range item.Params {
+				param.ParamName = strings.Join(strings.Fields(param.ParamName), " ")
+				param.Val = strings.Join(strings.Fields(param.Val), " ")
+				params[idx] = param
+			}
+			item.Params = params
+		}
+		return item
+	}), nil
+}
+
+// CANONICALIZE URLS
+
+// Query parameters used only for tracking
+var defaultTrackingParams = []string{
+	"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content",
+	"gclid", "fbclid", "msclkid", "yclid", "mc_cid", "mc_eid",
+}
+
+// Canonicalizes URL, IMGURL, IMGURL_ALTERNATIVE and VIDEO_URL:
+// lowercases scheme and host, removes default ports, fragments
+// and tracking query parameters and sorts query parameters.
+// Options:
+//   - trackingParams: comma separated additional tracking parameters
+func newCanonicalizeUrlsTransformer(options map[string]string) (ItemTransformerInterface, error) {
+	trackingParams := make(map[string]bool)
+	for _, param := range defaultTrackingParams {
+		trackingParams[param] = true
+	}
+	for _, param := range splitList(options["trackingParams"]) {
+		trackingParams[param] = true
+	}
+
+	return TransformerFunc(func(item models.ShopItem, _ models.FeedMetadata) models.ShopItem {
+		item.Url = canonicalizeUrl(item.Url, trackingParams)
+		item.ImgUrl = canonicalizeUrl(item.ImgUrl, trackingParams)
+		item.ImgUrlAlternative = canonicalizeUrl(item.ImgUrlAlternative, trackingParams)
+		item.VideoUrl = canonicalizeUrl(item.VideoUrl, trackingParams)
+		return item
+	}), nil
+}
+
+// Returns canonical form of absolute url, other values are returned unchanged
+func canonicalizeUrl(rawUrl string, trackingParams map[string]bool) string {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil || !u.IsAbs() || len(u.Host) == 0 {
+		return rawUrl
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	query := u.Query()
+	for param := range query {
+		if trackingParams[strings.ToLower(param)] {
+			query.Del(param)
+		}
+	}
+	// Encode sorts parameters by key
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// CATEGORY MAPPING
+
+// Maps CATEGORYTEXT onto internal category tree and sets InternalCategory.
+// When the whole category path is not mapped, its parents are tried
+// from the most specific one ("A | B | C", "A | B", "A").
+// Options:
+//   - mappingFile: path to JSON object with feed categories as keys
+//     and internal categories as values (required)
+//   - fallback: internal category for unmapped items (default empty)
+func newCategoryMappingTransformer(options map[string]string) (ItemTransformerInterface, error) {
+	path := options["mappingFile"]
+	if len(path) == 0 {
+		return nil, fmt.Errorf("option mappingFile is required")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rawMapping map[string]string
+	if err := json.Unmarshal(content, &rawMapping); err != nil {
+		return nil, fmt.Errorf("invalid mapping file %s: %w", path, err)
+	}
+	mapping := make(map[string]string, len(rawMapping))
+	for category, internal := range rawMapping {
+		mapping[normalizeCategory(category)] = internal
+	}
+	fallback := options["fallback"]
+
+	return TransformerFunc(func(item models.ShopItem, _ models.FeedMetadata) models.ShopItem {
+		item.InternalCategory = fallback
+		segments := strings.Split(normalizeCategory(item.CategoryText), " | ")
+		for length := len(segments); length > 0; length-- {
+			if internal, ok := mapping[strings.Join(segments[:length], " | ")]; ok {
+				item.InternalCategory = internal
+				break
+			}
+		}
+		return item
+	}), nil
+}
+
+// Returns category path with normalized separators and whitespace
+func normalizeCategory(category string) string {
+	segments := strings.Split(category, "|")
+	for idx, segment := range segments {
+		segments[idx] = strings.Join(strings.Fields(segment), " ")
+	}
+	return strings.Join(segments, " | ")
+}
+
+// FEED METADATA
+
+// Sets Metadata of the item to shop id, feed url and fetch time of the feed
+func newFeedMetadataTransformer(map[string]string) (ItemTransformerInterface, error) {
+	return TransformerFunc(func(item models.ShopItem, feed models.FeedMetadata) models.ShopItem {
+		metadata := feed
+		item.Metadata = &metadata
+		return item
+	}), nil
+}
+
+// HELPERS
+
+// Accessors of shop item text fields by their JSON names
+var itemStringFields = map[string]func(item *models.ShopItem) *string{
+	"itemId":            func(i *models.ShopItem) *string { return &i.ItemID },
+	"productName":       func(i *models.ShopItem) *string { return &i.ProductName },
+	"product":           func(i *models.ShopItem) *string { return &i.Product },
+	"description":       func(i *models.ShopItem) *string { return &i.Description },
+	"url":               func(i *models.ShopItem) *string { return &i.Url },
+	"imgUrl":            func(i *models.ShopItem) *string { return &i.ImgUrl },
+	"imgUrlAlternative": func(i *models.ShopItem) *string { return &i.ImgUrlAlternative },
+	"videoUrl":          func(i *models.ShopItem) *string { return &i.VideoUrl },
+	"priceVat":          func(i *models.ShopItem) *string { return &i.PriceVat },
+	"heurekaCPC":        func(i *models.ShopItem) *string { return &i.HeurekaCPC },
+	"categoryText":      func(i *models.ShopItem) *string { return &i.CategoryText },
+	"ean":               func(i *models.ShopItem) *string { return &i.EAN },
+	"productNo":         func(i *models.ShopItem) *string { return &i.ProductNo },
+	"deliveryDate":      func(i *models.ShopItem) *string { return &i.DelivaryDate },
+	"itemGroupId":       func(i *models.ShopItem) *string { return &i.ItemGroupId },
+	"accessory":         func(i *models.ShopItem) *string { return &i.Accessory },
+	"gift":              func(i *models.ShopItem) *string { return &i.Gift },
+	"specialService":    func(i *models.ShopItem) *string { return &i.SpecialService },
+}
+
+// Returns accessors of comma separated fields or defaults when empty
+func stringFields(
+	list string,
+	defaults []string,
+) ([]func(item *models.ShopItem) *string, error) {
+	names := splitList(list)
+	if len(names) == 0 {
+		names = defaults
+	}
+	fields := make([]func(item *models.ShopItem) *string, 0, len(names))
+	for _, name := range names {
+		field, ok := itemStringFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Splits comma separated list and trims its elements
+func splitList(list string) []string {
+	elements := []string{}
+	for _, element := range strings.Split(list, ",") {
+		if element = strings.TrimSpace(element); len(element) > 0 {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,143 @@
+package itemtransformer
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestStripHtml(t *testing.T) {
+	transformer, _ := NewTransformer(StripHtml, nil)
+	item := models.ShopItem{
+		ProductName: "<b>Name</b>",
+		Description: "<p>First&nbsp;line</p><!-- comment --><p>Second <b>bold</b> &amp; line<br/>third</p>",
+	}
+
+	result := transformer.Transform(item, models.FeedMetadata{})
+
+	if result.Description != "First line Second bold & line third" {
+		t.Fatalf("strip_html Description = %q, want plain text", result.Description)
+	}
+	if result.ProductName != item.ProductName {
+		t.Fatalf("strip_html ProductName = %q, want unchanged", result.ProductName)
+	}
+}
+
+func TestStripHtmlFields(t *testing.T) {
+	transformer, err := NewTransformer(StripHtml, map[string]string{"fields": "productName, description"})
+	if err != nil {
+		t.Fatalf("NewTransformer(strip_html), err = %v, want nil", err)
+	}
+
+	result := transformer.Transform(models.ShopItem{ProductName: "<b>Name</b>"}, models.FeedMetadata{})
+
+	if result.ProductName != "Name" {
+		t.Fatalf("strip_html ProductName = %q, want %q", result.ProductName, "Name")
+	}
+
+	if _, err := NewTransformer(StripHtml, map[string]string{"fields": "unknown"}); err == nil {
+		t.Fatalf("NewTransformer(strip_html) with unknown field, expected error, got nil")
+	}
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	transformer, _ := NewTransformer(NormalizeWhitespace, nil)
+	params := []models.ShopItemParam{{ParamName: " Barva ", Val: "černá\n"}}
+	item := models.ShopItem{
+		ProductName: "  Notebook \t Lenovo\n",
+		PriceVat:    " 1299 ",
+		Params:      params,
+	}
+
+	result := transformer.Transform(item, models.FeedMetadata{})
+
+	expected := models.ShopItem{
+		ProductName: "Notebook Lenovo",
+		PriceVat:    "1299",
+		Params:      []models.ShopItemParam{{ParamName: "Barva", Val: "černá"}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("normalize_whitespace = %+v, want %+v", result, expected)
+	}
+	if params[0].ParamName != " Barva " {
+		t.Fatalf("normalize_whitespace changed params of the input item")
+	}
+}
+
+func TestCanonicalizeUrls(t *testing.T) {
+	transformer, _ := NewTransformer(CanonicalizeUrls, map[string]string{"trackingParams": "ref"})
+	item := models.ShopItem{
+		Url:      "HTTPS://Shop.Test:443/product/1?utm_source=heureka&b=2&a=1&ref=feed#detail",
+		ImgUrl:   "http://cdn.shop.test:8080/img.jpg?gclid=abc",
+		VideoUrl: "/relative/video.mp4",
+	}
+
+	result := transformer.Transform(item, models.FeedMetadata{})
+
+	if result.Url != "https://shop.test/product/1?a=1&b=2" {
+		t.Fatalf("canonicalize_urls Url = %q", result.Url)
+	}
+	if result.ImgUrl != "http://cdn.shop.test:8080/img.jpg" {
+		t.Fatalf("canonicalize_urls ImgUrl = %q", result.ImgUrl)
+	}
+	if result.VideoUrl != item.VideoUrl {
+		t.Fatalf("canonicalize_urls VideoUrl = %q, want unchanged relative url", result.VideoUrl)
+	}
+}
+
+func TestCategoryMapping(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "categories.json")
+	os.WriteFile(mappingFile, []byte(`{
+		"Elektronika | Počítače": "electronics/computers",
+		"Elektronika|Počítače|Notebooky": "electronics/computers/notebooks"
+	}`), 0600)
+	transformer, err := NewTransformer(CategoryMapping, map[string]string{
+		"mappingFile": mappingFile,
+		"fallback":    "other",
+	})
+	if err != nil {
+		t.Fatalf("NewTransformer(category_mapping), err = %v, want nil", err)
+	}
+
+	testCases := map[string]string{
+		"Elektronika | Počítače | Notebooky": "electronics/computers/notebooks",
+		"Elektronika | Počítače | Monitory":  "electronics/computers",
+		"Elektronika |  Počítače":            "electronics/computers",
+		"Elektronika | Mobily":               "other",
+		"":                                   "other",
+	}
+	for category, expected := range testCases {
+		result := transformer.Transform(models.ShopItem{CategoryText: category}, models.FeedMetadata{})
+		if result.InternalCategory != expected {
+			t.Fatalf(
+				"category_mapping(%q) InternalCategory = %q, want %q",
+				category,
+				result.InternalCategory,
+				expected,
+			)
+		}
+	}
+
+	if _, err := NewTransformer(CategoryMapping, nil); err == nil {
+		t.Fatalf("NewTransformer(category_mapping) without mappingFile, expected error, got nil")
+	}
+}
+
+func TestFeedMetadata(t *testing.T) {
+	transformer, _ := NewTransformer(FeedMetadata, nil)
+	feed := models.FeedMetadata{
+		ShopId:    "shop_1",
+		FeedUrl:   "test_url_1",
+		FetchedAt: time.Date(2022, 3, 14, 12, 0, 0, 0, time.UTC),
+	}
+
+	result := transformer.Transform(models.ShopItem{ItemID: "a"}, feed)
+
+	if result.Metadata == nil || *result.Metadata != feed {
+		t.Fatalf("feed_metadata Metadata = %+v, want %+v", result.Metadata, feed)
+	}
+}
@@ -0,0 +1,97 @@
+package itemtransformer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Configuration of a single transform
+type TransformConfig struct {
+	Name    string            `json:"name"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Transforms configuration of a single feed
+type FeedTransformsConfig struct {
+	ShopId     string            `json:"shopId,omitempty"`
+	Transforms []TransformConfig `json:"transforms"`
+}
+
+// Transforms configuration with default chain
+// and per-feed chains keyed by feed url
+type TransformsConfig struct {
+	Default []TransformConfig               `json:"default"`
+	Feeds   map[string]FeedTransformsConfig `json:"feeds,omitempty"`
+}
+
+// Transform chains of all configured feeds
+type FeedTransforms struct {
+	defaultChain Chain
+	feedChains   map[string]Chain
+	shopIds      map[string]string
+}
+
+// Creates transform chains from configuration
+func NewFeedTransforms(config TransformsConfig) (*FeedTransforms, error) {
+	defaultChain, err := newChain(config.Default)
+	if err != nil {
+		return nil, fmt.Errorf("default transforms: %w", err)
+	}
+
+	t := &FeedTransforms{
+		defaultChain: defaultChain,
+		feedChains:   make(map[string]Chain),
+		shopIds:      make(map[string]string),
+	}
+	for feedUrl, feedConfig := range config.Feeds {
+		// Feeds without own transforms use the default chain
+		chain := defaultChain
+		if feedConfig.Transforms != nil {
+			chain, err = newChain(feedConfig.Transforms)
+			if err != nil {
+				return nil, fmt.Errorf("transforms of feed %s: %w", feedUrl, err)
+			}
+		}
+		t.feedChains[feedUrl] = chain
+		t.shopIds[feedUrl] = feedConfig.ShopId
+	}
+
+	return t, nil
+}
+
+// Reads transforms configuration from JSON file
+// and creates transform chains
+func LoadFeedTransforms(path string) (*FeedTransforms, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config TransformsConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("invalid transforms configuration %s: %w", path, err)
+	}
+	return NewFeedTransforms(config)
+}
+
+// Returns transform chain and shop id of the feed
+func (t *FeedTransforms) ForFeed(feedUrl string) (Chain, string) {
+	chain, ok := t.feedChains[feedUrl]
+	if !ok {
+		return t.defaultChain, ""
+	}
+	return chain, t.shopIds[feedUrl]
+}
+
+// Creates chain of registered transformers
+func newChain(configs []TransformConfig) (Chain, error) {
+	chain := make(Chain, 0, len(configs))
+	for _, config := range configs {
+		transformer, err := NewTransformer(config.Name, config.Options)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, transformer)
+	}
+	return chain, nil
+}
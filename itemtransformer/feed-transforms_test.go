@@ -0,0 +1,75 @@
+package itemtransformer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestFeedTransforms(t *testing.T) {
+	RegisterTransformer("test_uppercase", func(map[string]string) (ItemTransformerInterface, error) {
+		return TransformerFunc(func(item models.ShopItem, _ models.FeedMetadata) models.ShopItem {
+			item.ProductName = strings.ToUpper(item.ProductName)
+			return item
+		}), nil
+	})
+
+	transforms, err := NewFeedTransforms(TransformsConfig{
+		Default: []TransformConfig{{Name: NormalizeWhitespace}},
+		Feeds: map[string]FeedTransformsConfig{
+			"test_url_1": {
+				ShopId:     "shop_1",
+				Transforms: []TransformConfig{{Name: NormalizeWhitespace}, {Name: "test_uppercase"}},
+			},
+			"test_url_2": {
+				ShopId: "shop_2",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewFeedTransforms(config), err = %v, want nil", err)
+	}
+
+	testCases := []struct {
+		feedUrl        string
+		expectedShopId string
+		expectedName   string
+	}{
+		{"test_url_1", "shop_1", "NOTEBOOK LENOVO"},
+		{"test_url_2", "shop_2", "Notebook Lenovo"},
+		{"unknown_url", "", "Notebook Lenovo"},
+	}
+	for _, tc := range testCases {
+		chain, shopId := transforms.ForFeed(tc.feedUrl)
+		result := chain.Transform(models.ShopItem{ProductName: " Notebook  Lenovo"}, models.FeedMetadata{})
+		if shopId != tc.expectedShopId || result.ProductName != tc.expectedName {
+			t.Fatalf(
+				"FeedTransforms.ForFeed(%s) = %q, %q, want %q, %q",
+				tc.feedUrl,
+				shopId,
+				result.ProductName,
+				tc.expectedShopId,
+				tc.expectedName,
+			)
+		}
+	}
+}
+
+func TestFeedTransformsUnknownTransformer(t *testing.T) {
+	_, err := NewFeedTransforms(TransformsConfig{
+		Default: []TransformConfig{{Name: "unknown"}},
+	})
+	if err == nil {
+		t.Fatalf("NewFeedTransforms(config) with unknown transformer, expected error, got nil")
+	}
+}
+
+func TestRegisterTransformerTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("RegisterTransformer(strip_html), expected panic for registered name")
+		}
+	}()
+	RegisterTransformer(StripHtml, newStripHtmlTransformer)
+}
@@ -0,0 +1,9 @@
+package itemtransformer
+
+import "github.com/MichalMitros/feed-parser/models"
+
+// Transformation of a single shop item. Implementations
+// have to be safe for concurrent use
+type ItemTransformerInterface interface {
+	Transform(item models.ShopItem, feed models.FeedMetadata) models.ShopItem
+}
@@ -0,0 +1,83 @@
+package itemtransformer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Creates transformer from options of the transform configuration
+type TransformerFactory func(options map[string]string) (ItemTransformerInterface, error)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]TransformerFactory)
+)
+
+// Registers transformer factory under the name used in transforms
+// configuration. Custom transformers should be registered before
+// the configuration is loaded. Panics when name is already registered
+func RegisterTransformer(name string, factory TransformerFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("transformer %q is already registered", name))
+	}
+	registry[name] = factory
+}
+
+// Returns sorted names of all registered transformers
+func RegisteredTransformers() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Creates registered transformer with given options
+func NewTransformer(
+	name string,
+	options map[string]string,
+) (ItemTransformerInterface, error) {
+	registryMutex.RLock()
+	factory, ok := registry[name]
+	registryMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown transformer %q", name)
+	}
+	transformer, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("transformer %q: %w", name, err)
+	}
+	return transformer, nil
+}
+
+// List of transformers applied one after another
+// Implements ItemTransformerInterface
+type Chain []ItemTransformerInterface
+
+// Applies all transformers of the chain in order
+func (c Chain) Transform(item models.ShopItem, feed models.FeedMetadata) models.ShopItem {
+	for _, transformer := range c {
+		item = transformer.Transform(item, feed)
+	}
+	return item
+}
+
+// Transformer created from a plain function
+// Implements ItemTransformerInterface
+type TransformerFunc func(item models.ShopItem, feed models.FeedMetadata) models.ShopItem
+
+// Calls the function
+func (f TransformerFunc) Transform(item models.ShopItem, feed models.FeedMetadata) models.ShopItem {
+	return f(item, feed)
+}
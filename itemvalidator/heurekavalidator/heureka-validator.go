@@ -0,0 +1,202 @@
+package heurekavalidator
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Names of the validation rules
+const (
+	RuleRequiredItemId      = "required_item_id"
+	RuleRequiredProductName = "required_product_name"
+	RuleRequiredUrl         = "required_url"
+	RuleRequiredPriceVat    = "required_price_vat"
+	RulePriceVatFormat      = "price_vat_format"
+	RuleItemIdLength        = "item_id_length"
+	RuleItemIdCharset       = "item_id_charset"
+	RuleEanChecksum         = "ean_checksum"
+	RuleAbsoluteImgUrl      = "absolute_img_url"
+	RuleCpcRange            = "cpc_range"
+)
+
+// Maximal length of ITEM_ID allowed by Heureka
+const maxItemIdLength = 36
+
+// Characters allowed by Heureka in ITEM_ID
+var itemIdCharset = regexp.MustCompile(`^[0-9A-Za-z_-]*$`)
+
+// Non-negative decimal number with dot or comma as decimal separator
+var priceFormat = regexp.MustCompile(`^[0-9]+([.,][0-9]+)?$`)
+
+// Options of HeurekaValidator
+type HeurekaValidatorOptions struct {
+	// Minimal allowed HEUREKA_CPC value (inclusive)
+	MinCPC float64
+	// Maximal allowed HEUREKA_CPC value (inclusive)
+	MaxCPC float64
+}
+
+// Default options with CPC range allowed by Heureka
+var DefaultHeurekaValidatorOptions = HeurekaValidatorOptions{
+	MinCPC: 0,
+	MaxCPC: 100,
+}
+
+// Validator checking shop items against Heureka feed specification
+// Implements ItemValidatorInterface
+type HeurekaValidator struct {
+	minCPC float64
+	maxCPC float64
+}
+
+// Creates new HeurekaValidator instance
+func NewHeurekaValidator(options HeurekaValidatorOptions) *HeurekaValidator {
+	return &HeurekaValidator{
+		minCPC: options.MinCPC,
+		maxCPC: options.MaxCPC,
+	}
+}
+
+// Creates new HeurekaValidator instance with default options
+func DefaultHeurekaValidator() *HeurekaValidator {
+	return NewHeurekaValidator(DefaultHeurekaValidatorOptions)
+}
+
+// Validates shop item and returns list of broken rules.
+// Safe for concurrent use
+func (v *HeurekaValidator) Validate(item models.ShopItem) []models.RuleViolation {
+	violations := []models.RuleViolation{}
+	violate := func(rule string, format string, args ...interface{}) {
+		violations = append(violations, models.RuleViolation{
+			Rule:    rule,
+			Message: fmt.Sprintf(format, args...),
+		})
+		ruleViolations.WithLabelValues(rule).Inc()
+	}
+
+	// Required fields
+	if len(strings.TrimSpace(item.ItemID)) == 0 {
+		violate(RuleRequiredItemId, "ITEM_ID is required")
+	}
+	if len(strings.TrimSpace(item.ProductName)) == 0 {
+		violate(RuleRequiredProductName, "PRODUCTNAME is required")
+	}
+	if len(strings.TrimSpace(item.Url)) == 0 {
+		violate(RuleRequiredUrl, "URL is required")
+	}
+	if len(strings.TrimSpace(item.PriceVat)) == 0 {
+		violate(RuleRequiredPriceVat, "PRICE_VAT is required")
+	} else if !priceFormat.MatchString(strings.TrimSpace(item.PriceVat)) {
+		violate(RulePriceVatFormat, "PRICE_VAT %q is not a non-negative decimal number", item.PriceVat)
+	}
+
+	// ITEM_ID format
+	if len(item.ItemID) > maxItemIdLength {
+		violate(
+			RuleItemIdLength,
+			"ITEM_ID has %d characters, max %d allowed",
+			len(item.ItemID),
+			maxItemIdLength,
+		)
+	}
+	if !itemIdCharset.MatchString(item.ItemID) {
+		violate(
+			RuleItemIdCharset,
+			"ITEM_ID %q contains characters other than [0-9A-Za-z_-]",
+			item.ItemID,
+		)
+	}
+
+	// EAN checksum
+	if len(item.EAN) > 0 && !isValidEan(item.EAN) {
+		violate(RuleEanChecksum, "EAN %q is not valid", item.EAN)
+	}
+
+	// Image urls
+	if len(item.ImgUrl) > 0 && !isAbsoluteUrl(item.ImgUrl) {
+		violate(RuleAbsoluteImgUrl, "IMGURL %q is not absolute url", item.ImgUrl)
+	}
+	if len(item.ImgUrlAlternative) > 0 && !isAbsoluteUrl(item.ImgUrlAlternative) {
+		violate(
+			RuleAbsoluteImgUrl,
+			"IMGURL_ALTERNATIVE %q is not absolute url",
+			item.ImgUrlAlternative,
+		)
+	}
+
+	// CPC range
+	if len(item.HeurekaCPC) > 0 {
+		cpc, err := ParseDecimal(item.HeurekaCPC)
+		if err != nil || cpc < v.minCPC || cpc > v.maxCPC {
+			violate(
+				RuleCpcRange,
+				"HEUREKA_CPC %q is not a number between %v and %v",
+				item.HeurekaCPC,
+				v.minCPC,
+				v.maxCPC,
+			)
+		}
+	}
+
+	return violations
+}
+
+// Parses non-negative decimal number using either dot or comma as
+// decimal separator. Other notations accepted by strconv.ParseFloat,
+// e.g. "NaN", "Inf", "1e1" or "0x1p-2", are errors
+func ParseDecimal(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if !priceFormat.MatchString(value) {
+		return 0, fmt.Errorf("%q is not a decimal number", value)
+	}
+	value = strings.ReplaceAll(value, ",", ".")
+	return strconv.ParseFloat(value, 64)
+}
+
+// Checks if EAN is valid GTIN-8, GTIN-12, GTIN-13 or GTIN-14 code
+func isValidEan(ean string) bool {
+	switch len(ean) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+
+	sum := 0
+	for idx := len(ean) - 1; idx >= 0; idx-- {
+		if ean[idx] < '0' || ean[idx] > '9' {
+			return false
+		}
+		digit := int(ean[idx] - '0')
+		// Digits are weighted 1 and 3 alternately from the check digit
+		if (len(ean)-1-idx)%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+
+	return sum%10 == 0
+}
+
+// Checks if rawUrl is absolute http(s) url
+func isAbsoluteUrl(rawUrl string) bool {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+}
+
+// Prometheus rule violations counter
+var (
+	ruleViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_validation_rule_violations_total",
+		Help: "The total number of shop items validation rule violations",
+	}, []string{"rule"})
+)
@@ -0,0 +1,172 @@
+package heurekavalidator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestValidateCorrectItem(t *testing.T) {
+	validator := DefaultHeurekaValidator()
+
+	violations := validator.Validate(mockedValidItem)
+
+	if len(violations) != 0 {
+		t.Fatalf(
+			"HeurekaValidator.Validate(mockedValidItem) = %v, want no violations",
+			violations,
+		)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	validator := DefaultHeurekaValidator()
+
+	testCases := []struct {
+		name          string
+		modify        func(item *models.ShopItem)
+		expectedRules []string
+	}{
+		{
+			name:          "missing required fields",
+			modify:        func(item *models.ShopItem) { item.ItemID, item.ProductName, item.Url, item.PriceVat = "", "", "", " " },
+			expectedRules: []string{RuleRequiredItemId, RuleRequiredProductName, RuleRequiredUrl, RuleRequiredPriceVat},
+		},
+		{
+			name:          "price not a number",
+			modify:        func(item *models.ShopItem) { item.PriceVat = "abc" },
+			expectedRules: []string{RulePriceVatFormat},
+		},
+		{
+			name:          "negative price",
+			modify:        func(item *models.ShopItem) { item.PriceVat = "-10" },
+			expectedRules: []string{RulePriceVatFormat},
+		},
+		{
+			name:          "price in exponent notation",
+			modify:        func(item *models.ShopItem) { item.PriceVat = "1e3" },
+			expectedRules: []string{RulePriceVatFormat},
+		},
+		{
+			name:          "too long item id",
+			modify:        func(item *models.ShopItem) { item.ItemID = strings.Repeat("a", 37) },
+			expectedRules: []string{RuleItemIdLength},
+		},
+		{
+			name:          "item id with wrong characters",
+			modify:        func(item *models.ShopItem) { item.ItemID = "item/1" },
+			expectedRules: []string{RuleItemIdCharset},
+		},
+		{
+			name:          "wrong EAN checksum",
+			modify:        func(item *models.ShopItem) { item.EAN = "4006381333932" },
+			expectedRules: []string{RuleEanChecksum},
+		},
+		{
+			name:          "EAN with wrong length",
+			modify:        func(item *models.ShopItem) { item.EAN = "40063813339" },
+			expectedRules: []string{RuleEanChecksum},
+		},
+		{
+			name:          "relative image urls",
+			modify:        func(item *models.ShopItem) { item.ImgUrl, item.ImgUrlAlternative = "/img/1.jpg", "img/2.jpg" },
+			expectedRules: []string{RuleAbsoluteImgUrl, RuleAbsoluteImgUrl},
+		},
+		{
+			name:          "CPC above range",
+			modify:        func(item *models.ShopItem) { item.HeurekaCPC = "100,01" },
+			expectedRules: []string{RuleCpcRange},
+		},
+		{
+			name:          "CPC not a number",
+			modify:        func(item *models.ShopItem) { item.HeurekaCPC = "cheap" },
+			expectedRules: []string{RuleCpcRange},
+		},
+		{
+			name:          "CPC NaN",
+			modify:        func(item *models.ShopItem) { item.HeurekaCPC = "NaN" },
+			expectedRules: []string{RuleCpcRange},
+		},
+		{
+			name:          "CPC in exponent notation",
+			modify:        func(item *models.ShopItem) { item.HeurekaCPC = "1e1" },
+			expectedRules: []string{RuleCpcRange},
+		},
+		{
+			name:          "CPC in hexadecimal notation",
+			modify:        func(item *models.ShopItem) { item.HeurekaCPC = "0x1p-2" },
+			expectedRules: []string{RuleCpcRange},
+		},
+	}
+
+	for _, tc := range testCases {
+		item := mockedValidItem
+		tc.modify(&item)
+
+		violations := validator.Validate(item)
+
+		rules := []string{}
+		for _, violation := range violations {
+			rules = append(rules, violation.Rule)
+		}
+		if !reflect.DeepEqual(rules, tc.expectedRules) {
+			t.Fatalf(
+				"HeurekaValidator.Validate(%s) rules = %v, want %v",
+				tc.name,
+				rules,
+				tc.expectedRules,
+			)
+		}
+	}
+}
+
+func TestValidateCustomCpcRange(t *testing.T) {
+	validator := NewHeurekaValidator(HeurekaValidatorOptions{
+		MinCPC: 1,
+		MaxCPC: 5,
+	})
+	item := mockedValidItem
+	item.HeurekaCPC = "0.5"
+
+	violations := validator.Validate(item)
+
+	if len(violations) != 1 || violations[0].Rule != RuleCpcRange {
+		t.Fatalf(
+			"HeurekaValidator.Validate(item) = %v, want single %s violation",
+			violations,
+			RuleCpcRange,
+		)
+	}
+}
+
+func TestIsValidEan(t *testing.T) {
+	validEans := []string{"4006381333931", "96385074", "036000291452", "10614141000415"}
+	for _, ean := range validEans {
+		if !isValidEan(ean) {
+			t.Fatalf("isValidEan(%q) = false, want true", ean)
+		}
+	}
+
+	invalidEans := []string{"", "4006381333930", "96385075", "40063813339a1", "123"}
+	for _, ean := range invalidEans {
+		if isValidEan(ean) {
+			t.Fatalf("isValidEan(%q) = true, want false", ean)
+		}
+	}
+}
+
+// MOCKED DATA
+
+// Shop item following all Heureka rules
+var mockedValidItem = models.ShopItem{
+	ItemID:            "item_1-A",
+	ProductName:       "Test product",
+	Url:               "https://shop.test/product/1",
+	ImgUrl:            "https://shop.test/img/1.jpg",
+	ImgUrlAlternative: "http://cdn.shop.test/img/1_alt.jpg",
+	PriceVat:          "1299,90",
+	HeurekaCPC:        "12,50",
+	EAN:               "4006381333931",
+}
@@ -0,0 +1,14 @@
+package itemvalidator
+
+import "github.com/MichalMitros/feed-parser/models"
+
+// Validator checking shop items against feed rules.
+// Returns list of broken rules, empty when item is valid
+type ItemValidatorInterface interface {
+	Validate(item models.ShopItem) []models.RuleViolation
+}
+
+// Sink receiving invalid shop items for diagnostics purposes
+type DiagnosticsSinkInterface interface {
+	WriteDiagnostics(diagnostics chan models.ItemDiagnostics) error
+}
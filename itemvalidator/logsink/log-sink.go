@@ -0,0 +1,34 @@
+package logsink
+
+import (
+	"github.com/MichalMitros/feed-parser/models"
+	"go.uber.org/zap"
+)
+
+// Diagnostics sink logging invalid shop items as warnings
+// Implements DiagnosticsSinkInterface
+type LogSink struct{}
+
+// Creates new LogSink instance
+func NewLogSink() *LogSink {
+	return &LogSink{}
+}
+
+// Logs every invalid item from diagnostics channel
+// until the channel is closed
+func (s *LogSink) WriteDiagnostics(
+	diagnostics chan models.ItemDiagnostics,
+) error {
+	defer zap.L().Sync()
+
+	for d := range diagnostics {
+		zap.L().Warn(
+			"Invalid shop item",
+			zap.String("feedUrl", d.FeedUrl),
+			zap.String("itemId", d.Item.ItemID),
+			zap.Any("violations", d.Violations),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package itemvalidator
+
+import (
+	"sort"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Default number of offending item ids stored per rule
+const DefaultSampleSize = 5
+
+// Builder collecting validation results into models.QualityReport.
+// Not safe for concurrent use
+type QualityReportBuilder struct {
+	sampleSize   int
+	validItems   int
+	invalidItems int
+	rules        map[string]*models.RuleReport
+}
+
+// Creates new QualityReportBuilder storing up to sampleSize
+// offending item ids per rule
+func NewQualityReportBuilder(sampleSize int) *QualityReportBuilder {
+	if sampleSize < 0 {
+		sampleSize = 0
+	}
+	return &QualityReportBuilder{
+		sampleSize: sampleSize,
+		rules:      make(map[string]*models.RuleReport),
+	}
+}
+
+// Add validation result of a single item to the report
+func (b *QualityReportBuilder) Add(
+	item models.ShopItem,
+	violations []models.RuleViolation,
+) {
+	if len(violations) == 0 {
+		b.validItems++
+		return
+	}
+	b.invalidItems++
+
+	for _, violation := range violations {
+		rule, ok := b.rules[violation.Rule]
+		if !ok {
+			rule = &models.RuleReport{
+				Rule:          violation.Rule,
+				SampleItemIds: []string{},
+			}
+			b.rules[violation.Rule] = rule
+		}
+		rule.Count++
+		if len(rule.SampleItemIds) < b.sampleSize {
+			rule.SampleItemIds = append(rule.SampleItemIds, item.ItemID)
+		}
+	}
+}
+
+// Returns report with rules sorted by number of violations
+func (b *QualityReportBuilder) Build() *models.QualityReport {
+	report := &models.QualityReport{
+		ValidItems:   b.validItems,
+		InvalidItems: b.invalidItems,
+		Rules:        make([]models.RuleReport, 0, len(b.rules)),
+	}
+	for _, rule := range b.rules {
+		report.Rules = append(report.Rules, *rule)
+	}
+	sort.Slice(report.Rules, func(i, j int) bool {
+		if report.Rules[i].Count != report.Rules[j].Count {
+			return report.Rules[i].Count > report.Rules[j].Count
+		}
+		return report.Rules[i].Rule < report.Rules[j].Rule
+	})
+	return report
+}
@@ -0,0 +1,35 @@
+package itemvalidator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestQualityReportBuilder(t *testing.T) {
+	builder := NewQualityReportBuilder(2)
+
+	builder.Add(models.ShopItem{ItemID: "valid_1"}, nil)
+	builder.Add(models.ShopItem{ItemID: "invalid_1"}, []models.RuleViolation{{Rule: "rule_a"}, {Rule: "rule_b"}})
+	builder.Add(models.ShopItem{ItemID: "invalid_2"}, []models.RuleViolation{{Rule: "rule_b"}})
+	builder.Add(models.ShopItem{ItemID: "invalid_3"}, []models.RuleViolation{{Rule: "rule_b"}})
+
+	report := builder.Build()
+
+	expectedReport := &models.QualityReport{
+		ValidItems:   1,
+		InvalidItems: 3,
+		Rules: []models.RuleReport{
+			{Rule: "rule_b", Count: 3, SampleItemIds: []string{"invalid_1", "invalid_2"}},
+			{Rule: "rule_a", Count: 1, SampleItemIds: []string{"invalid_1"}},
+		},
+	}
+	if !reflect.DeepEqual(report, expectedReport) {
+		t.Fatalf(
+			"QualityReportBuilder.Build() = %+v, want %+v",
+			report,
+			expectedReport,
+		)
+	}
+}
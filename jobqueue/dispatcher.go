@@ -0,0 +1,106 @@
+package jobqueue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Enqueues parse requests for workers and collects statuses
+// of all jobs published by workers
+type Dispatcher struct {
+	broker JobBrokerInterface
+	store  *StatusStore
+}
+
+// Options of Dispatcher
+type DispatcherOptions struct {
+	// Number of jobs with kept statuses,
+	// DefaultStatusStoreCapacity is used when 0
+	StatusStoreCapacity int
+}
+
+// Creates new Dispatcher instance and starts collecting job statuses,
+// collecting stops when the broker is closed
+func NewDispatcher(
+	broker JobBrokerInterface,
+	options DispatcherOptions,
+) (*Dispatcher, error) {
+	statuses, err := broker.ConsumeStatuses()
+	if err != nil {
+		return nil, fmt.Errorf("cannot consume job statuses: %w", err)
+	}
+	d := &Dispatcher{
+		broker: broker,
+		store:  NewStatusStore(options.StatusStoreCapacity),
+	}
+	go func() {
+		for status := range statuses {
+			d.store.Save(status)
+		}
+	}()
+	return d, nil
+}
+
+// Adds job of client parsing feedUrls and registered feeds to the work
+// queue, returns its queued status
+func (d *Dispatcher) Enqueue(client string, feedUrls []string, feedIds []string) (models.JobStatus, error) {
+	defer zap.L().Sync()
+
+	now := time.Now().UTC()
+	job := models.ParseJob{
+		Id:         newJobId(),
+		FeedUrls:   feedUrls,
+		FeedIds:    feedIds,
+		Client:     client,
+		EnqueuedAt: now,
+	}
+	if err := d.broker.PublishJob(job); err != nil {
+		return models.JobStatus{}, fmt.Errorf("cannot enqueue job: %w", err)
+	}
+	enqueuedJobs.Inc()
+
+	status := models.JobStatus{
+		JobId:     job.Id,
+		State:     models.JobQueued,
+		Client:    client,
+		UpdatedAt: now,
+	}
+	d.store.Save(status)
+	// Other instances learn about the job from its status
+	if err := d.broker.PublishStatus(status); err != nil {
+		zap.L().Warn("Cannot publish job status", zap.String("jobId", job.Id), zap.Error(err))
+	}
+	return status, nil
+}
+
+// Returns the latest status of job, false when it's unknown
+func (d *Dispatcher) Status(jobId string) (models.JobStatus, bool) {
+	return d.store.Get(jobId)
+}
+
+// Checks if the broker is reachable
+func (d *Dispatcher) IsConnected() bool {
+	return d.broker.IsConnected()
+}
+
+// Returns random identifier of the job
+func newJobId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Prometheus enqueued jobs
+var (
+	enqueuedJobs = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_jobs_enqueued_total",
+		Help: "The total number of parse jobs added to the work queue",
+	})
+)
@@ -0,0 +1,73 @@
+package jobqueue
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestDispatcherEnqueue(t *testing.T) {
+	broker := NewMockedBroker()
+	dispatcher, err := NewDispatcher(broker, DispatcherOptions{})
+	if err != nil {
+		t.Fatalf("NewDispatcher(), err = %v, want nil", err)
+	}
+
+	status, err := dispatcher.Enqueue("client_a", []string{"url_1"}, []string{"feed_1"})
+	if err != nil {
+		t.Fatalf("Dispatcher.Enqueue(), err = %v, want nil", err)
+	}
+	if status.State != models.JobQueued || len(status.JobId) == 0 || status.Client != "client_a" {
+		t.Fatalf("Dispatcher.Enqueue(), status = %+v, want queued job of client_a", status)
+	}
+	if len(broker.jobs) != 1 || broker.jobs[0].Id != status.JobId || broker.jobs[0].FeedIds[0] != "feed_1" || broker.jobs[0].Client != "client_a" {
+		t.Fatalf("Dispatcher.Enqueue(), published jobs = %+v, want job %v", broker.jobs, status.JobId)
+	}
+	if statuses := broker.Statuses(); len(statuses) != 1 || !reflect.DeepEqual(statuses[0], status) {
+		t.Fatalf("Dispatcher.Enqueue(), published statuses = %+v, want %+v", statuses, status)
+	}
+
+	// Statuses published by workers replace older ones
+	broker.consumed <- models.JobStatus{JobId: status.JobId, State: models.JobRunning, UpdatedAt: status.UpdatedAt.Add(time.Second)}
+	broker.consumed <- models.JobStatus{JobId: status.JobId, State: models.JobQueued, UpdatedAt: status.UpdatedAt}
+	broker.consumed <- models.JobStatus{JobId: "other", State: models.JobDone}
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(5 * time.Millisecond) {
+		if _, ok := dispatcher.Status("other"); ok {
+			break
+		}
+	}
+	if stored, ok := dispatcher.Status(status.JobId); !ok || stored.State != models.JobRunning {
+		t.Fatalf("Dispatcher.Status(), got = %+v, want %v", stored, models.JobRunning)
+	}
+	if _, ok := dispatcher.Status("unknown"); ok {
+		t.Fatalf("Dispatcher.Status(unknown), ok = true, want false")
+	}
+}
+
+func TestDispatcherEnqueueFailure(t *testing.T) {
+	broker := NewMockedBroker()
+	broker.failPublish = true
+	dispatcher, _ := NewDispatcher(broker, DispatcherOptions{})
+
+	if _, err := dispatcher.Enqueue("client_a", []string{"url_1"}, nil); err == nil {
+		t.Fatalf("Dispatcher.Enqueue(), err = nil, want error")
+	}
+	if statuses := broker.Statuses(); len(statuses) != 0 {
+		t.Fatalf("Dispatcher.Enqueue(), published statuses = %+v, want none", statuses)
+	}
+}
+
+func TestStatusStoreCapacity(t *testing.T) {
+	store := NewStatusStore(2)
+	for _, id := range []string{"job_1", "job_2", "job_3"} {
+		store.Save(models.JobStatus{JobId: id, State: models.JobQueued})
+	}
+	if _, ok := store.Get("job_1"); ok {
+		t.Fatalf("StatusStore.Get(job_1), ok = true, want oldest job removed")
+	}
+	if _, ok := store.Get("job_3"); !ok {
+		t.Fatalf("StatusStore.Get(job_3), ok = false, want true")
+	}
+}
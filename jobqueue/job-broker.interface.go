@@ -0,0 +1,44 @@
+package jobqueue
+
+import (
+	"errors"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Transport of parse jobs from API instances to workers
+// and of job statuses back, e.g. rabbitbroker.RabbitBroker
+type JobBrokerInterface interface {
+	// Adds job to the work queue shared by all workers
+	PublishJob(job models.ParseJob) error
+	// Sends job status to all instances consuming statuses
+	PublishStatus(status models.JobStatus) error
+	// Returns jobs delivered to this instance, every job is delivered
+	// to a single worker and redelivered when it's not acknowledged.
+	// Channel is closed when the broker is closed
+	ConsumeJobs() (<-chan Delivery, error)
+	// Returns statuses of all jobs, channel is closed when
+	// the broker is closed
+	ConsumeStatuses() (<-chan models.JobStatus, error)
+	// Takes exclusive lease of key shared by all workers, returns function
+	// releasing it or ErrLeaseTaken when the lease is held by another
+	// worker. Leases of lost connections are released by the broker,
+	// also while the holder still uses them
+	AcquireLease(key string) (func(), error)
+	// Checks if the broker is reachable
+	IsConnected() bool
+}
+
+// Returned by AcquireLease when the lease is held by another worker
+var ErrLeaseTaken = errors.New("lease is held by another worker")
+
+// Job delivered from the work queue
+type Delivery struct {
+	Job models.ParseJob
+	// Job was delivered before without being acknowledged
+	Redelivered bool
+	// Removes job from the work queue
+	Ack func() error
+	// Returns job to the work queue or drops it when requeue is false
+	Nack func(requeue bool) error
+}
@@ -0,0 +1,15 @@
+package jobqueue
+
+import (
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Parser of jobs consumed by Worker, e.g. app.FeedRunner
+type JobRunnerInterface interface {
+	// Returns sources of registered feeds, error for unknown
+	// and disabled feeds
+	Resolve(feedIds []string) ([]feedparser.FeedSource, error)
+	// Parses feeds and stores results of registered feeds
+	Run(sources []feedparser.FeedSource) []models.FeedParsingResult
+}
@@ -0,0 +1,442 @@
+package rabbitbroker
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/jobqueue"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter/rabbitwriter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+)
+
+// Returned when RabbitBroker is already closed
+var ErrBrokerClosed = errors.New("rabbitmq job broker is closed")
+
+// Job broker using durable RabbitMQ work queue consumed with manual
+// acknowledgements and fanout exchange of job statuses. Connection is
+// shared through rabbitwriter.ConnectionManager, which reconnects when
+// it's lost and declares the topology on every connection. Unacknowledged
+// jobs of lost connections are redelivered by RabbitMQ, consumers
+// subscribe again until the broker is closed
+// Implements jobqueue.JobBrokerInterface
+type RabbitBroker struct {
+	manager *rabbitwriter.ConnectionManager
+	options RabbitBrokerOptions
+
+	mutex     sync.Mutex
+	closed    bool
+	done      chan struct{}
+	consumers sync.WaitGroup
+	// Lease queues held by this instance with their release signals
+	leases map[string]chan struct{}
+}
+
+// Connection options and topology of RabbitBroker
+type RabbitBrokerOptions struct {
+	Username string
+	Password string
+	Hostname string
+	// Durable work queue of parse jobs, DefaultQueue is used when empty
+	Queue string
+	// Fanout exchange of job statuses,
+	// DefaultStatusExchange is used when empty
+	StatusExchange string
+	// Maximal number of unacknowledged jobs delivered to this instance,
+	// DefaultPrefetch is used when 0
+	Prefetch int
+	// Maximal time of waiting for broker confirmation and for lost
+	// connection when publishing or taking leases,
+	// DefaultConfirmTimeout is used when 0
+	ConfirmTimeout time.Duration
+	// First delay between reconnection attempts and delay between
+	// subscription attempts of consumers,
+	// DefaultReconnectDelay is used when 0
+	ReconnectDelay time.Duration
+	// Maximal delay between reconnection attempts,
+	// rabbitwriter.DefaultReconnectMaxDelay is used when 0
+	ReconnectMaxDelay time.Duration
+}
+
+// Default values of RabbitBrokerOptions
+const (
+	DefaultQueue          = "parse_jobs"
+	DefaultStatusExchange = "parse_job_status"
+	DefaultPrefetch       = 1
+	DefaultConfirmTimeout = 30 * time.Second
+	DefaultReconnectDelay = 2 * time.Second
+)
+
+// Creates new RabbitBroker instance. Unavailable broker is not an error,
+// connection is retried in background
+func NewRabbitBroker(options RabbitBrokerOptions) *RabbitBroker {
+	return newRabbitBrokerWithDialer(options, rabbitwriter.DialAmqp)
+}
+
+// Creates new RabbitBroker instance connecting with dial
+func newRabbitBrokerWithDialer(
+	options RabbitBrokerOptions,
+	dial rabbitwriter.Dialer,
+) *RabbitBroker {
+	if len(options.Queue) == 0 {
+		options.Queue = DefaultQueue
+	}
+	if len(options.StatusExchange) == 0 {
+		options.StatusExchange = DefaultStatusExchange
+	}
+	if options.Prefetch <= 0 {
+		options.Prefetch = DefaultPrefetch
+	}
+	if options.ConfirmTimeout <= 0 {
+		options.ConfirmTimeout = DefaultConfirmTimeout
+	}
+	if options.ReconnectDelay <= 0 {
+		options.ReconnectDelay = DefaultReconnectDelay
+	}
+	if options.ReconnectMaxDelay <= 0 {
+		options.ReconnectMaxDelay = rabbitwriter.DefaultReconnectMaxDelay
+	}
+
+	b := &RabbitBroker{
+		options: options,
+		done:    make(chan struct{}),
+		leases:  make(map[string]chan struct{}),
+	}
+	// Never fails with ConnectInBackground
+	b.manager, _ = rabbitwriter.NewConnectionManager(
+		"amqp://"+
+			options.Username+":"+
+			options.Password+"@"+
+			options.Hostname+"/",
+		dial,
+		rabbitwriter.ConnectionManagerOptions{
+			Name:                  "jobs",
+			ChannelPoolSize:       rabbitwriter.DefaultChannelPoolSize,
+			ConfirmBufferSize:     1,
+			ReconnectInitialDelay: options.ReconnectDelay,
+			ReconnectMaxDelay:     options.ReconnectMaxDelay,
+			Setup:                 b.declare,
+			ConnectInBackground:   true,
+		},
+	)
+	return b
+}
+
+// Adds job to the work queue as persistent message
+func (b *RabbitBroker) PublishJob(job models.ParseJob) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	// Job queue is declared on every connection, so unroutable
+	// job is an error
+	return b.publish("", b.options.Queue, true, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    job.Id,
+		Body:         body,
+	})
+}
+
+// Sends job status to the status exchange
+func (b *RabbitBroker) PublishStatus(status models.JobStatus) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	// Statuses are dropped when no instance consumes them
+	return b.publish(b.options.StatusExchange, "", false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
+
+// Returns jobs of the work queue, at most Prefetch jobs are delivered
+// and not acknowledged at once. Malformed jobs are dropped
+func (b *RabbitBroker) ConsumeJobs() (<-chan jobqueue.Delivery, error) {
+	defer zap.L().Sync()
+
+	output := make(chan jobqueue.Delivery)
+	subscribe := func(ch rabbitwriter.AmqpChannelInterface) (<-chan amqp.Delivery, error) {
+		if err := ch.Qos(b.options.Prefetch, 0, false); err != nil {
+			return nil, err
+		}
+		return ch.Consume(b.options.Queue, "", false, false, false, false, nil)
+	}
+	handle := func(d amqp.Delivery) bool {
+		var job models.ParseJob
+		if err := json.Unmarshal(d.Body, &job); err != nil || len(job.Id) == 0 {
+			zap.L().Error("Dropping malformed job", zap.String("messageId", d.MessageId), zap.Error(err))
+			d.Nack(false, false)
+			return true
+		}
+		delivery := jobqueue.Delivery{
+			Job:         job,
+			Redelivered: d.Redelivered,
+			Ack: func() error {
+				return d.Ack(false)
+			},
+			Nack: func(requeue bool) error {
+				return d.Nack(false, requeue)
+			},
+		}
+		select {
+		case output <- delivery:
+			return true
+		case <-b.done:
+			return false
+		}
+	}
+	if err := b.startConsumer("jobs", subscribe, handle, func() { close(output) }); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// Returns statuses of all jobs received through exclusive queue
+// bound to the status exchange
+func (b *RabbitBroker) ConsumeStatuses() (<-chan models.JobStatus, error) {
+	defer zap.L().Sync()
+
+	output := make(chan models.JobStatus)
+	subscribe := func(ch rabbitwriter.AmqpChannelInterface) (<-chan amqp.Delivery, error) {
+		queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := ch.QueueBind(queue.Name, "", b.options.StatusExchange, false, nil); err != nil {
+			return nil, err
+		}
+		return ch.Consume(queue.Name, "", true, true, false, false, nil)
+	}
+	handle := func(d amqp.Delivery) bool {
+		var status models.JobStatus
+		if err := json.Unmarshal(d.Body, &status); err != nil {
+			zap.L().Error("Dropping malformed job status", zap.Error(err))
+			return true
+		}
+		select {
+		case output <- status:
+			return true
+		case <-b.done:
+			return false
+		}
+	}
+	if err := b.startConsumer("job statuses", subscribe, handle, func() { close(output) }); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// Takes lease of key by declaring exclusive lease queue. RabbitMQ lets
+// only a single connection own exclusive queue and deletes it when the
+// connection is lost, so leases of crashed workers expire with them.
+// Lease is lost together with the connection of this instance, even while
+// the feed is still being parsed, so it only makes parallel parsing of
+// a feed unlikely. Lost leases are logged and counted
+func (b *RabbitBroker) AcquireLease(key string) (func(), error) {
+	name := fmt.Sprintf("%s.lease.%x", b.options.Queue, sha1.Sum([]byte(key)))
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+	// Exclusive queue can be declared again by its own connection
+	if _, ok := b.leases[name]; ok {
+		return nil, jobqueue.ErrLeaseTaken
+	}
+	ch, err := b.manager.Channel(b.options.ConfirmTimeout)
+	if err != nil {
+		return nil, err
+	}
+	closes := ch.NotifyClose(make(chan *amqp.Error, 1))
+	if _, err := ch.QueueDeclare(name, false, false, true, false, nil); err != nil {
+		ch.Close()
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.ResourceLocked {
+			return nil, jobqueue.ErrLeaseTaken
+		}
+		return nil, fmt.Errorf("cannot declare lease queue %s: %w", name, err)
+	}
+	released := make(chan struct{})
+	b.leases[name] = released
+	go b.watchLease(key, name, closes, released)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(released)
+			ch.QueueDelete(name, false, false, false)
+			ch.Close()
+			b.forgetLease(name, released)
+		})
+	}, nil
+}
+
+// Watches channel of lease until it's released, lease of closed channel
+// is forgotten, so it can be taken again after reconnection
+func (b *RabbitBroker) watchLease(
+	key string,
+	name string,
+	closes chan *amqp.Error,
+	released chan struct{},
+) {
+	defer zap.L().Sync()
+
+	err := <-closes
+	select {
+	case <-released:
+		return
+	case <-b.done:
+		return
+	default:
+	}
+	zap.L().Warn(
+		"Feed lease lost with RabbitMQ channel, the feed may be parsed by another worker at once",
+		zap.String("feedUrl", key),
+		zap.Error(err),
+	)
+	lostLeases.Inc()
+	b.forgetLease(name, released)
+}
+
+// Removes lease queue held by this instance unless it was taken again
+func (b *RabbitBroker) forgetLease(name string, released chan struct{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.leases[name] == released {
+		delete(b.leases, name)
+	}
+}
+
+// Checks if connection is currently established
+func (b *RabbitBroker) IsConnected() bool {
+	return b.manager.IsConnected()
+}
+
+// Closes the connection and waits for consumers to stop,
+// unacknowledged jobs are redelivered to other workers
+func (b *RabbitBroker) Close() error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	b.mutex.Unlock()
+
+	err := b.manager.Close()
+	b.consumers.Wait()
+	return err
+}
+
+// Publishes message and waits for its confirmation
+func (b *RabbitBroker) publish(
+	exchange string,
+	key string,
+	mandatory bool,
+	msg amqp.Publishing,
+) error {
+	err := b.manager.Publish(exchange, key, mandatory, msg, b.options.ConfirmTimeout)
+	if errors.Is(err, rabbitwriter.ErrManagerClosed) {
+		return ErrBrokerClosed
+	}
+	return err
+}
+
+// Declares the work queue and the status exchange
+func (b *RabbitBroker) declare(connection rabbitwriter.AmqpConnectionInterface) error {
+	ch, err := connection.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if _, err := ch.QueueDeclare(b.options.Queue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("cannot declare job queue %s: %w", b.options.Queue, err)
+	}
+	if err := ch.ExchangeDeclare(b.options.StatusExchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("cannot declare status exchange %s: %w", b.options.StatusExchange, err)
+	}
+	return nil
+}
+
+// Starts consuming deliveries with handle until it returns false or
+// the broker is closed, subscription is renewed after connection loss.
+// finish is called when consuming stops
+func (b *RabbitBroker) startConsumer(
+	name string,
+	subscribe func(ch rabbitwriter.AmqpChannelInterface) (<-chan amqp.Delivery, error),
+	handle func(d amqp.Delivery) bool,
+	finish func(),
+) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+
+	b.consumers.Add(1)
+	go func() {
+		defer b.consumers.Done()
+		defer finish()
+		defer zap.L().Sync()
+
+		for {
+			ch, deliveries, err := b.subscribe(subscribe)
+			if err != nil {
+				if errors.Is(err, rabbitwriter.ErrManagerClosed) {
+					return
+				}
+				zap.L().Warn(fmt.Sprintf("Cannot consume %s from RabbitMQ", name), zap.Error(err))
+			} else {
+				for d := range deliveries {
+					if !handle(d) {
+						ch.Close()
+						return
+					}
+				}
+				ch.Close()
+			}
+
+			select {
+			case <-b.done:
+				return
+			case <-time.After(b.options.ReconnectDelay):
+			}
+		}
+	}()
+	return nil
+}
+
+// Opens channel and subscribes to deliveries
+func (b *RabbitBroker) subscribe(
+	subscribe func(ch rabbitwriter.AmqpChannelInterface) (<-chan amqp.Delivery, error),
+) (rabbitwriter.AmqpChannelInterface, <-chan amqp.Delivery, error) {
+	ch, err := b.manager.Channel(b.options.ReconnectDelay)
+	if err != nil {
+		return nil, nil, err
+	}
+	deliveries, err := subscribe(ch)
+	if err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+	return ch, deliveries, nil
+}
+
+// Prometheus lost leases
+var (
+	lostLeases = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_job_leases_lost_total",
+		Help: "The total number of feed leases lost with RabbitMQ connection before they were released",
+	})
+)
@@ -0,0 +1,666 @@
+package rabbitbroker
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/jobqueue"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter/rabbitwriter"
+	"github.com/streadway/amqp"
+)
+
+func TestRabbitBrokerJobs(t *testing.T) {
+	mockedBroker := newMockedBroker()
+	broker := newTestRabbitBroker(mockedBroker, 1)
+	defer broker.Close()
+
+	if err := broker.PublishJob(mockedJob); err != nil {
+		t.Fatalf("RabbitBroker.PublishJob(), err = %v, want nil", err)
+	}
+	deliveries, err := broker.ConsumeJobs()
+	if err != nil {
+		t.Fatalf("RabbitBroker.ConsumeJobs(), err = %v, want nil", err)
+	}
+	delivery := receiveDelivery(t, deliveries)
+	if !reflect.DeepEqual(delivery.Job, mockedJob) || delivery.Redelivered {
+		t.Fatalf("RabbitBroker.ConsumeJobs(), got = %+v, want %+v", delivery, mockedJob)
+	}
+
+	if err := delivery.Ack(); err != nil {
+		t.Fatalf("Delivery.Ack(), err = %v, want nil", err)
+	}
+	if count := mockedBroker.unackedCount(); count != 0 {
+		t.Fatalf("Delivery.Ack(), unacked jobs = %v, want 0", count)
+	}
+}
+
+func TestRabbitBrokerPrefetch(t *testing.T) {
+	broker := newTestRabbitBroker(newMockedBroker(), 1)
+	defer broker.Close()
+
+	for i := 0; i < 2; i++ {
+		job := mockedJob
+		job.Id = fmt.Sprintf("job_%d", i)
+		if err := broker.PublishJob(job); err != nil {
+			t.Fatalf("RabbitBroker.PublishJob(), err = %v, want nil", err)
+		}
+	}
+	deliveries, _ := broker.ConsumeJobs()
+	first := receiveDelivery(t, deliveries)
+
+	// Next job isn't delivered until the first one is acknowledged
+	select {
+	case delivery := <-deliveries:
+		t.Fatalf("RabbitBroker.ConsumeJobs(), got %v over prefetch, want no delivery", delivery.Job.Id)
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Ack()
+	if second := receiveDelivery(t, deliveries); second.Job.Id != "job_1" {
+		t.Fatalf("RabbitBroker.ConsumeJobs(), got = %v, want job_1", second.Job.Id)
+	}
+}
+
+func TestRabbitBrokerRedeliversAfterConnectionLoss(t *testing.T) {
+	mockedBroker := newMockedBroker()
+	broker := newTestRabbitBroker(mockedBroker, 1)
+	defer broker.Close()
+
+	broker.PublishJob(mockedJob)
+	deliveries, _ := broker.ConsumeJobs()
+	lost := receiveDelivery(t, deliveries)
+
+	// Job not acknowledged before connection loss is delivered again
+	mockedBroker.dropConnection()
+	if err := lost.Ack(); err == nil {
+		t.Fatalf("Delivery.Ack() after connection loss, err = nil, want error")
+	}
+	delivery := receiveDelivery(t, deliveries)
+	if delivery.Job.Id != mockedJob.Id || !delivery.Redelivered {
+		t.Fatalf("RabbitBroker.ConsumeJobs(), got = %+v, want redelivered %v", delivery, mockedJob.Id)
+	}
+	if err := delivery.Ack(); err != nil {
+		t.Fatalf("Delivery.Ack(), err = %v, want nil", err)
+	}
+}
+
+func TestRabbitBrokerStatuses(t *testing.T) {
+	mockedBroker := newMockedBroker()
+	api := newTestRabbitBroker(mockedBroker, 1)
+	defer api.Close()
+	worker := newTestRabbitBroker(mockedBroker, 1)
+	defer worker.Close()
+
+	// Every instance receives all statuses
+	apiStatuses, _ := api.ConsumeStatuses()
+	workerStatuses, _ := worker.ConsumeStatuses()
+	mockedBroker.waitForBindings(t, DefaultStatusExchange, 2)
+
+	status := models.JobStatus{JobId: mockedJob.Id, State: models.JobDone, UpdatedAt: mockedJob.EnqueuedAt}
+	if err := worker.PublishStatus(status); err != nil {
+		t.Fatalf("RabbitBroker.PublishStatus(), err = %v, want nil", err)
+	}
+	for _, statuses := range []<-chan models.JobStatus{apiStatuses, workerStatuses} {
+		select {
+		case received := <-statuses:
+			if !reflect.DeepEqual(received, status) {
+				t.Fatalf("RabbitBroker.ConsumeStatuses(), got = %+v, want %+v", received, status)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("RabbitBroker.ConsumeStatuses(), no status received")
+		}
+	}
+}
+
+func TestRabbitBrokerUnavailable(t *testing.T) {
+	mockedBroker := newMockedBroker()
+	mockedBroker.setUnavailable(true)
+	broker := newTestRabbitBroker(mockedBroker, 1)
+	defer broker.Close()
+
+	if broker.IsConnected() {
+		t.Fatalf("RabbitBroker.IsConnected(), got = true, want false")
+	}
+	if err := broker.PublishJob(mockedJob); err == nil {
+		t.Fatalf("RabbitBroker.PublishJob(), err = nil, want error")
+	}
+
+	// Connection is restored in background
+	mockedBroker.setUnavailable(false)
+	if err := broker.PublishJob(mockedJob); err != nil {
+		t.Fatalf("RabbitBroker.PublishJob(), err = %v, want nil", err)
+	}
+	if !broker.IsConnected() {
+		t.Fatalf("RabbitBroker.IsConnected(), got = false, want true")
+	}
+}
+
+func TestRabbitBrokerClose(t *testing.T) {
+	broker := newTestRabbitBroker(newMockedBroker(), 1)
+	deliveries, _ := broker.ConsumeJobs()
+	statuses, _ := broker.ConsumeStatuses()
+
+	if err := broker.Close(); err != nil {
+		t.Fatalf("RabbitBroker.Close(), err = %v, want nil", err)
+	}
+	if _, ok := <-deliveries; ok {
+		t.Fatalf("RabbitBroker.Close(), jobs channel is open, want closed")
+	}
+	if _, ok := <-statuses; ok {
+		t.Fatalf("RabbitBroker.Close(), statuses channel is open, want closed")
+	}
+	if err := broker.PublishJob(mockedJob); !errors.Is(err, ErrBrokerClosed) {
+		t.Fatalf("RabbitBroker.PublishJob() after Close(), err = %v, want %v", err, ErrBrokerClosed)
+	}
+}
+
+func TestRabbitBrokerLeases(t *testing.T) {
+	mockedBroker := newMockedBroker()
+	first := newTestRabbitBroker(mockedBroker, 1)
+	defer first.Close()
+	second := newTestRabbitBroker(mockedBroker, 1)
+	defer second.Close()
+
+	release, err := first.AcquireLease("https://example.com/feed.xml")
+	if err != nil {
+		t.Fatalf("RabbitBroker.AcquireLease(), err = %v, want nil", err)
+	}
+	// Lease is held by first instance for all its workers
+	for _, broker := range []*RabbitBroker{first, second} {
+		if _, err := broker.AcquireLease("https://example.com/feed.xml"); !errors.Is(err, jobqueue.ErrLeaseTaken) {
+			t.Fatalf("RabbitBroker.AcquireLease() of held lease, err = %v, want %v", err, jobqueue.ErrLeaseTaken)
+		}
+	}
+	if _, err := second.AcquireLease("https://example.com/other.xml"); err != nil {
+		t.Fatalf("RabbitBroker.AcquireLease() of other key, err = %v, want nil", err)
+	}
+
+	release()
+	releaseSecond, err := second.AcquireLease("https://example.com/feed.xml")
+	if err != nil {
+		t.Fatalf("RabbitBroker.AcquireLease() after release, err = %v, want nil", err)
+	}
+	releaseSecond()
+
+	// Leases of lost connections are released by the broker
+	first.AcquireLease("https://example.com/feed.xml")
+	mockedBroker.dropConnection()
+	releaseSecond, err = second.AcquireLease("https://example.com/feed.xml")
+	if err != nil {
+		t.Fatalf("RabbitBroker.AcquireLease() after connection loss, err = %v, want nil", err)
+	}
+	if _, err := first.AcquireLease("https://example.com/feed.xml"); !errors.Is(err, jobqueue.ErrLeaseTaken) {
+		t.Fatalf("RabbitBroker.AcquireLease() of lost lease, err = %v, want %v", err, jobqueue.ErrLeaseTaken)
+	}
+
+	// Lost lease is forgotten, so it can be taken again after reconnection
+	releaseSecond()
+	if _, err := first.AcquireLease("https://example.com/feed.xml"); err != nil {
+		t.Fatalf("RabbitBroker.AcquireLease() after lost lease is released, err = %v, want nil", err)
+	}
+}
+
+// Creates broker connected to mocked broker with short test delays
+func newTestRabbitBroker(mockedBroker *MockedBroker, prefetch int) *RabbitBroker {
+	return newRabbitBrokerWithDialer(
+		RabbitBrokerOptions{
+			Prefetch:          prefetch,
+			ConfirmTimeout:    time.Second,
+			ReconnectDelay:    10 * time.Millisecond,
+			ReconnectMaxDelay: 50 * time.Millisecond,
+		},
+		mockedBroker.dial,
+	)
+}
+
+// Returns next delivery or fails the test after timeout
+func receiveDelivery(t *testing.T, deliveries <-chan jobqueue.Delivery) jobqueue.Delivery {
+	t.Helper()
+	select {
+	case delivery, ok := <-deliveries:
+		if !ok {
+			t.Fatalf("RabbitBroker.ConsumeJobs(), channel closed, want delivery")
+		}
+		return delivery
+	case <-time.After(time.Second):
+		t.Fatalf("RabbitBroker.ConsumeJobs(), no delivery received")
+	}
+	return jobqueue.Delivery{}
+}
+
+// MOCKED BROKER
+
+// In-process stand-in of RabbitMQ broker
+type MockedBroker struct {
+	mutex    sync.Mutex
+	queues   map[string]chan amqp.Delivery
+	bindings map[string][]string
+	// Connections owning exclusive queues
+	exclusive   map[string]*MockedConnection
+	connections []*MockedConnection
+	unacked     int
+	queuesCount int
+	unavailable bool
+}
+
+func newMockedBroker() *MockedBroker {
+	return &MockedBroker{
+		queues:    make(map[string]chan amqp.Delivery),
+		bindings:  make(map[string][]string),
+		exclusive: make(map[string]*MockedConnection),
+	}
+}
+
+func (b *MockedBroker) dial(url string) (rabbitwriter.AmqpConnectionInterface, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.unavailable {
+		return nil, fmt.Errorf("connection refused")
+	}
+	connection := &MockedConnection{broker: b}
+	b.connections = append(b.connections, connection)
+	return connection, nil
+}
+
+func (b *MockedBroker) setUnavailable(unavailable bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.unavailable = unavailable
+}
+
+// Closes all connections like restarted broker
+func (b *MockedBroker) dropConnection() {
+	b.mutex.Lock()
+	connections := b.connections
+	b.connections = nil
+	b.mutex.Unlock()
+	for _, connection := range connections {
+		connection.shutdown(amqp.ErrClosed)
+	}
+}
+
+// Returns queue with name, creates it when it doesn't exist
+func (b *MockedBroker) queue(name string) chan amqp.Delivery {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	queue, ok := b.queues[name]
+	if !ok {
+		queue = make(chan amqp.Delivery, 100)
+		b.queues[name] = queue
+	}
+	return queue
+}
+
+func (b *MockedBroker) unackedCount() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.unacked
+}
+
+// Waits until count queues are bound to exchange
+func (b *MockedBroker) waitForBindings(t *testing.T, exchange string, count int) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(5 * time.Millisecond) {
+		b.mutex.Lock()
+		bound := len(b.bindings[exchange])
+		b.mutex.Unlock()
+		if bound >= count {
+			return
+		}
+	}
+	t.Fatalf("MockedBroker, exchange %s has no %d bindings", exchange, count)
+}
+
+type MockedConnection struct {
+	broker   *MockedBroker
+	mutex    sync.Mutex
+	channels []*MockedChannel
+	closes   []chan *amqp.Error
+	closed   bool
+}
+
+func (c *MockedConnection) Channel() (rabbitwriter.AmqpChannelInterface, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &MockedChannel{
+		broker:     c.broker,
+		connection: c,
+		closed:     make(chan struct{}),
+		unacked:    make(map[uint64]amqp.Delivery),
+	}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *MockedConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closes = append(c.closes, receiver)
+	return receiver
+}
+
+func (c *MockedConnection) NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking {
+	return receiver
+}
+
+func (c *MockedConnection) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+// Closes connection with its channels, notifies listeners about err
+func (c *MockedConnection) shutdown(err *amqp.Error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return
+	}
+	c.closed = true
+	channels := c.channels
+	closes := c.closes
+	c.mutex.Unlock()
+
+	for _, ch := range channels {
+		ch.shutdown(err)
+	}
+	// Exclusive queues are deleted with their connection
+	c.broker.mutex.Lock()
+	for name, owner := range c.broker.exclusive {
+		if owner == c {
+			delete(c.broker.exclusive, name)
+			delete(c.broker.queues, name)
+		}
+	}
+	c.broker.mutex.Unlock()
+	for _, receiver := range closes {
+		if err != nil {
+			receiver <- err
+		}
+		close(receiver)
+	}
+}
+
+type MockedChannel struct {
+	broker     *MockedBroker
+	connection *MockedConnection
+	mutex      sync.Mutex
+	prefetch   int
+	confirms   chan amqp.Confirmation
+	closes     []chan *amqp.Error
+	// Number of published messages in confirm mode
+	published uint64
+	nextTag   uint64
+	unacked   map[uint64]amqp.Delivery
+	// Released by acknowledgements, limits unacknowledged deliveries
+	slots     chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	consumers sync.WaitGroup
+}
+
+func (c *MockedChannel) QueueDeclare(
+	name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table,
+) (amqp.Queue, error) {
+	if len(name) == 0 {
+		c.broker.mutex.Lock()
+		c.broker.queuesCount++
+		name = fmt.Sprintf("amq.gen-%d", c.broker.queuesCount)
+		c.broker.mutex.Unlock()
+	}
+	if exclusive {
+		c.broker.mutex.Lock()
+		owner, ok := c.broker.exclusive[name]
+		if ok && owner != c.connection {
+			c.broker.mutex.Unlock()
+			return amqp.Queue{}, &amqp.Error{Code: amqp.ResourceLocked, Reason: "RESOURCE_LOCKED"}
+		}
+		c.broker.exclusive[name] = c.connection
+		c.broker.mutex.Unlock()
+	}
+	c.broker.queue(name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *MockedChannel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+	delete(c.broker.exclusive, name)
+	delete(c.broker.queues, name)
+	return 0, nil
+}
+
+func (c *MockedChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+	c.broker.bindings[exchange] = append(c.broker.bindings[exchange], name)
+	return nil
+}
+
+func (c *MockedChannel) ExchangeDeclare(
+	name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table,
+) error {
+	return nil
+}
+
+func (c *MockedChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.prefetch = prefetchCount
+	return nil
+}
+
+func (c *MockedChannel) Consume(
+	queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table,
+) (<-chan amqp.Delivery, error) {
+	c.mutex.Lock()
+	if !autoAck && c.prefetch > 0 {
+		c.slots = make(chan struct{}, c.prefetch)
+	}
+	slots := c.slots
+	c.mutex.Unlock()
+
+	messages := c.broker.queue(queue)
+	deliveries := make(chan amqp.Delivery)
+	c.consumers.Add(1)
+	go func() {
+		defer c.consumers.Done()
+		defer close(deliveries)
+		for {
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+				case <-c.closed:
+					return
+				}
+			}
+			var message amqp.Delivery
+			select {
+			case message = <-messages:
+			case <-c.closed:
+				return
+			}
+
+			c.mutex.Lock()
+			c.nextTag++
+			message.DeliveryTag = c.nextTag
+			message.Acknowledger = c
+			if !autoAck {
+				c.unacked[message.DeliveryTag] = message
+				c.broker.mutex.Lock()
+				c.broker.unacked++
+				c.broker.mutex.Unlock()
+			}
+			c.mutex.Unlock()
+
+			select {
+			case deliveries <- message:
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+func (c *MockedChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (c *MockedChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.confirms = confirm
+	return confirm
+}
+
+// Unroutable messages are not returned by mocked broker
+func (c *MockedChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	return returns
+}
+
+func (c *MockedChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	select {
+	case <-c.closed:
+		close(receiver)
+	default:
+		c.closes = append(c.closes, receiver)
+	}
+	return receiver
+}
+
+func (c *MockedChannel) Publish(
+	exchange, key string, mandatory, immediate bool, msg amqp.Publishing,
+) error {
+	select {
+	case <-c.closed:
+		return amqp.ErrClosed
+	default:
+	}
+
+	queues := []string{key}
+	if len(exchange) > 0 {
+		c.broker.mutex.Lock()
+		queues = append([]string{}, c.broker.bindings[exchange]...)
+		c.broker.mutex.Unlock()
+	}
+	for _, name := range queues {
+		c.broker.queue(name) <- amqp.Delivery{
+			// Queue of the message used for requeueing
+			RoutingKey:   name,
+			ContentType:  msg.ContentType,
+			DeliveryMode: msg.DeliveryMode,
+			MessageId:    msg.MessageId,
+			Body:         msg.Body,
+		}
+	}
+
+	c.mutex.Lock()
+	c.published++
+	tag := c.published
+	confirms := c.confirms
+	c.mutex.Unlock()
+	if confirms != nil {
+		go func() {
+			confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: true}
+		}()
+	}
+	return nil
+}
+
+func (c *MockedChannel) Ack(tag uint64, multiple bool) error {
+	_, err := c.settle(tag)
+	return err
+}
+
+func (c *MockedChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	message, err := c.settle(tag)
+	if err == nil && requeue {
+		c.requeue(message)
+	}
+	return err
+}
+
+func (c *MockedChannel) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
+
+// Removes unacknowledged delivery and frees its prefetch slot
+func (c *MockedChannel) settle(tag uint64) (amqp.Delivery, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	select {
+	case <-c.closed:
+		return amqp.Delivery{}, amqp.ErrClosed
+	default:
+	}
+	message, ok := c.unacked[tag]
+	if !ok {
+		return amqp.Delivery{}, fmt.Errorf("unknown delivery tag %d", tag)
+	}
+	delete(c.unacked, tag)
+	c.broker.mutex.Lock()
+	c.broker.unacked--
+	c.broker.mutex.Unlock()
+	if c.slots != nil {
+		<-c.slots
+	}
+	return message, nil
+}
+
+// Returns delivered message to its queue
+func (c *MockedChannel) requeue(message amqp.Delivery) {
+	message.Redelivered = true
+	message.Acknowledger = nil
+	c.broker.queue(message.RoutingKey) <- message
+}
+
+func (c *MockedChannel) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+// Closes channel and notifies listeners about err,
+// unacknowledged deliveries are requeued
+func (c *MockedChannel) shutdown(err *amqp.Error) {
+	c.closeOnce.Do(func() {
+		c.mutex.Lock()
+		close(c.closed)
+		closes := c.closes
+		c.mutex.Unlock()
+		c.consumers.Wait()
+
+		c.mutex.Lock()
+		unacked := c.unacked
+		c.unacked = make(map[uint64]amqp.Delivery)
+		c.mutex.Unlock()
+		c.broker.mutex.Lock()
+		c.broker.unacked -= len(unacked)
+		c.broker.mutex.Unlock()
+		for _, message := range unacked {
+			c.requeue(message)
+		}
+		for _, receiver := range closes {
+			if err != nil {
+				receiver <- err
+			}
+			close(receiver)
+		}
+	})
+}
+
+// MOCKED DATA
+
+var mockedJob = models.ParseJob{
+	Id:         "job_0",
+	FeedUrls:   []string{"https://example.com/feed.xml"},
+	FeedIds:    []string{"feed_1"},
+	EnqueuedAt: time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+}
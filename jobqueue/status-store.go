@@ -0,0 +1,63 @@
+package jobqueue
+
+import (
+	"sync"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// In-memory store of the latest statuses of jobs, the oldest jobs
+// are removed when the store is full
+type StatusStore struct {
+	capacity int
+
+	mutex    sync.RWMutex
+	statuses map[string]models.JobStatus
+	// Job ids in order of their first status
+	order []string
+}
+
+// Default capacity of StatusStore
+const DefaultStatusStoreCapacity = 10000
+
+// Creates new StatusStore instance keeping statuses of at most
+// capacity jobs, DefaultStatusStoreCapacity is used when 0
+func NewStatusStore(capacity int) *StatusStore {
+	if capacity <= 0 {
+		capacity = DefaultStatusStoreCapacity
+	}
+	return &StatusStore{
+		capacity: capacity,
+		statuses: make(map[string]models.JobStatus),
+	}
+}
+
+// Stores status unless newer status of the same job is already stored
+func (s *StatusStore) Save(status models.JobStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.statuses[status.JobId]
+	if ok {
+		if stored.UpdatedAt.After(status.UpdatedAt) {
+			return
+		}
+		s.statuses[status.JobId] = status
+		return
+	}
+
+	if len(s.order) >= s.capacity {
+		delete(s.statuses, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.statuses[status.JobId] = status
+	s.order = append(s.order, status.JobId)
+}
+
+// Returns the latest status of job, false when it's unknown
+func (s *StatusStore) Get(jobId string) (models.JobStatus, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	status, ok := s.statuses[jobId]
+	return status, ok
+}
@@ -0,0 +1,221 @@
+package jobqueue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Consumer of parse jobs from the work queue. Jobs are acknowledged
+// after they are parsed, so jobs of crashed workers are redelivered
+// to other workers
+type Worker struct {
+	broker      JobBrokerInterface
+	runner      JobRunnerInterface
+	workerId    string
+	concurrency int
+	// Number of jobs being parsed, accessed atomically
+	busy int32
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// Options of Worker
+type WorkerOptions struct {
+	// Identifier reported in job statuses, host name is used when empty
+	WorkerId string
+	// Number of jobs parsed at once, DefaultConcurrency is used when 0
+	Concurrency int
+}
+
+// Default values of WorkerOptions
+const DefaultConcurrency = 1
+
+// Creates new Worker instance and starts consuming jobs
+func NewWorker(
+	broker JobBrokerInterface,
+	runner JobRunnerInterface,
+	options WorkerOptions,
+) (*Worker, error) {
+	if len(options.WorkerId) == 0 {
+		options.WorkerId, _ = os.Hostname()
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultConcurrency
+	}
+	deliveries, err := broker.ConsumeJobs()
+	if err != nil {
+		return nil, fmt.Errorf("cannot consume jobs: %w", err)
+	}
+
+	w := &Worker{
+		broker:      broker,
+		runner:      runner,
+		workerId:    options.WorkerId,
+		concurrency: options.Concurrency,
+		stop:        make(chan struct{}),
+	}
+	w.stopped.Add(options.Concurrency)
+	for i := 0; i < options.Concurrency; i++ {
+		go w.consume(deliveries)
+	}
+	return w, nil
+}
+
+// Returns number of jobs being parsed and number of jobs
+// which can be parsed at once
+func (w *Worker) Load() (int, int) {
+	return int(atomic.LoadInt32(&w.busy)), w.concurrency
+}
+
+// Stops taking new jobs and waits for jobs in progress. Jobs delivered
+// but not started are redelivered when the broker is closed
+func (w *Worker) Close() error {
+	close(w.stop)
+	w.stopped.Wait()
+	return nil
+}
+
+// Processes deliveries until Close or the broker is closed
+func (w *Worker) consume(deliveries <-chan Delivery) {
+	defer w.stopped.Done()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return
+			}
+			w.process(delivery)
+		}
+	}
+}
+
+// Parses feeds of delivered job, reports its statuses and acknowledges it
+func (w *Worker) process(delivery Delivery) {
+	defer zap.L().Sync()
+
+	atomic.AddInt32(&w.busy, 1)
+	defer atomic.AddInt32(&w.busy, -1)
+
+	job := delivery.Job
+	status := models.JobStatus{
+		JobId:       job.Id,
+		State:       models.JobRunning,
+		Client:      job.Client,
+		WorkerId:    w.workerId,
+		Redelivered: delivery.Redelivered,
+	}
+	if delivery.Redelivered {
+		zap.L().Warn("Parsing redelivered job", zap.String("jobId", job.Id))
+	}
+	w.publish(status)
+
+	// Unknown or disabled feeds fail the job, it isn't retried
+	registered, err := w.runner.Resolve(job.FeedIds)
+	if err != nil {
+		status.State = models.JobFailed
+		status.Error = err.Error()
+	} else {
+		sources := make([]feedparser.FeedSource, 0, len(job.FeedUrls)+len(registered))
+		for _, url := range job.FeedUrls {
+			sources = append(sources, feedparser.FeedSource{Url: url})
+		}
+		status.Statuses, err = w.run(append(sources, registered...))
+		if err != nil {
+			// Job is parsed again by any worker once the broker recovers
+			zap.L().Warn("Cannot take feed leases, requeueing job", zap.String("jobId", job.Id), zap.Error(err))
+			status.State = models.JobQueued
+			status.Statuses = nil
+			w.publish(status)
+			delivery.Nack(true)
+			return
+		}
+		status.State = models.JobDone
+	}
+	w.publish(status)
+	processedJobs.WithLabelValues(string(status.State)).Inc()
+
+	if err := delivery.Ack(); err != nil {
+		zap.L().Warn(
+			"Cannot acknowledge job, it may be parsed again",
+			zap.String("jobId", job.Id),
+			zap.Error(err),
+		)
+	}
+}
+
+// Parses feeds with leases of their urls taken, so a feed is rarely
+// parsed by many workers at once. Parsing isn't stopped when a lease is
+// lost with the broker connection, so feeds are parsed at least once,
+// not exactly once. Feeds leased by other workers are not parsed, their
+// results have ParsingInProgress status. Returns error when leases
+// can't be taken
+func (w *Worker) run(sources []feedparser.FeedSource) ([]models.FeedParsingResult, error) {
+	results := make([]models.FeedParsingResult, len(sources))
+	leased := []feedparser.FeedSource{}
+	leasedIdx := []int{}
+	releases := []func(){}
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+
+	for idx, source := range sources {
+		release, err := w.broker.AcquireLease(source.Url)
+		if errors.Is(err, ErrLeaseTaken) {
+			zap.L().Info("Feed is already being parsed by another worker", zap.String("feedUrl", source.Url))
+			results[idx] = models.FeedParsingResult{
+				FeedUrl: source.Url,
+				FeedId:  source.FeedId,
+				Status:  models.ParsingInProgress,
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		releases = append(releases, release)
+		leased = append(leased, source)
+		leasedIdx = append(leasedIdx, idx)
+	}
+
+	for idx, result := range w.runner.Run(leased) {
+		results[leasedIdx[idx]] = result
+	}
+	return results, nil
+}
+
+// Publishes job status, failures are only logged
+func (w *Worker) publish(status models.JobStatus) {
+	status.UpdatedAt = time.Now().UTC()
+	if err := w.broker.PublishStatus(status); err != nil {
+		zap.L().Warn(
+			"Cannot publish job status",
+			zap.String("jobId", status.JobId),
+			zap.String("state", string(status.State)),
+			zap.Error(err),
+		)
+	}
+}
+
+// Prometheus processed jobs
+var (
+	processedJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_jobs_processed_total",
+		Help: "The total number of parse jobs processed by this worker",
+	}, []string{"state"})
+)
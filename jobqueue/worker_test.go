@@ -0,0 +1,293 @@
+package jobqueue
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestWorker(t *testing.T) {
+	broker := NewMockedBroker()
+	worker, err := NewWorker(broker, &MockedRunner{}, WorkerOptions{WorkerId: "worker_1"})
+	if err != nil {
+		t.Fatalf("NewWorker(), err = %v, want nil", err)
+	}
+
+	done := broker.deliver(models.ParseJob{Id: "job_1", FeedUrls: []string{"url_1"}, FeedIds: []string{"feed_1"}, Client: "client_a"}, false)
+	failed := broker.deliver(models.ParseJob{Id: "job_2", FeedIds: []string{"unknown"}}, true)
+	waitForAcks(t, done, failed)
+	worker.Close()
+
+	// Running and final status of every job is published
+	statuses := broker.Statuses()
+	if len(statuses) != 4 {
+		t.Fatalf("NewWorker(), published statuses = %v, want 4", len(statuses))
+	}
+	for idx, state := range []models.JobState{models.JobRunning, models.JobDone} {
+		if status := statuses[idx]; status.JobId != "job_1" || status.State != state || status.WorkerId != "worker_1" || status.Client != "client_a" {
+			t.Fatalf("NewWorker(), status = %+v, want %v of job_1 of client_a", status, state)
+		}
+	}
+	if results := statuses[1].Statuses; len(results) != 2 || results[0].FeedUrl != "url_1" || results[1].FeedId != "feed_1" {
+		t.Fatalf("NewWorker(), results = %+v, want url and registered feed", results)
+	}
+
+	// Job with unknown feeds fails and is not retried
+	if status := statuses[3]; status.State != models.JobFailed || len(status.Error) == 0 || !status.Redelivered {
+		t.Fatalf("NewWorker(), status = %+v, want failed redelivered job", status)
+	}
+}
+
+func TestWorkerCloseWaitsForJobs(t *testing.T) {
+	broker := NewMockedBroker()
+	runner := &MockedRunner{block: make(chan struct{})}
+	worker, _ := NewWorker(broker, runner, WorkerOptions{})
+
+	acked := broker.deliver(models.ParseJob{Id: "job_1", FeedUrls: []string{"url_1"}}, false)
+	broker.waitForStatus(t, models.JobRunning)
+
+	closed := make(chan struct{})
+	go func() {
+		worker.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatalf("Worker.Close(), returned before job finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(runner.block)
+	<-closed
+	waitForAcks(t, acked)
+}
+
+func TestWorkersParseFeedOnce(t *testing.T) {
+	// Workers share leases like instances connected to the same broker
+	leases := &MockedLeases{held: make(map[string]bool)}
+	firstBroker, secondBroker := NewMockedBroker(), NewMockedBroker()
+	firstBroker.leases, secondBroker.leases = leases, leases
+	blocked := &MockedRunner{block: make(chan struct{})}
+	first, _ := NewWorker(firstBroker, blocked, WorkerOptions{WorkerId: "worker_1"})
+	defer first.Close()
+	second, _ := NewWorker(secondBroker, &MockedRunner{}, WorkerOptions{WorkerId: "worker_2"})
+	defer second.Close()
+
+	running := firstBroker.deliver(models.ParseJob{Id: "job_1", FeedUrls: []string{"url_1"}}, false)
+	firstBroker.waitForStatus(t, models.JobRunning)
+
+	// Feed being parsed by the first worker is skipped by the second one
+	skipped := secondBroker.deliver(models.ParseJob{Id: "job_2", FeedUrls: []string{"url_1", "url_2"}}, false)
+	waitForAcks(t, skipped)
+	statuses := secondBroker.Statuses()
+	results := statuses[len(statuses)-1].Statuses
+	if len(results) != 2 || results[0].Status != models.ParsingInProgress || results[1].Status != models.ParsedSuccessfully {
+		t.Fatalf("Worker.process(), results = %+v, want url_1 in progress and url_2 parsed", results)
+	}
+
+	// Lease is released after the feed is parsed
+	close(blocked.block)
+	waitForAcks(t, running)
+	parsed := secondBroker.deliver(models.ParseJob{Id: "job_3", FeedUrls: []string{"url_1"}}, false)
+	waitForAcks(t, parsed)
+	statuses = secondBroker.Statuses()
+	if results := statuses[len(statuses)-1].Statuses; results[0].Status != models.ParsedSuccessfully {
+		t.Fatalf("Worker.process(), results = %+v, want url_1 parsed", results)
+	}
+}
+
+func TestWorkerRequeuesJobWithoutLeases(t *testing.T) {
+	broker := NewMockedBroker()
+	broker.leases = &MockedLeases{held: make(map[string]bool), unavailable: true}
+	worker, _ := NewWorker(broker, &MockedRunner{}, WorkerOptions{})
+	defer worker.Close()
+
+	requeued := broker.deliverNack(models.ParseJob{Id: "job_1", FeedUrls: []string{"url_1"}})
+	select {
+	case requeue := <-requeued:
+		if !requeue {
+			t.Fatalf("Worker.process(), job dropped, want requeued")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Worker.process(), job not requeued")
+	}
+	broker.waitForStatus(t, models.JobQueued)
+}
+
+// Waits until deliveries are acknowledged
+func waitForAcks(t *testing.T, acks ...chan struct{}) {
+	t.Helper()
+	for _, ack := range acks {
+		select {
+		case <-ack:
+		case <-time.After(time.Second):
+			t.Fatalf("Worker, job not acknowledged")
+		}
+	}
+}
+
+// MOCKED DATA
+
+// In-memory job broker recording published jobs and statuses
+type MockedBroker struct {
+	mutex       sync.Mutex
+	jobs        []models.ParseJob
+	statuses    []models.JobStatus
+	deliveries  chan Delivery
+	consumed    chan models.JobStatus
+	failPublish bool
+	// Leases shared by brokers, every lease is free when nil
+	leases *MockedLeases
+}
+
+func NewMockedBroker() *MockedBroker {
+	return &MockedBroker{
+		deliveries: make(chan Delivery, 10),
+		consumed:   make(chan models.JobStatus, 10),
+	}
+}
+
+func (b *MockedBroker) PublishJob(job models.ParseJob) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failPublish {
+		return fmt.Errorf("broker unavailable")
+	}
+	b.jobs = append(b.jobs, job)
+	return nil
+}
+
+func (b *MockedBroker) PublishStatus(status models.JobStatus) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.statuses = append(b.statuses, status)
+	return nil
+}
+
+func (b *MockedBroker) ConsumeJobs() (<-chan Delivery, error) {
+	return b.deliveries, nil
+}
+
+func (b *MockedBroker) ConsumeStatuses() (<-chan models.JobStatus, error) {
+	return b.consumed, nil
+}
+
+func (b *MockedBroker) AcquireLease(key string) (func(), error) {
+	if b.leases == nil {
+		return func() {}, nil
+	}
+	return b.leases.acquire(key)
+}
+
+func (b *MockedBroker) IsConnected() bool {
+	return true
+}
+
+// Delivers job to the worker, returned channel is closed on Ack
+func (b *MockedBroker) deliver(job models.ParseJob, redelivered bool) chan struct{} {
+	acked := make(chan struct{})
+	b.deliveries <- Delivery{
+		Job:         job,
+		Redelivered: redelivered,
+		Ack: func() error {
+			close(acked)
+			return nil
+		},
+		Nack: func(requeue bool) error {
+			return nil
+		},
+	}
+	return acked
+}
+
+// Delivers job to the worker, returned channel receives requeue of Nack
+func (b *MockedBroker) deliverNack(job models.ParseJob) chan bool {
+	nacked := make(chan bool, 1)
+	b.deliveries <- Delivery{
+		Job: job,
+		Ack: func() error {
+			return nil
+		},
+		Nack: func(requeue bool) error {
+			nacked <- requeue
+			return nil
+		},
+	}
+	return nacked
+}
+
+func (b *MockedBroker) Statuses() []models.JobStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]models.JobStatus{}, b.statuses...)
+}
+
+// Waits until status with state is published
+func (b *MockedBroker) waitForStatus(t *testing.T, state models.JobState) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(5 * time.Millisecond) {
+		for _, status := range b.Statuses() {
+			if status.State == state {
+				return
+			}
+		}
+	}
+	t.Fatalf("MockedBroker, no %v status published", state)
+}
+
+// Leases of workers, acquire fails when unavailable is set
+type MockedLeases struct {
+	mutex       sync.Mutex
+	held        map[string]bool
+	unavailable bool
+}
+
+func (l *MockedLeases) acquire(key string) (func(), error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.unavailable {
+		return nil, fmt.Errorf("broker unavailable")
+	}
+	if l.held[key] {
+		return nil, ErrLeaseTaken
+	}
+	l.held[key] = true
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		delete(l.held, key)
+	}, nil
+}
+
+// Runner knowing only feed "feed_1", runs wait for block when it's set
+type MockedRunner struct {
+	block chan struct{}
+}
+
+func (r *MockedRunner) Resolve(feedIds []string) ([]feedparser.FeedSource, error) {
+	sources := []feedparser.FeedSource{}
+	for _, id := range feedIds {
+		if id != "feed_1" {
+			return nil, fmt.Errorf("feed %s: feed not found", id)
+		}
+		sources = append(sources, feedparser.FeedSource{Url: "url_feed_1", FeedId: id})
+	}
+	return sources, nil
+}
+
+func (r *MockedRunner) Run(sources []feedparser.FeedSource) []models.FeedParsingResult {
+	if r.block != nil {
+		<-r.block
+	}
+	results := []models.FeedParsingResult{}
+	for _, source := range sources {
+		results = append(results, models.FeedParsingResult{
+			FeedUrl: source.Url,
+			FeedId:  source.FeedId,
+			Status:  models.ParsedSuccessfully,
+		})
+	}
+	return results
+}
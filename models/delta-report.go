@@ -0,0 +1,20 @@
+package models
+
+// Type of shop item change since the last feed run
+type ChangeType string
+
+const (
+	ItemAdded   ChangeType = "added"
+	ItemUpdated ChangeType = "updated"
+	ItemRemoved ChangeType = "removed"
+)
+
+// Number of changed shop items since the last feed run
+type DeltaReport struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Removed   int `json:"removed"`
+	Unchanged int `json:"unchanged"`
+	// Items with id repeated in the feed, not compared again
+	Duplicates int `json:"duplicates"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// Summary of repeated ITEM_IDs found in the feed
+type DuplicatesReport struct {
+	Policy string `json:"policy"`
+	// Number of distinct ids occurring more than once
+	DuplicateIds int `json:"duplicateIds"`
+	// Number of repeated occurrences (all occurrences except the first one)
+	DuplicateItems int `json:"duplicateItems"`
+	// Number of items not published due to the policy
+	DroppedItems int `json:"droppedItems"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Feed-level metadata of parsed feed
+type FeedMetadata struct {
+	ShopId    string    `json:"shopId,omitempty"`
+	FeedUrl   string    `json:"feedUrl"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
@@ -9,7 +9,12 @@ const (
 )
 
 type FeedParsingResult struct {
-	FeedUrl     string       `json:"feedUrl"`
-	Status      ResultStatus `json:"status"`
-	ParsingTime string       `json:"parsingTime"`
+	FeedUrl       string            `json:"feedUrl"`
+	FeedId        string            `json:"feedId,omitempty"`
+	JobId         string            `json:"jobId,omitempty"`
+	Status        ResultStatus      `json:"status"`
+	ParsingTime   string            `json:"parsingTime"`
+	QualityReport *QualityReport    `json:"qualityReport,omitempty"`
+	Duplicates    *DuplicatesReport `json:"duplicates,omitempty"`
+	Delta         *DeltaReport      `json:"delta,omitempty"`
 }
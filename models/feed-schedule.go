@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Scheduling state of a registered feed
+type FeedSchedule struct {
+	FeedId   string `json:"feedId"`
+	Schedule string `json:"schedule"`
+	// Nil when the schedule is invalid
+	NextRunAt *time.Time `json:"nextRunAt,omitempty"`
+	// Nil when the feed was never parsed
+	LastRunAt  *time.Time   `json:"lastRunAt,omitempty"`
+	LastStatus ResultStatus `json:"lastStatus,omitempty"`
+	Running    bool         `json:"running"`
+	// Error of invalid schedule or of the last run
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Format of feed files
+type FeedFormat string
+
+const (
+	HeurekaXml FeedFormat = "heureka_xml"
+)
+
+// Feed source managed in the feed registry
+type Feed struct {
+	Id     string     `json:"id"`
+	ShopId string     `json:"shopId,omitempty"`
+	Url    string     `json:"url"`
+	Format FeedFormat `json:"format"`
+	// Reference to credentials kept outside of the registry,
+	// e.g. name of a secret. Fetching with credentials isn't supported
+	// yet, feeds with it are stored but their runs fail
+	CredentialsRef string `json:"credentialsRef,omitempty"`
+	// Parsing schedule of the feed, cron expression or interval
+	// like "@every 1h"
+	Schedule string `json:"schedule,omitempty"`
+	// Name of routing profile, default routing is used when empty
+	RoutingProfile string `json:"routingProfile,omitempty"`
+	// API client which registered the feed, only it and admin clients
+	// can access the feed. Feeds without owner belong to anonymous client
+	Owner     string    `json:"owner,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Result and finish time of the last parsing,
+	// nil when the feed was never parsed
+	LastResult *FeedParsingResult `json:"lastResult,omitempty"`
+	LastRunAt  *time.Time         `json:"lastRunAt,omitempty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "pass"
+	// Degraded dependency which doesn't affect readiness
+	CheckWarn CheckStatus = "warn"
+	// Failed dependency, the service is not ready
+	CheckFail CheckStatus = "fail"
+)
+
+// Result of a single dependency check of health endpoints
+type HealthCheck struct {
+	Name    string      `json:"name"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message,omitempty"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Parse request queued for workers
+type ParseJob struct {
+	Id       string   `json:"id"`
+	FeedUrls []string `json:"feedUrls,omitempty"`
+	// Identifiers of feeds from the feed registry of workers
+	FeedIds []string `json:"feedIds,omitempty"`
+	// API client which enqueued the job
+	Client     string    `json:"client,omitempty"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+}
+
+type JobState string
+
+const (
+	JobQueued  JobState = "QUEUED"
+	JobRunning JobState = "RUNNING"
+	JobDone    JobState = "DONE"
+	JobFailed  JobState = "FAILED"
+)
+
+// Progress of queued parse job reported by workers
+type JobStatus struct {
+	JobId string   `json:"jobId"`
+	State JobState `json:"state"`
+	// API client which enqueued the job, only it and admin clients
+	// can read the status
+	Client string `json:"client,omitempty"`
+	// Worker processing the job
+	WorkerId string `json:"workerId,omitempty"`
+	// Job was delivered again after crash or connection loss of a worker
+	Redelivered bool `json:"redelivered,omitempty"`
+	// Results of parsed feeds, set when the job is done
+	Statuses []FeedParsingResult `json:"statuses,omitempty"`
+	// Reason of failed job
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Version of published shop items schema,
+// increased on incompatible changes of ShopItem
+const SchemaVersion = "1"
+
+// Metadata of a single feed run published together with its shop items
+type PublishMetadata struct {
+	FeedUrl string
+	ShopId  string
+	// Unique identifier of the feed run
+	JobId         string
+	ParsedAt      time.Time
+	SchemaVersion string
+}
@@ -0,0 +1,29 @@
+package models
+
+// Single validation rule broken by a shop item
+type RuleViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Invalid shop item with all the rules it breaks
+type ItemDiagnostics struct {
+	FeedUrl    string          `json:"feedUrl"`
+	Item       ShopItem        `json:"item"`
+	Violations []RuleViolation `json:"violations"`
+}
+
+// Number of violations of a single rule in the feed
+// with sample ids of the offending items
+type RuleReport struct {
+	Rule          string   `json:"rule"`
+	Count         int      `json:"count"`
+	SampleItemIds []string `json:"sampleItemIds"`
+}
+
+// Feed quality summary created by the validation stage
+type QualityReport struct {
+	ValidItems   int          `json:"validItems"`
+	InvalidItems int          `json:"invalidItems"`
+	Rules        []RuleReport `json:"rules"`
+}
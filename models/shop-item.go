@@ -27,6 +27,10 @@ type ShopItem struct {
 	ExtendedWarranty  []ShopItemExtendedWarranty `xml:"EXTENDED_WARRANTY" json:"extendedWarranty"`
 	SpecialService    string                     `xml:"SPECIAL_SERVICE" json:"specialService"`
 	SalesVoucher      []ShopItemSalesVoucher     `xml:"SALES_VOUCHER" json:"salesVoucher"`
+	Duplicate         bool                       `xml:"-" json:"duplicate,omitempty"`
+	ChangeType        ChangeType                 `xml:"-" json:"changeType,omitempty"`
+	InternalCategory  string                     `xml:"-" json:"internalCategory,omitempty"`
+	Metadata          *FeedMetadata              `xml:"-" json:"metadata,omitempty"`
 }
 
 type ShopItemParam struct {
@@ -0,0 +1,9 @@
+package models
+
+// Readiness of a single queue writer
+type SinkStatus struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	// Last error of the sink, empty when it's ready
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,180 @@
+package queuewriter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format of message body with many shop items
+type BatchFormat string
+
+const (
+	// Single shop item per message, batching is disabled
+	SingleItem BatchFormat = ""
+	// JSON array of shop items
+	JsonArray BatchFormat = "json_array"
+	// Shop items as newline delimited JSON objects
+	Ndjson BatchFormat = "ndjson"
+)
+
+// Compression of message body
+type Compression string
+
+const (
+	NoCompression Compression = ""
+	Gzip          Compression = "gzip"
+	Zstd          Compression = "zstd"
+)
+
+// Default maximal time of collecting items of a single batch
+const DefaultBatchInterval = 200 * time.Millisecond
+
+// Batching and compression of published messages
+type BatchOptions struct {
+	// Maximal number of items in a message, used with JsonArray or Ndjson
+	Size int
+	// Maximal time of collecting items of a message,
+	// DefaultBatchInterval is used when 0
+	Interval time.Duration
+	Format   BatchFormat
+	// Compression of message body, body is not compressed when empty
+	Compression Compression
+}
+
+// Encoder of shop items into message bodies
+type BatchEncoder struct {
+	options BatchOptions
+	zstd    *zstd.Encoder
+}
+
+// Gzip writers reused between messages
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// Creates new BatchEncoder instance, returns error on unknown
+// format or compression
+func NewBatchEncoder(options BatchOptions) (*BatchEncoder, error) {
+	switch options.Format {
+	case SingleItem:
+		options.Size = 1
+	case JsonArray, Ndjson:
+		if options.Size <= 0 {
+			return nil, fmt.Errorf("batch size of %s format has to be positive", options.Format)
+		}
+	default:
+		return nil, fmt.Errorf("unknown batch format %q", options.Format)
+	}
+	if options.Interval <= 0 {
+		options.Interval = DefaultBatchInterval
+	}
+
+	e := &BatchEncoder{options: options}
+	switch options.Compression {
+	case NoCompression, Gzip:
+	case Zstd:
+		// EncodeAll is safe for concurrent use
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		e.zstd = encoder
+	default:
+		return nil, fmt.Errorf("unknown compression %q", options.Compression)
+	}
+	return e, nil
+}
+
+// Checks if many items are published in a single message
+func (e *BatchEncoder) Batching() bool {
+	return e.options.Format != SingleItem
+}
+
+// Maximal number of items in a message
+func (e *BatchEncoder) Size() int {
+	return e.options.Size
+}
+
+// Maximal time of collecting items of a message
+func (e *BatchEncoder) Interval() time.Duration {
+	return e.options.Interval
+}
+
+// Content type of encoded messages
+func (e *BatchEncoder) ContentType() string {
+	if e.options.Format == Ndjson {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+// Content encoding of encoded messages, empty when not compressed
+func (e *BatchEncoder) ContentEncoding() string {
+	return string(e.options.Compression)
+}
+
+// Encodes items into message body. Safe for concurrent use
+func (e *BatchEncoder) Encode(items []models.ShopItem) ([]byte, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	switch e.options.Format {
+	case SingleItem:
+		if len(items) != 1 {
+			return nil, fmt.Errorf("single item message with %d items", len(items))
+		}
+		if err := encoder.Encode(items[0]); err != nil {
+			return nil, err
+		}
+		// Match json.Marshal output
+		body.Truncate(body.Len() - 1)
+	case JsonArray:
+		// Encoder adds newlines which are valid whitespace in JSON array
+		body.WriteByte('[')
+		for idx, item := range items {
+			if idx > 0 {
+				body.WriteByte(',')
+			}
+			if err := encoder.Encode(item); err != nil {
+				return nil, err
+			}
+		}
+		body.WriteByte(']')
+	case Ndjson:
+		for _, item := range items {
+			if err := encoder.Encode(item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return e.compress(body.Bytes())
+}
+
+// Compresses encoded body
+func (e *BatchEncoder) compress(body []byte) ([]byte, error) {
+	switch e.options.Compression {
+	case Gzip:
+		var compressed bytes.Buffer
+		writer := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(writer)
+		writer.Reset(&compressed)
+		if _, err := writer.Write(body); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return compressed.Bytes(), nil
+	case Zstd:
+		return e.zstd.EncodeAll(body, make([]byte, 0, len(body)/4)), nil
+	}
+	return body, nil
+}
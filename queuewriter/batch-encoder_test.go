@@ -0,0 +1,118 @@
+package queuewriter
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestBatchEncoderFormats(t *testing.T) {
+	items := []models.ShopItem{
+		{ItemID: "item_1", ProductName: "Product 1"},
+		{ItemID: "item_2", ProductName: "Product 2"},
+	}
+
+	for _, compression := range []Compression{NoCompression, Gzip, Zstd} {
+		// JSON array
+		encoder, err := NewBatchEncoder(BatchOptions{Size: 2, Format: JsonArray, Compression: compression})
+		if err != nil {
+			t.Fatalf("NewBatchEncoder(json_array, %q), err = %v, want nil", compression, err)
+		}
+		var decoded []models.ShopItem
+		if err := json.Unmarshal(decodeBody(t, encoder, items), &decoded); err != nil {
+			t.Fatalf("BatchEncoder(json_array, %q).Encode(), invalid JSON array: %v", compression, err)
+		}
+		if !reflect.DeepEqual(decoded, items) {
+			t.Fatalf("BatchEncoder(json_array, %q).Encode(), items = %v, want %v", compression, decoded, items)
+		}
+
+		// NDJSON
+		encoder, err = NewBatchEncoder(BatchOptions{Size: 2, Format: Ndjson, Compression: compression})
+		if err != nil {
+			t.Fatalf("NewBatchEncoder(ndjson, %q), err = %v, want nil", compression, err)
+		}
+		decoded = nil
+		scanner := bufio.NewScanner(bytes.NewReader(decodeBody(t, encoder, items)))
+		for scanner.Scan() {
+			var item models.ShopItem
+			if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+				t.Fatalf("BatchEncoder(ndjson, %q).Encode(), invalid line %q: %v", compression, scanner.Text(), err)
+			}
+			decoded = append(decoded, item)
+		}
+		if !reflect.DeepEqual(decoded, items) {
+			t.Fatalf("BatchEncoder(ndjson, %q).Encode(), items = %v, want %v", compression, decoded, items)
+		}
+		if encoder.ContentEncoding() != string(compression) {
+			t.Fatalf("BatchEncoder(ndjson, %q).ContentEncoding() = %q", compression, encoder.ContentEncoding())
+		}
+	}
+}
+
+func TestBatchEncoderSingleItem(t *testing.T) {
+	encoder, err := NewBatchEncoder(BatchOptions{})
+	if err != nil {
+		t.Fatalf("NewBatchEncoder(), err = %v, want nil", err)
+	}
+	item := models.ShopItem{ItemID: "item_1", Description: "<b>A & B</b>"}
+
+	body, err := encoder.Encode([]models.ShopItem{item})
+	if err != nil {
+		t.Fatalf("BatchEncoder.Encode(), err = %v, want nil", err)
+	}
+
+	// Body is the same as of not batched publishing
+	expected, _ := json.Marshal(item)
+	if !bytes.Equal(body, expected) {
+		t.Fatalf("BatchEncoder.Encode(), body = %s, want %s", body, expected)
+	}
+	if encoder.Batching() {
+		t.Fatalf("BatchEncoder.Batching() = true, want false")
+	}
+}
+
+func TestNewBatchEncoderErrors(t *testing.T) {
+	for _, options := range []BatchOptions{
+		{Size: 10, Format: "xml"},
+		{Size: 0, Format: Ndjson},
+		{Size: 10, Format: JsonArray, Compression: "lz4"},
+	} {
+		if _, err := NewBatchEncoder(options); err == nil {
+			t.Fatalf("NewBatchEncoder(%+v), err = nil, want error", options)
+		}
+	}
+}
+
+// Encodes items and decompresses the body
+func decodeBody(t *testing.T, encoder *BatchEncoder, items []models.ShopItem) []byte {
+	body, err := encoder.Encode(items)
+	if err != nil {
+		t.Fatalf("BatchEncoder.Encode(), err = %v, want nil", err)
+	}
+	switch Compression(encoder.ContentEncoding()) {
+	case Gzip:
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("BatchEncoder.Encode(), invalid gzip body: %v", err)
+		}
+		body, err = io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("BatchEncoder.Encode(), invalid gzip body: %v", err)
+		}
+	case Zstd:
+		decoder, _ := zstd.NewReader(nil)
+		defer decoder.Close()
+		body, err = decoder.DecodeAll(body, nil)
+		if err != nil {
+			t.Fatalf("BatchEncoder.Encode(), invalid zstd body: %v", err)
+		}
+	}
+	return body
+}
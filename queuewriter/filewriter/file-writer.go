@@ -0,0 +1,250 @@
+package filewriter
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Writer of shop items into files, every queue of every feed run
+// is written to separate files in target directory.
+// Files are created with ".tmp" suffix and renamed when complete,
+// so readers never see partially written files
+// Implements queuewriter.QueueWriterInterface
+type FileWriter struct {
+	options FileWriterOptions
+}
+
+// Format of written files
+type Format string
+
+const (
+	// Newline delimited JSON objects
+	Ndjson Format = "ndjson"
+	// JSON array of items
+	Json Format = "json"
+	// CSV with header, nested values are JSON encoded
+	Csv Format = "csv"
+	// Parquet with the same columns as CSV
+	Parquet Format = "parquet"
+)
+
+// Target directory, format and rotation of written files
+type FileWriterOptions struct {
+	// Directory of written files, created when it doesn't exist
+	Dir string
+	// Format of files, Ndjson is used when empty
+	Format Format
+	// Compress files with gzip. Parquet files use gzip compression
+	// of their pages instead of whole file compression
+	Gzip bool
+	// Maximal number of items in a file, next items are written to
+	// next file of the run. Files are not rotated by items when 0
+	MaxFileItems int
+	// Maximal size of a file in bytes, next items are written to
+	// next file of the run. Files are not rotated by size when 0
+	MaxFileBytes int64
+}
+
+// Checks options without touching the directory
+func (o FileWriterOptions) Validate() error {
+	switch o.Format {
+	case "", Ndjson, Json, Csv, Parquet:
+	default:
+		return fmt.Errorf("unknown file format %q", o.Format)
+	}
+	if len(o.Dir) == 0 {
+		return fmt.Errorf("directory of written files is not set")
+	}
+	return nil
+}
+
+// Creates new FileWriter instance writing to options.Dir
+func NewFileWriter(options FileWriterOptions) (*FileWriter, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if len(options.Format) == 0 {
+		options.Format = Ndjson
+	}
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileWriter{options: options}, nil
+}
+
+// Writes all products from shopItemsInput to files named
+// "<queue>_<parsed at>[_<job id>]_<part>.<format>[.gz]", starting
+// next part when current file reaches its limits. Files are not
+// created for runs without items. Incomplete file is removed on error.
+// shopItemsInput is always drained, also on error
+func (w *FileWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) (err error) {
+	var file *sinkFile
+	defer func() {
+		if err != nil {
+			if file != nil {
+				file.abort()
+			}
+			// Drain input so upstream stages don't block
+			for range shopItemsInput {
+			}
+		}
+	}()
+
+	part := 0
+	for item := range shopItemsInput {
+		if file == nil {
+			part++
+			file, err = w.create(w.fileName(queueName, metadata, part))
+			if err != nil {
+				writtenShopItemsFailures.Inc()
+				return err
+			}
+		}
+		if err := file.write(item); err != nil {
+			writtenShopItemsFailures.Inc()
+			return err
+		}
+		writtenShopItems.Inc()
+		if !w.full(file) {
+			continue
+		}
+		if err := file.close(); err != nil {
+			return err
+		}
+		file = nil
+	}
+	if file != nil {
+		return file.close()
+	}
+	return nil
+}
+
+// Returns name of part of feed run file
+func (w *FileWriter) fileName(
+	queueName string,
+	metadata models.PublishMetadata,
+	part int,
+) string {
+	name := strings.ReplaceAll(queueName, string(os.PathSeparator), "_") +
+		"_" + metadata.ParsedAt.UTC().Format("20060102T150405Z")
+	if len(metadata.JobId) > 0 {
+		name += "_" + metadata.JobId
+	}
+	name += fmt.Sprintf("_%04d.%s", part, w.options.Format)
+	if w.options.Gzip && w.options.Format != Parquet {
+		name += ".gz"
+	}
+	return filepath.Join(w.options.Dir, name)
+}
+
+// Checks if file reached its limits
+func (w *FileWriter) full(file *sinkFile) bool {
+	return (w.options.MaxFileItems > 0 && file.items >= w.options.MaxFileItems) ||
+		(w.options.MaxFileBytes > 0 && file.size.bytes >= w.options.MaxFileBytes)
+}
+
+// Creates temporary file renamed to path on close
+func (w *FileWriter) create(path string) (*sinkFile, error) {
+	defer zap.L().Sync()
+
+	output, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	file := &sinkFile{path: path, output: output}
+	file.size = &countingWriter{writer: output}
+
+	var writer io.Writer = file.size
+	if w.options.Gzip && w.options.Format != Parquet {
+		file.gzip = gzip.NewWriter(file.size)
+		writer = file.gzip
+	}
+	file.encoder, err = newItemEncoder(w.options.Format, writer, w.options.Gzip)
+	if err != nil {
+		file.abort()
+		return nil, err
+	}
+	zap.L().Debug("Created items file", zap.String("path", path))
+	return file, nil
+}
+
+// File being written
+type sinkFile struct {
+	path    string
+	output  *os.File
+	size    *countingWriter
+	gzip    *gzip.Writer
+	encoder itemEncoder
+	items   int
+}
+
+// Writes item to the file
+func (f *sinkFile) write(item models.ShopItem) error {
+	if err := f.encoder.encode(item); err != nil {
+		return fmt.Errorf("cannot write item to %s: %w", f.path, err)
+	}
+	f.items++
+	return nil
+}
+
+// Completes the file and renames it to its final path
+func (f *sinkFile) close() error {
+	err := f.encoder.close()
+	if err == nil && f.gzip != nil {
+		err = f.gzip.Close()
+	}
+	if closeErr := f.output.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.output.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.output.Name())
+		return fmt.Errorf("cannot write file %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// Removes incomplete file
+func (f *sinkFile) abort() {
+	f.output.Close()
+	os.Remove(f.output.Name())
+}
+
+// Writer counting bytes written to underlying writer
+type countingWriter struct {
+	writer io.Writer
+	bytes  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Prometheus written and failed shop items
+var (
+	writtenShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_file_written_items_total",
+		Help: "The total number of ShopItems written to files",
+	})
+	writtenShopItemsFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_file_written_items_failures_total",
+		Help: "The total number of failures in writing ShopItems to files",
+	})
+)
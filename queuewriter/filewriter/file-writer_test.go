@@ -0,0 +1,238 @@
+package filewriter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestFileWriterNdjsonRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewFileWriter(FileWriterOptions{Dir: dir, MaxFileItems: 2})
+	if err != nil {
+		t.Fatalf("NewFileWriter(), err = %v, want nil", err)
+	}
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	files := listFiles(t, dir)
+	expectedFiles := []string{
+		"shop_items_20220301T120000Z_job_1_0001.ndjson",
+		"shop_items_20220301T120000Z_job_1_0002.ndjson",
+		"shop_items_20220301T120000Z_job_1_0003.ndjson",
+	}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Fatalf("FileWriter.WriteToQueue(), files = %v, want %v", files, expectedFiles)
+	}
+
+	items := []models.ShopItem{}
+	for _, file := range files {
+		content, _ := os.Open(filepath.Join(dir, file))
+		scanner := bufio.NewScanner(content)
+		for scanner.Scan() {
+			var item models.ShopItem
+			if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+				t.Fatalf("FileWriter.WriteToQueue(), invalid line in %s: %v", file, err)
+			}
+			items = append(items, item)
+		}
+		content.Close()
+	}
+	if !reflect.DeepEqual(items, mockedItems) {
+		t.Fatalf("FileWriter.WriteToQueue(), items = %+v, want %+v", items, mockedItems)
+	}
+}
+
+func TestFileWriterGzippedJson(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewFileWriter(FileWriterOptions{Dir: dir, Format: Json, Gzip: true})
+	if err != nil {
+		t.Fatalf("NewFileWriter(), err = %v, want nil", err)
+	}
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	path := filepath.Join(dir, "shop_items_20220301T120000Z_job_1_0001.json.gz")
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), file not written: %v", err)
+	}
+	defer file.Close()
+	content, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), file not gzipped: %v", err)
+	}
+	var items []models.ShopItem
+	if err := json.NewDecoder(content).Decode(&items); err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), invalid JSON array: %v", err)
+	}
+	if !reflect.DeepEqual(items, mockedItems) {
+		t.Fatalf("FileWriter.WriteToQueue(), items = %+v, want %+v", items, mockedItems)
+	}
+}
+
+func TestFileWriterCsv(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewFileWriter(FileWriterOptions{Dir: dir, Format: Csv})
+	if err != nil {
+		t.Fatalf("NewFileWriter(), err = %v, want nil", err)
+	}
+
+	item := models.ShopItem{
+		ItemID:      "item_1",
+		ProductName: "Product, \"quoted\"",
+		Params:      []models.ShopItemParam{{ParamName: "color", Val: "red"}},
+	}
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel([]models.ShopItem{item})); err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	file, _ := os.Open(filepath.Join(dir, "shop_items_20220301T120000Z_job_1_0001.csv"))
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), invalid CSV: %v", err)
+	}
+	if len(rows) != 2 || !reflect.DeepEqual(rows[0], columns) {
+		t.Fatalf("FileWriter.WriteToQueue(), rows = %v, want header and single row", rows)
+	}
+	values := make(map[string]string)
+	for idx, column := range columns {
+		values[column] = rows[1][idx]
+	}
+	if values["productName"] != item.ProductName || values["param"] != `[{"paramName":"color","val":"red"}]` {
+		t.Fatalf("FileWriter.WriteToQueue(), row = %v, want values of %+v", values, item)
+	}
+}
+
+func TestFileWriterParquet(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewFileWriter(FileWriterOptions{Dir: dir, Format: Parquet, Gzip: true})
+	if err != nil {
+		t.Fatalf("NewFileWriter(), err = %v, want nil", err)
+	}
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	// Parquet files are compressed internally
+	path := filepath.Join(dir, "shop_items_20220301T120000Z_job_1_0001.parquet")
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), file not written: %v", err)
+	}
+	defer file.Close()
+	parquetReader, err := reader.NewParquetReader(file, nil, 1)
+	if err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), invalid parquet file: %v", err)
+	}
+	defer parquetReader.ReadStop()
+	if rows := parquetReader.GetNumRows(); rows != int64(len(mockedItems)) {
+		t.Fatalf("FileWriter.WriteToQueue(), parquet rows = %d, want %d", rows, len(mockedItems))
+	}
+}
+
+func TestFileWriterEmptyRun(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := NewFileWriter(FileWriterOptions{Dir: dir})
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(nil)); err != nil {
+		t.Fatalf("FileWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	if files := listFiles(t, dir); len(files) != 0 {
+		t.Fatalf("FileWriter.WriteToQueue(), files = %v, want none", files)
+	}
+}
+
+func TestFileWriterFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "items")
+	w, err := NewFileWriter(FileWriterOptions{Dir: dir})
+	if err != nil {
+		t.Fatalf("NewFileWriter(), err = %v, want nil", err)
+	}
+	os.RemoveAll(dir)
+
+	// Unbuffered input checks if it's drained after failure
+	input := make(chan models.ShopItem)
+	go func() {
+		for _, item := range mockedItems {
+			input <- item
+		}
+		close(input)
+	}()
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, input); err == nil {
+		t.Fatalf("FileWriter.WriteToQueue(), err = nil, want error")
+	}
+	if _, ok := <-input; ok {
+		t.Fatalf("FileWriter.WriteToQueue(), input not drained after error")
+	}
+}
+
+func TestNewFileWriterInvalidOptions(t *testing.T) {
+	for _, options := range []FileWriterOptions{
+		{Dir: t.TempDir(), Format: "xml"},
+		{},
+	} {
+		if _, err := NewFileWriter(options); err == nil {
+			t.Fatalf("NewFileWriter(%+v), err = nil, want error", options)
+		}
+	}
+}
+
+// Returns sorted names of files in dir
+func listFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir(%s), err = %v, want nil", dir, err)
+	}
+	files := []string{}
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Returns closed channel with all items
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	return input
+}
+
+// MOCKED DATA
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "item_1", ProductName: "Product 1"},
+	{ItemID: "item_2", ProductName: "Product 2"},
+	{ItemID: "item_3", ProductName: "Product 3"},
+	{ItemID: "item_1", ProductName: "Product 1 v2"},
+	{ItemID: "item_4", ProductName: "Product 4"},
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	SchemaVersion: models.SchemaVersion,
+}
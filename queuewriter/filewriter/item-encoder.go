@@ -0,0 +1,206 @@
+package filewriter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Encoder of items written to a single file
+type itemEncoder interface {
+	encode(item models.ShopItem) error
+	// Writes end of the file, doesn't close the underlying writer
+	close() error
+}
+
+// Creates encoder of format writing to output
+func newItemEncoder(format Format, output io.Writer, compress bool) (itemEncoder, error) {
+	switch format {
+	case Ndjson:
+		return &ndjsonEncoder{encoder: json.NewEncoder(output)}, nil
+	case Json:
+		if _, err := io.WriteString(output, "["); err != nil {
+			return nil, err
+		}
+		return &jsonArrayEncoder{output: output, encoder: json.NewEncoder(output)}, nil
+	case Csv:
+		encoder := &csvEncoder{writer: csv.NewWriter(output)}
+		if err := encoder.writer.Write(columns); err != nil {
+			return nil, err
+		}
+		return encoder, nil
+	case Parquet:
+		return newParquetEncoder(output, compress)
+	}
+	return nil, fmt.Errorf("unknown file format %q", format)
+}
+
+// Encodes items as newline delimited JSON
+type ndjsonEncoder struct {
+	encoder *json.Encoder
+}
+
+func (e *ndjsonEncoder) encode(item models.ShopItem) error {
+	return e.encoder.Encode(item)
+}
+
+func (e *ndjsonEncoder) close() error {
+	return nil
+}
+
+// Encodes items as JSON array with an item per line
+type jsonArrayEncoder struct {
+	output  io.Writer
+	encoder *json.Encoder
+	items   int
+}
+
+func (e *jsonArrayEncoder) encode(item models.ShopItem) error {
+	if e.items > 0 {
+		if _, err := io.WriteString(e.output, ","); err != nil {
+			return err
+		}
+	}
+	e.items++
+	return e.encoder.Encode(item)
+}
+
+func (e *jsonArrayEncoder) close() error {
+	_, err := io.WriteString(e.output, "]\n")
+	return err
+}
+
+// Encodes items as CSV rows of columns
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvEncoder) encode(item models.ShopItem) error {
+	return e.writer.Write(row(item))
+}
+
+func (e *csvEncoder) close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// Encodes items as parquet rows of optional string columns,
+// empty values are stored as nulls
+type parquetEncoder struct {
+	writer *writer.CSVWriter
+}
+
+// Parquet writers encode pages of rows in parallel
+const parquetParallelism = 4
+
+func newParquetEncoder(output io.Writer, compress bool) (*parquetEncoder, error) {
+	schema := make([]string, len(columns))
+	for idx, column := range columns {
+		schema[idx] = fmt.Sprintf(
+			"name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL",
+			column,
+		)
+	}
+	parquetWriter, err := writer.NewCSVWriterFromWriter(schema, output, parquetParallelism)
+	if err != nil {
+		return nil, err
+	}
+	if compress {
+		parquetWriter.CompressionType = parquet.CompressionCodec_GZIP
+	}
+	return &parquetEncoder{writer: parquetWriter}, nil
+}
+
+func (e *parquetEncoder) encode(item models.ShopItem) error {
+	values := row(item)
+	record := make([]*string, len(values))
+	for idx := range values {
+		if len(values[idx]) > 0 {
+			record[idx] = &values[idx]
+		}
+	}
+	return e.writer.WriteString(record)
+}
+
+func (e *parquetEncoder) close() error {
+	return e.writer.WriteStop()
+}
+
+// Columns of CSV and parquet files, named as JSON properties
+var columns = []string{
+	"itemId",
+	"productName",
+	"product",
+	"description",
+	"url",
+	"imgUrl",
+	"imgUrlAlternative",
+	"videoUrl",
+	"priceVAT",
+	"heurekaCPC",
+	"categoryText",
+	"ean",
+	"productNo",
+	"param",
+	"deliveryDate",
+	"deliveries",
+	"itemGroupId",
+	"accessory",
+	"gift",
+	"extendedWarranty",
+	"specialService",
+	"salesVoucher",
+	"duplicate",
+	"changeType",
+	"internalCategory",
+	"metadata",
+}
+
+// Returns values of item columns, nested values are JSON encoded
+// and empty ones are empty strings
+func row(item models.ShopItem) []string {
+	return []string{
+		item.ItemID,
+		item.ProductName,
+		item.Product,
+		item.Description,
+		item.Url,
+		item.ImgUrl,
+		item.ImgUrlAlternative,
+		item.VideoUrl,
+		item.PriceVat,
+		item.HeurekaCPC,
+		item.CategoryText,
+		item.EAN,
+		item.ProductNo,
+		jsonValue(item.Params),
+		item.DelivaryDate,
+		jsonValue(item.Deliveries),
+		item.ItemGroupId,
+		item.Accessory,
+		item.Gift,
+		jsonValue(item.ExtendedWarranty),
+		item.SpecialService,
+		jsonValue(item.SalesVoucher),
+		strconv.FormatBool(item.Duplicate),
+		string(item.ChangeType),
+		item.InternalCategory,
+		jsonValue(item.Metadata),
+	}
+}
+
+// Returns JSON encoded value, empty string for nil and empty slices
+func jsonValue(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	switch string(encoded) {
+	case "null", "[]":
+		return ""
+	}
+	return string(encoded)
+}
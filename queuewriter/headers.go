@@ -0,0 +1,57 @@
+package queuewriter
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/deltadetector"
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Names of message headers set by queue writers
+const (
+	HeaderFeedUrl       = "x-feed-url"
+	HeaderShopId        = "x-shop-id"
+	HeaderJobId         = "x-job-id"
+	HeaderItemHash      = "x-item-hash"
+	HeaderParsedAt      = "x-parsed-at"
+	HeaderSchemaVersion = "x-schema-version"
+	HeaderItemCount     = "x-item-count"
+)
+
+// Returns message headers of published item. Item hash is the same
+// as used by delta detection, so consumers can skip unchanged items.
+// Empty shop id is omitted
+func ItemHeaders(
+	item models.ShopItem,
+	metadata models.PublishMetadata,
+) map[string]string {
+	headers := metadataHeaders(metadata)
+	headers[HeaderItemHash] = strconv.FormatUint(deltadetector.HashItem(item), 16)
+	return headers
+}
+
+// Returns message headers of published batch of items,
+// with number of items instead of item hash
+func BatchHeaders(
+	items []models.ShopItem,
+	metadata models.PublishMetadata,
+) map[string]string {
+	headers := metadataHeaders(metadata)
+	headers[HeaderItemCount] = strconv.Itoa(len(items))
+	return headers
+}
+
+// Returns headers with feed run metadata
+func metadataHeaders(metadata models.PublishMetadata) map[string]string {
+	headers := map[string]string{
+		HeaderFeedUrl:       metadata.FeedUrl,
+		HeaderJobId:         metadata.JobId,
+		HeaderParsedAt:      metadata.ParsedAt.UTC().Format(time.RFC3339),
+		HeaderSchemaVersion: metadata.SchemaVersion,
+	}
+	if len(metadata.ShopId) > 0 {
+		headers[HeaderShopId] = metadata.ShopId
+	}
+	return headers
+}
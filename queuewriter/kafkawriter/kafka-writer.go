@@ -0,0 +1,366 @@
+package kafkawriter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Writer for Kafka topics. Messages are keyed by ITEM_ID,
+// so the same item always goes to the same partition
+// and compacted topics keep only its latest version
+// Implements queuewriter.QueueWriterInterface
+type KafkaWriter struct {
+	producer  sarama.SyncProducer
+	admin     TopicAdminInterface
+	topics    map[string]TopicConfig
+	flushSize int
+
+	mutex sync.Mutex
+	// Topics already created (or existing) by queue names
+	createdTopics map[string]bool
+}
+
+// Kafka producer acknowledgements
+type Acks string
+
+const (
+	// Wait for all in-sync replicas
+	AcksAll Acks = "all"
+	// Wait only for partition leader
+	AcksLeader Acks = "leader"
+	// Don't wait for broker response
+	AcksNone Acks = "none"
+)
+
+// Topic of a single queue
+type TopicConfig struct {
+	// Name of the topic, queue name is used when empty
+	Topic string `json:"topic"`
+	// Number of partitions of created topic,
+	// topic is expected to exist when 0
+	Partitions int32 `json:"partitions"`
+	// Replication factor of created topic, 1 is used when 0
+	ReplicationFactor int16 `json:"replicationFactor"`
+	// Create topic with compact cleanup policy
+	Compact bool `json:"compact"`
+}
+
+// Connection and producer options of Kafka writer
+type KafkaWriterOptions struct {
+	Brokers  []string
+	ClientId string
+	// Producer acknowledgements, AcksAll is used when empty
+	Acks Acks
+	// Enable idempotent producer, so retries don't duplicate messages.
+	// Requires AcksAll
+	Idempotent bool
+	// Number of retries of failed messages,
+	// DefaultMaxRetries is used when 0
+	MaxRetries int
+	// Topics by queue names, queue names are used as topics of
+	// not configured queues
+	Topics map[string]TopicConfig
+	// Number of messages sent to the broker at once,
+	// DefaultFlushSize is used when 0
+	FlushSize int
+}
+
+// Default values of KafkaWriterOptions
+const (
+	DefaultMaxRetries = 3
+	DefaultFlushSize  = 500
+	DefaultClientId   = "feed-parser"
+)
+
+// Checks options without connecting to brokers
+func (o KafkaWriterOptions) Validate() error {
+	_, err := newSaramaConfig(o)
+	return err
+}
+
+// Creates new KafkaWriter instance connected to options.Brokers
+func NewKafkaWriter(options KafkaWriterOptions) (*KafkaWriter, error) {
+	config, err := newSaramaConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewSyncProducer(options.Brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Admin is needed only when any topic is created
+	var admin TopicAdminInterface
+	for _, topic := range options.Topics {
+		if topic.Partitions > 0 {
+			admin, err = sarama.NewClusterAdmin(options.Brokers, config)
+			if err != nil {
+				producer.Close()
+				return nil, err
+			}
+			break
+		}
+	}
+
+	return newKafkaWriter(options, producer, admin), nil
+}
+
+// Creates new KafkaWriter instance with given producer and admin
+func newKafkaWriter(
+	options KafkaWriterOptions,
+	producer sarama.SyncProducer,
+	admin TopicAdminInterface,
+) *KafkaWriter {
+	flushSize := options.FlushSize
+	if flushSize <= 0 {
+		flushSize = DefaultFlushSize
+	}
+	topics := options.Topics
+	if topics == nil {
+		topics = make(map[string]TopicConfig)
+	}
+	return &KafkaWriter{
+		producer:      producer,
+		admin:         admin,
+		topics:        topics,
+		flushSize:     flushSize,
+		createdTopics: make(map[string]bool),
+	}
+}
+
+// Returns producer configuration of options
+func newSaramaConfig(options KafkaWriterOptions) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	config.ClientID = options.ClientId
+	if len(config.ClientID) == 0 {
+		config.ClientID = DefaultClientId
+	}
+	// Required by sync producer
+	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Producer.Retry.Max = options.MaxRetries
+	if config.Producer.Retry.Max <= 0 {
+		config.Producer.Retry.Max = DefaultMaxRetries
+	}
+
+	switch options.Acks {
+	case AcksAll, "":
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	case AcksLeader:
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	case AcksNone:
+		config.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		return nil, fmt.Errorf("unknown kafka acks %q", options.Acks)
+	}
+
+	if options.Idempotent {
+		if config.Producer.RequiredAcks != sarama.WaitForAll {
+			return nil, fmt.Errorf("idempotent kafka producer requires %q acks", AcksAll)
+		}
+		config.Producer.Idempotent = true
+		config.Net.MaxOpenRequests = 1
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Reads topics by queue names from JSON file
+func LoadTopicsConfig(path string) (map[string]TopicConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var topics map[string]TopicConfig
+	if err := json.Unmarshal(content, &topics); err != nil {
+		return nil, fmt.Errorf("invalid topics configuration %s: %w", path, err)
+	}
+	return topics, nil
+}
+
+// Sends all products from shopItemsInput to topic of queue queueName,
+// creating the topic first when it's configured with partitions.
+// Messages are keyed by ITEM_ID and have headers with feed run metadata.
+// shopItemsInput is always drained, also on error
+func (w *KafkaWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) (err error) {
+	defer func() {
+		if err != nil {
+			// Drain input so upstream stages don't block
+			for range shopItemsInput {
+			}
+		}
+	}()
+
+	topic, err := w.topic(queueName)
+	if err != nil {
+		return err
+	}
+
+	messages := make([]*sarama.ProducerMessage, 0, w.flushSize)
+	for item := range shopItemsInput {
+		messages = append(messages, newProducerMessage(topic, item, metadata))
+		if len(messages) < w.flushSize {
+			continue
+		}
+		if err := w.send(messages); err != nil {
+			return err
+		}
+		messages = messages[:0]
+	}
+	return w.send(messages)
+}
+
+// Closes the producer and the admin
+func (w *KafkaWriter) Close() error {
+	err := w.producer.Close()
+	if w.admin != nil {
+		if adminErr := w.admin.Close(); err == nil {
+			err = adminErr
+		}
+	}
+	return err
+}
+
+// Returns topic of queue, creates it on first use when configured
+func (w *KafkaWriter) topic(queueName string) (string, error) {
+	defer zap.L().Sync()
+
+	config := w.topics[queueName]
+	topic := config.Topic
+	if len(topic) == 0 {
+		topic = queueName
+	}
+	if config.Partitions <= 0 || w.admin == nil {
+		return topic, nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.createdTopics[queueName] {
+		return topic, nil
+	}
+
+	detail := &sarama.TopicDetail{
+		NumPartitions:     config.Partitions,
+		ReplicationFactor: config.ReplicationFactor,
+	}
+	if detail.ReplicationFactor <= 0 {
+		detail.ReplicationFactor = 1
+	}
+	if config.Compact {
+		cleanupPolicy := "compact"
+		detail.ConfigEntries = map[string]*string{"cleanup.policy": &cleanupPolicy}
+	}
+	err := w.admin.CreateTopic(topic, detail, false)
+	var topicErr *sarama.TopicError
+	if errors.As(err, &topicErr) && topicErr.Err == sarama.ErrTopicAlreadyExists {
+		err = nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot create kafka topic %s: %w", topic, err)
+	}
+	zap.L().Info(
+		fmt.Sprintf("Kafka topic %s is ready", topic),
+		zap.String("topic", topic),
+		zap.Int32("partitions", config.Partitions),
+	)
+	w.createdTopics[queueName] = true
+	return topic, nil
+}
+
+// Sends messages and waits for acknowledgements
+func (w *KafkaWriter) send(messages []*sarama.ProducerMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	err := w.producer.SendMessages(messages)
+	if err == nil {
+		publishedShopItems.Add(float64(len(messages)))
+		return nil
+	}
+	var producerErrs sarama.ProducerErrors
+	if errors.As(err, &producerErrs) {
+		publishedShopItems.Add(float64(len(messages) - len(producerErrs)))
+		publishedShopItemsFailures.Add(float64(len(producerErrs)))
+		return fmt.Errorf(
+			"%d of %d messages not sent to kafka: %w",
+			len(producerErrs),
+			len(messages),
+			producerErrs[0].Err,
+		)
+	}
+	publishedShopItemsFailures.Add(float64(len(messages)))
+	return err
+}
+
+// Returns message of item keyed by ITEM_ID. Removed items are sent as
+// tombstones without value, so compacted topics delete their key
+func newProducerMessage(
+	topic string,
+	item models.ShopItem,
+	metadata models.PublishMetadata,
+) *sarama.ProducerMessage {
+	var value sarama.Encoder
+	if item.ChangeType != models.ItemRemoved {
+		body, _ := json.Marshal(item)
+		value = sarama.ByteEncoder(body)
+	}
+	headers := queuewriter.ItemHeaders(item, metadata)
+	recordHeaders := make([]sarama.RecordHeader, 0, len(headers))
+	for name, value := range headers {
+		recordHeaders = append(recordHeaders, sarama.RecordHeader{
+			Key:   []byte(name),
+			Value: []byte(value),
+		})
+	}
+	return &sarama.ProducerMessage{
+		Topic:     topic,
+		Key:       sarama.StringEncoder(item.ItemID),
+		Value:     value,
+		Headers:   recordHeaders,
+		Timestamp: metadata.ParsedAt,
+	}
+}
+
+// Splits comma separated list of brokers
+func ParseBrokers(list string) []string {
+	brokers := []string{}
+	for _, broker := range strings.Split(list, ",") {
+		if broker = strings.TrimSpace(broker); len(broker) > 0 {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
+}
+
+// Prometheus published and failed shop items
+var (
+	publishedShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_kafka_published_items_total",
+		Help: "The total number of ShopItems published to Kafka",
+	})
+	publishedShopItemsFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_kafka_published_items_failures_total",
+		Help: "The total number of failures in publishing ShopItems to Kafka",
+	})
+)
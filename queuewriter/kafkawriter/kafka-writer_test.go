@@ -0,0 +1,371 @@
+package kafkawriter
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/Shopify/sarama"
+)
+
+func TestKafkaWriterWriteToQueue(t *testing.T) {
+	broker := newMockedKafkaBroker(t, "items", 4)
+	w, err := NewKafkaWriter(KafkaWriterOptions{
+		Brokers:    []string{broker.Addr()},
+		Idempotent: true,
+		Topics:     map[string]TopicConfig{"shop_items": {Topic: "items"}},
+	})
+	if err != nil {
+		t.Fatalf("NewKafkaWriter(), err = %v, want nil", err)
+	}
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("KafkaWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	w.Close()
+
+	// Idempotent producer gets its id and waits for all replicas
+	initProducerIdCalls := 0
+	for _, call := range broker.History() {
+		switch request := call.Request.(type) {
+		case *sarama.InitProducerIDRequest:
+			initProducerIdCalls++
+		case *sarama.ProduceRequest:
+			if request.RequiredAcks != sarama.WaitForAll {
+				t.Fatalf("KafkaWriter.WriteToQueue(), acks = %v, want %v", request.RequiredAcks, sarama.WaitForAll)
+			}
+		}
+	}
+	if initProducerIdCalls != 1 {
+		t.Fatalf("KafkaWriter.WriteToQueue(), InitProducerID calls = %v, want 1", initProducerIdCalls)
+	}
+
+	// Items are sent to partitions of their ITEM_ID hash
+	expected := make(map[int32]bool)
+	for _, item := range mockedItems {
+		partition, _ := sarama.NewHashPartitioner("items").Partition(
+			&sarama.ProducerMessage{Key: sarama.StringEncoder(item.ItemID)},
+			4,
+		)
+		expected[partition] = true
+	}
+	if partitions := producedPartitions(broker, "items"); !reflect.DeepEqual(partitions, expected) {
+		t.Fatalf("KafkaWriter.WriteToQueue(), partitions = %v, want %v", partitions, expected)
+	}
+}
+
+func TestKafkaWriterAcks(t *testing.T) {
+	broker := newMockedKafkaBroker(t, "shop_items", 1)
+	w, err := NewKafkaWriter(KafkaWriterOptions{
+		Brokers: []string{broker.Addr()},
+		Acks:    AcksLeader,
+	})
+	if err != nil {
+		t.Fatalf("NewKafkaWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("KafkaWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	for _, call := range broker.History() {
+		switch request := call.Request.(type) {
+		case *sarama.InitProducerIDRequest:
+			t.Fatalf("KafkaWriter.WriteToQueue(), InitProducerID called by not idempotent producer")
+		case *sarama.ProduceRequest:
+			if request.RequiredAcks != sarama.WaitForLocal {
+				t.Fatalf("KafkaWriter.WriteToQueue(), acks = %v, want %v", request.RequiredAcks, sarama.WaitForLocal)
+			}
+		}
+	}
+}
+
+func TestKafkaWriterUsesQueueNameAsTopic(t *testing.T) {
+	broker := newMockedKafkaBroker(t, "shop_items_bidding", 1)
+	w, err := NewKafkaWriter(KafkaWriterOptions{Brokers: []string{broker.Addr()}})
+	if err != nil {
+		t.Fatalf("NewKafkaWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	if err := w.WriteToQueue("shop_items_bidding", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("KafkaWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	if len(producedPartitions(broker, "shop_items_bidding")) == 0 {
+		t.Fatalf("KafkaWriter.WriteToQueue(), items not published to topic shop_items_bidding")
+	}
+}
+
+func TestKafkaWriterSendFailure(t *testing.T) {
+	broker := newMockedKafkaBroker(t, "shop_items", 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": mockedMetadataResponse(t, broker, "shop_items", 1),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetVersion(3).
+			SetError("shop_items", 0, sarama.ErrNotEnoughReplicas),
+	})
+	w, err := NewKafkaWriter(KafkaWriterOptions{
+		Brokers:    []string{broker.Addr()},
+		MaxRetries: 1,
+		FlushSize:  2,
+	})
+	if err != nil {
+		t.Fatalf("NewKafkaWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	// Unbuffered input checks if it's drained after failure
+	input := make(chan models.ShopItem)
+	go func() {
+		for _, item := range mockedItems {
+			input <- item
+		}
+		close(input)
+	}()
+
+	err = w.WriteToQueue("shop_items", mockedMetadata, input)
+	if !errors.Is(err, sarama.ErrNotEnoughReplicas) {
+		t.Fatalf("KafkaWriter.WriteToQueue(), err = %v, want %v", err, sarama.ErrNotEnoughReplicas)
+	}
+	if !strings.HasPrefix(err.Error(), "2 of 2 messages") {
+		t.Fatalf("KafkaWriter.WriteToQueue(), err = %v, want 2 of 2 messages not sent", err)
+	}
+	if _, ok := <-input; ok {
+		t.Fatalf("KafkaWriter.WriteToQueue(), input not drained after error")
+	}
+}
+
+func TestKafkaWriterCreatesTopic(t *testing.T) {
+	admin := newMockedTopicAdmin()
+	w := newKafkaWriter(
+		KafkaWriterOptions{
+			Topics: map[string]TopicConfig{
+				"shop_items": {Topic: "items", Partitions: 4, ReplicationFactor: 3, Compact: true},
+			},
+		},
+		newTestProducer(t, "items"),
+		admin,
+	)
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("KafkaWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	detail := admin.createdTopics["items"]
+	if detail == nil {
+		t.Fatalf("KafkaWriter.WriteToQueue(), topic items not created")
+	}
+	if detail.NumPartitions != 4 || detail.ReplicationFactor != 3 || *detail.ConfigEntries["cleanup.policy"] != "compact" {
+		t.Fatalf("KafkaWriter.WriteToQueue(), created topic = %+v, want 4 compacted partitions with 3 replicas", detail)
+	}
+}
+
+func TestKafkaWriterCreatesTopicOnce(t *testing.T) {
+	admin := newMockedTopicAdmin()
+	producer := newTestProducer(t, "shop_items")
+	options := KafkaWriterOptions{
+		Topics: map[string]TopicConfig{"shop_items": {Partitions: 2}},
+	}
+	first := newKafkaWriter(options, producer, admin)
+	second := newKafkaWriter(options, producer, admin)
+
+	// Second writer gets "topic already exists" error
+	for _, w := range []*KafkaWriter{first, first, second} {
+		if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+			t.Fatalf("KafkaWriter.WriteToQueue(), err = %v, want nil", err)
+		}
+	}
+
+	if admin.createTopicCalls != 2 {
+		t.Fatalf("KafkaWriter.WriteToQueue(), CreateTopic calls = %d, want 2", admin.createTopicCalls)
+	}
+}
+
+func TestNewProducerMessage(t *testing.T) {
+	item := models.ShopItem{ItemID: "item_1", ProductName: "Product 1", ChangeType: models.ItemUpdated}
+	msg := newProducerMessage("shop_items", item, mockedMetadata)
+
+	key, _ := msg.Key.Encode()
+	value, _ := msg.Value.Encode()
+	var decoded models.ShopItem
+	if err := json.Unmarshal(value, &decoded); err != nil || string(key) != item.ItemID || decoded.ItemID != item.ItemID {
+		t.Fatalf("newProducerMessage(), key = %q, value = %s, want item keyed by ITEM_ID", key, value)
+	}
+	headers := make(map[string]string)
+	for _, header := range msg.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	if headers["x-job-id"] != mockedMetadata.JobId || len(headers["x-item-hash"]) == 0 {
+		t.Fatalf("newProducerMessage(), headers = %v, want feed run metadata", headers)
+	}
+
+	// Removed items are tombstones
+	removed := newProducerMessage("shop_items", models.ShopItem{ItemID: "item_2", ChangeType: models.ItemRemoved}, mockedMetadata)
+	if removed.Value != nil {
+		t.Fatalf("newProducerMessage(removed), value = %v, want nil tombstone", removed.Value)
+	}
+}
+
+func TestNewSaramaConfig(t *testing.T) {
+	config, err := newSaramaConfig(KafkaWriterOptions{Idempotent: true})
+	if err != nil {
+		t.Fatalf("newSaramaConfig(idempotent), err = %v, want nil", err)
+	}
+	if !config.Producer.Idempotent ||
+		config.Producer.RequiredAcks != sarama.WaitForAll ||
+		config.Net.MaxOpenRequests != 1 ||
+		config.Producer.Retry.Max != DefaultMaxRetries {
+		t.Fatalf("newSaramaConfig(idempotent), producer = %+v, want idempotent producer", config.Producer)
+	}
+
+	config, err = newSaramaConfig(KafkaWriterOptions{Acks: AcksLeader})
+	if err != nil {
+		t.Fatalf("newSaramaConfig(leader), err = %v, want nil", err)
+	}
+	if config.Producer.RequiredAcks != sarama.WaitForLocal {
+		t.Fatalf("newSaramaConfig(leader), acks = %d, want %d", config.Producer.RequiredAcks, sarama.WaitForLocal)
+	}
+
+	for _, options := range []KafkaWriterOptions{
+		{Acks: AcksLeader, Idempotent: true},
+		{Acks: "some"},
+	} {
+		if _, err := newSaramaConfig(options); err == nil {
+			t.Fatalf("newSaramaConfig(%+v), err = nil, want error", options)
+		}
+	}
+}
+
+func TestLoadTopicsConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topics.json")
+	os.WriteFile(path, []byte(`{"shop_items": {"topic": "items", "partitions": 12, "compact": true}}`), 0644)
+
+	topics, err := LoadTopicsConfig(path)
+	if err != nil {
+		t.Fatalf("LoadTopicsConfig(), err = %v, want nil", err)
+	}
+
+	expected := map[string]TopicConfig{"shop_items": {Topic: "items", Partitions: 12, Compact: true}}
+	if !reflect.DeepEqual(topics, expected) {
+		t.Fatalf("LoadTopicsConfig(), topics = %+v, want %+v", topics, expected)
+	}
+}
+
+// Returns closed channel with all items
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	return input
+}
+
+// MOCKED BROKER
+
+// Creates in-process Kafka broker speaking the wire protocol, which
+// leads all partitions of topic and accepts all produced messages
+func newMockedKafkaBroker(t *testing.T, topic string, partitions int) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": mockedMetadataResponse(t, broker, topic, partitions),
+		"ProduceRequest":  sarama.NewMockProduceResponse(t).SetVersion(3),
+		"InitProducerIDRequest": sarama.NewMockWrapper(&sarama.InitProducerIDResponse{
+			ProducerID:    1000,
+			ProducerEpoch: 0,
+		}),
+	})
+	t.Cleanup(broker.Close)
+	return broker
+}
+
+// Returns metadata of broker leading all partitions of topic
+func mockedMetadataResponse(
+	t *testing.T,
+	broker *sarama.MockBroker,
+	topic string,
+	partitions int,
+) *sarama.MockMetadataResponse {
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(broker.Addr(), broker.BrokerID()).
+		SetController(broker.BrokerID())
+	for partition := 0; partition < partitions; partition++ {
+		metadata.SetLeader(topic, int32(partition), broker.BrokerID())
+	}
+	return metadata
+}
+
+// Returns partitions of topic which received messages
+func producedPartitions(broker *sarama.MockBroker, topic string) map[int32]bool {
+	partitions := make(map[int32]bool)
+	for _, call := range broker.History() {
+		if response, ok := call.Response.(*sarama.ProduceResponse); ok {
+			for partition := range response.Blocks[topic] {
+				partitions[partition] = true
+			}
+		}
+	}
+	return partitions
+}
+
+// Creates producer of mocked broker leading topic
+func newTestProducer(t *testing.T, topic string) sarama.SyncProducer {
+	broker := newMockedKafkaBroker(t, topic, 2)
+	config, _ := newSaramaConfig(KafkaWriterOptions{})
+	producer, err := sarama.NewSyncProducer([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatalf("sarama.NewSyncProducer(), err = %v, want nil", err)
+	}
+	t.Cleanup(func() { producer.Close() })
+	return producer
+}
+
+// Topic admin stand-in creating topics in memory
+type MockedTopicAdmin struct {
+	mutex            sync.Mutex
+	createdTopics    map[string]*sarama.TopicDetail
+	createTopicCalls int
+}
+
+func newMockedTopicAdmin() *MockedTopicAdmin {
+	return &MockedTopicAdmin{createdTopics: make(map[string]*sarama.TopicDetail)}
+}
+
+func (a *MockedTopicAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.createTopicCalls++
+	if _, ok := a.createdTopics[topic]; ok {
+		return &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}
+	}
+	a.createdTopics[topic] = detail
+	return nil
+}
+
+func (a *MockedTopicAdmin) Close() error {
+	return nil
+}
+
+// MOCKED DATA
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "item_1", ProductName: "Product 1"},
+	{ItemID: "item_2", ProductName: "Product 2"},
+	{ItemID: "item_3", ProductName: "Product 3"},
+	{ItemID: "item_1", ProductName: "Product 1 v2"},
+	{ItemID: "item_4", ProductName: "Product 4"},
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	SchemaVersion: models.SchemaVersion,
+}
@@ -0,0 +1,12 @@
+package kafkawriter
+
+import "github.com/Shopify/sarama"
+
+// Interface of sarama.ClusterAdmin methods used by KafkaWriter
+// for creating topics, made for testing with in-memory broker
+//
+// sarama.ClusterAdmin docs: https://pkg.go.dev/github.com/Shopify/sarama#ClusterAdmin
+type TopicAdminInterface interface {
+	CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error
+	Close() error
+}
@@ -0,0 +1,149 @@
+package queuewriter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Routing key (or subject) built from item fields and feed run metadata,
+// e.g. "shop.{shopId}.cat.{internalCategory}".
+// Placeholders:
+//   - {queue} - name of the output queue
+//   - {feedUrl}, {shopId}, {jobId} - feed run metadata
+//   - {itemId}, {categoryText}, {internalCategory}, ... - item fields by JSON names
+//   - {categoryId} - alias of {internalCategory}
+//   - {param:NAME} - value of item PARAM with PARAM_NAME equal to NAME
+//
+// Substituted values are sanitized, so each of them forms
+// a single word of dot separated topic key
+type KeyTemplate struct {
+	source string
+	parts  []keyPart
+}
+
+// Literal text or placeholder of the template
+type keyPart struct {
+	literal string
+	value   func(queueName string, item *models.ShopItem, metadata *models.PublishMetadata) string
+}
+
+// Compiles key template, returns error on unknown or unclosed placeholder
+func CompileKeyTemplate(source string) (*KeyTemplate, error) {
+	t := &KeyTemplate{source: source}
+	rest := source
+	for len(rest) > 0 {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			t.parts = append(t.parts, keyPart{literal: rest})
+			break
+		}
+		if rest[start] == '}' {
+			return nil, fmt.Errorf("unexpected '}' in key template %q", source)
+		}
+		if start > 0 {
+			t.parts = append(t.parts, keyPart{literal: rest[:start]})
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in key template %q", source)
+		}
+		name := rest[start+1 : start+end]
+		value, err := placeholderValue(name)
+		if err != nil {
+			return nil, fmt.Errorf("key template %q: %w", source, err)
+		}
+		t.parts = append(t.parts, keyPart{value: value})
+		rest = rest[start+end+1:]
+	}
+	return t, nil
+}
+
+// Returns key of item published to queue queueName
+func (t *KeyTemplate) Execute(
+	queueName string,
+	item models.ShopItem,
+	metadata models.PublishMetadata,
+) string {
+	var key strings.Builder
+	for _, part := range t.parts {
+		if part.value == nil {
+			key.WriteString(part.literal)
+			continue
+		}
+		key.WriteString(sanitizeKeyWord(part.value(queueName, &item, &metadata)))
+	}
+	return key.String()
+}
+
+// Returns source of the template
+func (t *KeyTemplate) String() string {
+	return t.source
+}
+
+// Returns accessor of placeholder value
+func placeholderValue(
+	name string,
+) (func(string, *models.ShopItem, *models.PublishMetadata) string, error) {
+	if paramName := strings.TrimPrefix(name, "param:"); paramName != name {
+		return func(_ string, item *models.ShopItem, _ *models.PublishMetadata) string {
+			for _, param := range item.Params {
+				if param.ParamName == paramName {
+					return param.Val
+				}
+			}
+			return ""
+		}, nil
+	}
+	switch name {
+	case "queue":
+		return func(queueName string, _ *models.ShopItem, _ *models.PublishMetadata) string {
+			return queueName
+		}, nil
+	case "feedUrl":
+		return func(_ string, _ *models.ShopItem, m *models.PublishMetadata) string {
+			return m.FeedUrl
+		}, nil
+	case "shopId":
+		return func(_ string, _ *models.ShopItem, m *models.PublishMetadata) string {
+			return m.ShopId
+		}, nil
+	case "jobId":
+		return func(_ string, _ *models.ShopItem, m *models.PublishMetadata) string {
+			return m.JobId
+		}, nil
+	}
+	field, ok := keyItemFields[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown placeholder {%s}", name)
+	}
+	return func(_ string, item *models.ShopItem, _ *models.PublishMetadata) string {
+		return field(item)
+	}, nil
+}
+
+// Item fields available in key templates by their JSON names
+var keyItemFields = map[string]func(item *models.ShopItem) string{
+	"itemId":           func(i *models.ShopItem) string { return i.ItemID },
+	"productNo":        func(i *models.ShopItem) string { return i.ProductNo },
+	"ean":              func(i *models.ShopItem) string { return i.EAN },
+	"itemGroupId":      func(i *models.ShopItem) string { return i.ItemGroupId },
+	"categoryText":     func(i *models.ShopItem) string { return i.CategoryText },
+	"internalCategory": func(i *models.ShopItem) string { return i.InternalCategory },
+	// Alias of internalCategory, the mapped category of the item
+	"categoryId": func(i *models.ShopItem) string { return i.InternalCategory },
+	"changeType": func(i *models.ShopItem) string { return string(i.ChangeType) },
+}
+
+// Replaces separators and wildcards of topic keys and subjects
+// (".", "*", "#", ">" and whitespace) with "_"
+func sanitizeKeyWord(word string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '#', '>', ' ', '\t', '\n', '\r':
+			return '_'
+		}
+		return r
+	}, word)
+}
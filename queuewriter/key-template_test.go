@@ -0,0 +1,91 @@
+package queuewriter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestKeyTemplateExecute(t *testing.T) {
+	testCases := []struct {
+		template    string
+		expectedKey string
+	}{
+		{template: "{queue}", expectedKey: "shop_items"},
+		{template: "shop.{shopId}.cat.{internalCategory}", expectedKey: "shop.shop_1.cat.Home___Garden"},
+		{template: "shop.{shopId}.cat.{categoryId}", expectedKey: "shop.shop_1.cat.Home___Garden"},
+		{template: "items.{param:Color}.{itemId}", expectedKey: "items.dark_red.item_1"},
+		{template: "{jobId}.{ean}", expectedKey: "job_1."},
+		{template: "static", expectedKey: "static"},
+	}
+
+	for _, tc := range testCases {
+		template, err := CompileKeyTemplate(tc.template)
+		if err != nil {
+			t.Fatalf("CompileKeyTemplate(%q), err = %v, want nil", tc.template, err)
+		}
+		key := template.Execute("shop_items", mockedItem, mockedMetadata)
+		if key != tc.expectedKey {
+			t.Fatalf("KeyTemplate(%q).Execute(), key = %q, want %q", tc.template, key, tc.expectedKey)
+		}
+	}
+}
+
+func TestCompileKeyTemplateErrors(t *testing.T) {
+	for _, template := range []string{"shop.{unknown}", "shop.{shopId", "shop.}", "{}"} {
+		if _, err := CompileKeyTemplate(template); err == nil {
+			t.Fatalf("CompileKeyTemplate(%q), err = nil, want error", template)
+		}
+	}
+}
+
+func TestItemHeaders(t *testing.T) {
+	headers := ItemHeaders(mockedItem, mockedMetadata)
+
+	expectedHeaders := map[string]string{
+		HeaderFeedUrl:       "https://example.com/feed.xml",
+		HeaderShopId:        "shop_1",
+		HeaderJobId:         "job_1",
+		HeaderParsedAt:      "2022-03-01T12:00:00Z",
+		HeaderSchemaVersion: models.SchemaVersion,
+	}
+	for name, value := range expectedHeaders {
+		if headers[name] != value {
+			t.Fatalf("ItemHeaders(), %s = %q, want %q", name, headers[name], value)
+		}
+	}
+
+	// Hash changes with item content
+	changedItem := mockedItem
+	changedItem.ProductName = "Changed"
+	if ItemHeaders(changedItem, mockedMetadata)[HeaderItemHash] == headers[HeaderItemHash] {
+		t.Fatalf("ItemHeaders(), %s not changed with item content", HeaderItemHash)
+	}
+
+	// Empty shop id is omitted
+	metadata := mockedMetadata
+	metadata.ShopId = ""
+	if _, ok := ItemHeaders(mockedItem, metadata)[HeaderShopId]; ok {
+		t.Fatalf("ItemHeaders() without shop id, %s is set, want omitted", HeaderShopId)
+	}
+}
+
+// MOCKED DATA
+
+var mockedItem = models.ShopItem{
+	ItemID:           "item_1",
+	ProductName:      "Product 1",
+	InternalCategory: "Home > Garden",
+	Params: []models.ShopItemParam{
+		{ParamName: "Color", Val: "dark red"},
+	},
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	ShopId:        "shop_1",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 13, 0, 0, 0, time.FixedZone("CET", 3600)),
+	SchemaVersion: models.SchemaVersion,
+}
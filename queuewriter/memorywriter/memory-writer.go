@@ -0,0 +1,168 @@
+package memorywriter
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Writer keeping written items in memory, for tests and previews
+// of parsed feeds without publishing them. Every queue keeps a bounded
+// number of messages, items above the limit are only counted
+// Implements queuewriter.QueueWriterInterface
+type MemoryWriter struct {
+	mutex      sync.Mutex
+	maxItems   int
+	keepLatest bool
+	queues     map[string]*queueRecord
+	writes     int
+}
+
+// Retention of captured messages
+type MemoryWriterOptions struct {
+	// Maximal number of messages kept per queue,
+	// all messages are kept when 0
+	MaxItemsPerQueue int
+	// Keep the latest messages instead of the first ones
+	KeepLatest bool
+}
+
+// Captured item with metadata of its feed run
+type Message struct {
+	Metadata models.PublishMetadata
+	Item     models.ShopItem
+}
+
+// Captured messages of a single queue
+type queueRecord struct {
+	messages []Message
+	total    int
+	writes   int
+	metadata models.PublishMetadata
+}
+
+// Creates new MemoryWriter instance
+func NewMemoryWriter(options MemoryWriterOptions) *MemoryWriter {
+	return &MemoryWriter{
+		maxItems:   options.MaxItemsPerQueue,
+		keepLatest: options.KeepLatest,
+		queues:     make(map[string]*queueRecord),
+	}
+}
+
+// Captures all products from shopItemsInput, always reads whole input
+func (w *MemoryWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) error {
+	w.mutex.Lock()
+	w.writes++
+	queue := w.queues[queueName]
+	if queue == nil {
+		queue = &queueRecord{messages: []Message{}}
+		w.queues[queueName] = queue
+	}
+	queue.writes++
+	queue.metadata = metadata
+	w.mutex.Unlock()
+
+	for item := range shopItemsInput {
+		w.mutex.Lock()
+		queue.total++
+		switch {
+		case w.maxItems <= 0 || len(queue.messages) < w.maxItems:
+			queue.messages = append(queue.messages, Message{metadata, item})
+		case w.keepLatest:
+			// Shifting is amortized by compacting only when
+			// the slice doubles
+			queue.messages = append(queue.messages, Message{metadata, item})
+			if len(queue.messages) >= 2*w.maxItems {
+				queue.messages = append(
+					make([]Message, 0, 2*w.maxItems),
+					queue.messages[len(queue.messages)-w.maxItems:]...,
+				)
+			}
+		}
+		w.mutex.Unlock()
+	}
+	return nil
+}
+
+// Returns copy of retained messages of queue, nil when queue
+// hasn't been written
+func (w *MemoryWriter) Messages(queueName string) []Message {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	queue := w.queues[queueName]
+	if queue == nil {
+		return nil
+	}
+	messages := queue.messages
+	if w.maxItems > 0 && len(messages) > w.maxItems {
+		messages = messages[len(messages)-w.maxItems:]
+	}
+	return append([]Message{}, messages...)
+}
+
+// Returns retained items of queue, nil when queue hasn't been written
+func (w *MemoryWriter) Items(queueName string) []models.ShopItem {
+	messages := w.Messages(queueName)
+	if messages == nil {
+		return nil
+	}
+	items := make([]models.ShopItem, len(messages))
+	for idx, message := range messages {
+		items[idx] = message.Item
+	}
+	return items
+}
+
+// Returns metadata of the last write to queue
+func (w *MemoryWriter) Metadata(queueName string) (models.PublishMetadata, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	queue := w.queues[queueName]
+	if queue == nil {
+		return models.PublishMetadata{}, false
+	}
+	return queue.metadata, true
+}
+
+// Returns number of all items written to queue, including not retained
+func (w *MemoryWriter) Total(queueName string) int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if queue := w.queues[queueName]; queue != nil {
+		return queue.total
+	}
+	return 0
+}
+
+// Returns sorted names of written queues
+func (w *MemoryWriter) Queues() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	names := make([]string, 0, len(w.queues))
+	for name := range w.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns number of WriteToQueue calls of all queues
+func (w *MemoryWriter) Writes() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writes
+}
+
+// Removes all captured messages
+func (w *MemoryWriter) Reset() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.queues = make(map[string]*queueRecord)
+	w.writes = 0
+}
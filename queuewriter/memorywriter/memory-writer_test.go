@@ -0,0 +1,91 @@
+package memorywriter
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestMemoryWriterWriteToQueue(t *testing.T) {
+	w := NewMemoryWriter(MemoryWriterOptions{})
+
+	var wg sync.WaitGroup
+	for _, queueName := range []string{"shop_items_bidding", "shop_items"} {
+		wg.Add(1)
+		go func(queueName string) {
+			defer wg.Done()
+			w.WriteToQueue(queueName, mockedMetadata, itemsChannel(mockedItems))
+		}(queueName)
+	}
+	wg.Wait()
+
+	if queues := w.Queues(); !reflect.DeepEqual(queues, []string{"shop_items", "shop_items_bidding"}) {
+		t.Fatalf("MemoryWriter.Queues(), queues = %v, want both written queues", queues)
+	}
+	if !reflect.DeepEqual(w.Items("shop_items"), mockedItems) {
+		t.Fatalf("MemoryWriter.Items(), items = %v, want %v", w.Items("shop_items"), mockedItems)
+	}
+	if metadata, ok := w.Metadata("shop_items"); !ok || metadata.JobId != mockedMetadata.JobId {
+		t.Fatalf("MemoryWriter.Metadata(), metadata = %+v, want %+v", metadata, mockedMetadata)
+	}
+	if w.Writes() != 2 {
+		t.Fatalf("MemoryWriter.Writes(), writes = %d, want 2", w.Writes())
+	}
+	if w.Items("unknown") != nil {
+		t.Fatalf("MemoryWriter.Items(unknown), items = %v, want nil", w.Items("unknown"))
+	}
+
+	w.Reset()
+	if len(w.Queues()) != 0 || w.Writes() != 0 {
+		t.Fatalf("MemoryWriter.Reset(), queues = %v, want none", w.Queues())
+	}
+}
+
+func TestMemoryWriterRetention(t *testing.T) {
+	first := NewMemoryWriter(MemoryWriterOptions{MaxItemsPerQueue: 2})
+	latest := NewMemoryWriter(MemoryWriterOptions{MaxItemsPerQueue: 2, KeepLatest: true})
+
+	for _, w := range []*MemoryWriter{first, latest} {
+		// Twice, so latest messages are compacted
+		w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems))
+		w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems))
+		if w.Total("shop_items") != 2*len(mockedItems) {
+			t.Fatalf("MemoryWriter.Total(), total = %d, want %d", w.Total("shop_items"), 2*len(mockedItems))
+		}
+	}
+
+	if items := first.Items("shop_items"); !reflect.DeepEqual(items, mockedItems[:2]) {
+		t.Fatalf("MemoryWriter.Items(), first items = %v, want %v", items, mockedItems[:2])
+	}
+	if items := latest.Items("shop_items"); !reflect.DeepEqual(items, mockedItems[1:]) {
+		t.Fatalf("MemoryWriter.Items(), latest items = %v, want %v", items, mockedItems[1:])
+	}
+}
+
+// Returns closed channel with all items
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	return input
+}
+
+// MOCKED DATA
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "item_1", ProductName: "Product 1"},
+	{ItemID: "item_2", ProductName: "Product 2"},
+	{ItemID: "item_3", ProductName: "Product 3"},
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	SchemaVersion: models.SchemaVersion,
+}
@@ -0,0 +1,332 @@
+package multiwriter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Composite writer publishing every queue to several sinks at once,
+// failures of sinks are handled by their policies
+// Implements queuewriter.QueueWriterInterface
+type MultiWriter struct {
+	sinks []Sink
+	// Best-effort writes still running in the background
+	background sync.WaitGroup
+}
+
+// Handling of sink failures
+type FailurePolicy string
+
+const (
+	// Sink failure fails the write, slow sink slows down the pipeline
+	Required FailurePolicy = "required"
+	// Sink gets items through a buffer, items are dropped when
+	// the buffer is full and sink failures are only logged.
+	// The write doesn't wait for the sink to finish
+	BestEffort FailurePolicy = "best_effort"
+	// Failed write is retried with all items of the queue,
+	// items are dropped when all retries fail. Queues larger than
+	// RetryBufferSize aren't retried
+	RetryThenDrop FailurePolicy = "retry_then_drop"
+)
+
+// Single output of MultiWriter
+type Sink struct {
+	// Unique name used in logs and metrics
+	Name   string
+	Writer queuewriter.QueueWriterInterface
+	// Failure policy, Required is used when empty
+	Policy FailurePolicy
+	// Buffer size of BestEffort sink, DefaultBufferSize is used when 0
+	BufferSize int
+	// Number of retries of RetryThenDrop sink,
+	// DefaultMaxRetries is used when 0
+	MaxRetries int
+	// Delay before first retry of RetryThenDrop sink, doubled with every
+	// next retry. DefaultRetryDelay is used when 0
+	RetryDelay time.Duration
+	// Maximal number of items of a queue kept in memory for retries
+	// of RetryThenDrop sink. Failed writes of larger queues are dropped
+	// without retries. DefaultRetryBufferSize is used when 0
+	RetryBufferSize int
+}
+
+// Default values of Sink
+const (
+	DefaultBufferSize      = 1000
+	DefaultMaxRetries      = 3
+	DefaultRetryDelay      = time.Second
+	DefaultRetryBufferSize = 10000
+)
+
+// Creates new MultiWriter instance publishing to sinks
+func NewMultiWriter(sinks ...Sink) (*MultiWriter, error) {
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("multi writer without sinks")
+	}
+	names := make(map[string]bool)
+	for idx := range sinks {
+		sink := &sinks[idx]
+		if len(sink.Name) == 0 || names[sink.Name] {
+			return nil, fmt.Errorf("sink names have to be unique and not empty, got %q", sink.Name)
+		}
+		names[sink.Name] = true
+		if sink.Writer == nil {
+			return nil, fmt.Errorf("sink %s has no writer", sink.Name)
+		}
+		switch sink.Policy {
+		case "":
+			sink.Policy = Required
+		case Required, BestEffort, RetryThenDrop:
+		default:
+			return nil, fmt.Errorf("unknown failure policy %q of sink %s", sink.Policy, sink.Name)
+		}
+		if sink.BufferSize <= 0 {
+			sink.BufferSize = DefaultBufferSize
+		}
+		if sink.MaxRetries <= 0 {
+			sink.MaxRetries = DefaultMaxRetries
+		}
+		if sink.RetryDelay <= 0 {
+			sink.RetryDelay = DefaultRetryDelay
+		}
+		if sink.RetryBufferSize <= 0 {
+			sink.RetryBufferSize = DefaultRetryBufferSize
+		}
+	}
+	return &MultiWriter{sinks: sinks}, nil
+}
+
+// Publishes all products from shopItemsInput to all sinks. Returns error
+// of the first failed Required sink, other sinks don't fail the write.
+// shopItemsInput is always drained, also on error
+func (w *MultiWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) error {
+	writes := make([]*sinkWrite, len(w.sinks))
+	for idx, sink := range w.sinks {
+		writes[idx] = w.start(sink, queueName, metadata)
+	}
+
+	// Tee items to all sinks
+	for item := range shopItemsInput {
+		for _, write := range writes {
+			write.send(item)
+		}
+	}
+	for _, write := range writes {
+		close(write.input)
+	}
+
+	var err error
+	for _, write := range writes {
+		if write.sink.Policy == BestEffort {
+			continue
+		}
+		if writeErr := <-write.result; writeErr != nil && err == nil {
+			err = fmt.Errorf("sink %s failed: %w", write.sink.Name, writeErr)
+		}
+	}
+	return err
+}
+
+// Waits for background best-effort writes and closes sinks
+// having Close method
+func (w *MultiWriter) Close() error {
+	w.background.Wait()
+	var err error
+	for _, sink := range w.sinks {
+		if closer, ok := sink.Writer.(interface{ Close() error }); ok {
+			if closeErr := closer.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+// Write of a single queue to a single sink
+type sinkWrite struct {
+	sink  Sink
+	input chan models.ShopItem
+	// Closed when sink writer returns
+	done   chan struct{}
+	result chan error
+	failed bool
+	// Items of the queue kept for retries of RetryThenDrop sink,
+	// released when the queue exceeds RetryBufferSize
+	items    []models.ShopItem
+	count    int
+	overflow bool
+}
+
+// Starts writing queue to sink
+func (w *MultiWriter) start(
+	sink Sink,
+	queueName string,
+	metadata models.PublishMetadata,
+) *sinkWrite {
+	write := &sinkWrite{
+		sink:   sink,
+		input:  make(chan models.ShopItem),
+		done:   make(chan struct{}),
+		result: make(chan error, 1),
+	}
+	if sink.Policy == BestEffort {
+		write.input = make(chan models.ShopItem, sink.BufferSize)
+		w.background.Add(1)
+	}
+
+	go func() {
+		err := sink.Writer.WriteToQueue(queueName, metadata, write.input)
+		close(write.done)
+		switch sink.Policy {
+		case BestEffort:
+			defer w.background.Done()
+			if err != nil {
+				w.logFailure(sink, queueName, err)
+			}
+		case RetryThenDrop:
+			// Wait for all items before releasing or retrying them
+			for range write.input {
+			}
+			if err == nil {
+				write.items = nil
+			} else {
+				err = w.retry(write, queueName, metadata, err)
+			}
+		case Required:
+			if err != nil {
+				sinkFailures.WithLabelValues(sink.Name).Inc()
+			}
+		}
+		write.result <- err
+	}()
+	return write
+}
+
+// Sends item to sink according to its policy
+func (s *sinkWrite) send(item models.ShopItem) {
+	if s.sink.Policy == RetryThenDrop {
+		s.count++
+		if !s.overflow && len(s.items) >= s.sink.RetryBufferSize {
+			s.overflow = true
+			s.items = nil
+		}
+		if !s.overflow {
+			s.items = append(s.items, item)
+		}
+	}
+	if s.failed {
+		return
+	}
+
+	if s.sink.Policy == BestEffort {
+		select {
+		case s.input <- item:
+			sinkItems.WithLabelValues(s.sink.Name).Inc()
+		default:
+			// Slow sink doesn't block other sinks
+			sinkDroppedItems.WithLabelValues(s.sink.Name).Inc()
+		}
+		return
+	}
+
+	select {
+	case s.input <- item:
+		sinkItems.WithLabelValues(s.sink.Name).Inc()
+	case <-s.done:
+		// Writer returned without draining its input
+		s.failed = true
+	}
+}
+
+// Retries failed write of RetryThenDrop sink with all items of the queue,
+// returns nil also when all retries failed and items are dropped
+func (w *MultiWriter) retry(
+	write *sinkWrite,
+	queueName string,
+	metadata models.PublishMetadata,
+	err error,
+) error {
+	defer zap.L().Sync()
+
+	// Items are complete after input is closed and drained
+	items := write.items
+	write.items = nil
+	if write.overflow {
+		err = fmt.Errorf("queue has more than %d items, write isn't retried: %w", write.sink.RetryBufferSize, err)
+		w.logFailure(write.sink, queueName, err)
+		sinkDroppedItems.WithLabelValues(write.sink.Name).Add(float64(write.count))
+		return nil
+	}
+	delay := write.sink.RetryDelay
+	for attempt := 1; attempt <= write.sink.MaxRetries; attempt++ {
+		zap.L().Warn(
+			fmt.Sprintf("Retrying write of queue %s to sink %s", queueName, write.sink.Name),
+			zap.String("sink", write.sink.Name),
+			zap.String("queueName", queueName),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+		time.Sleep(delay)
+		delay *= 2
+		sinkRetries.WithLabelValues(write.sink.Name).Inc()
+
+		input := make(chan models.ShopItem, len(items))
+		for _, item := range items {
+			input <- item
+		}
+		close(input)
+		if err = write.sink.Writer.WriteToQueue(queueName, metadata, input); err == nil {
+			return nil
+		}
+	}
+
+	w.logFailure(write.sink, queueName, err)
+	sinkDroppedItems.WithLabelValues(write.sink.Name).Add(float64(len(items)))
+	return nil
+}
+
+// Logs and counts failure of not required sink
+func (w *MultiWriter) logFailure(sink Sink, queueName string, err error) {
+	defer zap.L().Sync()
+
+	sinkFailures.WithLabelValues(sink.Name).Inc()
+	zap.L().Error(
+		fmt.Sprintf("Cannot write queue %s to sink %s, items are dropped", queueName, sink.Name),
+		zap.String("sink", sink.Name),
+		zap.String("policy", string(sink.Policy)),
+		zap.String("queueName", queueName),
+		zap.Error(err),
+	)
+}
+
+// Prometheus per-sink items, drops, failures and retries
+var (
+	sinkItems = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_sink_items_total",
+		Help: "The total number of ShopItems passed to sinks of multi writer",
+	}, []string{"sink"})
+	sinkDroppedItems = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_sink_dropped_items_total",
+		Help: "The total number of ShopItems dropped by best-effort and retry-then-drop sinks",
+	}, []string{"sink"})
+	sinkFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_sink_failures_total",
+		Help: "The total number of failed writes of sinks",
+	}, []string{"sink"})
+	sinkRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_sink_retries_total",
+		Help: "The total number of retried writes of retry-then-drop sinks",
+	}, []string{"sink"})
+)
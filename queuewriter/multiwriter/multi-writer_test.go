@@ -0,0 +1,263 @@
+package multiwriter
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMultiWriterWriteToQueue(t *testing.T) {
+	required := &MockedQueueWriter{}
+	bestEffort := &MockedQueueWriter{}
+	retried := &MockedQueueWriter{}
+	w, err := NewMultiWriter(
+		Sink{Name: "all_required", Writer: required},
+		Sink{Name: "all_best_effort", Writer: bestEffort, Policy: BestEffort},
+		Sink{Name: "all_retried", Writer: retried, Policy: RetryThenDrop},
+	)
+	if err != nil {
+		t.Fatalf("NewMultiWriter(), err = %v, want nil", err)
+	}
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("MultiWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	w.Close()
+
+	for name, writer := range map[string]*MockedQueueWriter{
+		"required":    required,
+		"best-effort": bestEffort,
+		"retried":     retried,
+	} {
+		if !reflect.DeepEqual(writer.written("shop_items"), mockedItems) {
+			t.Fatalf("MultiWriter.WriteToQueue(), %s sink items = %v, want %v", name, writer.written("shop_items"), mockedItems)
+		}
+	}
+	if !required.closed || !bestEffort.closed {
+		t.Fatalf("MultiWriter.Close(), sinks not closed")
+	}
+}
+
+func TestMultiWriterRequiredFailure(t *testing.T) {
+	failing := &MockedQueueWriter{failures: 1, failAfter: 1}
+	other := &MockedQueueWriter{}
+	w, _ := NewMultiWriter(
+		Sink{Name: "failing_required", Writer: failing},
+		Sink{Name: "other_required", Writer: other},
+	)
+
+	// Unbuffered input checks if it's drained after failure
+	input := make(chan models.ShopItem)
+	go func() {
+		for _, item := range mockedItems {
+			input <- item
+		}
+		close(input)
+	}()
+
+	err := w.WriteToQueue("shop_items", mockedMetadata, input)
+	if !errors.Is(err, errMockedWrite) {
+		t.Fatalf("MultiWriter.WriteToQueue(), err = %v, want %v", err, errMockedWrite)
+	}
+	if _, ok := <-input; ok {
+		t.Fatalf("MultiWriter.WriteToQueue(), input not drained after error")
+	}
+	if !reflect.DeepEqual(other.written("shop_items"), mockedItems) {
+		t.Fatalf("MultiWriter.WriteToQueue(), other sink items = %v, want %v", other.written("shop_items"), mockedItems)
+	}
+}
+
+func TestMultiWriterSlowBestEffortSink(t *testing.T) {
+	slow := &MockedQueueWriter{release: make(chan struct{})}
+	fast := &MockedQueueWriter{}
+	w, _ := NewMultiWriter(
+		Sink{Name: "fast_required", Writer: fast},
+		Sink{Name: "slow_best_effort", Writer: slow, Policy: BestEffort, BufferSize: 2},
+	)
+
+	droppedBefore := testutil.ToFloat64(sinkDroppedItems.WithLabelValues("slow_best_effort"))
+	finished := make(chan error)
+	go func() {
+		finished <- w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems))
+	}()
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatalf("MultiWriter.WriteToQueue(), err = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("MultiWriter.WriteToQueue(), stalled by slow best-effort sink")
+	}
+
+	close(slow.release)
+	w.Close()
+	if len(slow.written("shop_items")) != 2 {
+		t.Fatalf("MultiWriter.WriteToQueue(), slow sink items = %v, want 2 buffered items", slow.written("shop_items"))
+	}
+	if dropped := testutil.ToFloat64(sinkDroppedItems.WithLabelValues("slow_best_effort")) - droppedBefore; dropped != float64(len(mockedItems)-2) {
+		t.Fatalf("MultiWriter.WriteToQueue(), dropped items = %v, want %d", dropped, len(mockedItems)-2)
+	}
+	if !reflect.DeepEqual(fast.written("shop_items"), mockedItems) {
+		t.Fatalf("MultiWriter.WriteToQueue(), fast sink items = %v, want %v", fast.written("shop_items"), mockedItems)
+	}
+}
+
+func TestMultiWriterRetryThenDrop(t *testing.T) {
+	// Fails twice in the middle of the queue
+	flaky := &MockedQueueWriter{failures: 2, failAfter: 2}
+	w, _ := NewMultiWriter(
+		Sink{Name: "flaky_retried", Writer: flaky, Policy: RetryThenDrop, RetryDelay: time.Millisecond},
+	)
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("MultiWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	if flaky.attempts != 3 || !reflect.DeepEqual(flaky.written("shop_items"), mockedItems) {
+		t.Fatalf("MultiWriter.WriteToQueue(), attempts = %d, items = %v, want 3 attempts and all items", flaky.attempts, flaky.written("shop_items"))
+	}
+
+	// Always fails, items are dropped without failing the write
+	droppedBefore := testutil.ToFloat64(sinkDroppedItems.WithLabelValues("broken_retried"))
+	broken := &MockedQueueWriter{failures: 100}
+	w, _ = NewMultiWriter(
+		Sink{Name: "broken_retried", Writer: broken, Policy: RetryThenDrop, MaxRetries: 2, RetryDelay: time.Millisecond},
+	)
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("MultiWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	if broken.attempts != 3 {
+		t.Fatalf("MultiWriter.WriteToQueue(), attempts = %d, want 3", broken.attempts)
+	}
+	if dropped := testutil.ToFloat64(sinkDroppedItems.WithLabelValues("broken_retried")) - droppedBefore; dropped != float64(len(mockedItems)) {
+		t.Fatalf("MultiWriter.WriteToQueue(), dropped items = %v, want %d", dropped, len(mockedItems))
+	}
+}
+
+func TestMultiWriterRetryBufferOverflow(t *testing.T) {
+	// Queue larger than retry buffer isn't retried
+	droppedBefore := testutil.ToFloat64(sinkDroppedItems.WithLabelValues("overflowed_retried"))
+	flaky := &MockedQueueWriter{failures: 1, failAfter: 2}
+	w, _ := NewMultiWriter(
+		Sink{Name: "overflowed_retried", Writer: flaky, Policy: RetryThenDrop, RetryDelay: time.Millisecond, RetryBufferSize: 2},
+	)
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("MultiWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	if flaky.attempts != 1 {
+		t.Fatalf("MultiWriter.WriteToQueue(), attempts = %d, want 1", flaky.attempts)
+	}
+	if dropped := testutil.ToFloat64(sinkDroppedItems.WithLabelValues("overflowed_retried")) - droppedBefore; dropped != float64(len(mockedItems)) {
+		t.Fatalf("MultiWriter.WriteToQueue(), dropped items = %v, want %d", dropped, len(mockedItems))
+	}
+}
+
+func TestNewMultiWriterInvalidSinks(t *testing.T) {
+	writer := &MockedQueueWriter{}
+	for _, sinks := range [][]Sink{
+		{},
+		{{Name: "sink", Writer: writer}, {Name: "sink", Writer: writer}},
+		{{Writer: writer}},
+		{{Name: "sink"}},
+		{{Name: "sink", Writer: writer, Policy: "sometimes"}},
+	} {
+		if _, err := NewMultiWriter(sinks...); err == nil {
+			t.Fatalf("NewMultiWriter(%+v), err = nil, want error", sinks)
+		}
+	}
+}
+
+// Returns closed channel with all items
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	return input
+}
+
+// MOCKED WRITER
+
+var errMockedWrite = errors.New("mocked write error")
+
+// Records items of the last write of every queue
+type MockedQueueWriter struct {
+	mutex sync.Mutex
+	items map[string][]models.ShopItem
+	// Number of failing writes
+	failures int
+	// Number of items read by failing write before failure
+	failAfter int
+	attempts  int
+	// Write waits for this channel to be closed when set
+	release chan struct{}
+	closed  bool
+}
+
+func (w *MockedQueueWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) error {
+	if w.release != nil {
+		<-w.release
+	}
+
+	w.mutex.Lock()
+	w.attempts++
+	failing := w.attempts <= w.failures
+	w.mutex.Unlock()
+
+	items := []models.ShopItem{}
+	for item := range shopItemsInput {
+		if failing && len(items) == w.failAfter {
+			for range shopItemsInput {
+			}
+			return errMockedWrite
+		}
+		items = append(items, item)
+	}
+	if failing {
+		return errMockedWrite
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.items == nil {
+		w.items = make(map[string][]models.ShopItem)
+	}
+	w.items[queueName] = items
+	return nil
+}
+
+func (w *MockedQueueWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (w *MockedQueueWriter) written(queueName string) []models.ShopItem {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.items[queueName]
+}
+
+// MOCKED DATA
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "item_1", ProductName: "Product 1"},
+	{ItemID: "item_2", ProductName: "Product 2"},
+	{ItemID: "item_3", ProductName: "Product 3"},
+	{ItemID: "item_4", ProductName: "Product 4"},
+	{ItemID: "item_5", ProductName: "Product 5"},
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	SchemaVersion: models.SchemaVersion,
+}
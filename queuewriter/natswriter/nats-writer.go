@@ -0,0 +1,281 @@
+package natswriter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Writer for NATS JetStream streams. Every item is published with
+// Nats-Msg-Id based on its hash, so JetStream drops items republished
+// unchanged within duplicates window of the stream
+// Implements queuewriter.QueueWriterInterface
+type NatsWriter struct {
+	connection *nats.Conn
+	jetStream  nats.JetStreamContext
+	subject    *queuewriter.KeyTemplate
+	maxPending int
+	ackTimeout time.Duration
+}
+
+// Connection and publishing options of NATS writer
+type NatsWriterOptions struct {
+	// Server URL, may contain credentials
+	Url string
+	// Subject template of published items (see queuewriter.KeyTemplate),
+	// DefaultSubject is used when empty
+	Subject string
+	// Name of stream created when it doesn't exist,
+	// streams are expected to exist when empty
+	Stream string
+	// Subjects of created stream, required with Stream
+	StreamSubjects []string
+	// Duplicates window of created stream, server default (2 minutes)
+	// is used when 0
+	DuplicatesWindow time.Duration
+	// Maximal number of published items waiting for acknowledgement,
+	// DefaultMaxPending is used when 0
+	MaxPending int
+	// Maximal time of waiting for publish acknowledgements,
+	// DefaultAckTimeout is used when 0
+	AckTimeout time.Duration
+}
+
+// Default values of NatsWriterOptions
+const (
+	DefaultSubject    = "{queue}"
+	DefaultMaxPending = 256
+	DefaultAckTimeout = 5 * time.Second
+)
+
+// Checks options without connecting to the server
+func (o NatsWriterOptions) Validate() error {
+	if len(o.Subject) > 0 {
+		if _, err := queuewriter.CompileKeyTemplate(o.Subject); err != nil {
+			return err
+		}
+	}
+	if len(o.Stream) > 0 && len(o.StreamSubjects) == 0 {
+		return fmt.Errorf("subjects of nats stream %s are not set", o.Stream)
+	}
+	return nil
+}
+
+// Creates new NatsWriter instance connected to options.Url,
+// creates options.Stream when it doesn't exist
+func NewNatsWriter(options NatsWriterOptions) (*NatsWriter, error) {
+	defer zap.L().Sync()
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if len(options.Subject) == 0 {
+		options.Subject = DefaultSubject
+	}
+	subject, err := queuewriter.CompileKeyTemplate(options.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if options.MaxPending <= 0 {
+		options.MaxPending = DefaultMaxPending
+	}
+	if options.AckTimeout <= 0 {
+		options.AckTimeout = DefaultAckTimeout
+	}
+
+	connection, err := nats.Connect(
+		options.Url,
+		nats.Name("feed-parser"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			zap.L().Warn("Disconnected from NATS", zap.Error(err))
+		}),
+		nats.ReconnectHandler(func(connection *nats.Conn) {
+			zap.L().Info("Reconnected to NATS", zap.String("url", connection.ConnectedUrl()))
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	jetStream, err := connection.JetStream(nats.PublishAsyncMaxPending(options.MaxPending))
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	w := &NatsWriter{
+		connection: connection,
+		jetStream:  jetStream,
+		subject:    subject,
+		maxPending: options.MaxPending,
+		ackTimeout: options.AckTimeout,
+	}
+	if len(options.Stream) > 0 {
+		if err := w.ensureStream(options); err != nil {
+			connection.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Creates stream of options when it doesn't exist
+func (w *NatsWriter) ensureStream(options NatsWriterOptions) error {
+	defer zap.L().Sync()
+
+	_, err := w.jetStream.StreamInfo(options.Stream)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return fmt.Errorf("cannot get nats stream %s: %w", options.Stream, err)
+	}
+
+	_, err = w.jetStream.AddStream(&nats.StreamConfig{
+		Name:       options.Stream,
+		Subjects:   options.StreamSubjects,
+		Storage:    nats.FileStorage,
+		Duplicates: options.DuplicatesWindow,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot create nats stream %s: %w", options.Stream, err)
+	}
+	zap.L().Info(
+		fmt.Sprintf("NATS stream %s is ready", options.Stream),
+		zap.String("stream", options.Stream),
+		zap.Strings("subjects", options.StreamSubjects),
+	)
+	return nil
+}
+
+// Publishes all products from shopItemsInput to subjects rendered
+// from subject template and waits for acknowledgements of the stream.
+// Items republished by the same feed run (within duplicates window
+// of the stream) are acknowledged as duplicates and not stored again.
+// shopItemsInput is always drained, also on error
+func (w *NatsWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) (err error) {
+	defer func() {
+		if err != nil {
+			// Drain input so upstream stages don't block
+			for range shopItemsInput {
+			}
+		}
+	}()
+
+	pending := make([]nats.PubAckFuture, 0, w.maxPending)
+	for item := range shopItemsInput {
+		future, err := w.jetStream.PublishMsgAsync(w.newMsg(queueName, item, metadata))
+		if err != nil {
+			publishedShopItemsFailures.Inc()
+			w.abandon(pending)
+			return err
+		}
+		pending = append(pending, future)
+		if len(pending) < w.maxPending {
+			continue
+		}
+		if err := w.waitForAcks(pending); err != nil {
+			return err
+		}
+		pending = pending[:0]
+	}
+	return w.waitForAcks(pending)
+}
+
+// Closes the connection
+func (w *NatsWriter) Close() error {
+	w.connection.Close()
+	return nil
+}
+
+// Returns message of item with feed run metadata headers
+// and Nats-Msg-Id used for deduplication
+func (w *NatsWriter) newMsg(
+	queueName string,
+	item models.ShopItem,
+	metadata models.PublishMetadata,
+) *nats.Msg {
+	msg := nats.NewMsg(w.subject.Execute(queueName, item, metadata))
+	msg.Data, _ = json.Marshal(item)
+	headers := queuewriter.ItemHeaders(item, metadata)
+	for name, value := range headers {
+		msg.Header.Set(name, value)
+	}
+	msg.Header.Set(nats.MsgIdHdr, messageId(msg.Subject, item, metadata, headers[queuewriter.HeaderItemHash]))
+	return msg
+}
+
+// Returns deduplication id of item published to subject by a feed run.
+// Subject is included, so the same item can be published to different
+// subjects of a single stream. Feed run and change type are included,
+// because item hash ignores change type and the same content may be
+// published again by later runs, e.g. when removed item is added back
+func messageId(
+	subject string,
+	item models.ShopItem,
+	metadata models.PublishMetadata,
+	itemHash string,
+) string {
+	run := metadata.JobId
+	if len(run) == 0 {
+		run = metadata.ParsedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return subject + ":" + run + ":" + string(item.ChangeType) + ":" + itemHash
+}
+
+// Waits for acknowledgements of all pending messages
+func (w *NatsWriter) waitForAcks(pending []nats.PubAckFuture) error {
+	timeout := time.NewTimer(w.ackTimeout)
+	defer timeout.Stop()
+
+	for idx, future := range pending {
+		select {
+		case ack := <-future.Ok():
+			publishedShopItems.Inc()
+			if ack.Duplicate {
+				duplicatedShopItems.Inc()
+			}
+		case err := <-future.Err():
+			publishedShopItemsFailures.Inc()
+			w.abandon(pending[idx+1:])
+			return fmt.Errorf("nats message %s not acknowledged: %w", future.Msg().Subject, err)
+		case <-timeout.C:
+			w.abandon(pending[idx:])
+			return fmt.Errorf("nats message %s not acknowledged: %w", future.Msg().Subject, nats.ErrTimeout)
+		}
+	}
+	return nil
+}
+
+// Counts not acknowledged messages as failures
+func (w *NatsWriter) abandon(pending []nats.PubAckFuture) {
+	publishedShopItemsFailures.Add(float64(len(pending)))
+}
+
+// Prometheus published, duplicated and failed shop items
+var (
+	publishedShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_nats_published_items_total",
+		Help: "The total number of ShopItems published to NATS JetStream",
+	})
+	duplicatedShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_nats_duplicated_items_total",
+		Help: "The total number of ShopItems acknowledged by NATS JetStream as duplicates",
+	})
+	publishedShopItemsFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_nats_published_items_failures_total",
+		Help: "The total number of failures in publishing ShopItems to NATS JetStream",
+	})
+)
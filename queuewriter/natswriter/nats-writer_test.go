@@ -0,0 +1,229 @@
+package natswriter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func TestNatsWriterWriteToQueue(t *testing.T) {
+	url := runServer(t)
+	w, err := NewNatsWriter(NatsWriterOptions{
+		Url:            url,
+		Subject:        "{queue}.{itemId}",
+		Stream:         "ITEMS",
+		StreamSubjects: []string{"shop_items.>"},
+		MaxPending:     2,
+	})
+	if err != nil {
+		t.Fatalf("NewNatsWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("NatsWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	messages := streamMessages(t, w.jetStream, "ITEMS")
+	if len(messages) != len(mockedItems) {
+		t.Fatalf("NatsWriter.WriteToQueue(), stored %d messages, want %d", len(messages), len(mockedItems))
+	}
+	for idx, msg := range messages {
+		var item models.ShopItem
+		if err := json.Unmarshal(msg.Data, &item); err != nil {
+			t.Fatalf("NatsWriter.WriteToQueue(), invalid message data: %v", err)
+		}
+		if !reflect.DeepEqual(item, mockedItems[idx]) {
+			t.Fatalf("NatsWriter.WriteToQueue(), item = %+v, want %+v", item, mockedItems[idx])
+		}
+		if msg.Subject != "shop_items."+item.ItemID {
+			t.Fatalf("NatsWriter.WriteToQueue(), subject = %s, want shop_items.%s", msg.Subject, item.ItemID)
+		}
+		if msg.Header.Get(queuewriter.HeaderJobId) != mockedMetadata.JobId {
+			t.Fatalf("NatsWriter.WriteToQueue(), headers = %v, want feed run metadata", msg.Header)
+		}
+		expectedId := messageId(msg.Subject, item, mockedMetadata, msg.Header.Get(queuewriter.HeaderItemHash))
+		if msg.Header.Get(nats.MsgIdHdr) != expectedId {
+			t.Fatalf("NatsWriter.WriteToQueue(), message id = %s, want %s", msg.Header.Get(nats.MsgIdHdr), expectedId)
+		}
+	}
+}
+
+func TestNatsWriterDeduplicatesItems(t *testing.T) {
+	url := runServer(t)
+	w, err := NewNatsWriter(NatsWriterOptions{
+		Url:            url,
+		Stream:         "ITEMS",
+		StreamSubjects: []string{"shop_items", "shop_items_bidding"},
+	})
+	if err != nil {
+		t.Fatalf("NewNatsWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	// Republishing of the same run is a duplicate, other queue has separate message ids
+	for _, queueName := range []string{"shop_items", "shop_items", "shop_items_bidding"} {
+		if err := w.WriteToQueue(queueName, mockedMetadata, itemsChannel(mockedItems)); err != nil {
+			t.Fatalf("NatsWriter.WriteToQueue(%s), err = %v, want nil", queueName, err)
+		}
+	}
+	// Changed item is published again
+	changed := []models.ShopItem{{ItemID: "item_1", ProductName: "Product 1 v3"}}
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(changed)); err != nil {
+		t.Fatalf("NatsWriter.WriteToQueue(changed), err = %v, want nil", err)
+	}
+
+	info, err := w.jetStream.StreamInfo("ITEMS")
+	if err != nil {
+		t.Fatalf("StreamInfo(), err = %v, want nil", err)
+	}
+	if info.State.Msgs != uint64(2*len(mockedItems)+1) {
+		t.Fatalf("NatsWriter.WriteToQueue(), stored %d messages, want %d", info.State.Msgs, 2*len(mockedItems)+1)
+	}
+}
+
+func TestNatsWriterPublishesRepeatedChanges(t *testing.T) {
+	url := runServer(t)
+	w, err := NewNatsWriter(NatsWriterOptions{
+		Url:            url,
+		Stream:         "ITEMS",
+		StreamSubjects: []string{"shop_items_delta"},
+	})
+	if err != nil {
+		t.Fatalf("NewNatsWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	// Item is added, removed and added back with the same content by later runs
+	item := models.ShopItem{ItemID: "item_1", ProductName: "Product 1"}
+	for idx, changeType := range []models.ChangeType{models.ItemAdded, models.ItemRemoved, models.ItemAdded} {
+		metadata := mockedMetadata
+		metadata.JobId = fmt.Sprintf("job_%d", idx+1)
+		item.ChangeType = changeType
+		if err := w.WriteToQueue("shop_items_delta", metadata, itemsChannel([]models.ShopItem{item})); err != nil {
+			t.Fatalf("NatsWriter.WriteToQueue(%s), err = %v, want nil", changeType, err)
+		}
+	}
+
+	messages := streamMessages(t, w.jetStream, "ITEMS")
+	if len(messages) != 3 {
+		t.Fatalf("NatsWriter.WriteToQueue(), stored %d messages, want 3", len(messages))
+	}
+	var last models.ShopItem
+	json.Unmarshal(messages[2].Data, &last)
+	if last.ChangeType != models.ItemAdded {
+		t.Fatalf("NatsWriter.WriteToQueue(), last change = %s, want %s", last.ChangeType, models.ItemAdded)
+	}
+}
+
+func TestNatsWriterNoStream(t *testing.T) {
+	url := runServer(t)
+	w, err := NewNatsWriter(NatsWriterOptions{Url: url, MaxPending: 2})
+	if err != nil {
+		t.Fatalf("NewNatsWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	// Unbuffered input checks if it's drained after failure
+	input := make(chan models.ShopItem)
+	go func() {
+		for _, item := range mockedItems {
+			input <- item
+		}
+		close(input)
+	}()
+
+	err = w.WriteToQueue("shop_items", mockedMetadata, input)
+	if !errors.Is(err, nats.ErrNoResponders) {
+		t.Fatalf("NatsWriter.WriteToQueue(), err = %v, want %v", err, nats.ErrNoResponders)
+	}
+	if _, ok := <-input; ok {
+		t.Fatalf("NatsWriter.WriteToQueue(), input not drained after error")
+	}
+}
+
+func TestNewNatsWriterInvalidOptions(t *testing.T) {
+	url := runServer(t)
+	for _, options := range []NatsWriterOptions{
+		{Url: url, Subject: "{unknown}"},
+		{Url: url, Stream: "ITEMS"},
+	} {
+		if _, err := NewNatsWriter(options); err == nil {
+			t.Fatalf("NewNatsWriter(%+v), err = nil, want error", options)
+		}
+	}
+}
+
+// Starts embedded JetStream server stopped at the end of the test,
+// returns its URL
+func runServer(t *testing.T) string {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("server.NewServer(), err = %v, want nil", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatalf("NATS server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s.ClientURL()
+}
+
+// Returns all messages of stream in order
+func streamMessages(t *testing.T, jetStream nats.JetStreamContext, stream string) []*nats.RawStreamMsg {
+	info, err := jetStream.StreamInfo(stream)
+	if err != nil {
+		t.Fatalf("StreamInfo(), err = %v, want nil", err)
+	}
+	messages := []*nats.RawStreamMsg{}
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq && info.State.Msgs > 0; seq++ {
+		msg, err := jetStream.GetMsg(stream, seq)
+		if err != nil {
+			t.Fatalf("GetMsg(%d), err = %v, want nil", seq, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// Returns closed channel with all items
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	return input
+}
+
+// MOCKED DATA
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "item_1", ProductName: "Product 1"},
+	{ItemID: "item_2", ProductName: "Product 2"},
+	{ItemID: "item_3", ProductName: "Product 3"},
+	{ItemID: "item_1", ProductName: "Product 1 v2"},
+	{ItemID: "item_4", ProductName: "Product 4"},
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	SchemaVersion: models.SchemaVersion,
+}
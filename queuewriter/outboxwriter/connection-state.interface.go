@@ -0,0 +1,8 @@
+package outboxwriter
+
+// Interface of queue writers knowing state of their broker connection,
+// e.g. rabbitwriter.RabbitWriter. Failed writes of writers without it
+// are treated as unavailable broker only for network errors
+type ConnectionStateInterface interface {
+	IsConnected() bool
+}
@@ -0,0 +1,412 @@
+package outboxwriter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Writer storing items in durable disk outbox when the broker of wrapped
+// writer is unavailable. Background relay publishes stored items in order
+// once the broker recovers. Items are stored also when the outbox is not
+// empty, so they don't overtake older ones
+// Implements queuewriter.QueueWriterInterface
+type OutboxWriter struct {
+	writer        queuewriter.QueueWriterInterface
+	dir           string
+	log           *SegmentLog
+	batchSize     int
+	pendingWindow int
+	relayInterval time.Duration
+	// Batches rejected by the broker too many times
+	deadLetter       *SegmentLog
+	maxRelayAttempts int
+	// Failed relays of the batch at the head of the outbox, which ends
+	// at failedHead
+	failedHead   Position
+	headFailures int
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// Location of the outbox and relay options
+type OutboxWriterOptions struct {
+	// Directory of outbox segments
+	Dir string
+	// Size of a single segment file, DefaultSegmentSize is used when 0
+	SegmentSize int64
+	// Maximal number of items relayed in a single write,
+	// DefaultRelayBatchSize is used when 0
+	RelayBatchSize int
+	// Interval of checking the outbox and the broker,
+	// DefaultRelayInterval is used when 0
+	RelayInterval time.Duration
+	// Maximal number of items of a direct write kept in memory until
+	// the write is confirmed, older ones are spilled to a temporary file
+	// in Dir. DefaultPendingWindow is used when 0
+	PendingWindow int
+	// Number of relays of a batch failing while the broker is connected
+	// after which the batch is moved to dead-letter segments in
+	// Dir/dead-letter, DefaultMaxRelayAttempts is used when 0
+	MaxRelayAttempts int
+}
+
+// Default values of OutboxWriterOptions
+const (
+	DefaultRelayBatchSize   = 500
+	DefaultRelayInterval    = time.Second
+	DefaultPendingWindow    = 10000
+	DefaultMaxRelayAttempts = 5
+)
+
+// Subdirectory of the outbox with dead-letter segments
+const deadLetterDir = "dead-letter"
+
+// Creates new OutboxWriter instance wrapping writer
+// and starts relay of items already in the outbox
+func NewOutboxWriter(
+	writer queuewriter.QueueWriterInterface,
+	options OutboxWriterOptions,
+) (*OutboxWriter, error) {
+	if len(options.Dir) == 0 {
+		return nil, fmt.Errorf("outbox directory is not set")
+	}
+	log, err := OpenSegmentLog(options.Dir, options.SegmentSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open outbox %s: %w", options.Dir, err)
+	}
+	if options.RelayBatchSize <= 0 {
+		options.RelayBatchSize = DefaultRelayBatchSize
+	}
+	if options.RelayInterval <= 0 {
+		options.RelayInterval = DefaultRelayInterval
+	}
+	if options.PendingWindow <= 0 {
+		options.PendingWindow = DefaultPendingWindow
+	}
+	if options.MaxRelayAttempts <= 0 {
+		options.MaxRelayAttempts = DefaultMaxRelayAttempts
+	}
+	deadLetter, err := openSegmentLog(filepath.Join(options.Dir, deadLetterDir), options.SegmentSize, deadLetterItems)
+	if err != nil {
+		log.Close()
+		return nil, fmt.Errorf("cannot open outbox dead-letter segments: %w", err)
+	}
+	removeSpillFiles(options.Dir)
+
+	w := &OutboxWriter{
+		writer:           writer,
+		dir:              options.Dir,
+		log:              log,
+		batchSize:        options.RelayBatchSize,
+		pendingWindow:    options.PendingWindow,
+		relayInterval:    options.RelayInterval,
+		deadLetter:       deadLetter,
+		maxRelayAttempts: options.MaxRelayAttempts,
+		stop:             make(chan struct{}),
+	}
+	w.stopped.Add(1)
+	go w.runRelay()
+	return w, nil
+}
+
+// Publishes all products from shopItemsInput with wrapped writer. When the
+// broker is unavailable or the outbox is not empty, items are stored in
+// the outbox instead and nil is returned. Items of a write failed because
+// of the broker are stored as well, so some of them may be published twice.
+// Until the write is confirmed only the last PendingWindow items are kept
+// in memory, older ones are spilled to a temporary file in the outbox.
+// shopItemsInput is always drained, also on error
+func (w *OutboxWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) error {
+	if w.log.Len() > 0 || !w.isConnected() {
+		return w.store(queueName, metadata, nil, shopItemsInput)
+	}
+
+	// Items passed to the writer are kept for storing them on failure
+	passed := &pendingItems{dir: w.dir, window: w.pendingWindow}
+	defer passed.discard()
+	writerInput := make(chan models.ShopItem)
+	result := make(chan error, 1)
+	go func() {
+		result <- w.writer.WriteToQueue(queueName, metadata, writerInput)
+	}()
+
+	var err error
+	returned := false
+	for item := range shopItemsInput {
+		passed.add(item)
+		select {
+		case writerInput <- item:
+			continue
+		case err = <-result:
+			returned = true
+		}
+		// Writer returned without reading all items
+		break
+	}
+	close(writerInput)
+	if !returned {
+		err = <-result
+	}
+
+	if err == nil || !w.isUnavailable(err) {
+		for range shopItemsInput {
+		}
+		return err
+	}
+	zap.L().Warn(
+		fmt.Sprintf("Broker unavailable, storing queue %s in outbox", queueName),
+		zap.String("queueName", queueName),
+		zap.Error(err),
+	)
+	return w.store(queueName, metadata, passed, shopItemsInput)
+}
+
+// Number of items waiting in the outbox
+func (w *OutboxWriter) Backlog() int {
+	return w.log.Len()
+}
+
+// Stops the relay and closes the outbox
+func (w *OutboxWriter) Close() error {
+	close(w.stop)
+	w.stopped.Wait()
+	w.deadLetter.Close()
+	return w.log.Close()
+}
+
+// Appends pending items, when not nil, and rest of the input to the outbox
+func (w *OutboxWriter) store(
+	queueName string,
+	metadata models.PublishMetadata,
+	pending *pendingItems,
+	shopItemsInput chan models.ShopItem,
+) (err error) {
+	defer func() {
+		if err != nil {
+			// Drain input so upstream stages don't block
+			for range shopItemsInput {
+			}
+		}
+	}()
+
+	entries := make([]Entry, 0, w.batchSize)
+	flush := func() error {
+		if len(entries) == 0 {
+			return nil
+		}
+		if err := w.log.Append(entries); err != nil {
+			return err
+		}
+		storedShopItems.Add(float64(len(entries)))
+		entries = entries[:0]
+		return nil
+	}
+	add := func(item models.ShopItem) error {
+		entries = append(entries, Entry{queueName, metadata, item})
+		if len(entries) < w.batchSize {
+			return nil
+		}
+		return flush()
+	}
+	if pending != nil {
+		if err := pending.each(add); err != nil {
+			return err
+		}
+	}
+	for item := range shopItemsInput {
+		if err := add(item); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// Checks if wrapped writer is connected to its broker
+func (w *OutboxWriter) isConnected() bool {
+	if state, ok := w.writer.(ConnectionStateInterface); ok {
+		return state.IsConnected()
+	}
+	return true
+}
+
+// Checks if failed write is caused by unavailable broker. Writers without
+// connection state are unavailable only on network errors, so messages
+// rejected by the broker aren't stored
+func (w *OutboxWriter) isUnavailable(err error) bool {
+	if state, ok := w.writer.(ConnectionStateInterface); ok {
+		return !state.IsConnected()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Relays the outbox every relayInterval until Close
+func (w *OutboxWriter) runRelay() {
+	defer w.stopped.Done()
+
+	ticker := time.NewTicker(w.relayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.relay()
+		}
+	}
+}
+
+// Publishes items from the outbox in order until it's empty, the broker
+// is unavailable or the writer is closed
+func (w *OutboxWriter) relay() {
+	defer zap.L().Sync()
+
+	for w.log.Len() > 0 && w.isConnected() {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		records, err := w.log.Read(w.batchSize)
+		if err != nil {
+			zap.L().Error("Cannot read outbox", zap.Error(err))
+			return
+		}
+		if len(records) == 0 {
+			return
+		}
+
+		// Consecutive items of the same feed run and queue are relayed
+		// in a single write
+		for len(records) > 0 {
+			size := 1
+			for size < len(records) && sameWrite(records[0].Entry, records[size].Entry) {
+				size++
+			}
+			if err := w.relayWrite(records[:size]); err != nil {
+				relayFailures.Inc()
+				zap.L().Warn(
+					"Cannot relay items from outbox",
+					zap.String("queueName", records[0].Entry.Queue),
+					zap.Int("backlog", w.log.Len()),
+					zap.Error(err),
+				)
+				if w.isUnavailable(err) || !w.rejected(records[size-1].Next) {
+					return
+				}
+				if err := w.moveToDeadLetter(records[:size]); err != nil {
+					zap.L().Error("Cannot move items to outbox dead-letter segments", zap.Error(err))
+					return
+				}
+			}
+			records = records[size:]
+		}
+	}
+}
+
+// Publishes records of a single queue and commits them
+func (w *OutboxWriter) relayWrite(records []Record) error {
+	input := make(chan models.ShopItem, len(records))
+	for _, record := range records {
+		input <- record.Entry.Item
+	}
+	close(input)
+
+	entry := records[0].Entry
+	if err := w.writer.WriteToQueue(entry.Queue, entry.Metadata, input); err != nil {
+		return err
+	}
+	relayedShopItems.Add(float64(len(records)))
+	return w.log.Commit(records[len(records)-1].Next, len(records))
+}
+
+// Counts failed relay of batch at the head of the outbox ending at next,
+// returns true when it has failed maxRelayAttempts times
+func (w *OutboxWriter) rejected(next Position) bool {
+	if next != w.failedHead {
+		w.failedHead = next
+		w.headFailures = 0
+	}
+	w.headFailures++
+	return w.headFailures >= w.maxRelayAttempts
+}
+
+// Moves records from the head of the outbox to dead-letter segments,
+// so they don't block items behind them
+func (w *OutboxWriter) moveToDeadLetter(records []Record) error {
+	defer zap.L().Sync()
+
+	entries := make([]Entry, len(records))
+	for idx, record := range records {
+		entries[idx] = record.Entry
+	}
+	if err := w.deadLetter.Append(entries); err != nil {
+		return err
+	}
+	zap.L().Error(
+		fmt.Sprintf("Items rejected %d times, moved to outbox dead-letter segments", w.headFailures),
+		zap.String("queueName", entries[0].Queue),
+		zap.String("feedUrl", entries[0].Metadata.FeedUrl),
+		zap.Int("items", len(entries)),
+	)
+	deadLetteredShopItems.Add(float64(len(entries)))
+	w.headFailures = 0
+	return w.log.Commit(records[len(records)-1].Next, len(records))
+}
+
+// Number of items in dead-letter segments
+func (w *OutboxWriter) DeadLetters() int {
+	return w.deadLetter.Len()
+}
+
+// Checks if entries belong to the same write
+func sameWrite(a Entry, b Entry) bool {
+	return a.Queue == b.Queue &&
+		a.Metadata.JobId == b.Metadata.JobId &&
+		a.Metadata.FeedUrl == b.Metadata.FeedUrl
+}
+
+// Prometheus outbox backlog, stored, relayed, failed relays
+// and dead-lettered items
+var (
+	backlogItems = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "feedparser_outbox_backlog_items",
+		Help: "The number of ShopItems waiting in the outbox",
+	})
+	storedShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_outbox_stored_items_total",
+		Help: "The total number of ShopItems stored in the outbox",
+	})
+	relayedShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_outbox_relayed_items_total",
+		Help: "The total number of ShopItems relayed from the outbox",
+	})
+	relayFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_outbox_relay_failures_total",
+		Help: "The total number of failed relays of the outbox",
+	})
+	deadLetterItems = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "feedparser_outbox_dead_letter_items",
+		Help: "The number of ShopItems in outbox dead-letter segments",
+	})
+	deadLetteredShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_outbox_dead_lettered_items_total",
+		Help: "The total number of ShopItems moved to outbox dead-letter segments after repeated relay failures",
+	})
+)
@@ -0,0 +1,295 @@
+package outboxwriter
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+)
+
+func TestOutboxWriterConnected(t *testing.T) {
+	writer := newMockedQueueWriter(true)
+	w, err := NewOutboxWriter(writer, OutboxWriterOptions{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewOutboxWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("OutboxWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(writer.published("shop_items"), mockedItems) || w.Backlog() != 0 {
+		t.Fatalf("OutboxWriter.WriteToQueue(), published = %v, backlog = %d, want items published directly", writer.published("shop_items"), w.Backlog())
+	}
+}
+
+func TestOutboxWriterRelaysAfterRecovery(t *testing.T) {
+	writer := newMockedQueueWriter(false)
+	w, _ := NewOutboxWriter(writer, OutboxWriterOptions{
+		Dir:            t.TempDir(),
+		RelayBatchSize: 2,
+		RelayInterval:  10 * time.Millisecond,
+	})
+	defer w.Close()
+
+	// Broker is down, items are stored
+	for _, queueName := range []string{"shop_items", "shop_items_bidding"} {
+		if err := w.WriteToQueue(queueName, mockedMetadata, itemsChannel(mockedItems)); err != nil {
+			t.Fatalf("OutboxWriter.WriteToQueue(), err = %v, want nil", err)
+		}
+	}
+	if w.Backlog() != 2*len(mockedItems) || len(writer.published("shop_items")) != 0 {
+		t.Fatalf("OutboxWriter.WriteToQueue(), backlog = %d, want %d stored items", w.Backlog(), 2*len(mockedItems))
+	}
+
+	// Broker recovers, stored items are relayed in order
+	writer.setConnected(true)
+	waitFor(t, func() bool { return w.Backlog() == 0 })
+	for _, queueName := range []string{"shop_items", "shop_items_bidding"} {
+		if !reflect.DeepEqual(writer.published(queueName), mockedItems) {
+			t.Fatalf("OutboxWriter relay, %s published = %v, want %v", queueName, writer.published(queueName), mockedItems)
+		}
+	}
+	if writer.metadata.JobId != mockedMetadata.JobId {
+		t.Fatalf("OutboxWriter relay, metadata = %+v, want %+v", writer.metadata, mockedMetadata)
+	}
+}
+
+func TestOutboxWriterStoresFailedWrite(t *testing.T) {
+	writer := newMockedQueueWriter(true)
+	// Connection is lost after 2 items
+	writer.disconnectAfter = 2
+	w, _ := NewOutboxWriter(writer, OutboxWriterOptions{Dir: t.TempDir(), RelayInterval: time.Hour})
+	defer w.Close()
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("OutboxWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	if w.Backlog() != len(mockedItems) {
+		t.Fatalf("OutboxWriter.WriteToQueue(), backlog = %d, want %d", w.Backlog(), len(mockedItems))
+	}
+
+	// Next writes go to the outbox behind stored items
+	writer.setConnected(true)
+	w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems))
+	if w.Backlog() != 2*len(mockedItems) {
+		t.Fatalf("OutboxWriter.WriteToQueue(), backlog = %d, want %d", w.Backlog(), 2*len(mockedItems))
+	}
+}
+
+func TestOutboxWriterReturnsRejectedWrite(t *testing.T) {
+	mockedWriter := newMockedQueueWriter(true)
+	mockedWriter.rejectedQueue = "shop_items"
+	// Writer without connection state
+	writer := struct {
+		queuewriter.QueueWriterInterface
+	}{mockedWriter}
+	w, _ := NewOutboxWriter(writer, OutboxWriterOptions{Dir: t.TempDir(), RelayInterval: time.Hour})
+	defer w.Close()
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); !errors.Is(err, errMockedRejected) {
+		t.Fatalf("OutboxWriter.WriteToQueue(), err = %v, want %v", err, errMockedRejected)
+	}
+	if w.Backlog() != 0 {
+		t.Fatalf("OutboxWriter.WriteToQueue(), backlog = %d, want 0", w.Backlog())
+	}
+}
+
+func TestOutboxWriterMovesRejectedBatchToDeadLetter(t *testing.T) {
+	writer := newMockedQueueWriter(false)
+	w, _ := NewOutboxWriter(writer, OutboxWriterOptions{
+		Dir:              t.TempDir(),
+		RelayInterval:    10 * time.Millisecond,
+		MaxRelayAttempts: 2,
+	})
+	defer w.Close()
+
+	for _, queueName := range []string{"shop_items_rejected", "shop_items"} {
+		w.WriteToQueue(queueName, mockedMetadata, itemsChannel(mockedItems))
+	}
+
+	// Broker recovers, but rejects items of the first queue
+	writer.mutex.Lock()
+	writer.rejectedQueue = "shop_items_rejected"
+	writer.mutex.Unlock()
+	writer.setConnected(true)
+	waitFor(t, func() bool { return w.Backlog() == 0 })
+	if w.DeadLetters() != len(mockedItems) {
+		t.Fatalf("OutboxWriter relay, dead letters = %d, want %d", w.DeadLetters(), len(mockedItems))
+	}
+	if !reflect.DeepEqual(writer.published("shop_items"), mockedItems) {
+		t.Fatalf("OutboxWriter relay, published = %v, want %v", writer.published("shop_items"), mockedItems)
+	}
+}
+
+func TestOutboxWriterSpillsPendingItems(t *testing.T) {
+	dir := t.TempDir()
+	writer := newMockedQueueWriter(true)
+	w, _ := NewOutboxWriter(writer, OutboxWriterOptions{Dir: dir, RelayInterval: time.Hour, PendingWindow: 2})
+	defer w.Close()
+
+	// Connection is lost after items spilled to disk were published
+	writer.disconnectAfter = 4
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("OutboxWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	records, _ := w.log.Read(len(mockedItems) + 1)
+	stored := []models.ShopItem{}
+	for _, record := range records {
+		stored = append(stored, record.Entry.Item)
+	}
+	if !reflect.DeepEqual(stored, mockedItems) {
+		t.Fatalf("OutboxWriter.WriteToQueue(), stored = %v, want %v", stored, mockedItems)
+	}
+	if spilled, _ := filepath.Glob(filepath.Join(dir, spillPattern)); len(spilled) != 0 {
+		t.Fatalf("OutboxWriter.WriteToQueue(), spill files = %v, want removed", spilled)
+	}
+}
+
+func TestOutboxWriterKeepsBacklogAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	writer := newMockedQueueWriter(false)
+	w, _ := NewOutboxWriter(writer, OutboxWriterOptions{Dir: dir, RelayInterval: 10 * time.Millisecond})
+	w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems))
+	w.Close()
+
+	writer.setConnected(true)
+	w, _ = NewOutboxWriter(writer, OutboxWriterOptions{Dir: dir, RelayInterval: 10 * time.Millisecond})
+	defer w.Close()
+	waitFor(t, func() bool { return w.Backlog() == 0 })
+	if !reflect.DeepEqual(writer.published("shop_items"), mockedItems) {
+		t.Fatalf("OutboxWriter relay, published = %v, want %v", writer.published("shop_items"), mockedItems)
+	}
+}
+
+// Fails the test when condition isn't met within a second
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Returns closed channel with all items
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	return input
+}
+
+// MOCKED WRITER
+
+var (
+	errMockedDisconnected = errors.New("mocked broker disconnected")
+	errMockedRejected     = errors.New("mocked message rejected")
+)
+
+// Queue writer with switchable broker connection,
+// implements ConnectionStateInterface
+type MockedQueueWriter struct {
+	mutex     sync.Mutex
+	connected bool
+	// Connection is lost after publishing this number of items when set
+	disconnectAfter int
+	// Items of this queue are rejected by the broker
+	rejectedQueue string
+	items         map[string][]models.ShopItem
+	metadata      models.PublishMetadata
+}
+
+func newMockedQueueWriter(connected bool) *MockedQueueWriter {
+	return &MockedQueueWriter{
+		connected: connected,
+		items:     make(map[string][]models.ShopItem),
+	}
+}
+
+func (w *MockedQueueWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	published := 0
+	for item := range shopItemsInput {
+		if w.disconnectAfter > 0 && published == w.disconnectAfter {
+			w.connected = false
+			w.disconnectAfter = 0
+		}
+		if !w.connected {
+			for range shopItemsInput {
+			}
+			return errMockedDisconnected
+		}
+		if queueName == w.rejectedQueue {
+			for range shopItemsInput {
+			}
+			return errMockedRejected
+		}
+		w.items[queueName] = append(w.items[queueName], item)
+		w.metadata = metadata
+		published++
+	}
+	return nil
+}
+
+func (w *MockedQueueWriter) IsConnected() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.connected
+}
+
+func (w *MockedQueueWriter) setConnected(connected bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.connected = connected
+}
+
+func (w *MockedQueueWriter) published(queueName string) []models.ShopItem {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.items[queueName]
+}
+
+// MOCKED DATA
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "item_1", ProductName: "Product 1"},
+	{ItemID: "item_2", ProductName: "Product 2"},
+	{ItemID: "item_3", ProductName: "Product 3"},
+	{ItemID: "item_4", ProductName: "Product 4"},
+	{ItemID: "item_5", ProductName: "Product 5"},
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	SchemaVersion: models.SchemaVersion,
+}
+
+// Returns count entries of queue with item ids from "item_0"
+func mockedEntries(queue string, count int) []Entry {
+	entries := make([]Entry, count)
+	for idx := range entries {
+		entries[idx] = Entry{
+			Queue:    queue,
+			Metadata: mockedMetadata,
+			Item:     models.ShopItem{ItemID: fmt.Sprintf("item_%d", idx)},
+		}
+	}
+	return entries
+}
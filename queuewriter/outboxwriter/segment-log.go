@@ -0,0 +1,374 @@
+package outboxwriter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Item waiting in the outbox with its queue and feed run metadata
+type Entry struct {
+	Queue    string                 `json:"queue"`
+	Metadata models.PublishMetadata `json:"metadata"`
+	Item     models.ShopItem        `json:"item"`
+}
+
+// Position in the log, offset of a record in a segment
+type Position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Entry read from the log with position of the next record
+type Record struct {
+	Entry Entry
+	Next  Position
+}
+
+// Durable append-only log of entries split into segment files.
+// Every record is prefixed with its length and CRC32 checksum, so a
+// record torn by a crash is detected and dropped on open. Read position
+// is kept in cursor file and fully read segments are removed
+type SegmentLog struct {
+	dir         string
+	segmentSize int64
+
+	mutex      sync.Mutex
+	segments   []uint64
+	active     *os.File
+	activeSize int64
+	cursor     Position
+	backlog    int
+	// Gauge reporting backlog of the log
+	gauge prometheus.Gauge
+}
+
+// Default size of a single segment file
+const DefaultSegmentSize = 64 * 1024 * 1024
+
+const (
+	segmentSuffix = ".seg"
+	cursorFile    = "cursor"
+	// Length and checksum of record
+	recordHeaderSize = 8
+	// Records above this size are treated as corrupted
+	maxRecordSize = 64 * 1024 * 1024
+)
+
+// Opens log in dir, creating it when it doesn't exist.
+// DefaultSegmentSize is used when segmentSize is 0
+func OpenSegmentLog(dir string, segmentSize int64) (*SegmentLog, error) {
+	return openSegmentLog(dir, segmentSize, backlogItems)
+}
+
+// Opens log in dir reporting its backlog with gauge
+func openSegmentLog(dir string, segmentSize int64, gauge prometheus.Gauge) (*SegmentLog, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &SegmentLog{dir: dir, segmentSize: segmentSize, gauge: gauge}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, id)
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+	if len(l.segments) == 0 {
+		l.segments = []uint64{1}
+	}
+
+	if err := l.readCursor(); err != nil {
+		return nil, err
+	}
+	if err := l.openActive(); err != nil {
+		return nil, err
+	}
+	if err := l.countBacklog(); err != nil {
+		l.active.Close()
+		return nil, err
+	}
+	l.removeReadSegments()
+	l.gauge.Set(float64(l.backlog))
+	return l, nil
+}
+
+// Appends entries to the log and syncs it to disk
+func (l *SegmentLog) Append(entries []Entry) error {
+	var buffer bytes.Buffer
+	for _, entry := range entries {
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		var header [recordHeaderSize]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+		buffer.Write(header[:])
+		buffer.Write(payload)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	written, err := l.active.Write(buffer.Bytes())
+	if err == nil {
+		err = l.active.Sync()
+	}
+	if err != nil {
+		// Drop partially written records, so next appends are readable
+		l.active.Truncate(l.activeSize)
+		l.active.Seek(l.activeSize, io.SeekStart)
+		return fmt.Errorf("cannot append to outbox: %w", err)
+	}
+	l.activeSize += int64(written)
+	l.backlog += len(entries)
+	l.gauge.Set(float64(l.backlog))
+
+	if l.activeSize >= l.segmentSize {
+		return l.rotate()
+	}
+	return nil
+}
+
+// Reads up to max records from the cursor, without moving it
+func (l *SegmentLog) Read(max int) ([]Record, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	records := []Record{}
+	position := l.cursor
+	for len(records) < max {
+		_, err := l.scan(position, func(payload []byte, next Position) bool {
+			var entry Entry
+			if json.Unmarshal(payload, &entry) != nil {
+				// Written by Append, so it's always valid
+				return false
+			}
+			records = append(records, Record{Entry: entry, Next: next})
+			position = next
+			return len(records) < max
+		})
+		if err != nil {
+			return nil, err
+		}
+		next, ok := l.nextSegment(position.Segment)
+		if len(records) == max || !ok {
+			break
+		}
+		position = Position{Segment: next}
+	}
+	return records, nil
+}
+
+// Moves the cursor to position after count read records
+// and removes fully read segments
+func (l *SegmentLog) Commit(position Position, count int) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	content, _ := json.Marshal(position)
+	path := filepath.Join(l.dir, cursorFile)
+	if err := os.WriteFile(path+".tmp", content, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	l.cursor = position
+	l.backlog -= count
+	if l.backlog < 0 {
+		l.backlog = 0
+	}
+	l.gauge.Set(float64(l.backlog))
+	l.removeReadSegments()
+	return nil
+}
+
+// Number of not committed entries
+func (l *SegmentLog) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.backlog
+}
+
+// Closes the active segment
+func (l *SegmentLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.active.Close()
+}
+
+// Reads cursor file, cursor is at the first segment when it's missing
+func (l *SegmentLog) readCursor() error {
+	content, err := os.ReadFile(filepath.Join(l.dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		l.cursor = Position{Segment: l.segments[0]}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, &l.cursor); err != nil {
+		return fmt.Errorf("invalid outbox cursor: %w", err)
+	}
+	if l.cursor.Segment < l.segments[0] {
+		l.cursor = Position{Segment: l.segments[0]}
+	}
+	return nil
+}
+
+// Opens the last segment for appending, records torn by a crash are cut off
+func (l *SegmentLog) openActive() error {
+	id := l.segments[len(l.segments)-1]
+	end, err := l.scan(Position{Segment: id}, func([]byte, Position) bool { return true })
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.segmentPath(id), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(end); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Seek(end, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	l.active = file
+	l.activeSize = end
+	return nil
+}
+
+// Counts records after the cursor
+func (l *SegmentLog) countBacklog() error {
+	for _, id := range l.segments {
+		if id < l.cursor.Segment {
+			continue
+		}
+		position := Position{Segment: id}
+		if id == l.cursor.Segment {
+			position = l.cursor
+		}
+		_, err := l.scan(position, func([]byte, Position) bool {
+			l.backlog++
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Starts next segment
+func (l *SegmentLog) rotate() error {
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+	id := l.segments[len(l.segments)-1] + 1
+	file, err := os.OpenFile(l.segmentPath(id), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, id)
+	l.active = file
+	l.activeSize = 0
+	return nil
+}
+
+// Removes segments before the cursor
+func (l *SegmentLog) removeReadSegments() {
+	for len(l.segments) > 1 && l.segments[0] < l.cursor.Segment {
+		os.Remove(l.segmentPath(l.segments[0]))
+		l.segments = l.segments[1:]
+	}
+}
+
+// Returns id of segment after id
+func (l *SegmentLog) nextSegment(id uint64) (uint64, bool) {
+	for _, segment := range l.segments {
+		if segment > id {
+			return segment, true
+		}
+	}
+	return 0, false
+}
+
+// Calls fn with payloads of valid records from position until fn returns
+// false, returns offset after the last valid record
+func (l *SegmentLog) scan(
+	position Position,
+	fn func(payload []byte, next Position) bool,
+) (int64, error) {
+	file, err := os.Open(l.segmentPath(position.Segment))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(position.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	offset := position.Offset
+	var header [recordHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			// End of segment or torn header
+			return offset, nil
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		if size > maxRecordSize {
+			return offset, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			return offset, nil
+		}
+		offset += recordHeaderSize + int64(size)
+		if !fn(payload, Position{Segment: position.Segment, Offset: offset}) {
+			return offset, nil
+		}
+	}
+}
+
+// Returns path of segment file
+func (l *SegmentLog) segmentPath(id uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+}
@@ -0,0 +1,110 @@
+package outboxwriter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSegmentLog(t *testing.T) {
+	dir := t.TempDir()
+	log, err := OpenSegmentLog(dir, 0)
+	if err != nil {
+		t.Fatalf("OpenSegmentLog(), err = %v, want nil", err)
+	}
+
+	if err := log.Append(mockedEntries("shop_items", 5)); err != nil {
+		t.Fatalf("SegmentLog.Append(), err = %v, want nil", err)
+	}
+	records, err := log.Read(3)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("SegmentLog.Read(3), records = %d, err = %v, want 3 records", len(records), err)
+	}
+	if records[0].Entry.Item.ItemID != "item_0" || records[2].Entry.Item.ItemID != "item_2" {
+		t.Fatalf("SegmentLog.Read(3), records = %+v, want first 3 entries", records)
+	}
+	if err := log.Commit(records[2].Next, 3); err != nil {
+		t.Fatalf("SegmentLog.Commit(), err = %v, want nil", err)
+	}
+	log.Close()
+
+	// Cursor and backlog survive reopening
+	log, err = OpenSegmentLog(dir, 0)
+	if err != nil {
+		t.Fatalf("OpenSegmentLog(), err = %v, want nil", err)
+	}
+	defer log.Close()
+	if log.Len() != 2 {
+		t.Fatalf("SegmentLog.Len(), len = %d, want 2", log.Len())
+	}
+	records, _ = log.Read(10)
+	if len(records) != 2 || records[0].Entry.Item.ItemID != "item_3" {
+		t.Fatalf("SegmentLog.Read(10), records = %+v, want last 2 entries", records)
+	}
+}
+
+func TestSegmentLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	// Every append starts a new segment
+	log, _ := OpenSegmentLog(dir, 1)
+	defer log.Close()
+
+	for _, queue := range []string{"queue_a", "queue_b", "queue_c"} {
+		if err := log.Append(mockedEntries(queue, 2)); err != nil {
+			t.Fatalf("SegmentLog.Append(), err = %v, want nil", err)
+		}
+	}
+	if segments := countSegments(t, dir); segments != 4 {
+		t.Fatalf("SegmentLog.Append(), segments = %d, want 4", segments)
+	}
+
+	// Reads across segments
+	records, _ := log.Read(5)
+	if len(records) != 5 || records[4].Entry.Queue != "queue_c" {
+		t.Fatalf("SegmentLog.Read(5), records = %+v, want 5 records of 3 segments", records)
+	}
+	log.Commit(records[4].Next, 5)
+	if segments := countSegments(t, dir); segments != 2 {
+		t.Fatalf("SegmentLog.Commit(), segments = %d, want 2", segments)
+	}
+	if log.Len() != 1 {
+		t.Fatalf("SegmentLog.Len(), len = %d, want 1", log.Len())
+	}
+}
+
+func TestSegmentLogTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	log, _ := OpenSegmentLog(dir, 0)
+	log.Append(mockedEntries("shop_items", 2))
+	log.Close()
+
+	// Simulate crash in the middle of appending a record
+	segment, _ := os.OpenFile(filepath.Join(dir, "00000000000000000001.seg"), os.O_APPEND|os.O_WRONLY, 0644)
+	segment.Write([]byte{0, 0, 1, 0, 1, 2, 3, 4, '{', '"'})
+	segment.Close()
+
+	log, err := OpenSegmentLog(dir, 0)
+	if err != nil {
+		t.Fatalf("OpenSegmentLog(), err = %v, want nil", err)
+	}
+	defer log.Close()
+	if log.Len() != 2 {
+		t.Fatalf("SegmentLog.Len(), len = %d, want 2", log.Len())
+	}
+
+	// Appends after torn record are readable
+	log.Append(mockedEntries("shop_items_bidding", 1))
+	records, _ := log.Read(10)
+	if len(records) != 3 || records[2].Entry.Queue != "shop_items_bidding" {
+		t.Fatalf("SegmentLog.Read(10), records = %+v, want 3 records", records)
+	}
+}
+
+// Returns number of segment files in dir
+func countSegments(t *testing.T, dir string) int {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatalf("Glob(), err = %v, want nil", err)
+	}
+	return len(matches)
+}
@@ -0,0 +1,143 @@
+package outboxwriter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Pattern of spill file names in the outbox directory
+const spillPattern = "pending-*.tmp"
+
+// Temporary file with items passed to the wrapped writer which don't fit
+// into the in-memory window of a write. It's read back only when the write
+// fails and removed afterwards, so it isn't synced to disk
+type spillFile struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// Creates new empty spill file in dir
+func createSpillFile(dir string) (*spillFile, error) {
+	file, err := os.CreateTemp(dir, spillPattern)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bufio.NewWriter(file)
+	return &spillFile{
+		file:    file,
+		buffer:  buffer,
+		encoder: json.NewEncoder(buffer),
+	}, nil
+}
+
+// Appends items to the file
+func (s *spillFile) add(items []models.ShopItem) error {
+	for idx := range items {
+		if err := s.encoder.Encode(&items[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls handle with every item of the file in order
+func (s *spillFile) replay(handle func(item models.ShopItem) error) error {
+	if err := s.buffer.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bufio.NewReader(s.file))
+	for {
+		var item models.ShopItem
+		if err := decoder.Decode(&item); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := handle(item); err != nil {
+			return err
+		}
+	}
+}
+
+// Closes and deletes the file
+func (s *spillFile) remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// Deletes spill files left by writes interrupted by a crash
+func removeSpillFiles(dir string) {
+	names, _ := filepath.Glob(filepath.Join(dir, spillPattern))
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
+
+// Items of a write which aren't confirmed by the wrapped writer yet.
+// Only the last window of them is kept in memory, older ones are
+// spilled to a temporary file in dir
+type pendingItems struct {
+	dir    string
+	window int
+	items  []models.ShopItem
+	spill  *spillFile
+	// Spilling failed, items can't be stored anymore
+	err error
+}
+
+// Adds item passed to the wrapped writer
+func (p *pendingItems) add(item models.ShopItem) {
+	if p.err != nil {
+		return
+	}
+	if len(p.items) >= p.window {
+		if p.spill == nil {
+			p.spill, p.err = createSpillFile(p.dir)
+		}
+		if p.err == nil {
+			p.err = p.spill.add(p.items)
+		}
+		p.items = p.items[:0]
+		if p.err != nil {
+			return
+		}
+	}
+	p.items = append(p.items, item)
+}
+
+// Calls handle with every pending item in order
+func (p *pendingItems) each(handle func(item models.ShopItem) error) error {
+	if p.err != nil {
+		return fmt.Errorf("cannot spill pending items: %w", p.err)
+	}
+	if p.spill != nil {
+		if err := p.spill.replay(handle); err != nil {
+			return fmt.Errorf("cannot read spilled items: %w", err)
+		}
+	}
+	for _, item := range p.items {
+		if err := handle(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Forgets pending items and removes the spill file
+func (p *pendingItems) discard() {
+	if p.spill != nil {
+		p.spill.remove()
+		p.spill = nil
+	}
+	p.items = nil
+}
@@ -3,5 +3,11 @@ package queuewriter
 import "github.com/MichalMitros/feed-parser/models"
 
 type QueueWriterInterface interface {
-	WriteToQueue(queueName string, shopItems chan models.ShopItem) error
+	// Sends shop items to queue queueName, metadata of the feed run
+	// is published with every item
+	WriteToQueue(
+		queueName string,
+		metadata models.PublishMetadata,
+		shopItems chan models.ShopItem,
+	) error
 }
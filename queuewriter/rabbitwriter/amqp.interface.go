@@ -0,0 +1,54 @@
+package rabbitwriter
+
+import "github.com/streadway/amqp"
+
+// Interface of amqp.Connection methods used by ConnectionManager,
+// made for testing with in-process broker stand-in
+//
+// amqp.Connection docs: https://pkg.go.dev/github.com/streadway/amqp#Connection
+type AmqpConnectionInterface interface {
+	Channel() (AmqpChannelInterface, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking
+	Close() error
+}
+
+// Interface of amqp.Channel methods used by RabbitWriter and other users
+// of ConnectionManager, e.g. rabbitbroker.RabbitBroker
+//
+// amqp.Channel docs: https://pkg.go.dev/github.com/streadway/amqp#Channel
+type AmqpChannelInterface interface {
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// Opens new AMQP connection
+type Dialer func(url string) (AmqpConnectionInterface, error)
+
+// Dialer of real RabbitMQ connections
+func DialAmqp(url string) (AmqpConnectionInterface, error) {
+	connection, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return amqpConnection{connection}, nil
+}
+
+// Wrapper of amqp.Connection returning channels as AmqpChannelInterface
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+func (c amqpConnection) Channel() (AmqpChannelInterface, error) {
+	return c.Connection.Channel()
+}
@@ -0,0 +1,575 @@
+package rabbitwriter
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+)
+
+// Returned when ConnectionManager is already closed
+var ErrManagerClosed = errors.New("rabbitmq connection manager is closed")
+
+// Options of ConnectionManager
+type ConnectionManagerOptions struct {
+	// Name of the connection in metrics, e.g. "sink"
+	Name string
+	// Maximal number of idle channels kept for reuse
+	ChannelPoolSize int
+	// Buffer size of publisher confirmations and returned messages
+	// of each channel
+	ConfirmBufferSize int
+	// First delay between reconnection attempts, doubled after every failure
+	ReconnectInitialDelay time.Duration
+	// Maximal delay between reconnection attempts
+	ReconnectMaxDelay time.Duration
+	// Prepares every established connection before it's used, e.g.
+	// declares queues. Connection failing setup is closed and counts
+	// as failed connection attempt
+	Setup func(connection AmqpConnectionInterface) error
+	// Don't fail when the first connection attempt fails,
+	// reconnect in background like after connection loss
+	ConnectInBackground bool
+}
+
+// Manager of a single shared RabbitMQ connection.
+// Watches the connection with NotifyClose and NotifyBlocked,
+// reconnects with exponential backoff when the connection is lost
+// and keeps pool of channels in confirm mode
+type ConnectionManager struct {
+	url     string
+	dial    Dialer
+	options ConnectionManagerOptions
+
+	mutex      sync.Mutex
+	connection AmqpConnectionInterface
+	generation uint64
+	// Closed when connection is established
+	connected chan struct{}
+	// Closed when connection is not blocked by the broker
+	unblocked chan struct{}
+	pool      []*managedChannel
+	closed    bool
+	done      chan struct{}
+}
+
+// Channel in confirm mode with its own confirmations listener.
+// Mandatory messages are returned by the broker before they are
+// confirmed when they are unroutable
+type managedChannel struct {
+	AmqpChannelInterface
+	confirms        chan amqp.Confirmation
+	returns         chan amqp.Return
+	closes          chan *amqp.Error
+	nextDeliveryTag uint64
+	generation      uint64
+}
+
+// Publishes message and returns its delivery tag
+func (c *managedChannel) publish(
+	exchange string,
+	key string,
+	mandatory bool,
+	msg amqp.Publishing,
+) (uint64, error) {
+	if err := c.Publish(exchange, key, mandatory, false, msg); err != nil {
+		return 0, err
+	}
+	tag := c.nextDeliveryTag
+	c.nextDeliveryTag++
+	return tag, nil
+}
+
+// Checks if channel has not been closed
+func (c *managedChannel) isOpen() bool {
+	select {
+	case <-c.closes:
+		return false
+	default:
+		return true
+	}
+}
+
+// Connects to RabbitMQ and creates new ConnectionManager instance.
+// Returns error when the connection fails, unless ConnectInBackground
+// is set
+func NewConnectionManager(
+	url string,
+	dial Dialer,
+	options ConnectionManagerOptions,
+) (*ConnectionManager, error) {
+	m := &ConnectionManager{
+		url:       url,
+		dial:      dial,
+		options:   options,
+		connected: make(chan struct{}),
+		unblocked: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	close(m.unblocked)
+
+	connection, notifications, err := m.connect()
+	if err != nil {
+		if !options.ConnectInBackground {
+			return nil, err
+		}
+		defer zap.L().Sync()
+		zap.L().Warn("Cannot connect to RabbitMQ, reconnecting in background", zap.Error(err))
+		go func() {
+			if notifications, ok := m.reconnect(); ok {
+				m.watch(notifications)
+			}
+		}()
+		return m, nil
+	}
+	m.setConnection(connection)
+	go m.watch(notifications)
+
+	return m, nil
+}
+
+// Dials new connection, registers its notifications and sets it up
+func (m *ConnectionManager) connect() (AmqpConnectionInterface, connectionNotifications, error) {
+	connection, err := m.dial(m.url)
+	if err != nil {
+		return nil, connectionNotifications{}, err
+	}
+	notifications := listen(connection)
+	if m.options.Setup != nil {
+		if err := m.options.Setup(connection); err != nil {
+			connection.Close()
+			return nil, connectionNotifications{}, err
+		}
+	}
+	return connection, notifications, nil
+}
+
+// Close and blocked notifications of a single connection
+type connectionNotifications struct {
+	closes chan *amqp.Error
+	blocks chan amqp.Blocking
+}
+
+// Registers notifications of connection, must be called before the
+// connection is used, so its loss is not missed
+func listen(connection AmqpConnectionInterface) connectionNotifications {
+	return connectionNotifications{
+		closes: connection.NotifyClose(make(chan *amqp.Error, 1)),
+		blocks: connection.NotifyBlocked(make(chan amqp.Blocking, 1)),
+	}
+}
+
+// Watches connection and reconnects when it's lost
+func (m *ConnectionManager) watch(notifications connectionNotifications) {
+	defer zap.L().Sync()
+
+	for {
+		closes := notifications.closes
+		blocks := notifications.blocks
+
+		lost := false
+		for !lost {
+			select {
+			case <-m.done:
+				return
+			case blocking, ok := <-blocks:
+				if !ok {
+					// Stop selecting closed channel
+					blocks = nil
+					continue
+				}
+				m.setBlocked(blocking)
+			case err := <-closes:
+				if m.isClosed() {
+					return
+				}
+				zap.L().Error("RabbitMQ connection lost", zap.Error(err))
+				m.clearConnection()
+				lost = true
+			}
+		}
+
+		var ok bool
+		notifications, ok = m.reconnect()
+		if !ok {
+			return
+		}
+	}
+}
+
+// Reconnects with exponential backoff until success or manager close,
+// returns notifications of the new connection
+func (m *ConnectionManager) reconnect() (connectionNotifications, bool) {
+	defer zap.L().Sync()
+
+	delay := m.options.ReconnectInitialDelay
+	for attempt := 1; ; attempt++ {
+		// Add up to 20% jitter, so instances don't reconnect at once
+		jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+		select {
+		case <-m.done:
+			return connectionNotifications{}, false
+		case <-time.After(delay + jitter):
+		}
+
+		connection, notifications, err := m.connect()
+		if err == nil {
+			m.setConnection(connection)
+			reconnects.WithLabelValues(m.options.Name).Inc()
+			zap.L().Info(
+				fmt.Sprintf("RabbitMQ connection restored after %d attempts", attempt),
+			)
+			return notifications, true
+		}
+		zap.L().Warn(
+			"RabbitMQ reconnection failed",
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+
+		delay *= 2
+		if delay > m.options.ReconnectMaxDelay {
+			delay = m.options.ReconnectMaxDelay
+		}
+	}
+}
+
+// Sets new established connection
+func (m *ConnectionManager) setConnection(connection AmqpConnectionInterface) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		connection.Close()
+		return
+	}
+	m.connection = connection
+	m.generation++
+	close(m.connected)
+	connectionUp.WithLabelValues(m.options.Name).Set(1)
+}
+
+// Removes lost connection and closes its pooled channels
+func (m *ConnectionManager) clearConnection() {
+	m.mutex.Lock()
+	pool := m.pool
+	m.pool = nil
+	m.connection = nil
+	m.connected = make(chan struct{})
+	m.unblock()
+	m.mutex.Unlock()
+
+	connectionUp.WithLabelValues(m.options.Name).Set(0)
+	for _, ch := range pool {
+		ch.Close()
+	}
+}
+
+// Sets blocked state of the connection
+func (m *ConnectionManager) setBlocked(blocking amqp.Blocking) {
+	defer zap.L().Sync()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if blocking.Active {
+		zap.L().Warn("RabbitMQ connection blocked", zap.String("reason", blocking.Reason))
+		select {
+		case <-m.unblocked:
+			m.unblocked = make(chan struct{})
+			connectionBlocked.WithLabelValues(m.options.Name).Set(1)
+		default:
+		}
+		return
+	}
+	zap.L().Info("RabbitMQ connection unblocked")
+	m.unblock()
+}
+
+// Marks connection as unblocked, requires locked mutex
+func (m *ConnectionManager) unblock() {
+	select {
+	case <-m.unblocked:
+	default:
+		close(m.unblocked)
+		connectionBlocked.WithLabelValues(m.options.Name).Set(0)
+	}
+}
+
+func (m *ConnectionManager) isClosed() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.closed
+}
+
+// Checks if connection is currently established
+func (m *ConnectionManager) IsConnected() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.connection != nil
+}
+
+// Returns channel from the pool or opens new one in confirm mode.
+// Waits up to timeout for the connection when it's lost
+func (m *ConnectionManager) getChannel(timeout time.Duration) (*managedChannel, error) {
+	deadline := time.After(timeout)
+	for {
+		m.mutex.Lock()
+		if m.closed {
+			m.mutex.Unlock()
+			return nil, ErrManagerClosed
+		}
+		if m.connection == nil {
+			connected := m.connected
+			m.mutex.Unlock()
+			select {
+			case <-connected:
+				continue
+			case <-m.done:
+				return nil, ErrManagerClosed
+			case <-deadline:
+				return nil, fmt.Errorf("rabbitmq connection not restored within %s", timeout)
+			}
+		}
+
+		// Reuse pooled channel
+		if len(m.pool) > 0 {
+			ch := m.pool[len(m.pool)-1]
+			m.pool = m.pool[:len(m.pool)-1]
+			m.mutex.Unlock()
+			if ch.isOpen() {
+				return ch, nil
+			}
+			continue
+		}
+
+		connection := m.connection
+		generation := m.generation
+		m.mutex.Unlock()
+
+		ch, err := m.openChannel(connection, generation)
+		if err != nil {
+			// Channel can't be opened on closing connection,
+			// wait for the watcher to notice it
+			select {
+			case <-deadline:
+				return nil, err
+			case <-time.After(m.options.ReconnectInitialDelay):
+				continue
+			}
+		}
+		return ch, nil
+	}
+}
+
+// Opens new channel of the current connection, e.g. for consuming
+// or for exclusive queues, which has to be closed by the caller.
+// Waits up to timeout for the connection when it's lost
+func (m *ConnectionManager) Channel(timeout time.Duration) (AmqpChannelInterface, error) {
+	deadline := time.After(timeout)
+	for {
+		m.mutex.Lock()
+		if m.closed {
+			m.mutex.Unlock()
+			return nil, ErrManagerClosed
+		}
+		connection := m.connection
+		connected := m.connected
+		m.mutex.Unlock()
+
+		if connection == nil {
+			select {
+			case <-connected:
+				continue
+			case <-m.done:
+				return nil, ErrManagerClosed
+			case <-deadline:
+				return nil, fmt.Errorf("rabbitmq connection not restored within %s", timeout)
+			}
+		}
+		ch, err := connection.Channel()
+		if err != nil {
+			// Wait for the watcher to notice closing connection
+			select {
+			case <-deadline:
+				return nil, err
+			case <-time.After(m.options.ReconnectInitialDelay):
+				continue
+			}
+		}
+		return ch, nil
+	}
+}
+
+// Publishes single message on pooled channel and waits for its
+// confirmation. Mandatory message returned by the broker because no
+// queue is bound to its routing key is an error. Waits up to timeout
+// for lost connection, for blocked connection and for the confirmation
+func (m *ConnectionManager) Publish(
+	exchange string,
+	key string,
+	mandatory bool,
+	msg amqp.Publishing,
+	timeout time.Duration,
+) error {
+	ch, err := m.getChannel(timeout)
+	if err != nil {
+		return err
+	}
+	if err := m.waitUnblocked(timeout); err != nil {
+		m.releaseChannel(ch, true)
+		return err
+	}
+	tag, err := ch.publish(exchange, key, mandatory, msg)
+	if err != nil {
+		m.releaseChannel(ch, false)
+		return err
+	}
+
+	// Broker returns message before confirming it
+	returned := false
+	deadline := time.After(timeout)
+	for {
+		select {
+		case _, ok := <-ch.returns:
+			if !ok {
+				m.releaseChannel(ch, false)
+				return fmt.Errorf("rabbitmq channel closed before confirmation")
+			}
+			returned = true
+		case confirm, ok := <-ch.confirms:
+			if !ok {
+				m.releaseChannel(ch, false)
+				return fmt.Errorf("rabbitmq channel closed before confirmation")
+			}
+			if confirm.DeliveryTag != tag {
+				continue
+			}
+			// Return may wait in its channel next to the confirmation
+			select {
+			case _, ok := <-ch.returns:
+				returned = returned || ok
+			default:
+			}
+			m.releaseChannel(ch, true)
+			if returned {
+				return fmt.Errorf("message with routing key %s returned by broker, no queue is bound to it", key)
+			}
+			if !confirm.Ack {
+				return fmt.Errorf("message rejected by rabbitmq")
+			}
+			return nil
+		case <-deadline:
+			m.releaseChannel(ch, false)
+			return fmt.Errorf("rabbitmq confirmation not received within %s", timeout)
+		}
+	}
+}
+
+// Opens new channel in confirm mode
+func (m *ConnectionManager) openChannel(
+	connection AmqpConnectionInterface,
+	generation uint64,
+) (*managedChannel, error) {
+	ch, err := connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	openedChannels.WithLabelValues(m.options.Name).Inc()
+	return &managedChannel{
+		AmqpChannelInterface: ch,
+		confirms:             ch.NotifyPublish(make(chan amqp.Confirmation, m.options.ConfirmBufferSize)),
+		returns:              ch.NotifyReturn(make(chan amqp.Return, m.options.ConfirmBufferSize)),
+		closes:               ch.NotifyClose(make(chan *amqp.Error, 1)),
+		nextDeliveryTag:      1,
+		generation:           generation,
+	}, nil
+}
+
+// Returns channel to the pool or closes it when it's broken,
+// belongs to the lost connection or the pool is full
+func (m *ConnectionManager) releaseChannel(ch *managedChannel, healthy bool) {
+	m.mutex.Lock()
+	if healthy &&
+		!m.closed &&
+		ch.generation == m.generation &&
+		len(m.pool) < m.options.ChannelPoolSize &&
+		ch.isOpen() {
+		m.pool = append(m.pool, ch)
+		m.mutex.Unlock()
+		return
+	}
+	m.mutex.Unlock()
+	ch.Close()
+}
+
+// Waits up to timeout until connection is not blocked by the broker
+func (m *ConnectionManager) waitUnblocked(timeout time.Duration) error {
+	m.mutex.Lock()
+	unblocked := m.unblocked
+	m.mutex.Unlock()
+
+	select {
+	case <-unblocked:
+		return nil
+	case <-m.done:
+		return ErrManagerClosed
+	case <-time.After(timeout):
+		return fmt.Errorf("rabbitmq connection blocked for more than %s", timeout)
+	}
+}
+
+// Closes pooled channels and the connection, stops reconnecting
+func (m *ConnectionManager) Close() error {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+	pool := m.pool
+	m.pool = nil
+	connection := m.connection
+	m.connection = nil
+	m.mutex.Unlock()
+
+	connectionUp.WithLabelValues(m.options.Name).Set(0)
+	for _, ch := range pool {
+		ch.Close()
+	}
+	if connection != nil {
+		return connection.Close()
+	}
+	return nil
+}
+
+// Prometheus connection state and reconnects
+var (
+	connectionUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "feedparser_rabbitmq_connection_up",
+		Help: "Whether the RabbitMQ connection is established (1) or lost (0)",
+	}, []string{"connection"})
+	connectionBlocked = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "feedparser_rabbitmq_connection_blocked",
+		Help: "Whether the RabbitMQ connection is blocked by the broker",
+	}, []string{"connection"})
+	reconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_rabbitmq_reconnects_total",
+		Help: "The total number of restored RabbitMQ connections",
+	}, []string{"connection"})
+	openedChannels = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_rabbitmq_opened_channels_total",
+		Help: "The total number of opened RabbitMQ channels",
+	}, []string{"connection"})
+)
@@ -0,0 +1,91 @@
+package rabbitwriter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestConnectionManagerPublish(t *testing.T) {
+	broker := newMockedBroker()
+	broker.unroutable["unbound"] = true
+	manager := newTestConnectionManager(t, broker, ConnectionManagerOptions{})
+
+	if err := manager.Publish("", "test_queue", true, amqp.Publishing{Body: []byte("1")}, time.Second); err != nil {
+		t.Fatalf("ConnectionManager.Publish(), err = %v, want nil", err)
+	}
+	if count := broker.messagesCount("test_queue"); count != 1 {
+		t.Fatalf("ConnectionManager.Publish(), messages = %v, want 1", count)
+	}
+	if err := manager.Publish("", "unbound", true, amqp.Publishing{Body: []byte("2")}, time.Second); err == nil {
+		t.Fatalf("ConnectionManager.Publish() of unroutable mandatory message, err = nil, want error")
+	}
+	if err := manager.Publish("", "unbound", false, amqp.Publishing{Body: []byte("3")}, time.Second); err != nil {
+		t.Fatalf("ConnectionManager.Publish() of unroutable message, err = %v, want nil", err)
+	}
+
+	broker.mutex.Lock()
+	broker.nacks = 1
+	broker.mutex.Unlock()
+	if err := manager.Publish("", "test_queue", true, amqp.Publishing{Body: []byte("4")}, time.Second); err == nil {
+		t.Fatalf("ConnectionManager.Publish() of nacked message, err = nil, want error")
+	}
+	// Channel is reused by all publishes
+	if broker.openedChannels != 1 {
+		t.Fatalf("ConnectionManager.Publish(), opened channels = %v, want 1", broker.openedChannels)
+	}
+}
+
+func TestConnectionManagerConnectsInBackground(t *testing.T) {
+	broker := newMockedBroker()
+	broker.dialErrors = 2
+	setups := 0
+	manager := newTestConnectionManager(t, broker, ConnectionManagerOptions{
+		ConnectInBackground: true,
+		Setup: func(connection AmqpConnectionInterface) error {
+			setups++
+			ch, err := connection.Channel()
+			if err != nil {
+				return err
+			}
+			defer ch.Close()
+			_, err = ch.QueueDeclare("setup_queue", true, false, false, false, nil)
+			return err
+		},
+	})
+
+	ch, err := manager.Channel(time.Second)
+	if err != nil {
+		t.Fatalf("ConnectionManager.Channel(), err = %v, want nil", err)
+	}
+	ch.Close()
+	if !manager.IsConnected() || setups != 1 || !broker.durableQueues["setup_queue"] {
+		t.Fatalf("ConnectionManager.Channel(), connected = %v, setups = %v, want connection set up once", manager.IsConnected(), setups)
+	}
+
+	manager.Close()
+	if _, err := manager.Channel(time.Second); !errors.Is(err, ErrManagerClosed) {
+		t.Fatalf("ConnectionManager.Channel() after Close(), err = %v, want %v", err, ErrManagerClosed)
+	}
+}
+
+// Creates manager connected to mocked broker with short test delays
+func newTestConnectionManager(
+	t *testing.T,
+	broker *MockedBroker,
+	options ConnectionManagerOptions,
+) *ConnectionManager {
+	options.ChannelPoolSize = 1
+	options.ConfirmBufferSize = 1
+	options.ReconnectInitialDelay = 10 * time.Millisecond
+	options.ReconnectMaxDelay = 50 * time.Millisecond
+
+	manager, err := NewConnectionManager("amqp://test", broker.dial, options)
+	if err != nil {
+		t.Fatalf("NewConnectionManager(), err = %v, want nil", err)
+	}
+	t.Cleanup(func() { manager.Close() })
+	return manager
+}
@@ -1,20 +1,31 @@
 package rabbitwriter
 
 import (
-	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
 
 	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/streadway/amqp"
+	"go.uber.org/zap"
 )
 
 // Writer for RabbitMQ queues
 type RabbitWriter struct {
-	username   string
-	password   string
-	hostname   string
-	connection *amqp.Connection
+	manager          *ConnectionManager
+	exchange         string
+	exchangeType     string
+	routingKey       *queuewriter.KeyTemplate
+	encoder          *queuewriter.BatchEncoder
+	durable          bool
+	persistent       bool
+	maxInFlight      int
+	maxRetries       int
+	confirmTimeout   time.Duration
+	reconnectTimeout time.Duration
 }
 
 // Connection options for RabibitMQ writer
@@ -22,120 +33,575 @@ type RabbitWriterOptions struct {
 	Username string
 	Password string
 	Hostname string
+	// Exchange receiving shop items, items are published to the default
+	// exchange with queue name as routing key when empty
+	Exchange string
+	// Type of Exchange, DefaultExchangeType is used when empty
+	ExchangeType string
+	// Routing key template used with Exchange, e.g.
+	// "shop.{shopId}.cat.{internalCategory}" (see queuewriter.KeyTemplate),
+	// DefaultRoutingKey is used when empty
+	RoutingKey string
+	// Batching of many items into a single message and message
+	// body compression, every item is published as separate
+	// uncompressed message by default
+	Batch queuewriter.BatchOptions
+	// Declare durable queues (or exchange) surviving broker restart
+	Durable bool
+	// Publish messages with persistent delivery mode
+	Persistent bool
+	// Maximal number of published but not yet confirmed messages,
+	// DefaultMaxInFlight is used when 0
+	MaxInFlight int
+	// Number of republishing attempts of negatively acknowledged message
+	// and of resuming publishing after connection loss,
+	// DefaultMaxRetries is used when 0
+	MaxRetries int
+	// Maximal time of waiting for broker confirmation,
+	// DefaultConfirmTimeout is used when 0
+	ConfirmTimeout time.Duration
+	// Maximal time of waiting for lost connection to be restored
+	// (or blocked connection to be unblocked),
+	// DefaultReconnectTimeout is used when 0
+	ReconnectTimeout time.Duration
+	// Maximal number of idle channels kept for reuse,
+	// DefaultChannelPoolSize is used when 0
+	ChannelPoolSize int
+	// First delay between reconnection attempts,
+	// DefaultReconnectInitialDelay is used when 0
+	ReconnectInitialDelay time.Duration
+	// Maximal delay between reconnection attempts,
+	// DefaultReconnectMaxDelay is used when 0
+	ReconnectMaxDelay time.Duration
+}
+
+// Default values of RabbitWriterOptions
+const (
+	DefaultMaxInFlight           = 1000
+	DefaultMaxRetries            = 3
+	DefaultConfirmTimeout        = 30 * time.Second
+	DefaultReconnectTimeout      = time.Minute
+	DefaultChannelPoolSize       = 8
+	DefaultReconnectInitialDelay = 500 * time.Millisecond
+	DefaultReconnectMaxDelay     = 30 * time.Second
+	DefaultExchangeType          = amqp.ExchangeTopic
+	DefaultRoutingKey            = "{queue}"
+)
+
+// Checks options without connecting to the broker
+func (o RabbitWriterOptions) Validate() error {
+	if len(o.Exchange) == 0 && len(o.RoutingKey) > 0 {
+		return fmt.Errorf("routing key %q requires exchange", o.RoutingKey)
+	}
+	if len(o.RoutingKey) > 0 {
+		if _, err := queuewriter.CompileKeyTemplate(o.RoutingKey); err != nil {
+			return err
+		}
+	}
+	_, err := queuewriter.NewBatchEncoder(o.Batch)
+	return err
 }
 
 // Creates new RabbitWriter instance
 func NewRabbitWriter(
 	options RabbitWriterOptions,
 ) (*RabbitWriter, error) {
-	writer := RabbitWriter{
-		username: options.Username,
-		password: options.Password,
-		hostname: options.Hostname,
+	return newRabbitWriterWithDialer(options, DialAmqp)
+}
+
+// Creates new RabbitWriter instance connecting with dial
+func newRabbitWriterWithDialer(
+	options RabbitWriterOptions,
+	dial Dialer,
+) (*RabbitWriter, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	setDefault := func(value *int, defaultValue int) {
+		if *value <= 0 {
+			*value = defaultValue
+		}
+	}
+	setDefaultDuration := func(value *time.Duration, defaultValue time.Duration) {
+		if *value <= 0 {
+			*value = defaultValue
+		}
+	}
+	setDefault(&options.MaxInFlight, DefaultMaxInFlight)
+	setDefault(&options.MaxRetries, DefaultMaxRetries)
+	setDefault(&options.ChannelPoolSize, DefaultChannelPoolSize)
+	setDefaultDuration(&options.ConfirmTimeout, DefaultConfirmTimeout)
+	setDefaultDuration(&options.ReconnectTimeout, DefaultReconnectTimeout)
+	setDefaultDuration(&options.ReconnectInitialDelay, DefaultReconnectInitialDelay)
+	setDefaultDuration(&options.ReconnectMaxDelay, DefaultReconnectMaxDelay)
+
+	// Compile routing key of items published to the exchange
+	var routingKey *queuewriter.KeyTemplate
+	if len(options.Exchange) > 0 {
+		if len(options.ExchangeType) == 0 {
+			options.ExchangeType = DefaultExchangeType
+		}
+		if len(options.RoutingKey) == 0 {
+			options.RoutingKey = DefaultRoutingKey
+		}
+		var err error
+		routingKey, err = queuewriter.CompileKeyTemplate(options.RoutingKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	encoder, err := queuewriter.NewBatchEncoder(options.Batch)
+	if err != nil {
+		return nil, err
 	}
 
 	// Connect
-	err := writer.connect()
+	connString :=
+		"amqp://" +
+			options.Username + ":" +
+			options.Password + "@" +
+			options.Hostname + "/"
+	manager, err := NewConnectionManager(
+		connString,
+		dial,
+		ConnectionManagerOptions{
+			Name:                  "sink",
+			ChannelPoolSize:       options.ChannelPoolSize,
+			ConfirmBufferSize:     options.MaxInFlight,
+			ReconnectInitialDelay: options.ReconnectInitialDelay,
+			ReconnectMaxDelay:     options.ReconnectMaxDelay,
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &writer, nil
+	return &RabbitWriter{
+		manager:          manager,
+		exchange:         options.Exchange,
+		exchangeType:     options.ExchangeType,
+		routingKey:       routingKey,
+		encoder:          encoder,
+		durable:          options.Durable,
+		persistent:       options.Persistent,
+		maxInFlight:      options.MaxInFlight,
+		maxRetries:       options.MaxRetries,
+		confirmTimeout:   options.ConfirmTimeout,
+		reconnectTimeout: options.ReconnectTimeout,
+	}, nil
 }
 
-// Creates new connection channel and starts
-// new goroutine listening for products in shopItemsInput
-// and then sending them to queue queueName
-func (r RabbitWriter) WriteToQueue(
+// Takes channel from the pool and sends all products
+// from shopItemsInput to queue queueName or, when exchange is configured,
+// to the exchange with routing key built from item and metadata.
+// Every message has headers with feed run metadata and item hash
+// (or number of items when items are batched). Batches are collected
+// separately for every routing key and published when full or when
+// batch interval passes.
+// Returns when every message is confirmed by the broker
+// or with error when any message can't be delivered, including
+// messages returned by the broker as unroutable to any queue.
+// When the connection is lost, waits for reconnection and publishes
+// all unconfirmed messages again, so messages may be duplicated.
+// shopItemsInput is always drained, also on error
+func (r *RabbitWriter) WriteToQueue(
 	queueName string,
+	metadata models.PublishMetadata,
 	shopItemsInput chan models.ShopItem,
-) error {
-	// Declare RabbitMQ queue
-	ch, q, err := r.getQueueAndChannel(queueName)
-	if err != nil {
+) (err error) {
+	session := &publishSession{
+		writer:    r,
+		queueName: queueName,
+		pending:   make(map[uint64]pendingMessage),
+	}
+	defer func() {
+		session.close(err == nil)
+		if err != nil {
+			// Drain input so upstream stages don't block
+			for range shopItemsInput {
+			}
+		}
+	}()
+
+	// Take channel and declare RabbitMQ queue or exchange
+	if err := session.open(); err != nil {
 		return err
 	}
 
-	for item := range shopItemsInput {
-		body, _ := json.Marshal(item)
-		err = ch.Publish(
-			"",
-			q.Name,
-			false,
-			false,
-			amqp.Publishing{
-				ContentType: "application/json",
-				Body:        body,
-			})
-		// Handle connection error
-		if err != nil {
-			publishedShopItemsFailures.Inc()
-			ch, q, err = r.getQueueAndChannel(queueName)
-			if err != nil {
-				return err
+	if r.encoder.Batching() {
+		err = r.publishBatches(session, metadata, shopItemsInput)
+	} else {
+		for item := range shopItemsInput {
+			key := r.itemRoutingKey(queueName, item, metadata)
+			if err = r.publishItems(session, key, metadata, []models.ShopItem{item}); err != nil {
+				break
 			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Wait for all remaining confirmations
+	for len(session.pending) > 0 {
+		if err := session.waitForConfirm(); err != nil {
 			return err
-		} else {
-			publishedShopItems.Inc()
 		}
 	}
 
 	return nil
 }
 
-func (r *RabbitWriter) getQueueAndChannel(queueName string) (*amqp.Channel, *amqp.Queue, error) {
-	ch, err := r.getChannel()
+// Collects items into batches per routing key and publishes
+// full batches and, every batch interval, all collected ones
+func (r *RabbitWriter) publishBatches(
+	session *publishSession,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) error {
+	batches := make(map[string][]models.ShopItem)
+	flush := func() error {
+		keys := make([]string, 0, len(batches))
+		for key := range batches {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := r.publishItems(session, key, metadata, batches[key]); err != nil {
+				return err
+			}
+			delete(batches, key)
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(r.encoder.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case item, ok := <-shopItemsInput:
+			if !ok {
+				return flush()
+			}
+			key := r.itemRoutingKey(session.queueName, item, metadata)
+			batch := append(batches[key], item)
+			if len(batch) < r.encoder.Size() {
+				batches[key] = batch
+				continue
+			}
+			delete(batches, key)
+			if err := r.publishItems(session, key, metadata, batch); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Returns routing key of item, queue name is used with default exchange
+func (r *RabbitWriter) itemRoutingKey(
+	queueName string,
+	item models.ShopItem,
+	metadata models.PublishMetadata,
+) string {
+	if r.routingKey == nil {
+		return queueName
+	}
+	return r.routingKey.Execute(queueName, item, metadata)
+}
+
+// Encodes items into single message and publishes it
+func (r *RabbitWriter) publishItems(
+	session *publishSession,
+	routingKey string,
+	metadata models.PublishMetadata,
+	items []models.ShopItem,
+) error {
+	// Limit number of unconfirmed messages
+	for len(session.pending) >= r.maxInFlight {
+		if err := session.waitForConfirm(); err != nil {
+			return err
+		}
+	}
+
+	body, err := r.encoder.Encode(items)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	var headers map[string]string
+	if r.encoder.Batching() {
+		headers = queuewriter.BatchHeaders(items, metadata)
+	} else {
+		headers = queuewriter.ItemHeaders(items[0], metadata)
+	}
+	message := pendingMessage{
+		routingKey: routingKey,
+		headers:    make(amqp.Table, len(headers)),
+		timestamp:  metadata.ParsedAt,
+		body:       body,
+		items:      len(items),
 	}
+	for name, value := range headers {
+		message.headers[name] = value
+	}
+	return session.publish(message)
+}
 
-	// Declare RabbitMQ queue
-	q, err := ch.QueueDeclare(
-		queueName,
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
+// Closes the connection and all its channels
+func (r *RabbitWriter) Close() error {
+	return r.manager.Close()
+}
+
+// Checks if connection to RabbitMQ is established
+func (r *RabbitWriter) IsConnected() bool {
+	return r.manager.IsConnected()
+}
+
+// Message published but not confirmed by the broker yet
+type pendingMessage struct {
+	routingKey string
+	headers    amqp.Table
+	timestamp  time.Time
+	body       []byte
+	// Number of items in the message
+	items   int
+	retries int
+}
+
+// Publishing of messages to a single queue with confirmations tracking
+type publishSession struct {
+	writer    *RabbitWriter
+	queueName string
+	channel   *managedChannel
+	// Unconfirmed messages by delivery tag
+	pending map[uint64]pendingMessage
+	// Numbers of returned messages not confirmed yet by returnKey
+	returned map[string]int
+	resumes  int
+}
+
+// Identifies returned message, returns don't have delivery tags,
+// so they are matched to pending messages by routing key and body
+func returnKey(routingKey string, body []byte) string {
+	return routingKey + "\x00" + string(body)
+}
+
+// Records message returned by the broker, its confirmation follows
+func (s *publishSession) addReturn(message amqp.Return) {
+	if s.returned == nil {
+		s.returned = make(map[string]int)
+	}
+	s.returned[returnKey(message.RoutingKey, message.Body)]++
+}
+
+// Checks if confirmed message was returned by the broker. Returns are
+// always sent before confirmations of their messages, so returns
+// received so far are recorded first
+func (s *publishSession) wasReturned(message pendingMessage) bool {
+	for drained := false; !drained; {
+		select {
+		case returned := <-s.channel.returns:
+			s.addReturn(returned)
+		default:
+			drained = true
+		}
+	}
+	if len(s.returned) == 0 {
+		return false
+	}
+	key := returnKey(message.routingKey, message.body)
+	if s.returned[key] == 0 {
+		return false
+	}
+	s.returned[key]--
+	if s.returned[key] == 0 {
+		delete(s.returned, key)
+	}
+	return true
+}
+
+// Takes channel from the pool and declares the queue or the exchange.
+// Queues bound to the exchange are declared by consumers
+func (s *publishSession) open() error {
+	ch, err := s.writer.manager.getChannel(s.writer.reconnectTimeout)
+	if err != nil {
+		return err
+	}
+	if len(s.writer.exchange) > 0 {
+		err = ch.ExchangeDeclare(
+			s.writer.exchange,
+			s.writer.exchangeType,
+			s.writer.durable,
+			false,
+			false,
+			false,
+			nil,
+		)
+	} else {
+		_, err = ch.QueueDeclare(
+			s.queueName,
+			s.writer.durable,
+			false,
+			false,
+			false,
+			nil,
+		)
+	}
 	if err != nil {
-		return nil, nil, err
+		s.writer.manager.releaseChannel(ch, false)
+		return err
 	}
+	s.channel = ch
+	return nil
+}
 
-	return ch, &q, err
+// Returns channel to the pool, channels with unconfirmed
+// messages are closed
+func (s *publishSession) close(healthy bool) {
+	if s.channel != nil {
+		s.writer.manager.releaseChannel(s.channel, healthy && len(s.pending) == 0)
+		s.channel = nil
+	}
 }
 
-func (r *RabbitWriter) getChannel() (*amqp.Channel, error) {
-	ch, err := r.connection.Channel()
+// Publishes message, resumes publishing on new channel
+// when current one is broken
+func (s *publishSession) publish(message pendingMessage) error {
+	if err := s.writer.manager.waitUnblocked(s.writer.reconnectTimeout); err != nil {
+		return err
+	}
+
+	deliveryMode := amqp.Transient
+	if s.writer.persistent {
+		deliveryMode = amqp.Persistent
+	}
+	tag, err := s.channel.publish(
+		s.writer.exchange,
+		message.routingKey,
+		true,
+		amqp.Publishing{
+			Headers:         message.headers,
+			ContentType:     s.writer.encoder.ContentType(),
+			ContentEncoding: s.writer.encoder.ContentEncoding(),
+			DeliveryMode:    deliveryMode,
+			Timestamp:       message.timestamp,
+			Body:            message.body,
+		},
+	)
 	if err != nil {
-		err := r.connect()
-		if err != nil {
-			return nil, err
+		publishedShopItemsFailures.Add(float64(message.items))
+		// Message is not pending, so it's published after resume
+		if err := s.resume(err); err != nil {
+			return err
 		}
-		ch, err = r.connection.Channel()
+		return s.publish(message)
 	}
+	s.pending[tag] = message
+	return nil
+}
 
-	return ch, err
+// Waits for single confirmation and republishes nacked message.
+// Returned messages are failures, as retrying them doesn't help
+// until a queue is bound to their routing key
+func (s *publishSession) waitForConfirm() error {
+	select {
+	case returned := <-s.channel.returns:
+		s.addReturn(returned)
+		return nil
+	case confirm, ok := <-s.channel.confirms:
+		if !ok {
+			return s.resume(fmt.Errorf("channel closed"))
+		}
+		message, ok := s.pending[confirm.DeliveryTag]
+		if !ok {
+			return nil
+		}
+		delete(s.pending, confirm.DeliveryTag)
+		if s.wasReturned(message) {
+			returnedShopItems.Add(float64(message.items))
+			publishedShopItemsFailures.Add(float64(message.items))
+			return fmt.Errorf(
+				"message with routing key %s of queue %s returned by broker, no queue is bound to it",
+				message.routingKey,
+				s.queueName,
+			)
+		}
+		if confirm.Ack {
+			publishedShopItems.Add(float64(message.items))
+			return nil
+		}
+		nackedShopItems.Add(float64(message.items))
+		if message.retries >= s.writer.maxRetries {
+			publishedShopItemsFailures.Add(float64(message.items))
+			return fmt.Errorf(
+				"message rejected by broker %d times in queue %s",
+				message.retries+1,
+				s.queueName,
+			)
+		}
+		message.retries++
+		return s.publish(message)
+	case <-time.After(s.writer.confirmTimeout):
+		return fmt.Errorf(
+			"timeout waiting for confirmation of %d messages in queue %s",
+			len(s.pending),
+			s.queueName,
+		)
+	}
 }
 
-func (r *RabbitWriter) connect() error {
-	// Create connection
-	connString :=
-		"amqp://" +
-			r.username + ":" +
-			r.password + "@" +
-			r.hostname + "/"
-	conn, err := amqp.Dial(connString)
-	if err != nil {
-		return err
+// Replaces broken channel with new one (waiting for reconnection
+// if needed) and publishes all unconfirmed messages again in order
+func (s *publishSession) resume(cause error) error {
+	defer zap.L().Sync()
+
+	s.resumes++
+	if s.resumes > s.writer.maxRetries {
+		return fmt.Errorf(
+			"publishing to queue %s failed after %d resumes: %w",
+			s.queueName,
+			s.resumes-1,
+			cause,
+		)
+	}
+	zap.L().Warn(
+		fmt.Sprintf("Resuming publishing to queue %s", s.queueName),
+		zap.Int("unconfirmedMessages", len(s.pending)),
+		zap.Error(cause),
+	)
+
+	// Collect unconfirmed messages in publishing order
+	tags := make([]uint64, 0, len(s.pending))
+	for tag := range s.pending {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	messages := make([]pendingMessage, 0, len(tags))
+	for _, tag := range tags {
+		messages = append(messages, s.pending[tag])
 	}
 
-	r.connection = conn
+	s.writer.manager.releaseChannel(s.channel, false)
+	s.channel = nil
+	s.pending = make(map[uint64]pendingMessage)
+	s.returned = nil
+	if err := s.open(); err != nil {
+		return err
+	}
+	resumedPublishing.Inc()
 
+	for _, message := range messages {
+		if err := s.publish(message); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Prometheus published shop items
+// Prometheus published, failed and nacked shop items
 var (
 	publishedShopItems = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "feedparser_rabbitmq_published_items_total",
@@ -145,4 +611,16 @@ var (
 		Name: "feedparser_rabbitmq_published_items_failures_total",
 		Help: "The total number of failures in publishing ShopItems to RabbitMQ",
 	})
+	returnedShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_rabbitmq_returned_items_total",
+		Help: "The total number of ShopItems returned by RabbitMQ as unroutable",
+	})
+	nackedShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_rabbitmq_nacked_items_total",
+		Help: "The total number of ShopItems negatively acknowledged by RabbitMQ",
+	})
+	resumedPublishing = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_rabbitmq_resumed_publishing_total",
+		Help: "The total number of publishing resumes after RabbitMQ channel loss",
+	})
 )
@@ -0,0 +1,746 @@
+package rabbitwriter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/streadway/amqp"
+)
+
+func TestRabbitWriterWriteToQueue(t *testing.T) {
+	broker := newMockedBroker()
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{
+		Durable:    true,
+		Persistent: true,
+	})
+
+	err := w.WriteToQueue("test_queue", mockedMetadata, itemsChannel(mockedItems))
+	if err != nil {
+		t.Fatalf("RabbitWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	if ids := broker.queueItemIds("test_queue"); !reflect.DeepEqual(ids, mockedItemIds) {
+		t.Fatalf("RabbitWriter.WriteToQueue(), published = %v, want %v", ids, mockedItemIds)
+	}
+	if !broker.durableQueues["test_queue"] {
+		t.Fatalf("RabbitWriter.WriteToQueue(), queue declared as not durable, want durable")
+	}
+	for _, msg := range broker.queues["test_queue"] {
+		if msg.DeliveryMode != amqp.Persistent {
+			t.Fatalf(
+				"RabbitWriter.WriteToQueue(), delivery mode = %d, want %d",
+				msg.DeliveryMode,
+				amqp.Persistent,
+			)
+		}
+	}
+}
+
+func TestRabbitWriterPublishesToExchange(t *testing.T) {
+	broker := newMockedBroker()
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{
+		Exchange:   "shop_items",
+		RoutingKey: "shop.{shopId}.{queue}.{itemId}",
+	})
+
+	err := w.WriteToQueue("bidding", mockedMetadata, itemsChannel(mockedItems[:1]))
+	if err != nil {
+		t.Fatalf("RabbitWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	if kind := broker.exchanges["shop_items"]; kind != amqp.ExchangeTopic {
+		t.Fatalf("RabbitWriter.WriteToQueue(), exchange type = %q, want %q", kind, amqp.ExchangeTopic)
+	}
+	messages := broker.queues["shop.shop_1.bidding.item_1"]
+	if len(messages) != 1 {
+		t.Fatalf("RabbitWriter.WriteToQueue(), published to %v, want routing key shop.shop_1.bidding.item_1", broker.queues)
+	}
+	headers := messages[0].Headers
+	expectedHeaders := map[string]string{
+		"x-feed-url":       "https://example.com/feed.xml",
+		"x-shop-id":        "shop_1",
+		"x-job-id":         "job_1",
+		"x-parsed-at":      "2022-03-01T12:00:00Z",
+		"x-schema-version": "1",
+	}
+	for name, value := range expectedHeaders {
+		if headers[name] != value {
+			t.Fatalf("RabbitWriter.WriteToQueue(), header %s = %v, want %q", name, headers[name], value)
+		}
+	}
+	if hash, _ := headers["x-item-hash"].(string); len(hash) == 0 {
+		t.Fatalf("RabbitWriter.WriteToQueue(), header x-item-hash is empty")
+	}
+}
+
+func TestRabbitWriterFailsOnUnroutableMessage(t *testing.T) {
+	broker := newMockedBroker()
+	broker.unroutable["shop.shop_1.cat.item_2"] = true
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{
+		Exchange:   "shop_items",
+		RoutingKey: "shop.{shopId}.cat.{itemId}",
+	})
+
+	err := w.WriteToQueue("bidding", mockedMetadata, itemsChannel(mockedItems))
+	if err == nil || !strings.Contains(err.Error(), "shop.shop_1.cat.item_2") {
+		t.Fatalf("RabbitWriter.WriteToQueue(), err = %v, want error of returned message", err)
+	}
+
+	// Channel stays usable once the unroutable key is bound
+	delete(broker.unroutable, "shop.shop_1.cat.item_2")
+	if err := w.WriteToQueue("bidding", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("RabbitWriter.WriteToQueue() after binding, err = %v, want nil", err)
+	}
+}
+
+func TestNewRabbitWriterRoutingKeyWithoutExchange(t *testing.T) {
+	broker := newMockedBroker()
+
+	_, err := newRabbitWriterWithDialer(RabbitWriterOptions{RoutingKey: "shop.{shopId}"}, broker.dial)
+	if err == nil {
+		t.Fatalf("NewRabbitWriter() with routing key without exchange, err = nil, want error")
+	}
+}
+
+func TestRabbitWriterPublishesBatches(t *testing.T) {
+	broker := newMockedBroker()
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{
+		Batch: queuewriter.BatchOptions{
+			Size:        2,
+			Format:      queuewriter.Ndjson,
+			Compression: queuewriter.Gzip,
+		},
+	})
+
+	err := w.WriteToQueue("test_queue", mockedMetadata, itemsChannel(mockedItems))
+	if err != nil {
+		t.Fatalf("RabbitWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	messages := broker.queues["test_queue"]
+	expectedCounts := []string{"2", "2", "1"}
+	if len(messages) != len(expectedCounts) {
+		t.Fatalf("RabbitWriter.WriteToQueue(), published %d messages, want %d", len(messages), len(expectedCounts))
+	}
+	for idx, msg := range messages {
+		if msg.Headers["x-item-count"] != expectedCounts[idx] {
+			t.Fatalf(
+				"RabbitWriter.WriteToQueue(), message %d x-item-count = %v, want %s",
+				idx,
+				msg.Headers["x-item-count"],
+				expectedCounts[idx],
+			)
+		}
+		if msg.ContentEncoding != "gzip" || msg.ContentType != "application/x-ndjson" {
+			t.Fatalf(
+				"RabbitWriter.WriteToQueue(), message %d content = %s (%s), want application/x-ndjson (gzip)",
+				idx,
+				msg.ContentType,
+				msg.ContentEncoding,
+			)
+		}
+	}
+}
+
+func TestRabbitWriterPublishesBatchAfterInterval(t *testing.T) {
+	broker := newMockedBroker()
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{
+		Batch: queuewriter.BatchOptions{
+			Size:     100,
+			Interval: 10 * time.Millisecond,
+			Format:   queuewriter.JsonArray,
+		},
+	})
+
+	input := make(chan models.ShopItem)
+	result := make(chan error, 1)
+	go func() {
+		result <- w.WriteToQueue("test_queue", mockedMetadata, input)
+	}()
+	input <- mockedItems[0]
+
+	// Not full batch is published before input is closed
+	deadline := time.Now().Add(time.Second)
+	for broker.messagesCount("test_queue") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("RabbitWriter.WriteToQueue(), batch not published after interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(input)
+	if err := <-result; err != nil {
+		t.Fatalf("RabbitWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	var items []models.ShopItem
+	if err := json.Unmarshal(broker.queues["test_queue"][0].Body, &items); err != nil {
+		t.Fatalf("RabbitWriter.WriteToQueue(), invalid JSON array body: %v", err)
+	}
+	if len(items) != 1 || items[0].ItemID != mockedItems[0].ItemID {
+		t.Fatalf("RabbitWriter.WriteToQueue(), batch = %v, want [%v]", items, mockedItems[0])
+	}
+}
+
+func TestRabbitWriterRepublishesNacked(t *testing.T) {
+	broker := newMockedBroker()
+	broker.nacks = 2
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{MaxRetries: 2})
+
+	err := w.WriteToQueue("test_queue", mockedMetadata, itemsChannel(mockedItems))
+	if err != nil {
+		t.Fatalf("RabbitWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	if ids := broker.queueItemIds("test_queue"); len(ids) != len(mockedItemIds) {
+		t.Fatalf("RabbitWriter.WriteToQueue(), published = %v, want %v", ids, mockedItemIds)
+	}
+}
+
+func TestRabbitWriterFailsAfterMaxRetries(t *testing.T) {
+	broker := newMockedBroker()
+	broker.nacks = 100
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{MaxRetries: 1})
+
+	// Unbuffered input checks if it's drained after failure
+	input := make(chan models.ShopItem)
+	go func() {
+		for _, item := range mockedItems {
+			input <- item
+		}
+		close(input)
+	}()
+
+	err := w.WriteToQueue("test_queue", mockedMetadata, input)
+	if err == nil {
+		t.Fatalf("RabbitWriter.WriteToQueue(), err = nil, want error")
+	}
+	if _, ok := <-input; ok {
+		t.Fatalf("RabbitWriter.WriteToQueue(), input not drained after error")
+	}
+}
+
+func TestRabbitWriterResumesAfterConnectionLoss(t *testing.T) {
+	broker := newMockedBroker()
+	// Third message is lost together with the connection
+	broker.dropOnPublish = 3
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{})
+
+	err := w.WriteToQueue("test_queue", mockedMetadata, itemsChannel(mockedItems))
+	if err != nil {
+		t.Fatalf("RabbitWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	// Unconfirmed messages may be published twice, but none is lost
+	published := make(map[string]bool)
+	for _, id := range broker.queueItemIds("test_queue") {
+		published[id] = true
+	}
+	for _, id := range mockedItemIds {
+		if !published[id] {
+			t.Fatalf("RabbitWriter.WriteToQueue(), item %s not published after reconnection", id)
+		}
+	}
+	if broker.dials != 2 {
+		t.Fatalf("RabbitWriter.WriteToQueue(), dials = %d, want 2", broker.dials)
+	}
+	if !w.IsConnected() {
+		t.Fatalf("RabbitWriter.IsConnected() = false, want true")
+	}
+}
+
+func TestRabbitWriterReusesChannels(t *testing.T) {
+	broker := newMockedBroker()
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{})
+
+	for _, queueName := range []string{"first_queue", "second_queue"} {
+		if err := w.WriteToQueue(queueName, mockedMetadata, itemsChannel(mockedItems)); err != nil {
+			t.Fatalf("RabbitWriter.WriteToQueue(%s), err = %v, want nil", queueName, err)
+		}
+	}
+
+	if broker.openedChannels != 1 {
+		t.Fatalf("RabbitWriter.WriteToQueue(), opened channels = %d, want 1", broker.openedChannels)
+	}
+}
+
+func TestRabbitWriterWaitsWhenBlocked(t *testing.T) {
+	broker := newMockedBroker()
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{})
+	broker.setBlocked(true)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- w.WriteToQueue("test_queue", mockedMetadata, itemsChannel(mockedItems))
+	}()
+
+	select {
+	case err := <-result:
+		t.Fatalf("RabbitWriter.WriteToQueue() on blocked connection returned %v, want waiting", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	broker.setBlocked(false)
+	if err := <-result; err != nil {
+		t.Fatalf("RabbitWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+	if ids := broker.queueItemIds("test_queue"); !reflect.DeepEqual(ids, mockedItemIds) {
+		t.Fatalf("RabbitWriter.WriteToQueue(), published = %v, want %v", ids, mockedItemIds)
+	}
+}
+
+func TestRabbitWriterClose(t *testing.T) {
+	broker := newMockedBroker()
+	w := newTestRabbitWriter(t, broker, RabbitWriterOptions{})
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("RabbitWriter.Close(), err = %v, want nil", err)
+	}
+
+	err := w.WriteToQueue("test_queue", mockedMetadata, itemsChannel(mockedItems))
+	if !errors.Is(err, ErrManagerClosed) {
+		t.Fatalf("RabbitWriter.WriteToQueue() after Close(), err = %v, want %v", err, ErrManagerClosed)
+	}
+}
+
+func TestNewRabbitWriterDialFailure(t *testing.T) {
+	broker := newMockedBroker()
+	broker.dialErrors = 1
+
+	_, err := newRabbitWriterWithDialer(RabbitWriterOptions{}, broker.dial)
+	if err == nil {
+		t.Fatalf("NewRabbitWriter(), err = nil, want error")
+	}
+}
+
+func BenchmarkRabbitWriterPerItem(b *testing.B) {
+	benchmarkRabbitWriter(b, queuewriter.BatchOptions{})
+}
+
+func BenchmarkRabbitWriterBatchedNdjson(b *testing.B) {
+	benchmarkRabbitWriter(b, queuewriter.BatchOptions{Size: 500, Format: queuewriter.Ndjson})
+}
+
+func BenchmarkRabbitWriterBatchedJsonArray(b *testing.B) {
+	benchmarkRabbitWriter(b, queuewriter.BatchOptions{Size: 500, Format: queuewriter.JsonArray})
+}
+
+func BenchmarkRabbitWriterBatchedGzip(b *testing.B) {
+	benchmarkRabbitWriter(b, queuewriter.BatchOptions{
+		Size:        500,
+		Format:      queuewriter.Ndjson,
+		Compression: queuewriter.Gzip,
+	})
+}
+
+func BenchmarkRabbitWriterBatchedZstd(b *testing.B) {
+	benchmarkRabbitWriter(b, queuewriter.BatchOptions{
+		Size:        500,
+		Format:      queuewriter.Ndjson,
+		Compression: queuewriter.Zstd,
+	})
+}
+
+// Publishes benchmark items to mocked broker discarding messages
+// and reports publishing throughput
+func benchmarkRabbitWriter(b *testing.B, batch queuewriter.BatchOptions) {
+	broker := newMockedBroker()
+	broker.discard = true
+	w, err := newRabbitWriterWithDialer(RabbitWriterOptions{Batch: batch}, broker.dial)
+	if err != nil {
+		b.Fatalf("NewRabbitWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+	items := benchmarkItems(10000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		if err := w.WriteToQueue("test_queue", mockedMetadata, itemsChannel(items)); err != nil {
+			b.Fatalf("RabbitWriter.WriteToQueue(), err = %v, want nil", err)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(items))/time.Since(start).Seconds(), "items/s")
+}
+
+// Creates writer connected to mocked broker with short test timeouts
+func newTestRabbitWriter(
+	t *testing.T,
+	broker *MockedBroker,
+	options RabbitWriterOptions,
+) *RabbitWriter {
+	options.ConfirmTimeout = time.Second
+	options.ReconnectTimeout = time.Second
+	options.ReconnectInitialDelay = 10 * time.Millisecond
+	options.ReconnectMaxDelay = 50 * time.Millisecond
+
+	w, err := newRabbitWriterWithDialer(options, broker.dial)
+	if err != nil {
+		t.Fatalf("NewRabbitWriter(), err = %v, want nil", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// Returns closed channel with all items
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	return input
+}
+
+// MOCKED BROKER
+
+// In-process stand-in of RabbitMQ broker
+type MockedBroker struct {
+	mutex sync.Mutex
+	// Published messages by routing key
+	queues         map[string][]amqp.Publishing
+	durableQueues  map[string]bool
+	exchanges      map[string]string
+	connection     *MockedConnection
+	dials          int
+	openedChannels int
+	// Number of dials to fail
+	dialErrors int
+	// Number of next messages to nack
+	nacks int
+	// Drops connection on n-th published message, losing it
+	dropOnPublish int
+	published     int
+	// Confirm messages without storing them
+	discard bool
+	// Routing keys without bound queue, mandatory messages are returned
+	unroutable map[string]bool
+}
+
+func newMockedBroker() *MockedBroker {
+	return &MockedBroker{
+		queues:        make(map[string][]amqp.Publishing),
+		durableQueues: make(map[string]bool),
+		exchanges:     make(map[string]string),
+		unroutable:    make(map[string]bool),
+	}
+}
+
+func (b *MockedBroker) dial(url string) (AmqpConnectionInterface, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.dialErrors > 0 {
+		b.dialErrors--
+		return nil, errors.New("connection refused")
+	}
+	b.dials++
+	b.connection = &MockedConnection{broker: b}
+	return b.connection, nil
+}
+
+// Returns ITEM_IDs of messages in queue
+func (b *MockedBroker) queueItemIds(queueName string) []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ids := []string{}
+	for _, msg := range b.queues[queueName] {
+		var item models.ShopItem
+		json.Unmarshal(msg.Body, &item)
+		ids = append(ids, item.ItemID)
+	}
+	return ids
+}
+
+// Returns number of messages in queue
+func (b *MockedBroker) messagesCount(queueName string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.queues[queueName])
+}
+
+// Notifies current connection about blocked state
+func (b *MockedBroker) setBlocked(active bool) {
+	b.mutex.Lock()
+	connection := b.connection
+	b.mutex.Unlock()
+
+	connection.mutex.Lock()
+	defer connection.mutex.Unlock()
+	for _, receiver := range connection.blocks {
+		receiver <- amqp.Blocking{Active: active, Reason: "low on memory"}
+	}
+	// Give the watcher time to apply the state
+	time.Sleep(10 * time.Millisecond)
+}
+
+type MockedConnection struct {
+	broker   *MockedBroker
+	mutex    sync.Mutex
+	closed   bool
+	closes   []chan *amqp.Error
+	blocks   []chan amqp.Blocking
+	channels []*MockedChannel
+}
+
+func (c *MockedConnection) Channel() (AmqpChannelInterface, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	c.broker.mutex.Lock()
+	c.broker.openedChannels++
+	c.broker.mutex.Unlock()
+	ch := &MockedChannel{connection: c}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *MockedConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closes = append(c.closes, receiver)
+	return receiver
+}
+
+func (c *MockedConnection) NotifyBlocked(receiver chan amqp.Blocking) chan amqp.Blocking {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.blocks = append(c.blocks, receiver)
+	return receiver
+}
+
+func (c *MockedConnection) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+// Closes connection with its channels, notifies listeners about err
+func (c *MockedConnection) shutdown(err *amqp.Error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return
+	}
+	c.closed = true
+	channels := c.channels
+	closes := c.closes
+	blocks := c.blocks
+	c.mutex.Unlock()
+
+	for _, ch := range channels {
+		ch.shutdown(err)
+	}
+	for _, receiver := range closes {
+		if err != nil {
+			receiver <- err
+		}
+		close(receiver)
+	}
+	for _, receiver := range blocks {
+		close(receiver)
+	}
+}
+
+type MockedChannel struct {
+	connection      *MockedConnection
+	mutex           sync.Mutex
+	closed          bool
+	confirms        []chan amqp.Confirmation
+	returns         []chan amqp.Return
+	closes          []chan *amqp.Error
+	nextDeliveryTag uint64
+}
+
+func (c *MockedChannel) QueueDeclare(
+	name string,
+	durable, autoDelete, exclusive, noWait bool,
+	args amqp.Table,
+) (amqp.Queue, error) {
+	broker := c.connection.broker
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.durableQueues[name] = durable
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *MockedChannel) ExchangeDeclare(
+	name, kind string,
+	durable, autoDelete, internal, noWait bool,
+	args amqp.Table,
+) error {
+	broker := c.connection.broker
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.exchanges[name] = kind
+	return nil
+}
+
+// Queue management and consuming are not used by RabbitWriter
+
+func (c *MockedChannel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	return 0, nil
+}
+
+func (c *MockedChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (c *MockedChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return nil
+}
+
+func (c *MockedChannel) Consume(
+	queue, consumer string,
+	autoAck, exclusive, noLocal, noWait bool,
+	args amqp.Table,
+) (<-chan amqp.Delivery, error) {
+	return nil, fmt.Errorf("consuming is not supported")
+}
+
+func (c *MockedChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (c *MockedChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.confirms = append(c.confirms, confirm)
+	return confirm
+}
+
+func (c *MockedChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.returns = append(c.returns, returns)
+	return returns
+}
+
+func (c *MockedChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closes = append(c.closes, receiver)
+	return receiver
+}
+
+func (c *MockedChannel) Publish(
+	exchange, key string,
+	mandatory, immediate bool,
+	msg amqp.Publishing,
+) error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return amqp.ErrClosed
+	}
+	c.nextDeliveryTag++
+	confirmation := amqp.Confirmation{DeliveryTag: c.nextDeliveryTag, Ack: true}
+
+	broker := c.connection.broker
+	broker.mutex.Lock()
+	broker.published++
+	drop := broker.published == broker.dropOnPublish
+	returned := mandatory && broker.unroutable[key]
+	if !drop && !returned {
+		if broker.nacks > 0 {
+			broker.nacks--
+			confirmation.Ack = false
+		} else if !broker.discard {
+			broker.queues[key] = append(broker.queues[key], msg)
+		}
+	}
+	broker.mutex.Unlock()
+
+	if drop {
+		c.mutex.Unlock()
+		c.connection.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "broker restart"})
+		return nil
+	}
+	// Broker returns message before confirming it
+	if returned {
+		for _, receiver := range c.returns {
+			receiver <- amqp.Return{Exchange: exchange, RoutingKey: key, Headers: msg.Headers, Body: msg.Body}
+		}
+	}
+	for _, confirm := range c.confirms {
+		confirm <- confirmation
+	}
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *MockedChannel) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+// Closes channel and notifies listeners about err
+func (c *MockedChannel) shutdown(err *amqp.Error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, receiver := range c.closes {
+		if err != nil {
+			receiver <- err
+		}
+		close(receiver)
+	}
+	for _, confirm := range c.confirms {
+		close(confirm)
+	}
+	for _, receiver := range c.returns {
+		close(receiver)
+	}
+}
+
+// MOCKED DATA
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	ShopId:        "shop_1",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 13, 0, 0, 0, time.FixedZone("CET", 3600)),
+	SchemaVersion: models.SchemaVersion,
+}
+
+// Returns n shop items of realistic size
+func benchmarkItems(n int) []models.ShopItem {
+	items := make([]models.ShopItem, n)
+	for idx := range items {
+		items[idx] = models.ShopItem{
+			ItemID:       fmt.Sprintf("item_%d", idx),
+			ProductName:  fmt.Sprintf("Product %d", idx),
+			Description:  strings.Repeat("Long product description. ", 20),
+			Url:          fmt.Sprintf("https://example.com/products/%d", idx),
+			ImgUrl:       fmt.Sprintf("https://example.com/images/%d.jpg", idx),
+			PriceVat:     "199.90",
+			CategoryText: "Home | Garden | Tools",
+			EAN:          "5901234123457",
+			Params: []models.ShopItemParam{
+				{ParamName: "Color", Val: "red"},
+				{ParamName: "Size", Val: "XL"},
+			},
+		}
+	}
+	return items
+}
+
+var mockedItemIds = []string{"item_1", "item_2", "item_3", "item_4", "item_5"}
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "item_1", ProductName: "Product 1"},
+	{ItemID: "item_2", ProductName: "Product 2"},
+	{ItemID: "item_3", ProductName: "Product 3"},
+	{ItemID: "item_4", ProductName: "Product 4"},
+	{ItemID: "item_5", ProductName: "Product 5"},
+}
@@ -0,0 +1,260 @@
+package rediswriter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Writer for Redis Streams, every item is added with XADD
+// as a separate stream entry with feed run metadata fields
+// Implements queuewriter.QueueWriterInterface
+type RedisWriter struct {
+	client       *redis.Client
+	stream       *queuewriter.KeyTemplate
+	encoding     FieldEncoding
+	maxLen       int64
+	approximate  bool
+	pipelineSize int
+}
+
+// Encoding of item in stream entry fields
+type FieldEncoding string
+
+const (
+	// Whole item as JSON in ItemField
+	JsonField FieldEncoding = "json"
+	// Every non-empty item property in a separate field named as its
+	// JSON key, nested values (params, deliveries etc.) are JSON encoded
+	FlatFields FieldEncoding = "fields"
+)
+
+// Name of field with JSON encoded item
+const ItemField = "item"
+
+// Connection and stream options of Redis writer
+type RedisWriterOptions struct {
+	Address  string
+	Username string
+	Password string
+	DB       int
+	// Stream key template of published items (see queuewriter.KeyTemplate),
+	// DefaultStream is used when empty
+	Stream string
+	// Item encoding, JsonField is used when empty
+	Encoding FieldEncoding
+	// Maximal length of streams trimmed on every XADD,
+	// streams are not trimmed when 0
+	MaxLen int64
+	// Trim streams with "MAXLEN ~", which is much cheaper
+	// but keeps a bit more entries than MaxLen
+	ApproximateTrim bool
+	// Number of XADD commands sent in a single pipeline,
+	// DefaultPipelineSize is used when 0
+	PipelineSize int
+}
+
+// Default values of RedisWriterOptions
+const (
+	DefaultStream       = "{queue}"
+	DefaultPipelineSize = 500
+)
+
+// Checks options without connecting to the server
+func (o RedisWriterOptions) Validate() error {
+	if len(o.Stream) > 0 {
+		if _, err := queuewriter.CompileKeyTemplate(o.Stream); err != nil {
+			return err
+		}
+	}
+	switch o.Encoding {
+	case "", JsonField, FlatFields:
+	default:
+		return fmt.Errorf("unknown redis field encoding %q", o.Encoding)
+	}
+	if o.MaxLen < 0 {
+		return fmt.Errorf("redis stream max length has to be positive")
+	}
+	return nil
+}
+
+// Creates new RedisWriter instance connected to options.Address
+func NewRedisWriter(options RedisWriterOptions) (*RedisWriter, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if len(options.Stream) == 0 {
+		options.Stream = DefaultStream
+	}
+	stream, err := queuewriter.CompileKeyTemplate(options.Stream)
+	if err != nil {
+		return nil, err
+	}
+	if len(options.Encoding) == 0 {
+		options.Encoding = JsonField
+	}
+	if options.PipelineSize <= 0 {
+		options.PipelineSize = DefaultPipelineSize
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     options.Address,
+		Username: options.Username,
+		Password: options.Password,
+		DB:       options.DB,
+	})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("cannot connect to redis %s: %w", options.Address, err)
+	}
+
+	return &RedisWriter{
+		client:       client,
+		stream:       stream,
+		encoding:     options.Encoding,
+		maxLen:       options.MaxLen,
+		approximate:  options.ApproximateTrim,
+		pipelineSize: options.PipelineSize,
+	}, nil
+}
+
+// Adds all products from shopItemsInput to streams rendered from
+// stream template, sending XADD commands in pipelined batches.
+// shopItemsInput is always drained, also on error
+func (w *RedisWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) (err error) {
+	defer func() {
+		if err != nil {
+			// Drain input so upstream stages don't block
+			for range shopItemsInput {
+			}
+		}
+	}()
+
+	ctx := context.Background()
+	pipeline := w.client.Pipeline()
+	defer pipeline.Close()
+
+	for item := range shopItemsInput {
+		values, err := w.encode(item, metadata)
+		if err != nil {
+			publishedShopItemsFailures.Inc()
+			return err
+		}
+		pipeline.XAdd(ctx, &redis.XAddArgs{
+			Stream: w.stream.Execute(queueName, item, metadata),
+			MaxLen: w.maxLen,
+			Approx: w.approximate,
+			Values: values,
+		})
+		if pipeline.Len() < w.pipelineSize {
+			continue
+		}
+		if err := w.exec(ctx, pipeline); err != nil {
+			return err
+		}
+	}
+	return w.exec(ctx, pipeline)
+}
+
+// Closes the client
+func (w *RedisWriter) Close() error {
+	return w.client.Close()
+}
+
+// Sends pipelined commands, returns first failed command error
+func (w *RedisWriter) exec(ctx context.Context, pipeline redis.Pipeliner) error {
+	size := pipeline.Len()
+	if size == 0 {
+		return nil
+	}
+	cmds, err := pipeline.Exec(ctx)
+	if err == nil {
+		publishedShopItems.Add(float64(size))
+		return nil
+	}
+
+	failed := 0
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			failed++
+		}
+	}
+	if len(cmds) == 0 {
+		// Connection error, nothing was sent
+		failed = size
+	}
+	publishedShopItems.Add(float64(size - failed))
+	publishedShopItemsFailures.Add(float64(failed))
+	return fmt.Errorf("%d of %d items not added to redis streams: %w", failed, size, err)
+}
+
+// Returns stream entry fields of item with feed run metadata
+func (w *RedisWriter) encode(
+	item models.ShopItem,
+	metadata models.PublishMetadata,
+) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for name, value := range queuewriter.ItemHeaders(item, metadata) {
+		values[name] = value
+	}
+
+	body, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	if w.encoding == JsonField {
+		values[ItemField] = body
+		return values, nil
+	}
+
+	var properties map[string]json.RawMessage
+	if err := json.Unmarshal(body, &properties); err != nil {
+		return nil, err
+	}
+	for name, raw := range properties {
+		value, empty := flatValue(raw)
+		if !empty {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+// Returns field value of JSON encoded property, strings are unquoted
+// and other values are kept as JSON. Empty strings, arrays
+// and nulls are reported as empty
+func flatValue(raw json.RawMessage) (string, bool) {
+	value := string(raw)
+	switch value {
+	case "null", `""`, "[]":
+		return "", true
+	}
+	if raw[0] == '"' {
+		var unquoted string
+		json.Unmarshal(raw, &unquoted)
+		return unquoted, false
+	}
+	return value, false
+}
+
+// Prometheus published and failed shop items
+var (
+	publishedShopItems = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_redis_published_items_total",
+		Help: "The total number of ShopItems added to Redis Streams",
+	})
+	publishedShopItemsFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_redis_published_items_failures_total",
+		Help: "The total number of failures in adding ShopItems to Redis Streams",
+	})
+)
@@ -0,0 +1,192 @@
+package rediswriter
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/MichalMitros/feed-parser/queuewriter"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisWriterWriteToQueue(t *testing.T) {
+	server := miniredis.RunT(t)
+	w, err := NewRedisWriter(RedisWriterOptions{
+		Address:      server.Addr(),
+		Stream:       "feed:{queue}",
+		PipelineSize: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewRedisWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("RedisWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	entries := streamEntries(t, w.client, "feed:shop_items")
+	if len(entries) != len(mockedItems) {
+		t.Fatalf("RedisWriter.WriteToQueue(), added %d entries, want %d", len(entries), len(mockedItems))
+	}
+	for idx, entry := range entries {
+		var item models.ShopItem
+		if err := json.Unmarshal([]byte(entry.Values[ItemField].(string)), &item); err != nil {
+			t.Fatalf("RedisWriter.WriteToQueue(), invalid item field: %v", err)
+		}
+		if !reflect.DeepEqual(item, mockedItems[idx]) {
+			t.Fatalf("RedisWriter.WriteToQueue(), item = %+v, want %+v", item, mockedItems[idx])
+		}
+		if entry.Values[queuewriter.HeaderJobId] != mockedMetadata.JobId {
+			t.Fatalf("RedisWriter.WriteToQueue(), fields = %v, want feed run metadata", entry.Values)
+		}
+	}
+}
+
+func TestRedisWriterFlatFields(t *testing.T) {
+	server := miniredis.RunT(t)
+	w, err := NewRedisWriter(RedisWriterOptions{
+		Address:  server.Addr(),
+		Encoding: FlatFields,
+	})
+	if err != nil {
+		t.Fatalf("NewRedisWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	item := models.ShopItem{
+		ItemID:      "item_1",
+		ProductName: "Product \"1\"",
+		Params:      []models.ShopItemParam{{ParamName: "color", Val: "red"}},
+	}
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel([]models.ShopItem{item})); err != nil {
+		t.Fatalf("RedisWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	entries := streamEntries(t, w.client, "shop_items")
+	if len(entries) != 1 {
+		t.Fatalf("RedisWriter.WriteToQueue(), added %d entries, want 1", len(entries))
+	}
+	values := entries[0].Values
+	expected := map[string]string{
+		"itemId":      "item_1",
+		"productName": "Product \"1\"",
+		"param":       `[{"paramName":"color","val":"red"}]`,
+	}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Fatalf("RedisWriter.WriteToQueue(), field %s = %v, want %s", name, values[name], value)
+		}
+	}
+	// Empty properties are skipped
+	for _, name := range []string{"description", "deliveries", ItemField} {
+		if _, ok := values[name]; ok {
+			t.Fatalf("RedisWriter.WriteToQueue(), unexpected field %s in %v", name, values)
+		}
+	}
+}
+
+func TestRedisWriterMaxLen(t *testing.T) {
+	server := miniredis.RunT(t)
+	w, err := NewRedisWriter(RedisWriterOptions{
+		Address:      server.Addr(),
+		MaxLen:       2,
+		PipelineSize: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewRedisWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, itemsChannel(mockedItems)); err != nil {
+		t.Fatalf("RedisWriter.WriteToQueue(), err = %v, want nil", err)
+	}
+
+	entries := streamEntries(t, w.client, "shop_items")
+	if len(entries) != 2 {
+		t.Fatalf("RedisWriter.WriteToQueue(), stream length = %d, want 2", len(entries))
+	}
+	// Only the latest entries are kept
+	if last := entries[1].Values[queuewriter.HeaderItemHash]; last != queuewriter.ItemHeaders(mockedItems[4], mockedMetadata)[queuewriter.HeaderItemHash] {
+		t.Fatalf("RedisWriter.WriteToQueue(), last entry = %v, want last item", entries[1].Values)
+	}
+}
+
+func TestRedisWriterFailure(t *testing.T) {
+	server := miniredis.RunT(t)
+	w, err := NewRedisWriter(RedisWriterOptions{Address: server.Addr(), PipelineSize: 2})
+	if err != nil {
+		t.Fatalf("NewRedisWriter(), err = %v, want nil", err)
+	}
+	defer w.Close()
+	server.SetError("LOADING Redis is loading the dataset in memory")
+
+	// Unbuffered input checks if it's drained after failure
+	input := make(chan models.ShopItem)
+	go func() {
+		for _, item := range mockedItems {
+			input <- item
+		}
+		close(input)
+	}()
+
+	if err := w.WriteToQueue("shop_items", mockedMetadata, input); err == nil {
+		t.Fatalf("RedisWriter.WriteToQueue(), err = nil, want error")
+	}
+	if _, ok := <-input; ok {
+		t.Fatalf("RedisWriter.WriteToQueue(), input not drained after error")
+	}
+}
+
+func TestNewRedisWriterInvalidOptions(t *testing.T) {
+	server := miniredis.RunT(t)
+	for _, options := range []RedisWriterOptions{
+		{Address: server.Addr(), Stream: "{unknown}"},
+		{Address: server.Addr(), Encoding: "xml"},
+		{Address: server.Addr(), MaxLen: -1},
+	} {
+		if _, err := NewRedisWriter(options); err == nil {
+			t.Fatalf("NewRedisWriter(%+v), err = nil, want error", options)
+		}
+	}
+}
+
+// Returns all entries of stream
+func streamEntries(t *testing.T, client *redis.Client, stream string) []redis.XMessage {
+	entries, err := client.XRange(context.Background(), stream, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange(%s), err = %v, want nil", stream, err)
+	}
+	return entries
+}
+
+// Returns closed channel with all items
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	return input
+}
+
+// MOCKED DATA
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "item_1", ProductName: "Product 1"},
+	{ItemID: "item_2", ProductName: "Product 2"},
+	{ItemID: "item_3", ProductName: "Product 3"},
+	{ItemID: "item_1", ProductName: "Product 1 v2"},
+	{ItemID: "item_4", ProductName: "Product 4"},
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	SchemaVersion: models.SchemaVersion,
+}
@@ -0,0 +1,81 @@
+package stdoutwriter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Writer of shop items to standard output as newline delimited JSON
+// records with queue name, for offline runs and piping into other tools.
+// Records of concurrently written queues are interleaved line by line
+// Implements queuewriter.QueueWriterInterface
+type StdoutWriter struct {
+	mutex  sync.Mutex
+	output io.Writer
+}
+
+// Single line of output
+type record struct {
+	Queue string          `json:"queue"`
+	JobId string          `json:"jobId,omitempty"`
+	Item  models.ShopItem `json:"item"`
+}
+
+// Size of buffered output of a single queue
+const flushSize = 64 * 1024
+
+// Creates new StdoutWriter instance
+func NewStdoutWriter() *StdoutWriter {
+	return newStdoutWriter(os.Stdout)
+}
+
+// Creates new StdoutWriter instance writing to output
+func newStdoutWriter(output io.Writer) *StdoutWriter {
+	return &StdoutWriter{output: output}
+}
+
+// Writes all products from shopItemsInput as JSON lines.
+// shopItemsInput is always drained, also on error
+func (w *StdoutWriter) WriteToQueue(
+	queueName string,
+	metadata models.PublishMetadata,
+	shopItemsInput chan models.ShopItem,
+) (err error) {
+	defer func() {
+		if err != nil {
+			// Drain input so upstream stages don't block
+			for range shopItemsInput {
+			}
+		}
+	}()
+
+	// Lines are buffered per queue, so the output is locked only
+	// for flushing and other queues are not blocked
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for item := range shopItemsInput {
+		if err := encoder.Encode(record{queueName, metadata.JobId, item}); err != nil {
+			return err
+		}
+		if buffer.Len() < flushSize {
+			continue
+		}
+		if err := w.flush(&buffer); err != nil {
+			return err
+		}
+	}
+	return w.flush(&buffer)
+}
+
+// Writes buffered lines to output
+func (w *StdoutWriter) flush(buffer *bytes.Buffer) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err := buffer.WriteTo(w.output)
+	return err
+}
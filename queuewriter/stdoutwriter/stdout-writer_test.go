@@ -0,0 +1,80 @@
+package stdoutwriter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestStdoutWriterWriteToQueue(t *testing.T) {
+	var output bytes.Buffer
+	w := newStdoutWriter(&output)
+
+	// Queues are written concurrently
+	queues := []string{"shop_items", "shop_items_bidding"}
+	var wg sync.WaitGroup
+	for _, queueName := range queues {
+		wg.Add(1)
+		go func(queueName string) {
+			defer wg.Done()
+			if err := w.WriteToQueue(queueName, mockedMetadata, itemsChannel(mockedItems)); err != nil {
+				t.Errorf("StdoutWriter.WriteToQueue(%s), err = %v, want nil", queueName, err)
+			}
+		}(queueName)
+	}
+	wg.Wait()
+
+	written := make(map[string][]string)
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		var line record
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("StdoutWriter.WriteToQueue(), invalid line %s: %v", scanner.Text(), err)
+		}
+		if line.JobId != mockedMetadata.JobId {
+			t.Fatalf("StdoutWriter.WriteToQueue(), job id = %s, want %s", line.JobId, mockedMetadata.JobId)
+		}
+		written[line.Queue] = append(written[line.Queue], line.Item.ProductName)
+	}
+
+	for _, queueName := range queues {
+		if len(written[queueName]) != len(mockedItems) {
+			t.Fatalf("StdoutWriter.WriteToQueue(), %s items = %v, want %d items", queueName, written[queueName], len(mockedItems))
+		}
+		for idx, item := range mockedItems {
+			if written[queueName][idx] != item.ProductName {
+				t.Fatalf("StdoutWriter.WriteToQueue(), %s items = %v, want items in order", queueName, written[queueName])
+			}
+		}
+	}
+}
+
+// Returns closed channel with all items
+func itemsChannel(items []models.ShopItem) chan models.ShopItem {
+	input := make(chan models.ShopItem, len(items))
+	for _, item := range items {
+		input <- item
+	}
+	close(input)
+	return input
+}
+
+// MOCKED DATA
+
+var mockedItems = []models.ShopItem{
+	{ItemID: "item_1", ProductName: "Product 1"},
+	{ItemID: "item_2", ProductName: "Product 2"},
+	{ItemID: "item_3", ProductName: "Product 3"},
+}
+
+var mockedMetadata = models.PublishMetadata{
+	FeedUrl:       "https://example.com/feed.xml",
+	JobId:         "job_1",
+	ParsedAt:      time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC),
+	SchemaVersion: models.SchemaVersion,
+}
@@ -0,0 +1,14 @@
+package scheduler
+
+import (
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+// Parser of registered feeds, e.g. app.FeedRunner
+type FeedRunnerInterface interface {
+	// Returns source of registered feed, error when it can't be parsed
+	Source(feed models.Feed) (feedparser.FeedSource, error)
+	// Parses feeds and stores their results in the registry
+	Run(sources []feedparser.FeedSource) []models.FeedParsingResult
+}
@@ -0,0 +1,313 @@
+package scheduler
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/feedregistry"
+	"github.com/MichalMitros/feed-parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+// Runs enabled registered feeds with schedules. Every feed is run
+// at most once at a time, runs still in progress at the next
+// scheduled time are skipped
+type Scheduler struct {
+	registry        feedregistry.FeedRegistryInterface
+	runner          FeedRunnerInterface
+	refreshInterval time.Duration
+	maxJitter       time.Duration
+	missedRunPolicy MissedRunPolicy
+	// Interval of checking due runs
+	tickInterval time.Duration
+
+	mutex sync.Mutex
+	feeds map[string]*scheduledFeed
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+	runs    sync.WaitGroup
+}
+
+// Handling of runs missed while the service was stopped
+type MissedRunPolicy string
+
+const (
+	// Feed is run at the next scheduled time
+	SkipMissed MissedRunPolicy = "skip"
+	// Feed is run once right after the start, however many runs
+	// were missed
+	RunOnce MissedRunPolicy = "run_once"
+)
+
+// Options of Scheduler
+type SchedulerOptions struct {
+	// Interval of reading feeds from the registry,
+	// DefaultRefreshInterval is used when 0
+	RefreshInterval time.Duration
+	// Maximal random delay of every run spreading runs of feeds
+	// with the same schedule, runs are not delayed when 0
+	MaxJitter time.Duration
+	// SkipMissed is used when empty
+	MissedRunPolicy MissedRunPolicy
+}
+
+// Default values of SchedulerOptions
+const DefaultRefreshInterval = 30 * time.Second
+
+// Scheduling state of a single feed
+type scheduledFeed struct {
+	feed     models.Feed
+	schedule cron.Schedule
+	// Invalid schedule or error of the last run
+	err        error
+	next       time.Time
+	running    bool
+	lastRunAt  *time.Time
+	lastStatus models.ResultStatus
+}
+
+// Creates new Scheduler instance and starts scheduling feeds
+// of the registry
+func NewScheduler(
+	registry feedregistry.FeedRegistryInterface,
+	runner FeedRunnerInterface,
+	options SchedulerOptions,
+) (*Scheduler, error) {
+	return newScheduler(registry, runner, options, time.Second)
+}
+
+// Creates new Scheduler instance checking due runs every tickInterval
+func newScheduler(
+	registry feedregistry.FeedRegistryInterface,
+	runner FeedRunnerInterface,
+	options SchedulerOptions,
+	tickInterval time.Duration,
+) (*Scheduler, error) {
+	switch options.MissedRunPolicy {
+	case "":
+		options.MissedRunPolicy = SkipMissed
+	case SkipMissed, RunOnce:
+	default:
+		return nil, fmt.Errorf("unknown missed run policy %q", options.MissedRunPolicy)
+	}
+	if options.RefreshInterval < 0 || options.MaxJitter < 0 {
+		return nil, fmt.Errorf("scheduler intervals can't be negative")
+	}
+	if options.RefreshInterval == 0 {
+		options.RefreshInterval = DefaultRefreshInterval
+	}
+
+	s := &Scheduler{
+		registry:        registry,
+		runner:          runner,
+		refreshInterval: options.RefreshInterval,
+		maxJitter:       options.MaxJitter,
+		missedRunPolicy: options.MissedRunPolicy,
+		tickInterval:    tickInterval,
+		feeds:           make(map[string]*scheduledFeed),
+		stop:            make(chan struct{}),
+	}
+	s.stopped.Add(1)
+	go s.loop()
+	return s, nil
+}
+
+// Returns scheduling state of all scheduled feeds ordered by feed id
+func (s *Scheduler) Schedules() []models.FeedSchedule {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	schedules := make([]models.FeedSchedule, 0, len(s.feeds))
+	for id, scheduled := range s.feeds {
+		schedule := models.FeedSchedule{
+			FeedId:     id,
+			Schedule:   scheduled.feed.Schedule,
+			LastRunAt:  scheduled.lastRunAt,
+			LastStatus: scheduled.lastStatus,
+			Running:    scheduled.running,
+		}
+		if scheduled.schedule != nil {
+			next := scheduled.next
+			schedule.NextRunAt = &next
+		}
+		if scheduled.err != nil {
+			schedule.Error = scheduled.err.Error()
+		}
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].FeedId < schedules[j].FeedId
+	})
+	return schedules
+}
+
+// Stops scheduling and waits for runs in progress
+func (s *Scheduler) Close() error {
+	close(s.stop)
+	s.stopped.Wait()
+	s.runs.Wait()
+	return nil
+}
+
+// Refreshes feeds and starts due runs until Close
+func (s *Scheduler) loop() {
+	defer s.stopped.Done()
+
+	ticker := time.NewTicker(s.tickInterval)
+	defer ticker.Stop()
+
+	var refreshedAt time.Time
+	for {
+		now := time.Now()
+		if now.Sub(refreshedAt) >= s.refreshInterval {
+			s.refresh(now)
+			refreshedAt = now
+		}
+		s.startDueRuns(now)
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reads enabled feeds with schedules from the registry
+func (s *Scheduler) refresh(now time.Time) {
+	defer zap.L().Sync()
+
+	feeds, err := s.registry.List()
+	if err != nil {
+		zap.L().Error("Cannot read feeds of the scheduler", zap.Error(err))
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current := make(map[string]bool)
+	for _, feed := range feeds {
+		if !feed.Enabled || len(feed.Schedule) == 0 {
+			continue
+		}
+		current[feed.Id] = true
+
+		scheduled, ok := s.feeds[feed.Id]
+		if ok && scheduled.feed.Schedule == feed.Schedule {
+			scheduled.feed = feed
+			continue
+		}
+		if !ok {
+			scheduled = &scheduledFeed{
+				lastRunAt: feed.LastRunAt,
+			}
+			if feed.LastResult != nil {
+				scheduled.lastStatus = feed.LastResult.Status
+			}
+			s.feeds[feed.Id] = scheduled
+		}
+		scheduled.feed = feed
+		scheduled.schedule, scheduled.err = feedregistry.ParseSchedule(feed.Schedule)
+		if scheduled.err != nil {
+			zap.L().Error(
+				"Invalid schedule of feed",
+				zap.String("feedId", feed.Id),
+				zap.String("schedule", feed.Schedule),
+				zap.Error(scheduled.err),
+			)
+			continue
+		}
+		scheduled.next = s.nextRun(scheduled.schedule, now)
+
+		// Run once when a run was missed since the last one
+		if s.missedRunPolicy == RunOnce && scheduled.lastRunAt != nil &&
+			scheduled.schedule.Next(*scheduled.lastRunAt).Before(now) {
+			scheduled.next = now
+		}
+	}
+
+	// Forget removed and disabled feeds, runs in progress finish
+	for id := range s.feeds {
+		if !current[id] {
+			delete(s.feeds, id)
+		}
+	}
+}
+
+// Starts runs of feeds with passed next run time
+func (s *Scheduler) startDueRuns(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, scheduled := range s.feeds {
+		if scheduled.schedule == nil || scheduled.next.After(now) {
+			continue
+		}
+		if scheduled.running {
+			// Previous run is still in progress, skip this one
+			skippedRuns.Inc()
+			scheduled.next = s.nextRun(scheduled.schedule, now)
+			continue
+		}
+		scheduled.running = true
+		s.runs.Add(1)
+		go s.run(scheduled, scheduled.feed)
+	}
+}
+
+// Parses feed and schedules its next run
+func (s *Scheduler) run(scheduled *scheduledFeed, feed models.Feed) {
+	defer s.runs.Done()
+	defer zap.L().Sync()
+
+	zap.L().Info("Starting scheduled run of feed", zap.String("feedId", feed.Id))
+	status := models.ParsingErrors
+	source, err := s.runner.Source(feed)
+	if err == nil {
+		results := s.runner.Run([]feedparser.FeedSource{source})
+		status = results[0].Status
+		if status == models.ParsingErrors {
+			err = fmt.Errorf("parsing failed")
+		}
+	}
+	scheduledRuns.WithLabelValues(string(status)).Inc()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	finishedAt := time.Now()
+	scheduled.running = false
+	scheduled.err = err
+	scheduled.lastStatus = status
+	scheduled.lastRunAt = &finishedAt
+	scheduled.next = s.nextRun(scheduled.schedule, finishedAt)
+}
+
+// Returns next run time after t with random jitter
+func (s *Scheduler) nextRun(schedule cron.Schedule, t time.Time) time.Time {
+	next := schedule.Next(t)
+	if s.maxJitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.maxJitter))))
+	}
+	return next
+}
+
+// Prometheus scheduled runs counters
+var (
+	scheduledRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedparser_scheduler_runs_total",
+		Help: "The total number of scheduled feed runs by result status",
+	}, []string{"status"})
+	skippedRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedparser_scheduler_skipped_runs_total",
+		Help: "The total number of scheduled runs skipped as the previous run was still in progress",
+	})
+)
@@ -0,0 +1,208 @@
+package scheduler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichalMitros/feed-parser/feedparser"
+	"github.com/MichalMitros/feed-parser/feedregistry"
+	"github.com/MichalMitros/feed-parser/models"
+)
+
+func TestSchedulerRuns(t *testing.T) {
+	runner := NewMockedRunner()
+	scheduler, err := newScheduler(
+		&MockedRegistry{feeds: mockedFeeds},
+		runner,
+		SchedulerOptions{},
+		10*time.Millisecond,
+	)
+	if err != nil {
+		t.Fatalf("NewScheduler(), err = %v, want nil", err)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+	scheduler.Close()
+
+	// Only enabled feed with schedule is run every second
+	if runs := runner.Runs("feed_1"); runs < 2 || runs > 3 {
+		t.Fatalf("NewScheduler(), feed_1 runs = %v, want 2 or 3", runs)
+	}
+	if runs := runner.Runs("feed_2") + runner.Runs("feed_3"); runs != 0 {
+		t.Fatalf("NewScheduler(), disabled and unscheduled feeds runs = %v, want 0", runs)
+	}
+
+	schedules := scheduler.Schedules()
+	if len(schedules) != 1 || schedules[0].FeedId != "feed_1" {
+		t.Fatalf("Schedules(), got = %v, want only feed_1", schedules)
+	}
+	schedule := schedules[0]
+	if schedule.LastRunAt == nil || schedule.NextRunAt == nil ||
+		!schedule.NextRunAt.After(*schedule.LastRunAt) ||
+		schedule.LastStatus != models.ParsedSuccessfully || schedule.Running {
+		t.Fatalf("Schedules(), got = %+v, want finished successful run with next run", schedule)
+	}
+}
+
+func TestSchedulerSingleRun(t *testing.T) {
+	runner := NewMockedRunner()
+	runner.block = make(chan struct{})
+	scheduler, err := newScheduler(
+		&MockedRegistry{feeds: mockedFeeds},
+		runner,
+		SchedulerOptions{},
+		10*time.Millisecond,
+	)
+	if err != nil {
+		t.Fatalf("NewScheduler(), err = %v, want nil", err)
+	}
+
+	// Runs at the next scheduled times are skipped
+	time.Sleep(2500 * time.Millisecond)
+	if runs := runner.Runs("feed_1"); runs != 1 {
+		t.Fatalf("NewScheduler(), feed_1 runs = %v, want 1", runs)
+	}
+	if schedules := scheduler.Schedules(); !schedules[0].Running {
+		t.Fatalf("Schedules(), running = false, want true")
+	}
+
+	// Close waits for the run in progress
+	closed := make(chan struct{})
+	go func() {
+		scheduler.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatalf("Close(), returned before run finished")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(runner.block)
+	<-closed
+}
+
+func TestSchedulerMissedRuns(t *testing.T) {
+	lastRunAt := time.Now().Add(-2 * time.Hour)
+	feeds := []models.Feed{{Id: "feed_1", Url: "https://example.com/feed.xml", Schedule: "@hourly", Enabled: true, LastRunAt: &lastRunAt}}
+
+	expectedRuns := map[MissedRunPolicy]int{
+		SkipMissed: 0,
+		RunOnce:    1,
+	}
+	for policy, expected := range expectedRuns {
+		runner := NewMockedRunner()
+		scheduler, err := newScheduler(
+			&MockedRegistry{feeds: feeds},
+			runner,
+			SchedulerOptions{MissedRunPolicy: policy},
+			10*time.Millisecond,
+		)
+		if err != nil {
+			t.Fatalf("NewScheduler(), err = %v, want nil", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		scheduler.Close()
+
+		if runs := runner.Runs("feed_1"); runs != expected {
+			t.Fatalf("NewScheduler() with %s policy, runs = %v, want %v", policy, runs, expected)
+		}
+	}
+}
+
+func TestNewSchedulerInvalidOptions(t *testing.T) {
+	invalidOptions := []SchedulerOptions{
+		{MissedRunPolicy: "run_all"},
+		{RefreshInterval: -time.Second},
+		{MaxJitter: -time.Second},
+	}
+	for _, options := range invalidOptions {
+		if _, err := NewScheduler(&MockedRegistry{}, NewMockedRunner(), options); err == nil {
+			t.Fatalf("NewScheduler(%+v), err = nil, want error", options)
+		}
+	}
+}
+
+// MOCKED DATA
+
+// Registry with fixed list of feeds
+type MockedRegistry struct {
+	feeds []models.Feed
+}
+
+func (r *MockedRegistry) List() ([]models.Feed, error) {
+	return r.feeds, nil
+}
+
+func (r *MockedRegistry) Get(id string) (*models.Feed, error) {
+	for _, feed := range r.feeds {
+		if feed.Id == id {
+			return &feed, nil
+		}
+	}
+	return nil, feedregistry.ErrFeedNotFound
+}
+
+func (r *MockedRegistry) Create(feed models.Feed) (*models.Feed, error) {
+	return &feed, nil
+}
+
+func (r *MockedRegistry) Update(feed models.Feed) (*models.Feed, error) {
+	return &feed, nil
+}
+
+func (r *MockedRegistry) Delete(id string) error {
+	return nil
+}
+
+func (r *MockedRegistry) SaveResult(id string, result models.FeedParsingResult) error {
+	return nil
+}
+
+// Runner counting runs of feeds, runs wait for block when it's set
+type MockedRunner struct {
+	mutex sync.Mutex
+	runs  map[string]int
+	block chan struct{}
+}
+
+func NewMockedRunner() *MockedRunner {
+	return &MockedRunner{runs: make(map[string]int)}
+}
+
+func (r *MockedRunner) Source(feed models.Feed) (feedparser.FeedSource, error) {
+	return feedparser.FeedSource{Url: feed.Url, FeedId: feed.Id}, nil
+}
+
+func (r *MockedRunner) Run(sources []feedparser.FeedSource) []models.FeedParsingResult {
+	r.mutex.Lock()
+	for _, source := range sources {
+		r.runs[source.FeedId]++
+	}
+	r.mutex.Unlock()
+
+	if r.block != nil {
+		<-r.block
+	}
+	results := []models.FeedParsingResult{}
+	for _, source := range sources {
+		results = append(results, models.FeedParsingResult{
+			FeedUrl: source.Url,
+			FeedId:  source.FeedId,
+			Status:  models.ParsedSuccessfully,
+		})
+	}
+	return results
+}
+
+func (r *MockedRunner) Runs(feedId string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.runs[feedId]
+}
+
+var mockedFeeds = []models.Feed{
+	{Id: "feed_1", Url: "https://example.com/feed_1.xml", Schedule: "@every 1s", Enabled: true},
+	{Id: "feed_2", Url: "https://example.com/feed_2.xml", Schedule: "@every 1s", Enabled: false},
+	{Id: "feed_3", Url: "https://example.com/feed_3.xml", Enabled: true},
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/MichalMitros/feed-parser/app"
+	"github.com/MichalMitros/feed-parser/auth"
 	"github.com/MichalMitros/feed-parser/controllers"
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
@@ -17,77 +23,173 @@ import (
 )
 
 var (
-	opsProcessed = promauto.NewCounter(prometheus.CounterOpts{
+	opsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "feedparser_requests_total",
 		Help: "The total number of received requests",
-	})
+	}, []string{"client"})
 )
 
 func main() {
-	envMode := os.Getenv("ENV")
+	// Read configuration from CONFIG_PATH file and environment variables
+	configPath := os.Getenv("CONFIG_PATH")
+	config, configErr := app.LoadConfig(configPath)
 
 	// Set logger
 	var logger *zap.Logger
 
 	// Set mode of the application (logger and gin server)
-	if strings.ToLower(envMode) == "development" {
+	if strings.ToLower(config.Server.Mode) == "development" {
 		gin.SetMode(gin.DebugMode)
 		logger, _ = zap.NewDevelopment()
-		zap.L().Info("Running in DEVELOPMENT mode")
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 		logger, _ = zap.NewProduction()
-		zap.L().Info("Running in PRODUCTION mode")
 	}
 	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
 
-	serverAddress, isServerAddrSet := os.LookupEnv("SERVER_ADDRESS")
-	if !isServerAddrSet {
-		zap.L().Error(
-			"'SERVER_ADDRESS' variable not set, starting on default ':8080'",
+	if configErr != nil {
+		zap.L().Panic(
+			"Invalid configuration",
+			zap.String("path", configPath),
+			zap.Error(configErr),
 		)
-		serverAddress = ":8080"
 	}
+	zap.L().Info(fmt.Sprintf("Running in %s mode", strings.ToUpper(config.Server.Mode)))
+
+	// Create dependencies from configuration
+	container, err := app.NewContainer(config)
+	if err != nil {
+		zap.L().Panic("Cannot create dependencies", zap.Error(err))
+	}
+	defer container.Close()
+	handlers := controllers.NewHandlers(container)
+
+	// Apply changed configuration without restart
+	watcher := app.WatchConfig(configPath, container)
+	defer watcher.Close()
 
 	// Create gin server
 	r := gin.New()
 
 	// Use zap logger in gin server
-	zap.ReplaceGlobals(logger)
 	r.Use(ginzap.GinzapWithConfig(logger, &ginzap.Config{
 		TimeFormat: time.RFC3339,
 		UTC:        true,
-		SkipPaths:  []string{"/metrics"},
+		SkipPaths:  []string{"/metrics", "/healthz", "/readyz"},
 	}))
 	r.Use(ginzap.RecoveryWithZap(zap.L(), true))
 
 	// Use prometheus middleware
-	r.Use(promMiddleware)
+	r.Use(promMiddleware(container.ClientLabels))
+
+	// Require authentication of API clients when it's configured,
+	// probes and metrics stay open
+	api := r.Group("/")
+	if container.Auth != nil {
+		api.Use(authMiddleware(container.Auth))
+	}
 
 	// Add routes and controllers
-	r.POST("/parse-feed", controllers.PostParseFeed)
-	r.POST("/parse-feed-async", controllers.PostParseFeedAsync)
+	api.POST("/parse-feed", handlers.PostParseFeed)
+	api.POST("/parse-feed-async", handlers.PostParseFeedAsync)
+	api.POST("/parse-feed/preview", handlers.PostParseFeedPreview)
+	if handlers.Registry != nil {
+		api.GET("/feeds", handlers.GetFeeds)
+		api.POST("/feeds", handlers.PostFeed)
+		api.GET("/feeds/:id", handlers.GetFeed)
+		api.PUT("/feeds/:id", handlers.PutFeed)
+		api.DELETE("/feeds/:id", handlers.DeleteFeed)
+	}
+	if handlers.Scheduler != nil {
+		api.GET("/schedules", handlers.GetSchedules)
+	}
+	if handlers.Jobs != nil {
+		api.GET("/jobs/:id", handlers.GetJob)
+	}
+	r.GET("/healthz", handlers.GetHealth)
+	r.GET("/readyz", handlers.GetReady)
+	r.GET("/health", handlers.GetHealth)
+	r.GET("/ready", handlers.GetReady)
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// Run server
 	zap.L().Info(
-		fmt.Sprintf("Listening and serving HTTP on %s", serverAddress),
+		fmt.Sprintf("Listening and serving HTTP on %s", config.Server.Address),
 	)
-	err := r.Run(serverAddress)
-	if err != nil {
+	server := &http.Server{
+		Addr:    config.Server.Address,
+		Handler: r,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Wait for termination signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-serverErr:
 		zap.L().Panic(
 			"Couldn't start the server",
 			zap.Error(err),
 		)
+	case sig := <-quit:
+		zap.L().Info("Shutting down", zap.String("signal", sig.String()))
+	}
+
+	// Stop accepting new jobs and let feeds being parsed finish,
+	// the server keeps answering readiness probes while draining
+	ctx := context.Background()
+	if config.Server.DrainTimeoutS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(config.Server.DrainTimeoutS)*time.Second)
+		defer cancel()
 	}
+	container.Drain(ctx)
+	if err := server.Shutdown(ctx); err != nil {
+		zap.L().Warn("Requests not finished before drain timeout", zap.Error(err))
+	}
+	// Deferred Close flushes queue writers and closes broker connections
+	zap.L().Info("Server stopped, closing queue writers and connections")
 }
 
-func promMiddleware(c *gin.Context) {
-	if !strings.HasSuffix(c.Request.URL.Path, "/metrics") {
-		// Increment prom total requests counter
-		opsProcessed.Inc()
+func promMiddleware(labels *auth.ClientLabels) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Pass on to the next-in-chain
+		c.Next()
+
+		if !strings.HasSuffix(c.Request.URL.Path, "/metrics") {
+			// Increment prom total requests counter of client known
+			// after authentication
+			opsProcessed.WithLabelValues(labels.Label(controllers.Client(c))).Inc()
+		}
 	}
+}
 
-	// Pass on to the next-in-chain
-	c.Next()
+// Identifies client of the request, responds with 401 Unauthorized
+// when credentials are missing or invalid
+func authMiddleware(authenticator auth.AuthenticatorInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer zap.L().Sync()
+
+		client, err := authenticator.Authenticate(c.Request)
+		if err != nil {
+			zap.L().Warn(
+				"Unauthorized request",
+				zap.String("path", c.Request.URL.Path),
+				zap.Error(err),
+			)
+			c.Header("WWW-Authenticate", "Bearer")
+			c.IndentedJSON(http.StatusUnauthorized, gin.H{
+				"status":  "UNAUTHORIZED",
+				"message": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+		c.Set(controllers.ClientKey, client)
+		c.Next()
+	}
 }
@@ -0,0 +1,90 @@
+package boltstore
+
+import (
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Name of the bucket containing nested bucket for every feed
+var snapshotsBucket = []byte("snapshots")
+
+// Snapshot store persisted in embedded BoltDB file
+// Implements SnapshotStoreInterface
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// Opens or creates BoltDB file at path and creates new BoltStore instance
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(snapshotsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{
+		db: db,
+	}, nil
+}
+
+// Returns snapshot of the feed, empty snapshot when the feed
+// has never been stored
+func (s *BoltStore) LoadSnapshot(feedUrl string) (map[string]uint64, error) {
+	snapshot := make(map[string]uint64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		feedBucket := tx.Bucket(snapshotsBucket).Bucket([]byte(feedUrl))
+		if feedBucket == nil {
+			return nil
+		}
+		return feedBucket.ForEach(func(k, v []byte) error {
+			snapshot[string(k)] = binary.BigEndian.Uint64(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// Replaces stored snapshot of the feed in a single transaction
+func (s *BoltStore) SaveSnapshot(feedUrl string, snapshot map[string]uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		snapshots := tx.Bucket(snapshotsBucket)
+		if snapshots.Bucket([]byte(feedUrl)) != nil {
+			if err := snapshots.DeleteBucket([]byte(feedUrl)); err != nil {
+				return err
+			}
+		}
+		feedBucket, err := snapshots.CreateBucket([]byte(feedUrl))
+		if err != nil {
+			return err
+		}
+
+		for itemId, hash := range snapshot {
+			// Value has to stay valid until the end of the transaction
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, hash)
+			if err := feedBucket.Put([]byte(itemId), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Closes underlying BoltDB file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
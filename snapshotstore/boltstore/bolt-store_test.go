@@ -0,0 +1,51 @@
+package boltstore
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBoltStoreSnapshots(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatalf("NewBoltStore(path), err = %v, want nil", err)
+	}
+	defer store.Close()
+
+	// Check snapshot of unknown feed
+	snapshot, err := store.LoadSnapshot("test_url_1")
+	if err != nil || len(snapshot) != 0 {
+		t.Fatalf(
+			`BoltStore.LoadSnapshot("test_url_1") = %v, %v, want empty snapshot`,
+			snapshot,
+			err,
+		)
+	}
+
+	// Check if saved snapshots are loaded unchanged
+	firstSnapshot := map[string]uint64{"item_1": 1, "item_2": 2}
+	secondSnapshot := map[string]uint64{"item_2": 3, "item_3": 4}
+	for _, expected := range []map[string]uint64{firstSnapshot, secondSnapshot} {
+		if err := store.SaveSnapshot("test_url_1", expected); err != nil {
+			t.Fatalf(`BoltStore.SaveSnapshot("test_url_1"), err = %v, want nil`, err)
+		}
+		snapshot, err := store.LoadSnapshot("test_url_1")
+		if err != nil {
+			t.Fatalf(`BoltStore.LoadSnapshot("test_url_1"), err = %v, want nil`, err)
+		}
+		if !reflect.DeepEqual(snapshot, expected) {
+			t.Fatalf(
+				`BoltStore.LoadSnapshot("test_url_1") = %v, want %v`,
+				snapshot,
+				expected,
+			)
+		}
+	}
+
+	// Check if feeds are stored separately
+	snapshot, _ = store.LoadSnapshot("test_url_2")
+	if len(snapshot) != 0 {
+		t.Fatalf(`BoltStore.LoadSnapshot("test_url_2") = %v, want empty snapshot`, snapshot)
+	}
+}
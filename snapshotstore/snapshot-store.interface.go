@@ -0,0 +1,11 @@
+package snapshotstore
+
+// Store of per-feed snapshots with content hashes of shop items
+// keyed by ITEM_ID
+type SnapshotStoreInterface interface {
+	// Returns snapshot of the feed, empty snapshot when the feed
+	// has never been stored
+	LoadSnapshot(feedUrl string) (map[string]uint64, error)
+	// Replaces stored snapshot of the feed
+	SaveSnapshot(feedUrl string, snapshot map[string]uint64) error
+}
@@ -0,0 +1,254 @@
+package urlpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Policy of urls which may be fetched, so feed urls can't reach
+// internal services like brokers or cloud metadata endpoints
+type UrlPolicy struct {
+	schemes         map[string]bool
+	allowedHosts    []string
+	deniedHosts     []string
+	allowedNetworks []*net.IPNet
+	maxRedirects    int
+	// Hosts which can't be resolved are rejected by Check
+	failClosed bool
+	// Resolves host names checked before requests, replaced in tests
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Options of UrlPolicy
+type UrlPolicyOptions struct {
+	// Allowed url schemes, DefaultSchemes are used when empty
+	AllowedSchemes []string
+	// Host name patterns like "*.example.com", matched with path.Match.
+	// All hosts are allowed when empty
+	AllowedHosts []string
+	// Host name patterns which are never allowed
+	DeniedHosts []string
+	// Networks in CIDR notation exempted from blocking of private,
+	// loopback and link-local addresses, e.g. feeds hosted in the cluster
+	AllowedNetworks []string
+	// Maximal number of followed redirects, DefaultMaxRedirects
+	// is used when 0
+	MaxRedirects int
+}
+
+// Default values of UrlPolicyOptions
+var DefaultSchemes = []string{"http", "https"}
+
+const DefaultMaxRedirects = 5
+
+// Timeout of resolving host names checked before requests
+const lookupTimeout = 2 * time.Second
+
+// Returned, possibly wrapped, for urls violating the policy
+var ErrForbiddenUrl = errors.New("url is not allowed")
+
+// Networks of addresses which are not public, in addition to loopback,
+// private, link-local and multicast addresses recognized by net.IP
+var blockedNetworks = mustParseNetworks(
+	"0.0.0.0/8",      // "This" network
+	"100.64.0.0/10",  // Carrier-grade NAT, metadata endpoints of some clouds
+	"192.0.0.0/24",   // IETF protocol assignments
+	"198.18.0.0/15",  // Benchmarking
+	"240.0.0.0/4",    // Reserved and broadcast
+	"64:ff9b::/96",   // NAT64 translating to IPv4 addresses
+	"64:ff9b:1::/48", // Local-use NAT64
+	"2001::/32",      // Teredo tunneling to IPv4 addresses
+	"2002::/16",      // 6to4 tunneling to IPv4 addresses
+	"100::/64",       // Discard-only
+	"fec0::/10",      // Deprecated site-local
+)
+
+// Creates new UrlPolicy instance, returns error for invalid
+// schemes, patterns or networks
+func NewUrlPolicy(options UrlPolicyOptions) (*UrlPolicy, error) {
+	if options.MaxRedirects < 0 {
+		return nil, fmt.Errorf("maximal redirects can't be negative")
+	}
+	if options.MaxRedirects == 0 {
+		options.MaxRedirects = DefaultMaxRedirects
+	}
+	if len(options.AllowedSchemes) == 0 {
+		options.AllowedSchemes = DefaultSchemes
+	}
+
+	policy := &UrlPolicy{
+		schemes:      make(map[string]bool),
+		maxRedirects: options.MaxRedirects,
+		lookup:       net.DefaultResolver.LookupIPAddr,
+	}
+	for _, scheme := range options.AllowedSchemes {
+		scheme = strings.ToLower(scheme)
+		if scheme != "http" && scheme != "https" {
+			return nil, fmt.Errorf("unsupported url scheme %q", scheme)
+		}
+		policy.schemes[scheme] = true
+	}
+	for _, patterns := range []struct {
+		source []string
+		target *[]string
+	}{
+		{options.AllowedHosts, &policy.allowedHosts},
+		{options.DeniedHosts, &policy.deniedHosts},
+	} {
+		for _, pattern := range patterns.source {
+			pattern = strings.ToLower(pattern)
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid host pattern %q", pattern)
+			}
+			*patterns.target = append(*patterns.target, pattern)
+		}
+	}
+	for _, cidr := range options.AllowedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed network %q", cidr)
+		}
+		policy.allowedNetworks = append(policy.allowedNetworks, network)
+	}
+	return policy, nil
+}
+
+// Checks scheme and host of url, addresses of host are resolved
+// and checked as well. Hosts which can't be resolved are allowed,
+// as they are checked again when connection is opened, unless
+// the policy fails closed
+func (p *UrlPolicy) Check(rawUrl string) error {
+	parsed, err := p.checkUrl(rawUrl)
+	if err != nil {
+		return err
+	}
+
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIp(ip)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	addresses, err := p.lookup(ctx, host)
+	if err != nil {
+		if p.failClosed {
+			return fmt.Errorf("%w: host %s can't be resolved: %v", ErrForbiddenUrl, host, err)
+		}
+		return nil
+	}
+	for _, address := range addresses {
+		if err := p.CheckIp(address.IP); err != nil {
+			return fmt.Errorf("%w: host %s resolves to %s", ErrForbiddenUrl, host, address.IP)
+		}
+	}
+	return nil
+}
+
+// Returns copy of the policy rejecting hosts which can't be resolved.
+// Used when connections go through a proxy, which resolves host names
+// itself, so addresses can't be checked when connections are opened
+func (p *UrlPolicy) FailClosed() *UrlPolicy {
+	policy := *p
+	policy.failClosed = true
+	return &policy
+}
+
+// Checks scheme and host name of url without resolving it
+func (p *UrlPolicy) checkUrl(rawUrl string) (*url.URL, error) {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q is not an absolute url", ErrForbiddenUrl, rawUrl)
+	}
+	if !p.schemes[strings.ToLower(parsed.Scheme)] {
+		return nil, fmt.Errorf("%w: scheme %q is not allowed", ErrForbiddenUrl, parsed.Scheme)
+	}
+	if len(parsed.Hostname()) == 0 {
+		return nil, fmt.Errorf("%w: %q is not an absolute url", ErrForbiddenUrl, rawUrl)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if matchAny(p.deniedHosts, host) {
+		return nil, fmt.Errorf("%w: host %s is denied", ErrForbiddenUrl, host)
+	}
+	if len(p.allowedHosts) > 0 && !matchAny(p.allowedHosts, host) {
+		return nil, fmt.Errorf("%w: host %s is not allowed", ErrForbiddenUrl, host)
+	}
+	return parsed, nil
+}
+
+// Checks if address is public or in allowed networks
+func (p *UrlPolicy) CheckIp(ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, network := range p.allowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("%w: address %s is not public", ErrForbiddenUrl, ip)
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return fmt.Errorf("%w: address %s is not public", ErrForbiddenUrl, ip)
+		}
+	}
+	return nil
+}
+
+// Control function of net.Dialer checking resolved address of every
+// connection, so host names can't be rebound to blocked addresses
+// after they were checked
+func (p *UrlPolicy) Control(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: invalid address %s", ErrForbiddenUrl, address)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: unresolved address %s", ErrForbiddenUrl, address)
+	}
+	return p.CheckIp(ip)
+}
+
+// CheckRedirect function of http.Client limiting number of redirects
+// and checking url of every hop
+func (p *UrlPolicy) CheckRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) > p.maxRedirects {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrForbiddenUrl, p.maxRedirects)
+	}
+	return p.Check(request.URL.String())
+}
+
+// Checks if host matches any of patterns
+func matchAny(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
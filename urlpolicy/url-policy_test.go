@@ -0,0 +1,180 @@
+package urlpolicy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUrlPolicyCheck(t *testing.T) {
+	policy, err := NewUrlPolicy(UrlPolicyOptions{
+		DeniedHosts:     []string{"*.internal", "metadata.google.internal"},
+		AllowedNetworks: []string{"10.1.0.0/16"},
+	})
+	if err != nil {
+		t.Fatalf("NewUrlPolicy(), err = %v, want nil", err)
+	}
+	policy.lookup = mockedLookup
+
+	tests := map[string]bool{
+		"https://shop.example.com/feed.xml":         true,
+		"http://93.184.216.34/feed.xml":             true,
+		"https://unresolvable.example.com/feed.xml": true,
+		// Allowed network
+		"http://10.1.2.3/feed.xml": true,
+		// Schemes
+		"ftp://shop.example.com/feed.xml":  false,
+		"file:///etc/passwd":               false,
+		"shop.example.com/feed.xml":        false,
+		"http:///feed.xml":                 false,
+		"gopher://shop.example.com:70/_1x": false,
+		// Denied hosts
+		"http://metadata.google.internal/computeMetadata/v1/": false,
+		"http://RabbitMQ.Internal:15672/":                     false,
+		// Blocked addresses
+		"http://127.0.0.1:15672/api/queues":            false,
+		"http://169.254.169.254/latest/meta-data/":     false,
+		"http://100.100.100.200/latest/meta-data/":     false,
+		"http://10.0.0.1/feed.xml":                     false,
+		"http://192.168.1.1/feed.xml":                  false,
+		"http://0.0.0.0:8080/feed.xml":                 false,
+		"http://[::1]:8080/feed.xml":                   false,
+		"http://[::ffff:127.0.0.1]:8080/feed.xml":      false,
+		"http://[fd00::1]/feed.xml":                    false,
+		"http://[64:ff9b::a9fe:a9fe]/latest/meta-data": false,
+		// Host names resolving to blocked addresses
+		"http://localhost:5672/":               false,
+		"http://rebind.example.com/feed.xml":   false,
+		"http://dual-stack.example.com/feed.x": false,
+	}
+	for url, allowed := range tests {
+		err := policy.Check(url)
+		if allowed && err != nil {
+			t.Fatalf("Check(%q), err = %v, want nil", url, err)
+		}
+		if !allowed && !errors.Is(err, ErrForbiddenUrl) {
+			t.Fatalf("Check(%q), err = %v, want %v", url, err, ErrForbiddenUrl)
+		}
+	}
+}
+
+func TestUrlPolicyFailClosed(t *testing.T) {
+	policy, _ := NewUrlPolicy(UrlPolicyOptions{})
+	policy.lookup = mockedLookup
+	failClosed := policy.FailClosed()
+
+	if err := failClosed.Check("https://unresolvable.example.com/feed.xml"); !errors.Is(err, ErrForbiddenUrl) {
+		t.Fatalf("FailClosed().Check(unresolvable), err = %v, want %v", err, ErrForbiddenUrl)
+	}
+	if err := failClosed.Check("https://shop.example.com/feed.xml"); err != nil {
+		t.Fatalf("FailClosed().Check(resolvable), err = %v, want nil", err)
+	}
+	// Original policy is not changed
+	if err := policy.Check("https://unresolvable.example.com/feed.xml"); err != nil {
+		t.Fatalf("Check(unresolvable), err = %v, want nil", err)
+	}
+}
+
+func TestUrlPolicyAllowedHosts(t *testing.T) {
+	policy, err := NewUrlPolicy(UrlPolicyOptions{
+		AllowedSchemes: []string{"https"},
+		AllowedHosts:   []string{"*.example.com", "feeds.example.org"},
+		DeniedHosts:    []string{"admin.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("NewUrlPolicy(), err = %v, want nil", err)
+	}
+	policy.lookup = mockedLookup
+
+	tests := map[string]bool{
+		"https://shop.example.com/feed.xml":   true,
+		"https://feeds.example.org/feed.xml":  true,
+		"https://Feeds.Example.org./feed.xml": true,
+		"http://shop.example.com/feed.xml":    false,
+		"https://example.com/feed.xml":        false,
+		"https://shop.example.net/feed.xml":   false,
+		"https://admin.example.com/feed.xml":  false,
+	}
+	for url, allowed := range tests {
+		if err := policy.Check(url); (err == nil) != allowed {
+			t.Fatalf("Check(%q), err = %v, want allowed = %v", url, err, allowed)
+		}
+	}
+}
+
+func TestUrlPolicyControl(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(), err = %v, want nil", err)
+	}
+	defer listener.Close()
+
+	policy, _ := NewUrlPolicy(UrlPolicyOptions{})
+	dialer := &net.Dialer{Control: policy.Control}
+	if _, err := dialer.Dial("tcp", listener.Addr().String()); !errors.Is(err, ErrForbiddenUrl) {
+		t.Fatalf("Dial(), err = %v, want %v", err, ErrForbiddenUrl)
+	}
+
+	allowing, _ := NewUrlPolicy(UrlPolicyOptions{AllowedNetworks: []string{"127.0.0.0/8"}})
+	dialer = &net.Dialer{Control: allowing.Control}
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() to allowed network, err = %v, want nil", err)
+	}
+	conn.Close()
+}
+
+func TestUrlPolicyCheckRedirect(t *testing.T) {
+	policy, _ := NewUrlPolicy(UrlPolicyOptions{MaxRedirects: 2})
+	policy.lookup = mockedLookup
+
+	request := httptest.NewRequest("GET", "https://shop.example.com/feed.xml", nil)
+	via := []*http.Request{request, request}
+	if err := policy.CheckRedirect(request, via); err != nil {
+		t.Fatalf("CheckRedirect() after 2 requests, err = %v, want nil", err)
+	}
+	if err := policy.CheckRedirect(request, append(via, request)); !errors.Is(err, ErrForbiddenUrl) {
+		t.Fatalf("CheckRedirect() after 3 requests, err = %v, want %v", err, ErrForbiddenUrl)
+	}
+	blocked := httptest.NewRequest("GET", "http://169.254.169.254/latest/meta-data/", nil)
+	if err := policy.CheckRedirect(blocked, via[:1]); !errors.Is(err, ErrForbiddenUrl) {
+		t.Fatalf("CheckRedirect() to blocked address, err = %v, want %v", err, ErrForbiddenUrl)
+	}
+}
+
+func TestNewUrlPolicyInvalidOptions(t *testing.T) {
+	tests := map[string]UrlPolicyOptions{
+		"scheme":    {AllowedSchemes: []string{"ftp"}},
+		"pattern":   {AllowedHosts: []string{"[example.com"}},
+		"network":   {AllowedNetworks: []string{"10.0.0.0"}},
+		"redirects": {MaxRedirects: -1},
+	}
+	for name, options := range tests {
+		if _, err := NewUrlPolicy(options); err == nil {
+			t.Fatalf("NewUrlPolicy() with invalid %s, err = nil, want error", name)
+		}
+	}
+}
+
+// MOCKED DATA
+
+// Resolves host names of tests without DNS
+func mockedLookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addresses := map[string][]string{
+		"shop.example.com":       {"93.184.216.34"},
+		"localhost":              {"127.0.0.1", "::1"},
+		"rebind.example.com":     {"10.0.0.5"},
+		"dual-stack.example.com": {"93.184.216.34", "fd00::5"},
+	}[host]
+	if len(addresses) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	ips := make([]net.IPAddr, 0, len(addresses))
+	for _, address := range addresses {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(address)})
+	}
+	return ips, nil
+}